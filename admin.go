@@ -0,0 +1,209 @@
+package sqsworker
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultDrainTimeout is how long a drain requested through the admin API waits for
+// in-flight messages when no timeout is given
+const DefaultDrainTimeout = 30 * time.Second
+
+// Config describes how a worker is configured, as reported by the admin API
+type Config struct {
+	Name               string        `json:"name"`
+	QueueName          string        `json:"queue_name,omitempty"`
+	QueueURLs          []string      `json:"queue_urls"`
+	TopicArn           string        `json:"topic_arn"`
+	StarvationLimit    int           `json:"starvation_limit"`
+	QueueWeights       []int         `json:"queue_weights,omitempty"`
+	MaxPerKey          int           `json:"max_per_key"`
+	KeyDeferral        int64         `json:"key_deferral"`
+	QueueDepthInterval time.Duration `json:"queue_depth_interval"`
+	MaxMessageAge      time.Duration `json:"max_message_age"`
+	Settings           Settings      `json:"settings"`
+}
+
+// Config returns the worker's configuration along with its current settings
+func (w *Worker) Config() Config {
+	return Config{
+		Name:               w.Name,
+		QueueName:          w.QueueName,
+		QueueURLs:          w.Queues(),
+		TopicArn:           w.TopicArn,
+		StarvationLimit:    w.StarvationLimit,
+		QueueWeights:       w.QueueWeights,
+		MaxPerKey:          w.MaxPerKey,
+		KeyDeferral:        w.KeyDeferral,
+		QueueDepthInterval: w.QueueDepthInterval,
+		MaxMessageAge:      w.MaxMessageAge,
+		Settings:           w.Settings(),
+	}
+}
+
+// AdminHandler returns an http.Handler to operate a running worker:
+//
+//	GET  /stats            counters and gauges, see Stats
+//	GET  /config           configuration and current settings
+//	GET  /settings         current settings
+//	PUT  /settings         apply settings, fields left out keep their current value
+//	POST /pause?queue=     stop polling, only the discovered queue when given
+//	POST /resume?queue=    restart polling, only the discovered queue when given
+//	POST /drain?timeout=   stop polling and wait for in-flight messages, 30s by default
+//	GET  /debug/vars       expvar variables, see PublishExpvar
+func AdminHandler(w *Worker) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", func(rw http.ResponseWriter, r *http.Request) {
+		if allow(rw, r, http.MethodGet) {
+			writeJSON(rw, w.Stats())
+		}
+	})
+	mux.HandleFunc("/config", func(rw http.ResponseWriter, r *http.Request) {
+		if allow(rw, r, http.MethodGet) {
+			writeJSON(rw, w.Config())
+		}
+	})
+	mux.HandleFunc("/settings", func(rw http.ResponseWriter, r *http.Request) {
+		if !allow(rw, r, http.MethodGet, http.MethodPut) {
+			return
+		}
+		if r.Method == http.MethodPut {
+			var s Settings
+			if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Apply(s)
+		}
+		writeJSON(rw, w.Settings())
+	})
+	mux.HandleFunc("/pause", func(rw http.ResponseWriter, r *http.Request) {
+		if !allow(rw, r, http.MethodPost) {
+			return
+		}
+		if queueURL := r.URL.Query().Get("queue"); queueURL != "" {
+			w.PauseQueue(queueURL)
+			writeJSON(rw, w.Queues())
+			return
+		}
+		w.pause()
+		writeJSON(rw, w.Settings())
+	})
+	mux.HandleFunc("/resume", func(rw http.ResponseWriter, r *http.Request) {
+		if !allow(rw, r, http.MethodPost) {
+			return
+		}
+		if queueURL := r.URL.Query().Get("queue"); queueURL != "" {
+			w.ResumeQueue(queueURL)
+			writeJSON(rw, w.Queues())
+			return
+		}
+		w.Resume()
+		writeJSON(rw, w.Settings())
+	})
+	mux.HandleFunc("/drain", func(rw http.ResponseWriter, r *http.Request) {
+		if !allow(rw, r, http.MethodPost) {
+			return
+		}
+		timeout := DefaultDrainTimeout
+		if value := r.URL.Query().Get("timeout"); value != "" {
+			var err error
+			if timeout, err = time.ParseDuration(value); err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		if err := w.Drain(ctx); err != nil {
+			http.Error(rw, err.Error(), http.StatusGatewayTimeout)
+			return
+		}
+		writeJSON(rw, w.Stats())
+	})
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// ErrAdminToken is returned by ServeAdmin for an address other than localhost without a token
+var ErrAdminToken = errors.New("sqsworker: the admin API needs a token to listen beyond localhost")
+
+// ServeAdmin serves the AdminHandler on addr until the context is done. An address without a
+// host, such as ":8080", listens on localhost only. When token is not empty every request must
+// carry it as a bearer token, and addresses other than localhost require one. Servers needing
+// mTLS serve the AdminHandler themselves.
+func ServeAdmin(ctx context.Context, w *Worker, addr, token string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	if token == "" && !loopback(host) {
+		return ErrAdminToken
+	}
+	handler := AdminHandler(w)
+	if token != "" {
+		handler = RequireToken(token, handler)
+	}
+	server := &http.Server{Addr: net.JoinHostPort(host, port), Handler: handler}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	err = server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// loopback reports whether the host is localhost or a loopback address
+func loopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// RequireToken rejects the requests to the handler without the token as their bearer token
+func RequireToken(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			rw.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// allow reports whether the request uses one of the methods, replying with an error if not
+func allow(rw http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	rw.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	return false
+}
+
+func writeJSON(rw http.ResponseWriter, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(v)
+}
@@ -0,0 +1,118 @@
+package sqsworker_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAdminHandler(t *testing.T) {
+	queue := GetMockeQueue()
+	handler := &RecordingWorker{Bodies: make(chan string, 10)}
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: handler,
+		Name:      "TestApp",
+	})
+	w.Queue = &PollQueue{queue}
+	go w.Run()
+	defer w.Close()
+
+	server := httptest.NewServer(sqsworker.AdminHandler(w))
+	defer server.Close()
+
+	queue.Push("hello")
+	<-handler.Bodies
+
+	resp, err := http.Post(server.URL+"/drain?timeout=1s", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats sqsworker.Stats
+	json.NewDecoder(resp.Body).Decode(&stats)
+	resp.Body.Close()
+	if stats.Processed != 1 {
+		t.Error("Actual: ", stats.Processed, "Expected: ", 1)
+	}
+	if !w.Paused() {
+		t.Error("Expected worker to be paused")
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, server.URL+"/settings", strings.NewReader(`{"consumers": 4}`))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var settings sqsworker.Settings
+	json.NewDecoder(resp.Body).Decode(&settings)
+	resp.Body.Close()
+	expected := sqsworker.Settings{Consumers: 4, VisibilityTimeout: sqsworker.DefaultVisibilityTimeout, Paused: aws.Bool(true), DebugSample: aws.Float64(0)}
+	if !reflect.DeepEqual(settings, expected) {
+		t.Error("Actual: ", settings, "Expected: ", expected)
+	}
+
+	resp, err = http.Post(server.URL+"/resume", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if w.Paused() {
+		t.Error("Expected worker to be resumed")
+	}
+
+	resp, err = http.Get(server.URL + "/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config sqsworker.Config
+	json.NewDecoder(resp.Body).Decode(&config)
+	resp.Body.Close()
+	if config.Name != "TestApp" || config.Settings.Consumers != 4 {
+		t.Error("Actual: ", config)
+	}
+
+	resp, err = http.Get(server.URL + "/pause")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Error("Actual: ", resp.StatusCode, "Expected: ", http.StatusMethodNotAllowed)
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{QueueURL: workerQueueURL, Logger: zap.NewNop()})
+	server := httptest.NewServer(sqsworker.RequireToken("secret", sqsworker.AdminHandler(w)))
+	defer server.Close()
+
+	for token, status := range map[string]int{"": http.StatusUnauthorized, "guess": http.StatusUnauthorized, "secret": http.StatusOK} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/stats", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Error("Actual: ", resp.StatusCode, "Expected: ", status)
+		}
+	}
+
+	// the admin API only listens beyond localhost with a token
+	if err := sqsworker.ServeAdmin(context.Background(), w, "0.0.0.0:0", ""); err != sqsworker.ErrAdminToken {
+		t.Error("Actual: ", err, "Expected: ", sqsworker.ErrAdminToken)
+	}
+}
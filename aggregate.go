@@ -0,0 +1,180 @@
+package sqsworker
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"sync"
+	"time"
+)
+
+// DefaultAggregateTimeout is how long an Aggregator waits for a group to fill
+const DefaultAggregateTimeout = time.Minute
+
+// errAggregatePanicked fails the members of a group whose handler panicked
+var errAggregatePanicked = errors.New("sqsworker: aggregate handler panicked")
+
+// AggregateFunc handles a group of related messages once, returning the result to publish
+type AggregateFunc func(ctx context.Context, group []*sqs.Message) (*sns.PublishInput, error)
+
+// AggregateConfig settings for an Aggregator
+type AggregateConfig struct {
+	// Key returns the correlation key of a message, by default its correlation ID when the
+	// worker has a CorrelationAttr. Messages with an empty key are handled alone.
+	Key KeyFunc
+	// Count is the number of messages in a complete group, unless Size returns the size of the
+	// group a message belongs to, e.g. from a parts attribute
+	Count int
+	Size  func(*sqs.Message) int
+	// Timeout is how long the first message of a group waits for the others, by default
+	// DefaultAggregateTimeout. A group that does not fill in time is handled as it is.
+	Timeout time.Duration
+	// VisibilityTimeout in seconds is set on the buffered messages every Heartbeat, by default
+	// DefaultVisibilityTimeout and half of it, so they are not received again while they wait
+	VisibilityTimeout int64
+	Heartbeat         time.Duration
+	Handler           AggregateFunc
+}
+
+// Aggregator is a Processor buffering messages that share a correlation key until their group
+// is complete, then calling its handler once with the whole group. The message that started a
+// group publishes the result, and every member is deleted once the handler succeeded. When it
+// fails, every member fails with its error and is received again.
+//
+// A buffered message holds its consumer while it waits, so a worker needs more Consumers than
+// the largest group, or groups only complete at their Timeout.
+type Aggregator struct {
+	Queue  sqsiface.SQSAPI
+	config AggregateConfig
+	mu     sync.Mutex
+	groups map[string]*aggregateGroup
+}
+
+// aggregateGroup is a group of messages being buffered
+type aggregateGroup struct {
+	messages []*sqs.Message
+	size     int
+	// full is closed when the group has size messages, done once the handler returned
+	full chan struct{}
+	done chan struct{}
+	err  error
+}
+
+// NewAggregator creates an Aggregator, extending the visibility of the buffered messages with
+// the queue client
+func NewAggregator(queue sqsiface.SQSAPI, config AggregateConfig) *Aggregator {
+	if config.Timeout == 0 {
+		config.Timeout = DefaultAggregateTimeout
+	}
+	if config.VisibilityTimeout == 0 {
+		config.VisibilityTimeout = DefaultVisibilityTimeout
+	}
+	if config.Heartbeat == 0 {
+		config.Heartbeat = time.Duration(config.VisibilityTimeout) * time.Second / 2
+	}
+	return &Aggregator{Queue: queue, config: config, groups: make(map[string]*aggregateGroup)}
+}
+
+// Process adds the message to its group and waits for the group to be handled
+func (a *Aggregator) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	key := CorrelationID(ctx)
+	if a.config.Key != nil {
+		key = a.config.Key(m)
+	}
+	if key == "" {
+		return a.config.Handler(ctx, []*sqs.Message{m})
+	}
+
+	g, first := a.join(key, m)
+	if first {
+		return a.lead(ctx, key, g, m)
+	}
+	return nil, a.wait(ctx, g, m)
+}
+
+// join adds a message to the group of its key, creating the group for the first message
+func (a *Aggregator) join(key string, m *sqs.Message) (*aggregateGroup, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	g, ok := a.groups[key]
+	if !ok {
+		size := a.config.Count
+		if a.config.Size != nil {
+			size = a.config.Size(m)
+		}
+		if size < 1 {
+			size = 1
+		}
+		g = &aggregateGroup{size: size, full: make(chan struct{}), done: make(chan struct{})}
+		a.groups[key] = g
+	}
+	g.messages = append(g.messages, m)
+	if len(g.messages) == g.size {
+		close(g.full)
+	}
+	return g, !ok
+}
+
+// take removes a group once it is full or timed out, so later messages start a new group
+func (a *Aggregator) take(key string, g *aggregateGroup) []*sqs.Message {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.groups[key] == g {
+		delete(a.groups, key)
+	}
+	return g.messages
+}
+
+// lead waits for the group started by a message to fill or time out, then handles it
+func (a *Aggregator) lead(ctx context.Context, key string, g *aggregateGroup, m *sqs.Message) (*sns.PublishInput, error) {
+	// The other members fail unless the handler returns, rather than panics
+	g.err = errAggregatePanicked
+	defer close(g.done)
+
+	timeout := time.NewTimer(a.config.Timeout)
+	defer timeout.Stop()
+	err := a.heartbeat(ctx, m, g.full, timeout.C)
+	messages := a.take(key, g)
+
+	var output *sns.PublishInput
+	if err == nil {
+		output, err = a.config.Handler(ctx, messages)
+	}
+	g.err = err
+	return output, err
+}
+
+// wait waits for the group a message joined to be handled, returning the handler's error
+func (a *Aggregator) wait(ctx context.Context, g *aggregateGroup, m *sqs.Message) error {
+	if err := a.heartbeat(ctx, m, g.done, nil); err != nil {
+		return err
+	}
+	return g.err
+}
+
+// heartbeat extends the visibility of a buffered message until done or timeout is closed, or
+// the context is done
+func (a *Aggregator) heartbeat(ctx context.Context, m *sqs.Message, done <-chan struct{}, timeout <-chan time.Time) error {
+	ticker := time.NewTicker(a.config.Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-timeout:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			// A message that cannot be extended may be received again, and is handled then
+			a.Queue.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+				QueueUrl:          aws.String(SourceQueue(ctx)),
+				ReceiptHandle:     m.ReceiptHandle,
+				VisibilityTimeout: aws.Int64(a.config.VisibilityTimeout),
+			})
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package sqsworker_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// joinParts publishes the sorted bodies of a group
+func joinParts(ctx context.Context, group []*sqs.Message) (*sns.PublishInput, error) {
+	parts := make([]string, len(group))
+	for i, m := range group {
+		parts[i] = *m.Body
+	}
+	sort.Strings(parts)
+	return &sns.PublishInput{Message: aws.String(strings.Join(parts, ","))}, nil
+}
+
+// handleAll handles the messages concurrently, waiting between each
+func handleAll(w *sqsworker.Worker, wait time.Duration, messages ...*sqs.Message) []error {
+	errs := make([]error, len(messages))
+	var wg sync.WaitGroup
+	for i, m := range messages {
+		wg.Add(1)
+		go func(i int, m *sqs.Message) {
+			defer wg.Done()
+			errs[i] = w.Handle(context.Background(), m)
+		}(i, m)
+		time.Sleep(wait)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestAggregator(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn: "arn:aws:sns:us-east-1:88888888888:Out",
+	})
+	h.Worker.Processor = sqsworker.NewAggregator(h.Queue, sqsworker.AggregateConfig{
+		Key:       sqsworker.AttributeKey("order"),
+		Count:     3,
+		Timeout:   200 * time.Millisecond,
+		Heartbeat: 10 * time.Millisecond,
+		Handler:   joinParts,
+	})
+
+	// The second order does not fill its group, and is handled at the timeout
+	errs := handleAll(h.Worker, 20*time.Millisecond,
+		workertest.NewMessage("a", workertest.Attribute("order", "1")),
+		workertest.NewMessage("x", workertest.Attribute("order", "2")),
+		workertest.NewMessage("b", workertest.Attribute("order", "1")),
+		workertest.NewMessage("c", workertest.Attribute("order", "1")),
+	)
+	for _, err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	if len(h.Topic.Published) != 2 || *h.Topic.Published[0].Message != "a,b,c" || *h.Topic.Published[1].Message != "x" {
+		t.Error("Expected each group to be published once, got ", h.Topic.Published)
+	}
+	if len(h.Queue.Deleted) != 4 {
+		t.Error("Expected every message to be deleted, got ", len(h.Queue.Deleted))
+	}
+	if len(h.Queue.Visible) == 0 || *h.Queue.Visible[0].VisibilityTimeout != sqsworker.DefaultVisibilityTimeout {
+		t.Error("Expected the visibility of the buffered messages to be extended, got ", h.Queue.Visible)
+	}
+}
+
+func TestAggregatorFailed(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{})
+	h.Worker.Processor = sqsworker.NewAggregator(h.Queue, sqsworker.AggregateConfig{
+		Key: sqsworker.AttributeKey("order"),
+		Size: func(m *sqs.Message) int {
+			return 2
+		},
+		Handler: func(ctx context.Context, group []*sqs.Message) (*sns.PublishInput, error) {
+			return nil, errPoison
+		},
+	})
+
+	errs := handleAll(h.Worker, 0,
+		workertest.NewMessage("a", workertest.Attribute("order", "1")),
+		workertest.NewMessage("b", workertest.Attribute("order", "1")),
+	)
+	if errs[0] != errPoison || errs[1] != errPoison {
+		t.Error("Expected every member to fail, got ", errs)
+	}
+	if len(h.Queue.Deleted) != 0 {
+		t.Error("Expected no message to be deleted")
+	}
+}
@@ -0,0 +1,98 @@
+package sqsworker
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"strings"
+	"time"
+)
+
+// DefaultAlarmPeriod over which the queue metrics are evaluated, SQS publishes them every 5 minutes
+const DefaultAlarmPeriod = 5 * time.Minute
+
+// sqsNamespace CloudWatch namespace of the SQS metrics
+const sqsNamespace = "AWS/SQS"
+
+// Alarms configures the standard CloudWatch alarms for a worker's queues. An alarm is only
+// created when its threshold, or for the dead-letter alarm its queue, is set.
+type Alarms struct {
+	// Name prefixes the alarm names
+	Name     string
+	QueueURL string
+	// DeadLetterQueueURL alarms as soon as it holds a message
+	DeadLetterQueueURL string
+	// MaxMessageAge alarms when the oldest message in QueueURL is older
+	MaxMessageAge time.Duration
+	// MaxBacklog alarms when QueueURL holds more visible messages
+	MaxBacklog int64
+	// AlarmTopicArn is notified when an alarm fires and when it recovers
+	AlarmTopicArn string
+	// Period defaults to DefaultAlarmPeriod
+	Period time.Duration
+	// EvaluationPeriods over the threshold before alarming, defaults to 1
+	EvaluationPeriods int64
+}
+
+// PutAlarms creates or updates the alarms, returning their names
+func PutAlarms(cw cloudwatchiface.CloudWatchAPI, a Alarms) ([]string, error) {
+	var inputs []*cloudwatch.PutMetricAlarmInput
+	if a.DeadLetterQueueURL != "" {
+		inputs = append(inputs, a.alarm("dlq-not-empty", a.DeadLetterQueueURL,
+			"ApproximateNumberOfMessagesVisible", 0, "messages were sent to the dead-letter queue"))
+	}
+	if a.MaxMessageAge > 0 {
+		inputs = append(inputs, a.alarm("oldest-message-age", a.QueueURL,
+			"ApproximateAgeOfOldestMessage", a.MaxMessageAge.Seconds(), "messages are waiting longer than "+a.MaxMessageAge.String()))
+	}
+	if a.MaxBacklog > 0 {
+		inputs = append(inputs, a.alarm("backlog", a.QueueURL,
+			"ApproximateNumberOfMessagesVisible", float64(a.MaxBacklog), "the queue backlog is growing"))
+	}
+
+	names := make([]string, 0, len(inputs))
+	for _, input := range inputs {
+		if _, err := cw.PutMetricAlarm(input); err != nil {
+			return names, err
+		}
+		names = append(names, *input.AlarmName)
+	}
+	return names, nil
+}
+
+func (a Alarms) alarm(suffix, queueURL, metric string, threshold float64, description string) *cloudwatch.PutMetricAlarmInput {
+	period, evaluationPeriods := a.Period, a.EvaluationPeriods
+	if period == 0 {
+		period = DefaultAlarmPeriod
+	}
+	if evaluationPeriods == 0 {
+		evaluationPeriods = 1
+	}
+
+	input := &cloudwatch.PutMetricAlarmInput{
+		AlarmName:        aws.String(a.Name + "-" + suffix),
+		AlarmDescription: aws.String(a.Name + ": " + description),
+		Namespace:        aws.String(sqsNamespace),
+		MetricName:       aws.String(metric),
+		Dimensions: []*cloudwatch.Dimension{{
+			Name:  aws.String("QueueName"),
+			Value: aws.String(queueName(queueURL)),
+		}},
+		Statistic:          aws.String(cloudwatch.StatisticMaximum),
+		Period:             aws.Int64(int64(period.Seconds())),
+		EvaluationPeriods:  aws.Int64(evaluationPeriods),
+		Threshold:          aws.Float64(threshold),
+		ComparisonOperator: aws.String(cloudwatch.ComparisonOperatorGreaterThanThreshold),
+		TreatMissingData:   aws.String("notBreaching"),
+	}
+	if a.AlarmTopicArn != "" {
+		input.AlarmActions = aws.StringSlice([]string{a.AlarmTopicArn})
+		input.OKActions = aws.StringSlice([]string{a.AlarmTopicArn})
+	}
+	return input
+}
+
+// queueName is the last path segment of a queue url
+func queueName(queueURL string) string {
+	return queueURL[strings.LastIndex(queueURL, "/")+1:]
+}
@@ -0,0 +1,67 @@
+package sqsworker_test
+
+import (
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// AlarmRecorder records the alarms put to CloudWatch
+type AlarmRecorder struct {
+	cloudwatchiface.CloudWatchAPI
+	Alarms []*cloudwatch.PutMetricAlarmInput
+}
+
+func (a *AlarmRecorder) PutMetricAlarm(input *cloudwatch.PutMetricAlarmInput) (*cloudwatch.PutMetricAlarmOutput, error) {
+	a.Alarms = append(a.Alarms, input)
+	return &cloudwatch.PutMetricAlarmOutput{}, nil
+}
+
+func TestPutAlarms(t *testing.T) {
+	cw := &AlarmRecorder{}
+	names, err := sqsworker.PutAlarms(cw, sqsworker.Alarms{
+		Name:               "TestApp",
+		QueueURL:           workerQueueURL,
+		DeadLetterQueueURL: "https://sqs.us-east-1.amazonaws.com/88888888888/DLQ",
+		MaxMessageAge:      10 * time.Minute,
+		MaxBacklog:         1000,
+		AlarmTopicArn:      "arn:aws:sns:us-east-1:88888888888:Alarms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"TestApp-dlq-not-empty", "TestApp-oldest-message-age", "TestApp-backlog"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Error("Actual: ", names, "Expected: ", expected)
+	}
+
+	age := cw.Alarms[1]
+	if *age.Threshold != 600 || *age.MetricName != "ApproximateAgeOfOldestMessage" || *age.Period != 300 {
+		t.Error("unexpected alarm: ", age)
+	}
+	if queue := aws.StringValue(age.Dimensions[0].Value); queue != "In" {
+		t.Error("Actual: ", queue, "Expected: ", "In")
+	}
+	if aws.StringValue(cw.Alarms[0].Dimensions[0].Value) != "DLQ" || *cw.Alarms[0].Threshold != 0 {
+		t.Error("unexpected alarm: ", cw.Alarms[0])
+	}
+	if len(age.AlarmActions) != 1 || len(age.OKActions) != 1 {
+		t.Error("Expected the alarm topic to be notified")
+	}
+}
+
+func TestPutAlarmsThresholds(t *testing.T) {
+	cw := &AlarmRecorder{}
+	names, err := sqsworker.PutAlarms(cw, sqsworker.Alarms{Name: "TestApp", QueueURL: workerQueueURL, MaxBacklog: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "TestApp-backlog" {
+		t.Error("unexpected alarms: ", names)
+	}
+}
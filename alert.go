@@ -0,0 +1,94 @@
+package sqsworker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultAlertInterval minimum time between two age alerts
+const DefaultAlertInterval = time.Minute
+
+// AgeAlert describes a received message older than the configured MaxMessageAge
+type AgeAlert struct {
+	Name      string        `json:"name"`
+	QueueURL  string        `json:"queue_url"`
+	MessageID string        `json:"message_id"`
+	Age       time.Duration `json:"age"`
+	Threshold time.Duration `json:"threshold"`
+}
+
+func (a AgeAlert) String() string {
+	return fmt.Sprintf("%s: message %s on %s is %v old, above the threshold of %v", a.Name, a.MessageID, a.QueueURL, a.Age, a.Threshold)
+}
+
+// AlertFunc is called when the worker is falling behind
+type AlertFunc func(AgeAlert) error
+
+// WebhookAlert returns an AlertFunc that posts each alert as JSON to url
+func WebhookAlert(url string) AlertFunc {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func(a AgeAlert) error {
+		body, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("webhook %s returned %s", url, resp.Status)
+		}
+		return nil
+	}
+}
+
+// ageAlerter throttles age alerts to one per interval, and skips alerts while one is being sent
+type ageAlerter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     time.Time
+	sending  bool
+}
+
+// checkAge calls the AgeAlert func if the message is older than MaxMessageAge. The alert is
+// sent on its own goroutine so that a slow webhook does not stall polling.
+func (w *Worker) checkAge(queueURL string, m *sqs.Message) {
+	age := messageAge(m)
+	if age == 0 || age <= w.MaxMessageAge {
+		return
+	}
+
+	w.alerter.mu.Lock()
+	if w.alerter.sending || time.Since(w.alerter.last) < w.alerter.interval {
+		w.alerter.mu.Unlock()
+		return
+	}
+	w.alerter.last = time.Now()
+	w.alerter.sending = true
+	w.alerter.mu.Unlock()
+
+	go w.alert(AgeAlert{
+		Name:      w.Name,
+		QueueURL:  queueURL,
+		MessageID: aws.StringValue(m.MessageId),
+		Age:       age,
+		Threshold: w.MaxMessageAge,
+	})
+}
+
+func (w *Worker) alert(a AgeAlert) {
+	if err := w.AgeAlert(a); err != nil {
+		w.logError("age alert failed!", err)
+	}
+	w.alerter.mu.Lock()
+	w.alerter.sending = false
+	w.alerter.mu.Unlock()
+}
@@ -0,0 +1,127 @@
+package sqsworker_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// StaticQueue returns the same message on every receive
+type StaticQueue struct {
+	sqsiface.SQSAPI
+	Message *sqs.Message
+}
+
+func (s *StaticQueue) ReceiveMessageRequest(input *sqs.ReceiveMessageInput) (*request.Request, *sqs.ReceiveMessageOutput) {
+	time.Sleep(time.Millisecond)
+	return newRequest(), &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{s.Message}}
+}
+
+func (s *StaticQueue) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (s *StaticQueue) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+}
+
+func TestAgeAlertWebhook(t *testing.T) {
+	alerts := make(chan sqsworker.AgeAlert, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var alert sqsworker.AgeAlert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Error(err)
+		}
+		alerts <- alert
+	}))
+	defer server.Close()
+
+	sent := time.Now().Add(-time.Hour).UnixNano() / int64(time.Millisecond)
+	queue := &StaticQueue{Message: &sqs.Message{
+		Body:       aws.String("old"),
+		MessageId:  aws.String("1"),
+		Attributes: map[string]*string{sqs.MessageSystemAttributeNameSentTimestamp: aws.String(fmt.Sprint(sent))},
+	}}
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:      workerQueueURL,
+		Workers:       1,
+		Logger:        zap.NewNop(),
+		Processor:     &NoOP{},
+		Name:          "TestApp",
+		MaxMessageAge: 10 * time.Minute,
+		AgeAlert:      sqsworker.WebhookAlert(server.URL),
+		AlertInterval: time.Hour,
+	})
+	w.Queue = queue
+
+	go func() {
+		alert := <-alerts
+		if alert.MessageID != "1" || alert.Name != "TestApp" || alert.Age < time.Hour {
+			t.Error("unexpected alert: ", alert)
+		}
+		time.Sleep(10 * time.Millisecond)
+		w.Close()
+	}()
+
+	w.Run()
+	if len(alerts) != 0 {
+		t.Error("Actual: ", len(alerts), "Expected: ", 0)
+	}
+}
+
+func TestAgeAlertAsync(t *testing.T) {
+	sent := time.Now().Add(-time.Hour).UnixNano() / int64(time.Millisecond)
+	queue := &StaticQueue{Message: &sqs.Message{
+		Body:       aws.String("old"),
+		MessageId:  aws.String("1"),
+		Attributes: map[string]*string{sqs.MessageSystemAttributeNameSentTimestamp: aws.String(fmt.Sprint(sent))},
+	}}
+	release := make(chan struct{})
+	var alerts int32
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:      workerQueueURL,
+		Workers:       1,
+		Logger:        zap.NewNop(),
+		Processor:     &NoOP{},
+		Name:          "TestApp",
+		MaxMessageAge: 10 * time.Minute,
+		AgeAlert: func(sqsworker.AgeAlert) error {
+			atomic.AddInt32(&alerts, 1)
+			<-release
+			return nil
+		},
+		AlertInterval: time.Nanosecond,
+	})
+	w.Queue = queue
+
+	go func() {
+		defer w.Close()
+		defer close(release)
+		// messages keep being processed while the first alert is blocked
+		deadline := time.Now().Add(time.Second)
+		for w.Stats().Processed < 2 || atomic.LoadInt32(&alerts) == 0 {
+			if time.Now().After(deadline) {
+				t.Error("polling stalled while the alert was sent, processed: ", w.Stats().Processed)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if actual := atomic.LoadInt32(&alerts); actual != 1 {
+			t.Error("Actual: ", actual, "Expected: ", 1)
+		}
+	}()
+
+	w.Run()
+}
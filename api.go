@@ -0,0 +1,75 @@
+package sqsworker
+
+import "sync/atomic"
+
+// DefaultPublishPrice is the price in dollars of an SNS publish, used to estimate costs. Each
+// message of a PublishBatch is billed as a publish.
+const DefaultPublishPrice = 0.50 / 1000000
+
+// APIUsage counts the requests a worker made with its Queue and Topic. Requests made by Sinks,
+// stores and the Outbox relay with their own clients are not counted.
+type APIUsage struct {
+	// Receives counts the ReceiveMessage requests, and EmptyReceives those that returned no
+	// messages
+	Receives      int64
+	EmptyReceives int64
+	Deletes       int64
+	// VisibilityChanges counts the ChangeMessageVisibility requests, including the batches
+	// returning received messages that were not processed
+	VisibilityChanges int64
+	Sends             int64
+	// QueueAttributes counts the GetQueueAttributes requests fetching the queue depth
+	QueueAttributes int64
+	Publishes       int64
+	// PublishBatches counts the PublishBatch requests, and PublishBatchEntries the messages
+	// published by them
+	PublishBatches      int64
+	PublishBatchEntries int64
+	// QueueLists counts the ListQueues and ListQueueTags requests of a Discovery
+	QueueLists int64
+}
+
+// SQSRequests is the number of requests made to SQS
+func (u APIUsage) SQSRequests() int64 {
+	return u.Receives + u.Deletes + u.VisibilityChanges + u.Sends + u.QueueAttributes + u.QueueLists
+}
+
+// SNSRequests is the number of publishes billed by SNS
+func (u APIUsage) SNSRequests() int64 {
+	return u.Publishes + u.PublishBatchEntries
+}
+
+// Cost estimates the cost of the requests in dollars at the DefaultRequestPrice and
+// DefaultPublishPrice, ignoring free tiers and payloads billed as several requests
+func (u APIUsage) Cost() float64 {
+	return float64(u.SQSRequests())*DefaultRequestPrice + float64(u.SNSRequests())*DefaultPublishPrice
+}
+
+// apiUsage holds the live request counters of a Worker
+type apiUsage struct {
+	receives            int64
+	emptyReceives       int64
+	deletes             int64
+	visibilityChanges   int64
+	sends               int64
+	queueAttributes     int64
+	queueLists          int64
+	publishes           int64
+	publishBatches      int64
+	publishBatchEntries int64
+}
+
+func (u *apiUsage) snapshot() APIUsage {
+	return APIUsage{
+		Receives:            atomic.LoadInt64(&u.receives),
+		EmptyReceives:       atomic.LoadInt64(&u.emptyReceives),
+		Deletes:             atomic.LoadInt64(&u.deletes),
+		VisibilityChanges:   atomic.LoadInt64(&u.visibilityChanges),
+		Sends:               atomic.LoadInt64(&u.sends),
+		QueueAttributes:     atomic.LoadInt64(&u.queueAttributes),
+		QueueLists:          atomic.LoadInt64(&u.queueLists),
+		Publishes:           atomic.LoadInt64(&u.publishes),
+		PublishBatches:      atomic.LoadInt64(&u.publishBatches),
+		PublishBatchEntries: atomic.LoadInt64(&u.publishBatchEntries),
+	}
+}
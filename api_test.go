@@ -0,0 +1,29 @@
+package sqsworker_test
+
+import (
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"testing"
+)
+
+func TestAPIUsage(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:           "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor:          &LowerCaseWorker{},
+		DeadLetterQueueURL: "https://sqs.us-east-1.amazonaws.com/88888888888/dlq",
+	})
+	h.Run(workertest.NewMessage("HELLO")).Succeeded().Deleted()
+	h.Worker.Processor = &FailingWorker{Err: sqsworker.Fatal(errPoison)}
+	h.Run(workertest.NewMessage("FAIL")).Failed().DeadLettered()
+	h.Worker.Processor = sqsworker.Split(splitManifest)
+	h.Run(workertest.NewMessage("12")).Succeeded()
+
+	usage := h.Worker.Stats().API
+	expected := sqsworker.APIUsage{Deletes: 3, Sends: 1, Publishes: 1, PublishBatches: 2, PublishBatchEntries: 12}
+	if usage != expected {
+		t.Error("Actual: ", usage, "Expected: ", expected)
+	}
+	if usage.SQSRequests() != 4 || usage.SNSRequests() != 13 || usage.Cost() != 4*sqsworker.DefaultRequestPrice+13*sqsworker.DefaultPublishPrice {
+		t.Error("unexpected requests: ", usage.SQSRequests(), usage.SNSRequests(), usage.Cost())
+	}
+}
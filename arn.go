@@ -0,0 +1,56 @@
+package sqsworker
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// QueueURLFromARN returns the url of the SQS queue with the arn, e.g.
+// https://sqs.eu-west-1.amazonaws.com/123456789012/orders for
+// arn:aws:sqs:eu-west-1:123456789012:orders
+func QueueURLFromARN(queueArn string) (string, error) {
+	a, err := parseARN(queueArn, sqs.EndpointsID)
+	if err != nil {
+		return "", err
+	}
+	endpoint, err := endpoints.DefaultResolver().EndpointFor(sqs.EndpointsID, a.Region)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s/%s", endpoint.URL, a.AccountID, a.Resource), nil
+}
+
+// parseARN parses the arn of a resource of the service
+func parseARN(s, service string) (arn.ARN, error) {
+	a, err := arn.Parse(s)
+	if err != nil {
+		return a, fmt.Errorf("sqsworker: invalid %s arn %q: %v", service, s, err)
+	}
+	if a.Service != service || a.Region == "" || a.AccountID == "" || a.Resource == "" {
+		return a, fmt.Errorf("sqsworker: %q is not the arn of an %s resource", s, service)
+	}
+	return a, nil
+}
+
+// regionConfig returns the config of the clients of the resource with the arn, in its region
+// when the session is configured for another region, or nil
+func regionConfig(sess *session.Session, s, service string) *aws.Config {
+	a, err := parseARN(s, service)
+	if err != nil || a.Region == aws.StringValue(sess.Config.Region) {
+		return nil
+	}
+	return aws.NewConfig().WithRegion(a.Region)
+}
+
+// topicConfigs returns the configs of the SNS client publishing to the topic
+func topicConfigs(sess *session.Session, topicArn string) []*aws.Config {
+	if config := regionConfig(sess, topicArn, sns.EndpointsID); config != nil {
+		return []*aws.Config{config}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package sqsworker_test
+
+import (
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestQueueURLFromARN(t *testing.T) {
+	tests := []struct {
+		arn      string
+		expected string
+	}{
+		{"arn:aws:sqs:eu-west-1:123456789012:orders", "https://sqs.eu-west-1.amazonaws.com/123456789012/orders"},
+		{"arn:aws-cn:sqs:cn-north-1:123456789012:orders.fifo", "https://sqs.cn-north-1.amazonaws.com.cn/123456789012/orders.fifo"},
+		{"arn:aws:sns:eu-west-1:123456789012:orders", ""},
+		{"orders", ""},
+	}
+	for _, test := range tests {
+		queueURL, err := sqsworker.QueueURLFromARN(test.arn)
+		if queueURL != test.expected || (err != nil) != (test.expected == "") {
+			t.Error(test.arn, "Actual: ", queueURL, err, "Expected: ", test.expected)
+		}
+	}
+}
+
+func TestQueueArn(t *testing.T) {
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueArn:  "arn:aws:sqs:eu-west-1:123456789012:orders",
+		TopicArn:  "arn:aws:sns:eu-west-2:123456789012:results",
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+	})
+	if expected := "https://sqs.eu-west-1.amazonaws.com/123456789012/orders"; w.QueueURL != expected {
+		t.Error("Actual: ", w.QueueURL, "Expected: ", expected)
+	}
+	if region := aws.StringValue(w.Queue.(*sqs.SQS).Config.Region); region != "eu-west-1" {
+		t.Error("Actual: ", region, "Expected: ", "eu-west-1")
+	}
+	if region := aws.StringValue(w.Topic.(*sns.SNS).Config.Region); region != "eu-west-2" {
+		t.Error("Actual: ", region, "Expected: ", "eu-west-2")
+	}
+}
+
+func TestQueueArnInvalid(t *testing.T) {
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueArn:  "arn:aws:sns:eu-west-1:123456789012:orders",
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+	})
+	w.Run()
+	if w.Err() == nil || w.Err() == sqsworker.ErrNoQueue {
+		t.Error("Actual: ", w.Err(), "Expected an invalid arn error")
+	}
+}
@@ -0,0 +1,252 @@
+package sqsworker
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Outcomes of the messages recorded by an AuditSink
+const (
+	AuditPublished = "published"
+	AuditProcessed = "processed"
+	AuditSkipped   = "skipped"
+	AuditFiltered  = "filtered"
+	AuditExpired   = "expired"
+	AuditDuplicate = "duplicate"
+	AuditFailed    = "failed"
+)
+
+// AuditRecord describes a message the worker handled
+type AuditRecord struct {
+	Time          time.Time `json:"time"`
+	Worker        string    `json:"worker"`
+	Queue         string    `json:"queue"`
+	MessageID     string    `json:"message_id"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	// Outcome is one of AuditPublished, AuditProcessed, AuditSkipped, AuditFiltered,
+	// AuditExpired, AuditDuplicate and AuditFailed
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+	// PublishID is the id of the published result
+	PublishID string `json:"publish_id,omitempty"`
+	Deleted   bool   `json:"deleted"`
+	// Duration of the Processor, and Total time handling the message
+	Duration time.Duration `json:"duration"`
+	Total    time.Duration `json:"total"`
+	// BodyHash is the hex SHA-256 of the message body, set when the worker's AuditBodyHash is
+	BodyHash string `json:"body_hash,omitempty"`
+}
+
+// AuditSink records every message a worker handled, once it is done with it. Audit is called
+// concurrently by the consumers, and its errors are logged without failing the message.
+type AuditSink interface {
+	Audit(ctx context.Context, record AuditRecord) error
+}
+
+// audit sends the record of a handled message to the AuditSink
+func (w *Worker) audit(ctx context.Context, state *consumerState, msg message, result Result, start time.Time) {
+	record := AuditRecord{
+		Time:          time.Now().UTC(),
+		Worker:        w.Name,
+		Queue:         msg.queueURL,
+		MessageID:     aws.StringValue(msg.MessageId),
+		CorrelationID: state.correlationID,
+		Deleted:       result.Deleted,
+		Duration:      result.Duration,
+	}
+	record.Total = time.Since(start)
+	switch {
+	case result.Err != nil:
+		record.Outcome = AuditFailed
+		record.Error = w.Redactor.Redact(result.Err.Error())
+	case result.Filtered:
+		record.Outcome = AuditFiltered
+	case result.Expired:
+		record.Outcome = AuditExpired
+	case result.Deduplicated:
+		record.Outcome = AuditDuplicate
+	case result.Skipped:
+		record.Outcome = AuditSkipped
+	case result.Published:
+		record.Outcome = AuditPublished
+	default:
+		record.Outcome = AuditProcessed
+	}
+	if result.Publish != nil {
+		record.PublishID = aws.StringValue(result.Publish.MessageId)
+	}
+	if w.AuditBodyHash && msg.Body != nil {
+		sum := sha256.Sum256([]byte(*msg.Body))
+		record.BodyHash = hex.EncodeToString(sum[:])
+	}
+	if err := w.Audit.Audit(ctx, record); err != nil {
+		w.logConsumerError(state, "audit failed!", err)
+	}
+}
+
+// auditEntry is an AuditRecord written by a JSONAudit, chained to the entry before it
+type auditEntry struct {
+	AuditRecord
+	// Prev is the Hash of the entry before, empty for the first entry
+	Prev string `json:"prev"`
+	// Hash is the hex HMAC-SHA256 of Prev and the JSON encoded record
+	Hash string `json:"hash"`
+}
+
+// ErrAuditKey is returned by a JSONAudit without a key
+var ErrAuditKey = errors.New("sqsworker: audit key is empty")
+
+// chainHash returns the keyed hash of a record following the entry with the prev hash
+func chainHash(key []byte, prev string, record []byte) string {
+	h := hmac.New(sha256.New, key)
+	io.WriteString(h, prev)
+	h.Write(record)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// JSONAudit is an AuditSink writing a line of JSON per record. Each line holds the HMAC of the
+// line before it, keyed with a secret kept away from the log, so a record that is changed,
+// removed or inserted afterwards breaks the chain checked by VerifyAudit. Records removed from
+// the end leave a valid chain, so the Head should be stored elsewhere as well.
+type JSONAudit struct {
+	mu   sync.Mutex
+	w    io.Writer
+	file *os.File
+	key  []byte
+	prev string
+}
+
+// NewJSONAudit creates a JSONAudit writing to w, chaining its records with the key
+func NewJSONAudit(w io.Writer, key []byte) *JSONAudit {
+	return &JSONAudit{w: w, key: key}
+}
+
+// StdoutAudit creates a JSONAudit writing to stdout
+func StdoutAudit(key []byte) *JSONAudit {
+	return NewJSONAudit(os.Stdout, key)
+}
+
+// OpenAuditFile opens a JSONAudit appending to the file at path, creating it when it does not
+// exist. The chain continues from the last record in the file.
+func OpenAuditFile(path string, key []byte) (*JSONAudit, error) {
+	if len(key) == 0 {
+		return nil, ErrAuditKey
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := lastAuditHash(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &JSONAudit{w: f, file: f, key: key, prev: prev}, nil
+}
+
+// lastAuditHash returns the hash of the last entry of an audit log
+func lastAuditHash(r io.Reader) (string, error) {
+	var prev string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return "", fmt.Errorf("sqsworker: invalid audit entry: %v", err)
+		}
+		prev = entry.Hash
+	}
+	return prev, scanner.Err()
+}
+
+// Audit writes the record
+func (a *JSONAudit) Audit(ctx context.Context, record AuditRecord) error {
+	if len(a.key) == 0 {
+		return ErrAuditKey
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entry := auditEntry{AuditRecord: record, Prev: a.prev, Hash: chainHash(a.key, a.prev, data)}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := a.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	a.prev = entry.Hash
+	return nil
+}
+
+// Head returns the hash of the last record written, empty before the first. Storing it outside
+// the log, e.g. periodically, lets VerifyAudit detect records removed from the end of the log.
+func (a *JSONAudit) Head() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.prev
+}
+
+// Close closes the file of a JSONAudit opened with OpenAuditFile
+func (a *JSONAudit) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// ErrAuditTampered is returned by VerifyAudit when the chain of an audit log is broken
+var ErrAuditTampered = errors.New("sqsworker: audit log was tampered with")
+
+// VerifyAudit checks the chain of an audit log written by a JSONAudit with the key, returning
+// the number of records. A record that was changed, removed or inserted fails with
+// ErrAuditTampered, as does a log without the record of a head returned by the JSONAudit's
+// Head, when it is not empty.
+func VerifyAudit(r io.Reader, key []byte, head string) (int, error) {
+	if len(key) == 0 {
+		return 0, ErrAuditKey
+	}
+	var prev string
+	anchored := head == ""
+	n := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return n, fmt.Errorf("sqsworker: invalid audit entry %d: %v", n+1, err)
+		}
+		data, err := json.Marshal(entry.AuditRecord)
+		if err != nil {
+			return n, err
+		}
+		if entry.Prev != prev || !hmac.Equal([]byte(entry.Hash), []byte(chainHash(key, prev, data))) {
+			return n, ErrAuditTampered
+		}
+		prev = entry.Hash
+		anchored = anchored || prev == head
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return n, err
+	}
+	if !anchored {
+		return n, ErrAuditTampered
+	}
+	return n, nil
+}
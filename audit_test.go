@@ -0,0 +1,122 @@
+package sqsworker_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// MemoryAudit collects the audit records
+type MemoryAudit struct {
+	mu      sync.Mutex
+	Records []sqsworker.AuditRecord
+}
+
+func (m *MemoryAudit) Audit(ctx context.Context, record sqsworker.AuditRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Records = append(m.Records, record)
+	return nil
+}
+
+func TestAudit(t *testing.T) {
+	audit := &MemoryAudit{}
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Name:     "orders",
+		TopicArn: "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			switch *m.Body {
+			case "fail":
+				return nil, errors.New("failed")
+			case "skip":
+				return nil, sqsworker.ErrSkip
+			}
+			return &sns.PublishInput{Message: m.Body}, nil
+		}),
+		Audit:         audit,
+		AuditBodyHash: true,
+	})
+
+	m := workertest.NewMessage("hello")
+	h.Run(m).Succeeded()
+	h.Run(workertest.NewMessage("fail")).Failed()
+	h.Run(workertest.NewMessage("skip")).Succeeded()
+
+	if len(audit.Records) != 3 {
+		t.Fatal("unexpected audit records: ", audit.Records)
+	}
+	sum := sha256.Sum256([]byte("hello"))
+	record := audit.Records[0]
+	if record.MessageID != *m.MessageId || record.Worker != "orders" || record.Outcome != sqsworker.AuditPublished ||
+		!record.Deleted || record.PublishID == "" || record.BodyHash != hex.EncodeToString(sum[:]) || record.Total < record.Duration {
+		t.Error("unexpected audit record: ", record)
+	}
+	if record := audit.Records[1]; record.Outcome != sqsworker.AuditFailed || record.Error != "failed" || record.Deleted {
+		t.Error("unexpected audit record: ", record)
+	}
+	if record := audit.Records[2]; record.Outcome != sqsworker.AuditSkipped || !record.Deleted {
+		t.Error("unexpected audit record: ", record)
+	}
+}
+
+func TestJSONAudit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqsworker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	key := []byte("secret")
+	if _, err := sqsworker.OpenAuditFile(path, nil); err != sqsworker.ErrAuditKey {
+		t.Error("Actual: ", err, "Expected: ", sqsworker.ErrAuditKey)
+	}
+
+	// Reopening the file continues the chain
+	var head string
+	for _, id := range []string{"1", "2", "3"} {
+		audit, err := sqsworker.OpenAuditFile(path, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := audit.Audit(context.Background(), sqsworker.AuditRecord{MessageID: id, Outcome: sqsworker.AuditProcessed}); err != nil {
+			t.Fatal(err)
+		}
+		head = audit.Head()
+		audit.Close()
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := sqsworker.VerifyAudit(bytes.NewReader(data), key, head); n != 3 || err != nil {
+		t.Fatal("Actual: ", n, err, "Expected: ", 3)
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	changed := strings.Replace(string(data), `"message_id":"2"`, `"message_id":"4"`, 1)
+	removed := lines[0] + lines[2]
+	truncated := lines[0] + lines[1]
+	for _, log := range []string{changed, removed, truncated} {
+		if _, err := sqsworker.VerifyAudit(strings.NewReader(log), key, head); err != sqsworker.ErrAuditTampered {
+			t.Error("Actual: ", err, "Expected: ", sqsworker.ErrAuditTampered)
+		}
+	}
+	// a chain rewritten without the key does not verify
+	if _, err := sqsworker.VerifyAudit(bytes.NewReader(data), []byte("guess"), ""); err != sqsworker.ErrAuditTampered {
+		t.Error("Actual: ", err, "Expected: ", sqsworker.ErrAuditTampered)
+	}
+}
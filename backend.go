@@ -0,0 +1,303 @@
+package sqsworker
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"strconv"
+	"time"
+)
+
+// ErrBackendUnsupported is returned by the SQS requests a Backend cannot serve
+var ErrBackendUnsupported = errors.New("sqsworker: not supported by the backend")
+
+// Backend is a message broker other than SQS that a worker consumes, so the same Processors,
+// middleware, retries and metrics run on other transports. Its messages are *sqs.Message: the
+// ReceiptHandle identifies a delivery to the Backend, and the Backend sets the MessageId, and
+// the SentTimestamp, ApproximateReceiveCount and MessageGroupId attributes it knows. Queues are
+// named by the worker's QueueURL, in the Backend's own terms.
+//
+// A received message that is neither deleted nor made visible again is redelivered once its
+// visibility timeout has passed, which is how the worker retries failed messages.
+type Backend interface {
+	// Receive returns up to max messages, waiting up to wait for the first one. The messages
+	// are hidden from other consumers for the visibility timeout, or the Backend's default
+	// when it is zero.
+	Receive(ctx context.Context, queue string, max int64, wait, visibility time.Duration) ([]*sqs.Message, error)
+	// Delete acknowledges a message that was processed
+	Delete(ctx context.Context, queue, receiptHandle string) error
+	// ChangeVisibility redelivers a message once the timeout has passed, immediately when it
+	// is zero
+	ChangeVisibility(ctx context.Context, queue, receiptHandle string, timeout time.Duration) error
+	// Send sends a message to a queue, returning its MessageId
+	Send(ctx context.Context, queue string, input *sqs.SendMessageInput) (string, error)
+}
+
+// DepthReporter is implemented by Backends that count the messages of their queues, for the
+// QueueDepthInterval
+type DepthReporter interface {
+	Depth(ctx context.Context, queue string) (QueueDepth, error)
+}
+
+// backendQueue serves the SQS requests a worker makes with a Backend. The queue management
+// requests fail with ErrBackendUnsupported, and the requests it does not define panic on the
+// nil SQSAPI.
+type backendQueue struct {
+	sqsiface.SQSAPI
+	backend Backend
+}
+
+// NewBackendQueue adapts a Backend to the Queue of a worker, as NewWorker does for the
+// Backend of its config. The requests made by the worker, Peek, DeleteMatching, QueueSinks
+// and Jobs are served by the Backend. Creating, purging, listing, tagging and configuring
+// queues fail with ErrBackendUnsupported.
+func NewBackendQueue(backend Backend) sqsiface.SQSAPI {
+	return &backendQueue{backend: backend}
+}
+
+// seconds converts a number of seconds to a duration, nil is zero
+func seconds(s *int64) time.Duration {
+	return time.Duration(aws.Int64Value(s)) * time.Second
+}
+
+func (q *backendQueue) receive(ctx context.Context, input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	max := aws.Int64Value(input.MaxNumberOfMessages)
+	if max <= 0 {
+		max = 1
+	}
+	messages, err := q.backend.Receive(ctx, aws.StringValue(input.QueueUrl), max, seconds(input.WaitTimeSeconds), seconds(input.VisibilityTimeout))
+	return &sqs.ReceiveMessageOutput{Messages: messages}, err
+}
+
+// ReceiveMessageRequest returns a request receiving from the Backend when it is sent, with the
+// context it is given
+func (q *backendQueue) ReceiveMessageRequest(input *sqs.ReceiveMessageInput) (*request.Request, *sqs.ReceiveMessageOutput) {
+	output := &sqs.ReceiveMessageOutput{}
+	req := request.New(aws.Config{}, metadata.ClientInfo{ServiceName: "backend"}, request.Handlers{}, nil,
+		&request.Operation{Name: "ReceiveMessage"}, input, output)
+	req.Handlers.Send.PushBack(func(r *request.Request) {
+		out, err := q.receive(r.Context(), input)
+		output.Messages = out.Messages
+		r.Error = err
+	})
+	return req, output
+}
+
+func (q *backendQueue) ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	return q.receive(context.Background(), input)
+}
+
+func (q *backendQueue) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	return q.receive(ctx, input)
+}
+
+func (q *backendQueue) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	return q.DeleteMessageWithContext(context.Background(), input)
+}
+
+func (q *backendQueue) DeleteMessageWithContext(ctx aws.Context, input *sqs.DeleteMessageInput, opts ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	err := q.backend.Delete(ctx, aws.StringValue(input.QueueUrl), aws.StringValue(input.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, err
+}
+
+// DeleteMessageBatch deletes the entries one at a time, reporting those that failed
+func (q *backendQueue) DeleteMessageBatch(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	out := &sqs.DeleteMessageBatchOutput{}
+	for _, entry := range input.Entries {
+		err := q.backend.Delete(context.Background(), aws.StringValue(input.QueueUrl), aws.StringValue(entry.ReceiptHandle))
+		if err != nil {
+			out.Failed = append(out.Failed, batchError(entry.Id, err))
+			continue
+		}
+		out.Successful = append(out.Successful, &sqs.DeleteMessageBatchResultEntry{Id: entry.Id})
+	}
+	return out, nil
+}
+
+func (q *backendQueue) ChangeMessageVisibility(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+	return q.ChangeMessageVisibilityWithContext(context.Background(), input)
+}
+
+func (q *backendQueue) ChangeMessageVisibilityWithContext(ctx aws.Context, input *sqs.ChangeMessageVisibilityInput, opts ...request.Option) (*sqs.ChangeMessageVisibilityOutput, error) {
+	err := q.backend.ChangeVisibility(ctx, aws.StringValue(input.QueueUrl), aws.StringValue(input.ReceiptHandle), seconds(input.VisibilityTimeout))
+	return &sqs.ChangeMessageVisibilityOutput{}, err
+}
+
+// ChangeMessageVisibilityBatch changes the visibility of the entries one at a time, reporting
+// those that failed
+func (q *backendQueue) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	out := &sqs.ChangeMessageVisibilityBatchOutput{}
+	for _, entry := range input.Entries {
+		err := q.backend.ChangeVisibility(context.Background(), aws.StringValue(input.QueueUrl), aws.StringValue(entry.ReceiptHandle), seconds(entry.VisibilityTimeout))
+		if err != nil {
+			out.Failed = append(out.Failed, batchError(entry.Id, err))
+			continue
+		}
+		out.Successful = append(out.Successful, &sqs.ChangeMessageVisibilityBatchResultEntry{Id: entry.Id})
+	}
+	return out, nil
+}
+
+func (q *backendQueue) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	return q.SendMessageWithContext(context.Background(), input)
+}
+
+func (q *backendQueue) SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	id, err := q.backend.Send(ctx, aws.StringValue(input.QueueUrl), input)
+	if err != nil {
+		return nil, err
+	}
+	return &sqs.SendMessageOutput{MessageId: aws.String(id)}, nil
+}
+
+// SendMessageBatch sends the entries one at a time, reporting those that failed
+func (q *backendQueue) SendMessageBatch(input *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	out := &sqs.SendMessageBatchOutput{}
+	for _, entry := range input.Entries {
+		id, err := q.backend.Send(context.Background(), aws.StringValue(input.QueueUrl), &sqs.SendMessageInput{
+			QueueUrl:               input.QueueUrl,
+			MessageBody:            entry.MessageBody,
+			MessageAttributes:      entry.MessageAttributes,
+			DelaySeconds:           entry.DelaySeconds,
+			MessageGroupId:         entry.MessageGroupId,
+			MessageDeduplicationId: entry.MessageDeduplicationId,
+		})
+		if err != nil {
+			out.Failed = append(out.Failed, batchError(entry.Id, err))
+			continue
+		}
+		out.Successful = append(out.Successful, &sqs.SendMessageBatchResultEntry{Id: entry.Id, MessageId: aws.String(id)})
+	}
+	return out, nil
+}
+
+// GetQueueAttributes returns the approximate message counts of Backends that are
+// DepthReporters
+func (q *backendQueue) GetQueueAttributes(input *sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error) {
+	return q.GetQueueAttributesWithContext(context.Background(), input)
+}
+
+func (q *backendQueue) GetQueueAttributesWithContext(ctx aws.Context, input *sqs.GetQueueAttributesInput, opts ...request.Option) (*sqs.GetQueueAttributesOutput, error) {
+	reporter, ok := q.backend.(DepthReporter)
+	if !ok {
+		return nil, ErrBackendUnsupported
+	}
+	depth, err := reporter.Depth(ctx, aws.StringValue(input.QueueUrl))
+	if err != nil {
+		return nil, err
+	}
+	return &sqs.GetQueueAttributesOutput{Attributes: map[string]*string{
+		sqs.QueueAttributeNameApproximateNumberOfMessages:           aws.String(strconv.FormatInt(depth.Visible, 10)),
+		sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible: aws.String(strconv.FormatInt(depth.NotVisible, 10)),
+		sqs.QueueAttributeNameApproximateNumberOfMessagesDelayed:    aws.String(strconv.FormatInt(depth.Delayed, 10)),
+	}}, nil
+}
+
+// GetQueueUrl returns the QueueName, the name of a queue is its url for a Backend
+func (q *backendQueue) GetQueueUrl(input *sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error) {
+	return &sqs.GetQueueUrlOutput{QueueUrl: input.QueueName}, nil
+}
+
+// The queue management requests are not supported, queues are managed with the Backend
+
+func (q *backendQueue) CreateQueue(input *sqs.CreateQueueInput) (*sqs.CreateQueueOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) CreateQueueWithContext(ctx aws.Context, input *sqs.CreateQueueInput, opts ...request.Option) (*sqs.CreateQueueOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) DeleteQueue(input *sqs.DeleteQueueInput) (*sqs.DeleteQueueOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) DeleteQueueWithContext(ctx aws.Context, input *sqs.DeleteQueueInput, opts ...request.Option) (*sqs.DeleteQueueOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) PurgeQueue(input *sqs.PurgeQueueInput) (*sqs.PurgeQueueOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) PurgeQueueWithContext(ctx aws.Context, input *sqs.PurgeQueueInput, opts ...request.Option) (*sqs.PurgeQueueOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) SetQueueAttributes(input *sqs.SetQueueAttributesInput) (*sqs.SetQueueAttributesOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) SetQueueAttributesWithContext(ctx aws.Context, input *sqs.SetQueueAttributesInput, opts ...request.Option) (*sqs.SetQueueAttributesOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) ListQueues(input *sqs.ListQueuesInput) (*sqs.ListQueuesOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) ListQueuesWithContext(ctx aws.Context, input *sqs.ListQueuesInput, opts ...request.Option) (*sqs.ListQueuesOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) ListQueueTags(input *sqs.ListQueueTagsInput) (*sqs.ListQueueTagsOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) ListQueueTagsWithContext(ctx aws.Context, input *sqs.ListQueueTagsInput, opts ...request.Option) (*sqs.ListQueueTagsOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) TagQueue(input *sqs.TagQueueInput) (*sqs.TagQueueOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) TagQueueWithContext(ctx aws.Context, input *sqs.TagQueueInput, opts ...request.Option) (*sqs.TagQueueOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) UntagQueue(input *sqs.UntagQueueInput) (*sqs.UntagQueueOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) UntagQueueWithContext(ctx aws.Context, input *sqs.UntagQueueInput, opts ...request.Option) (*sqs.UntagQueueOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) ListDeadLetterSourceQueues(input *sqs.ListDeadLetterSourceQueuesInput) (*sqs.ListDeadLetterSourceQueuesOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) ListDeadLetterSourceQueuesWithContext(ctx aws.Context, input *sqs.ListDeadLetterSourceQueuesInput, opts ...request.Option) (*sqs.ListDeadLetterSourceQueuesOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) AddPermission(input *sqs.AddPermissionInput) (*sqs.AddPermissionOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) AddPermissionWithContext(ctx aws.Context, input *sqs.AddPermissionInput, opts ...request.Option) (*sqs.AddPermissionOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) RemovePermission(input *sqs.RemovePermissionInput) (*sqs.RemovePermissionOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+func (q *backendQueue) RemovePermissionWithContext(ctx aws.Context, input *sqs.RemovePermissionInput, opts ...request.Option) (*sqs.RemovePermissionOutput, error) {
+	return nil, ErrBackendUnsupported
+}
+
+// batchError is the failed entry of a batch
+func batchError(id *string, err error) *sqs.BatchResultErrorEntry {
+	code := "BackendError"
+	if aerr, ok := err.(awserr.Error); ok {
+		code = aerr.Code()
+	}
+	return &sqs.BatchResultErrorEntry{Id: id, Code: aws.String(code), Message: aws.String(err.Error()), SenderFault: aws.Bool(false)}
+}
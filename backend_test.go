@@ -0,0 +1,162 @@
+package sqsworker_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ListBackend is a Backend keeping the messages of its queues in memory. Messages are never
+// redelivered, and the receipt handle "fail" cannot be deleted.
+type ListBackend struct {
+	mu      sync.Mutex
+	queues  map[string][]*sqs.Message
+	sent    int
+	Deleted chan string
+	Visible chan time.Duration
+}
+
+func NewListBackend(bodies ...string) *ListBackend {
+	b := &ListBackend{queues: make(map[string][]*sqs.Message), Deleted: make(chan string, 10), Visible: make(chan time.Duration, 10)}
+	for _, body := range bodies {
+		b.Send(context.Background(), "in", &sqs.SendMessageInput{MessageBody: aws.String(body)})
+	}
+	return b
+}
+
+func (b *ListBackend) Receive(ctx context.Context, queue string, max int64, wait, visibility time.Duration) ([]*sqs.Message, error) {
+	b.mu.Lock()
+	messages := b.queues[queue]
+	if int64(len(messages)) > max {
+		messages = messages[:max]
+	}
+	b.queues[queue] = b.queues[queue][len(messages):]
+	b.mu.Unlock()
+	if len(messages) == 0 {
+		sleep := time.NewTimer(10 * time.Millisecond)
+		defer sleep.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-sleep.C:
+		}
+	}
+	return messages, nil
+}
+
+func (b *ListBackend) Delete(ctx context.Context, queue, receiptHandle string) error {
+	if receiptHandle == "fail" {
+		return errors.New("delete failed")
+	}
+	b.Deleted <- receiptHandle
+	return nil
+}
+
+func (b *ListBackend) ChangeVisibility(ctx context.Context, queue, receiptHandle string, timeout time.Duration) error {
+	b.Visible <- timeout
+	return nil
+}
+
+func (b *ListBackend) Send(ctx context.Context, queue string, input *sqs.SendMessageInput) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sent++
+	id := strconv.Itoa(b.sent)
+	b.queues[queue] = append(b.queues[queue], &sqs.Message{MessageId: aws.String(id), ReceiptHandle: aws.String(id), Body: input.MessageBody})
+	return id, nil
+}
+
+// Bodies returns the bodies of the messages waiting in the queue
+func (b *ListBackend) Bodies(queue string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var bodies []string
+	for _, m := range b.queues[queue] {
+		bodies = append(bodies, aws.StringValue(m.Body))
+	}
+	return bodies
+}
+
+func TestBackend(t *testing.T) {
+	backend := NewListBackend("Hello", "World")
+	w := sqsworker.NewWorker(nil, sqsworker.WorkerConfig{
+		Backend:   backend,
+		QueueURL:  "in",
+		Processor: &LowerCaseWorker{},
+		Sink:      sqsworker.NewQueueSink(sqsworker.NewBackendQueue(backend), sqsworker.QueueConfig{QueueURL: "out"}),
+		Workers:   2,
+		Logger:    zap.NewNop(),
+	})
+	go w.Run()
+	defer w.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-backend.Deleted:
+		case <-time.After(time.Second):
+			t.Fatal("messages were not deleted")
+		}
+	}
+	w.Close()
+	<-w.Done()
+
+	bodies := backend.Bodies("out")
+	if len(bodies) != 2 || bodies[0]+bodies[1] != "helloworld" && bodies[0]+bodies[1] != "worldhello" {
+		t.Errorf("unexpected results %v", bodies)
+	}
+	if s := w.Stats(); s.Processed != 2 || s.API.Receives == 0 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestBackendQueue(t *testing.T) {
+	backend := NewListBackend()
+	queue := sqsworker.NewBackendQueue(backend)
+
+	out, err := queue.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String("in"),
+		Entries: []*sqs.DeleteMessageBatchRequestEntry{
+			{Id: aws.String("0"), ReceiptHandle: aws.String("1")},
+			{Id: aws.String("1"), ReceiptHandle: aws.String("fail")},
+		},
+	})
+	if err != nil || len(out.Successful) != 1 || len(out.Failed) != 1 || aws.StringValue(out.Failed[0].Id) != "1" {
+		t.Errorf("unexpected batch delete %v %v", out, err)
+	}
+
+	_, err = queue.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+		QueueUrl: aws.String("in"), ReceiptHandle: aws.String("1"), VisibilityTimeout: aws.Int64(30),
+	})
+	if timeout := <-backend.Visible; err != nil || timeout != 30*time.Second {
+		t.Errorf("unexpected visibility %v %v", timeout, err)
+	}
+
+	if _, err := queue.GetQueueAttributes(&sqs.GetQueueAttributesInput{QueueUrl: aws.String("in")}); err != sqsworker.ErrBackendUnsupported {
+		t.Errorf("expected the depth to be unsupported, got %v", err)
+	}
+	if _, err := queue.PurgeQueue(&sqs.PurgeQueueInput{QueueUrl: aws.String("in")}); err != sqsworker.ErrBackendUnsupported {
+		t.Errorf("expected purging to be unsupported, got %v", err)
+	}
+	if _, err := sqsworker.DiscoverQueues(queue, "", nil); err != sqsworker.ErrBackendUnsupported {
+		t.Errorf("expected listing queues to be unsupported, got %v", err)
+	}
+	if _, err := queue.CreateQueue(&sqs.CreateQueueInput{QueueName: aws.String("out")}); err != sqsworker.ErrBackendUnsupported {
+		t.Errorf("expected creating queues to be unsupported, got %v", err)
+	}
+
+	// a receive request waits for its context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := queue.ReceiveMessageRequest(&sqs.ReceiveMessageInput{QueueUrl: aws.String("in"), WaitTimeSeconds: aws.Int64(20)})
+	req.SetContext(ctx)
+	if err := req.Send(); err != context.Canceled {
+		t.Errorf("expected the receive to be canceled, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package sqsworker
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBackpressurePause time to wait before re-checking an overloaded worker
+const DefaultBackpressurePause = time.Second
+
+// Backpressure settings pause polling while handlers are slow or the process is using too much memory,
+// rather than receiving messages that will time out before they are processed.
+type Backpressure struct {
+	// MaxLatency is the handler latency above which polling pauses. Zero disables the check.
+	// The latency is the longer of the average age of the handlers in flight, and the average
+	// latency of recent handlers while any handler is in flight, so polling resumes once the
+	// slow handlers finish.
+	MaxLatency time.Duration
+	// MaxMemory is the resident memory of the process, in bytes, above which polling pauses.
+	// Zero disables the check.
+	MaxMemory uint64
+	// Pause is how long to wait before checking again, defaults to DefaultBackpressurePause
+	Pause time.Duration
+}
+
+// backpressure tracks the handlers in flight, and the latency of finished handlers as an
+// exponentially weighted moving average
+type backpressure struct {
+	config Backpressure
+	base   time.Time
+	mu     sync.Mutex
+	// average latency of finished handlers
+	average time.Duration
+	// inFlight number of handlers running, and started the sum of their start times since base
+	inFlight int64
+	started  time.Duration
+}
+
+func newBackpressure(config Backpressure) *backpressure {
+	if config.Pause == 0 {
+		config.Pause = DefaultBackpressurePause
+	}
+	return &backpressure{config: config, base: time.Now()}
+}
+
+// begin records a handler starting, returning its start time to pass to end
+func (b *backpressure) begin() time.Duration {
+	start := time.Since(b.base)
+	b.mu.Lock()
+	b.inFlight++
+	b.started += start
+	b.mu.Unlock()
+	return start
+}
+
+// end records a handler that started at start finishing
+func (b *backpressure) end(start time.Duration) {
+	d := time.Since(b.base) - start
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.inFlight--
+	b.started -= start
+	if b.average == 0 {
+		b.average = d
+	} else {
+		b.average += (d - b.average) / 8
+	}
+}
+
+// latency returns the current handler latency, zero when no handler is in flight
+func (b *backpressure) latency() time.Duration {
+	now := time.Since(b.base)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.inFlight == 0 {
+		return 0
+	}
+	age := now - b.started/time.Duration(b.inFlight)
+	if age > b.average {
+		return age
+	}
+	return b.average
+}
+
+// overloaded returns the reason polling should pause, or an empty string
+func (b *backpressure) overloaded() string {
+	if b.config.MaxLatency > 0 && b.latency() > b.config.MaxLatency {
+		return "handler latency"
+	}
+	if b.config.MaxMemory > 0 && memoryUsage() > b.config.MaxMemory {
+		return "memory usage"
+	}
+	return ""
+}
+
+// memoryUsage returns the resident set size of the process, falling back to the
+// memory obtained by the go runtime when /proc is unavailable.
+func memoryUsage() uint64 {
+	if statm, err := ioutil.ReadFile("/proc/self/statm"); err == nil {
+		fields := strings.Fields(string(statm))
+		if len(fields) > 1 {
+			if pages, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+				return pages * uint64(os.Getpagesize())
+			}
+		}
+	}
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.Sys
+}
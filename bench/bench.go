@@ -0,0 +1,227 @@
+// Package bench load tests a sqsworker.Worker end to end. It floods the worker's queue with
+// synthetic messages at a configurable rate and payload size and reports throughput and
+// latency percentiles, measured from the time each message was sent until its handler returned.
+//
+// The worker's Processor receives the synthetic payloads, so it should be able to handle
+// arbitrary bodies. Handler errors are counted in the report.
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMessages number of messages sent when Config.Messages is not set
+const DefaultMessages = 1000
+
+// DefaultPayloadSize size in bytes of each message body when Config.PayloadSize is not set
+const DefaultPayloadSize = 256
+
+// batchSize maximum entries in a SendMessageBatch request
+const batchSize = 10
+
+// Config settings for a benchmark run
+type Config struct {
+	// QueueURL to flood, defaults to the worker's QueueURL
+	QueueURL string
+	// Messages is the total number of messages to send
+	Messages int
+	// Rate is the number of messages sent per second, zero sends as fast as possible
+	Rate int
+	// PayloadSize is the size in bytes of each message body
+	PayloadSize int
+}
+
+// Report results of a benchmark run
+type Report struct {
+	Messages   int
+	Errors     int
+	Duration   time.Duration
+	Throughput float64
+	P50        time.Duration
+	P90        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("%d messages (%d errors) in %v: %.1f msg/s, p50 %v, p90 %v, p99 %v, max %v",
+		r.Messages, r.Errors, r.Duration, r.Throughput, r.P50, r.P90, r.P99, r.Max)
+}
+
+// recorder wraps the worker's Processor and records the latency of every message
+type recorder struct {
+	processor sqsworker.Processor
+	mu        sync.Mutex
+	latencies []time.Duration
+	errors    int
+	expected  int
+	done      chan struct{}
+}
+
+func (r *recorder) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	output, err := r.processor.Process(ctx, m)
+	sent, parseErr := sentTime(aws.StringValue(m.Body))
+	if parseErr != nil {
+		return output, err
+	}
+	latency := time.Since(sent)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.latencies) == r.expected {
+		return output, err
+	}
+	r.latencies = append(r.latencies, latency)
+	if err != nil {
+		r.errors++
+	}
+	if len(r.latencies) == r.expected {
+		close(r.done)
+	}
+	return output, err
+}
+
+// payload builds a message body carrying the send time, padded to size bytes
+func payload(sent time.Time, size int) string {
+	body := strconv.FormatInt(sent.UnixNano(), 10) + ":"
+	if pad := size - len(body); pad > 0 {
+		body += strings.Repeat("x", pad)
+	}
+	return body
+}
+
+func sentTime(body string) (time.Time, error) {
+	i := strings.IndexByte(body, ':')
+	if i < 0 {
+		return time.Time{}, errors.New("bench: message is not a benchmark payload")
+	}
+	nanos, err := strconv.ParseInt(body[:i], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, nanos), nil
+}
+
+func send(ctx context.Context, sqsc sqsiface.SQSAPI, cfg Config) error {
+	var interval time.Duration
+	if cfg.Rate > 0 {
+		interval = time.Second * batchSize / time.Duration(cfg.Rate)
+	}
+
+	for sent := 0; sent < cfg.Messages; sent += batchSize {
+		start := time.Now()
+		input := &sqs.SendMessageBatchInput{QueueUrl: aws.String(cfg.QueueURL)}
+		for i := sent; i < sent+batchSize && i < cfg.Messages; i++ {
+			input.Entries = append(input.Entries, &sqs.SendMessageBatchRequestEntry{
+				Id:          aws.String(strconv.Itoa(i)),
+				MessageBody: aws.String(payload(time.Now(), cfg.PayloadSize)),
+			})
+		}
+		out, err := sqsc.SendMessageBatch(input)
+		if err != nil {
+			return err
+		}
+		if len(out.Failed) > 0 {
+			return fmt.Errorf("bench: failed to send %d messages: %s", len(out.Failed), aws.StringValue(out.Failed[0].Message))
+		}
+
+		if interval > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(interval - time.Since(start)):
+			}
+		}
+	}
+	return nil
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
+
+// Run floods the queue using sqsc, runs the worker until every message has been processed
+// or ctx is done, then closes the worker and returns the report.
+func Run(ctx context.Context, sqsc sqsiface.SQSAPI, w *sqsworker.Worker, cfg Config) (*Report, error) {
+	if cfg.QueueURL == "" {
+		cfg.QueueURL = w.QueueURL
+	}
+	if cfg.Messages == 0 {
+		cfg.Messages = DefaultMessages
+	}
+	if cfg.PayloadSize == 0 {
+		cfg.PayloadSize = DefaultPayloadSize
+	}
+
+	rec := &recorder{
+		processor: w.Processor,
+		expected:  cfg.Messages,
+		done:      make(chan struct{}),
+	}
+	w.Processor = rec
+	defer func() { w.Processor = rec.processor }()
+
+	stopped := make(chan struct{})
+	go func() {
+		w.Run()
+		close(stopped)
+	}()
+
+	start := time.Now()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- send(ctx, sqsc, cfg)
+	}()
+
+	var err error
+	select {
+	case <-rec.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	case err = <-errs:
+		if err == nil {
+			select {
+			case <-rec.done:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+	}
+	duration := time.Since(start)
+	w.Close()
+	<-stopped
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	latencies := append([]time.Duration(nil), rec.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	report := &Report{
+		Messages:   len(latencies),
+		Errors:     rec.errors,
+		Duration:   duration,
+		Throughput: float64(len(latencies)) / duration.Seconds(),
+		P50:        percentile(latencies, 0.5),
+		P90:        percentile(latencies, 0.9),
+		P99:        percentile(latencies, 0.99),
+	}
+	if len(latencies) > 0 {
+		report.Max = latencies[len(latencies)-1]
+	}
+	return report, err
+}
@@ -0,0 +1,88 @@
+package bench_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/bench"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// newRequest returns a request that sends nothing, for mocked receives
+func newRequest() *request.Request {
+	return &request.Request{HTTPRequest: &http.Request{URL: &url.URL{}, Header: http.Header{}}}
+}
+
+const queueURL = "https://sqs.us-east-1.amazonaws.com/88888888888/In"
+
+type ChanQueue struct {
+	sqsiface.SQSAPI
+	Messages chan *sqs.Message
+}
+
+func (c *ChanQueue) SendMessageBatch(input *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	for _, entry := range input.Entries {
+		c.Messages <- &sqs.Message{Body: entry.MessageBody}
+	}
+	return &sqs.SendMessageBatchOutput{}, nil
+}
+
+func (c *ChanQueue) ReceiveMessageRequest(input *sqs.ReceiveMessageInput) (*request.Request, *sqs.ReceiveMessageOutput) {
+	out := &sqs.ReceiveMessageOutput{}
+	select {
+	case m := <-c.Messages:
+		out.Messages = append(out.Messages, m)
+	case <-time.After(time.Millisecond):
+	}
+	return newRequest(), out
+}
+
+func (c *ChanQueue) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (c *ChanQueue) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+}
+
+type NoOP struct {
+}
+
+func (n *NoOP) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	return nil, nil
+}
+
+func TestRun(t *testing.T) {
+	queue := &ChanQueue{Messages: make(chan *sqs.Message, 100)}
+	w := sqsworker.NewWorker(session.New(&aws.Config{Region: aws.String("us-east-1")}), sqsworker.WorkerConfig{
+		QueueURL:  queueURL,
+		Workers:   2,
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+		Name:      "TestApp",
+	})
+	w.Queue = queue
+
+	report, err := bench.Run(context.Background(), queue, w, bench.Config{Messages: 25, Rate: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Messages != 25 {
+		t.Error("Actual: ", report.Messages, "Expected: ", 25)
+	}
+	if report.P50 > report.P99 || report.P99 > report.Max {
+		t.Error("percentiles out of order: ", report)
+	}
+	if _, ok := w.Processor.(*NoOP); !ok {
+		t.Error("Processor was not restored")
+	}
+}
@@ -0,0 +1,143 @@
+package sqsworker
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultFalsePositiveRate is the rate of a BloomDedupStore created without one
+const DefaultFalsePositiveRate = 0.001
+
+// BloomDedupStore is an in-process DedupStore backed by bloom filters, for throughputs where
+// remembering every key is too expensive. It uses a fixed amount of memory, at the cost of
+// dropping a small share of messages whose keys were never seen.
+//
+// Keys are added to the current filter and looked up in the current and previous ones. The
+// filters rotate every window, or once the current one holds its capacity, so keys are
+// remembered for one to two windows.
+type BloomDedupStore struct {
+	mu       sync.Mutex
+	window   time.Duration
+	capacity int
+	current  *bloomFilter
+	previous *bloomFilter
+	rotated  time.Time
+}
+
+// NewBloomDedupStore creates a BloomDedupStore sized for capacity keys per window with the false
+// positive rate, by default DefaultFalsePositiveRate. The store uses about
+// 2 * -capacity * ln(rate) / ln(2)^2 bits.
+func NewBloomDedupStore(window time.Duration, capacity int, rate float64) *BloomDedupStore {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if rate <= 0 || rate >= 1 {
+		rate = DefaultFalsePositiveRate
+	}
+	bits := int(math.Ceil(-float64(capacity) * math.Log(rate) / (math.Ln2 * math.Ln2)))
+	hashes := int(math.Round(float64(bits) / float64(capacity) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &BloomDedupStore{
+		window:   window,
+		capacity: capacity,
+		current:  newBloomFilter(bits, hashes),
+		previous: newBloomFilter(bits, hashes),
+		rotated:  time.Now(),
+	}
+}
+
+// Seen reports whether the key was probably marked as seen in the last one to two windows
+func (b *BloomDedupStore) Seen(ctx context.Context, key string) (bool, error) {
+	h1, h2 := bloomHash(key)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rotate()
+	return b.current.has(h1, h2) || b.previous.has(h1, h2), nil
+}
+
+// MarkSeen adds the key to the current filter
+func (b *BloomDedupStore) MarkSeen(ctx context.Context, key string) error {
+	h1, h2 := bloomHash(key)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rotate()
+	b.current.add(h1, h2)
+	return nil
+}
+
+// rotate replaces the previous filter with the current one when the window passed or the
+// current filter is full
+func (b *BloomDedupStore) rotate() {
+	if time.Since(b.rotated) < b.window && b.current.count < b.capacity {
+		return
+	}
+	// two windows without a key forget everything
+	if time.Since(b.rotated) >= 2*b.window {
+		b.current.reset()
+	}
+	b.current, b.previous = b.previous, b.current
+	b.current.reset()
+	b.rotated = time.Now()
+}
+
+// bloomFilter is a fixed size bloom filter
+type bloomFilter struct {
+	bits   []uint64
+	hashes int
+	count  int
+}
+
+func newBloomFilter(bits, hashes int) *bloomFilter {
+	return &bloomFilter{bits: make([]uint64, (bits+63)/64), hashes: hashes}
+}
+
+// add sets the bits of a key, using double hashing to derive them from two hashes
+func (f *bloomFilter) add(h1, h2 uint64) {
+	n := uint64(len(f.bits) * 64)
+	for i := 0; i < f.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % n
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.count++
+}
+
+// has reports whether every bit of a key is set
+func (f *bloomFilter) has(h1, h2 uint64) bool {
+	n := uint64(len(f.bits) * 64)
+	for i := 0; i < f.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % n
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) reset() {
+	for i := range f.bits {
+		f.bits[i] = 0
+	}
+	f.count = 0
+}
+
+// bloomHash returns two hashes of a key without allocating: its 64-bit FNV-1a hash, and that
+// hash mixed by the splitmix64 finalizer
+func bloomHash(key string) (uint64, uint64) {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	m := h
+	m ^= m >> 30
+	m *= 0xbf58476d1ce4e5b9
+	m ^= m >> 27
+	m *= 0x94d049bb133111eb
+	m ^= m >> 31
+	// the step is never zero, so the hashes of a key set different bits
+	return h, m | 1
+}
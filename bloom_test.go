@@ -0,0 +1,64 @@
+package sqsworker_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"testing"
+	"time"
+)
+
+func TestBloomDedupStore(t *testing.T) {
+	ctx := context.Background()
+	store := sqsworker.NewBloomDedupStore(time.Hour, 10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		store.MarkSeen(ctx, fmt.Sprint("seen-", i))
+	}
+	for i := 0; i < 10000; i++ {
+		if seen, _ := store.Seen(ctx, fmt.Sprint("seen-", i)); !seen {
+			t.Fatal("Expected every marked key to be seen, missing ", i)
+		}
+	}
+
+	var falsePositives int
+	for i := 0; i < 10000; i++ {
+		if seen, _ := store.Seen(ctx, fmt.Sprint("unseen-", i)); seen {
+			falsePositives++
+		}
+	}
+	if falsePositives > 200 {
+		t.Error("Expected about 1% false positives, got ", falsePositives)
+	}
+}
+
+func TestBloomDedupStoreRotation(t *testing.T) {
+	ctx := context.Background()
+	store := sqsworker.NewBloomDedupStore(100*time.Millisecond, 100, 0)
+	store.MarkSeen(ctx, "order-1")
+
+	// keys are remembered for at least a window
+	time.Sleep(110 * time.Millisecond)
+	if seen, _ := store.Seen(ctx, "order-1"); !seen {
+		t.Error("Expected the key to be seen after one rotation")
+	}
+	time.Sleep(110 * time.Millisecond)
+	if seen, _ := store.Seen(ctx, "order-1"); seen {
+		t.Error("Expected the key to be forgotten after two rotations")
+	}
+}
+
+func TestBloomDedupWorker(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:   "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor:  &LowerCaseWorker{},
+		DedupStore: sqsworker.NewBloomDedupStore(time.Minute, 1000, 0),
+	})
+
+	order := workertest.Attribute(sqsworker.DefaultDedupAttr, "order-1")
+	h.Run(workertest.NewMessage("FIRST", order)).Succeeded().Deleted().Published("first")
+	h.Run(workertest.NewMessage("SECOND", order)).Succeeded().Deleted().NotPublished()
+	if stats := h.Worker.Stats(); stats.Deduplicated != 1 {
+		t.Error("unexpected stats: ", stats)
+	}
+}
@@ -0,0 +1,186 @@
+// Package chaos injects faults into a sqsworker.Worker so retry and dead-letter behavior can
+// be verified without breaking real AWS resources.
+//
+// An Injector wraps the worker's queue, topic and processor. Faults are controlled by a Config,
+// which can be changed at any time from tests or loaded from a JSON chaos config file:
+//
+//	injector := chaos.New(chaos.Config{ReceiveErrorRate: 0.1, HandlerLatency: time.Second})
+//	injector.Inject(w)
+//	go w.Run()
+//	injector.Set(chaos.Config{PublishErrorRate: 1})
+//
+package chaos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrInjected is returned by every injected failure
+var ErrInjected = errors.New("chaos: injected fault")
+
+// Duration is a time.Duration that is read from JSON as a string such as "1.5s"
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	*d = Duration(parsed)
+	return err
+}
+
+// MarshalJSON formats the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Config controls which faults are injected. Rates are probabilities between 0 and 1.
+type Config struct {
+	// ReceiveErrorRate fails ReceiveMessage requests
+	ReceiveErrorRate float64 `json:"receive_error_rate"`
+	// DeleteErrorRate fails DeleteMessage requests
+	DeleteErrorRate float64 `json:"delete_error_rate"`
+	// DeleteDelay delays every DeleteMessage request
+	DeleteDelay Duration `json:"delete_delay"`
+	// PublishErrorRate fails SNS Publish requests
+	PublishErrorRate float64 `json:"publish_error_rate"`
+	// HandlerErrorRate fails messages before they reach the Processor
+	HandlerErrorRate float64 `json:"handler_error_rate"`
+	// HandlerLatency is added before every call to the Processor
+	HandlerLatency Duration `json:"handler_latency"`
+}
+
+// Load reads a Config from a JSON chaos config file
+func Load(path string) (Config, error) {
+	var c Config
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+// Injector decides when to inject faults
+type Injector struct {
+	mu     sync.Mutex
+	config Config
+	rand   *rand.Rand
+}
+
+// New creates an Injector with the given config
+func New(c Config) *Injector {
+	return &Injector{config: c, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Set replaces the config, taking effect on the next call
+func (i *Injector) Set(c Config) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.config = c
+}
+
+// Config returns the current config
+func (i *Injector) Config() Config {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.config
+}
+
+// fail reports whether to inject a failure for the rate selected from the config
+func (i *Injector) fail(rate func(Config) float64) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	r := rate(i.config)
+	return r > 0 && i.rand.Float64() < r
+}
+
+// Inject wraps the worker's Queue, Topic and Processor
+func (i *Injector) Inject(w *sqsworker.Worker) {
+	w.Queue = i.Queue(w.Queue)
+	w.Topic = i.Topic(w.Topic)
+	w.Processor = i.Processor(w.Processor)
+}
+
+// Queue wraps an SQS client with receive and delete faults
+func (i *Injector) Queue(q sqsiface.SQSAPI) sqsiface.SQSAPI {
+	return &queue{q, i}
+}
+
+// Topic wraps an SNS client with publish faults
+func (i *Injector) Topic(t snsiface.SNSAPI) snsiface.SNSAPI {
+	return &topic{t, i}
+}
+
+// Processor wraps a Processor with handler latency and failures
+func (i *Injector) Processor(p sqsworker.Processor) sqsworker.Processor {
+	return &processor{p, i}
+}
+
+type queue struct {
+	sqsiface.SQSAPI
+	injector *Injector
+}
+
+func (q *queue) ReceiveMessageRequest(input *sqs.ReceiveMessageInput) (*request.Request, *sqs.ReceiveMessageOutput) {
+	if q.injector.fail(func(c Config) float64 { return c.ReceiveErrorRate }) {
+		return &request.Request{Error: ErrInjected}, &sqs.ReceiveMessageOutput{}
+	}
+	return q.SQSAPI.ReceiveMessageRequest(input)
+}
+
+func (q *queue) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	if delay := q.injector.Config().DeleteDelay; delay > 0 {
+		time.Sleep(time.Duration(delay))
+	}
+	if q.injector.fail(func(c Config) float64 { return c.DeleteErrorRate }) {
+		return nil, ErrInjected
+	}
+	return q.SQSAPI.DeleteMessage(input)
+}
+
+type topic struct {
+	snsiface.SNSAPI
+	injector *Injector
+}
+
+func (t *topic) Publish(input *sns.PublishInput) (*sns.PublishOutput, error) {
+	if t.injector.fail(func(c Config) float64 { return c.PublishErrorRate }) {
+		return nil, ErrInjected
+	}
+	return t.SNSAPI.Publish(input)
+}
+
+type processor struct {
+	sqsworker.Processor
+	injector *Injector
+}
+
+func (p *processor) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	if latency := p.injector.Config().HandlerLatency; latency > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(latency)):
+		}
+	}
+	if p.injector.fail(func(c Config) float64 { return c.HandlerErrorRate }) {
+		return nil, ErrInjected
+	}
+	return p.Processor.Process(ctx, m)
+}
@@ -0,0 +1,70 @@
+package chaos_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/chaos"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+type NoOP struct {
+}
+
+func (n *NoOP) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	return nil, nil
+}
+
+func TestHandlerFaults(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{Processor: &NoOP{}})
+	injector := chaos.New(chaos.Config{HandlerErrorRate: 1})
+	injector.Inject(h.Worker)
+
+	result := h.Run(workertest.NewMessage("hello")).Failed().Retried()
+	if result.Err != chaos.ErrInjected {
+		t.Error("Actual: ", result.Err, "Expected: ", chaos.ErrInjected)
+	}
+
+	injector.Set(chaos.Config{HandlerLatency: chaos.Duration(10 * time.Millisecond)})
+	start := time.Now()
+	h.Run(workertest.NewMessage("hello")).Succeeded().Deleted()
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Error("expected handler latency, took ", elapsed)
+	}
+}
+
+func TestQueueFaults(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{Processor: &NoOP{}})
+	injector := chaos.New(chaos.Config{DeleteErrorRate: 1, ReceiveErrorRate: 1})
+	injector.Inject(h.Worker)
+
+	h.Run(workertest.NewMessage("hello")).Failed().NotDeleted()
+
+	req, _ := h.Worker.Queue.ReceiveMessageRequest(&sqs.ReceiveMessageInput{})
+	if err := req.Send(); err != chaos.ErrInjected {
+		t.Error("Actual: ", err, "Expected: ", chaos.ErrInjected)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	f, err := ioutil.TempFile("", "chaos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"publish_error_rate": 0.5, "delete_delay": "250ms"}`)
+	f.Close()
+
+	c, err := chaos.Load(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.PublishErrorRate != 0.5 || time.Duration(c.DeleteDelay) != 250*time.Millisecond {
+		t.Error("unexpected config: ", c)
+	}
+}
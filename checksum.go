@@ -0,0 +1,123 @@
+package sqsworker
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// checksumError is a message whose MD5 checksum does not match its body or attributes
+type checksumError struct {
+	err error
+}
+
+func (c *checksumError) Error() string {
+	return c.err.Error()
+}
+
+// IsChecksumMismatch reports whether a message failed because its MD5 checksums did not match
+func IsChecksumMismatch(err error) bool {
+	if i, ok := err.(*invalidError); ok {
+		err = i.err
+	}
+	_, ok := err.(*checksumError)
+	return ok
+}
+
+// md5Hex returns the hex MD5 of data
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// md5OfMessageAttributes returns the MD5 SQS computes over message attributes: each attribute,
+// sorted by name, is encoded as its name, data type, transport type and value, with the
+// strings prefixed by their length
+func md5OfMessageAttributes(attributes map[string]*sqs.MessageAttributeValue) string {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf []byte
+	field := func(value []byte) {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(value)))
+		buf = append(append(buf, n[:]...), value...)
+	}
+	for _, name := range names {
+		value := attributes[name]
+		dataType := aws.StringValue(value.DataType)
+		field([]byte(name))
+		field([]byte(dataType))
+		if strings.HasPrefix(dataType, "Binary") {
+			buf = append(buf, 2)
+			field(value.BinaryValue)
+		} else {
+			buf = append(buf, 1)
+			field([]byte(aws.StringValue(value.StringValue)))
+		}
+	}
+	return md5Hex(buf)
+}
+
+// verifyChecksums checks the MD5 checksums of a body and its attributes returned by SQS.
+// Checksums that were not returned are not checked.
+func verifyChecksums(body *string, attributes map[string]*sqs.MessageAttributeValue, md5OfBody, md5OfAttributes *string) error {
+	if md5OfBody != nil {
+		if sum := md5Hex([]byte(aws.StringValue(body))); sum != *md5OfBody {
+			return &checksumError{fmt.Errorf("sqsworker: MD5 of body %s does not match %s", sum, *md5OfBody)}
+		}
+	}
+	if md5OfAttributes != nil && len(attributes) > 0 {
+		if sum := md5OfMessageAttributes(attributes); sum != *md5OfAttributes {
+			return &checksumError{fmt.Errorf("sqsworker: MD5 of message attributes %s does not match %s", sum, *md5OfAttributes)}
+		}
+	}
+	return nil
+}
+
+// verifyReceived checks the checksums of a received message
+func verifyReceived(m *sqs.Message) error {
+	if err := verifyChecksums(m.Body, m.MessageAttributes, m.MD5OfBody, m.MD5OfMessageAttributes); err != nil {
+		return &invalidError{err}
+	}
+	return nil
+}
+
+// sendChecked sends a message. When mismatch is set it is called with the error of checksums
+// SQS returns that do not match the message, which is stored all the same, so the send does not
+// fail and the message is not sent twice.
+func sendChecked(queue sqsiface.SQSAPI, input *sqs.SendMessageInput, mismatch func(error)) (*sqs.SendMessageOutput, error) {
+	out, err := queue.SendMessage(input)
+	if err != nil || mismatch == nil {
+		return out, err
+	}
+	if err := verifyChecksums(input.MessageBody, input.MessageAttributes, out.MD5OfMessageBody, out.MD5OfMessageAttributes); err != nil {
+		mismatch(err)
+	}
+	return out, nil
+}
+
+// sendMismatch counts and logs a message sent by the worker whose checksums did not match
+func (w *Worker) sendMismatch(err error) {
+	atomic.AddInt64(&w.stats.sendMismatches, 1)
+	w.logError("sent message checksum mismatch!", err)
+}
+
+// sendCheck is the mismatch callback of the messages the worker sends. They are only checked
+// with VerifyMD5, since its client does not check them, while the SDK does otherwise.
+func (w *Worker) sendCheck() func(error) {
+	if !w.VerifyMD5 {
+		return nil
+	}
+	return w.sendMismatch
+}
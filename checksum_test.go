@@ -0,0 +1,92 @@
+package sqsworker_test
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"testing"
+)
+
+// checksummedMessage returns a message with the checksums SQS sends, for a timestamp attribute
+func checksummedMessage(body string) *sqs.Message {
+	m := workertest.NewMessage(body)
+	m.MessageAttributes["timestamp"] = &sqs.MessageAttributeValue{DataType: aws.String("Number"), StringValue: aws.String("1493147359900")}
+	sum := md5.Sum([]byte(body))
+	m.MD5OfBody = aws.String(hex.EncodeToString(sum[:]))
+	m.MD5OfMessageAttributes = aws.String("235c5c510d26fb653d073faed50ae77c")
+	return m
+}
+
+func TestVerifyMD5(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{Processor: &NoOP{}, VerifyMD5: true})
+	h.Run(checksummedMessage("hello")).Succeeded().Deleted()
+
+	corrupted := checksummedMessage("hello")
+	corrupted.Body = aws.String("hellp")
+	result := h.Run(corrupted).Failed().Deleted()
+	if !sqsworker.IsChecksumMismatch(result.Err) {
+		t.Error("Expected a checksum mismatch, got: ", result.Err)
+	}
+
+	corrupted = checksummedMessage("hello")
+	corrupted.MessageAttributes["timestamp"].StringValue = aws.String("1493147359901")
+	h = workertest.New(t, sqsworker.WorkerConfig{
+		Processor:          &NoOP{},
+		VerifyMD5:          true,
+		QuarantineQueueURL: workertest.QueueURL + "-quarantine",
+	})
+	h.Run(corrupted).Failed().Quarantined()
+	if h.Worker.Stats().Quarantined != 1 {
+		t.Error("Actual: ", h.Worker.Stats().Quarantined, "Expected: ", 1)
+	}
+
+	// Without VerifyMD5 the checksums are not checked
+	h = workertest.New(t, sqsworker.WorkerConfig{Processor: &NoOP{}})
+	h.Run(corrupted).Succeeded()
+}
+
+// CorruptingQueue returns the checksum of another body for every message sent
+type CorruptingQueue struct {
+	workertest.Queue
+}
+
+func (c *CorruptingQueue) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	return &sqs.SendMessageOutput{MessageId: aws.String("sent"), MD5OfMessageBody: aws.String("d41d8cd98f00b204e9800998ecf8427e")}, nil
+}
+
+func TestVerifySent(t *testing.T) {
+	// A mismatch is counted, the message was stored so it is not failed and sent again
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor:    &EventWorker{Output: eventOutput},
+		Destinations: destinations,
+		Router:       routeByEvent,
+		VerifyMD5:    true,
+	})
+	h.Worker.Queue = &CorruptingQueue{}
+	h.Run(workertest.NewMessage("viewed", workertest.Attribute("event", "audit"))).Succeeded()
+	if actual := h.Worker.Stats().SendChecksumMismatches; actual != 1 {
+		t.Error("Actual: ", actual, "Expected: ", 1)
+	}
+
+	// Without VerifyMD5 the SDK checks the checksums
+	h = workertest.New(t, sqsworker.WorkerConfig{
+		Processor:    &EventWorker{Output: eventOutput},
+		Destinations: destinations,
+		Router:       routeByEvent,
+	})
+	h.Worker.Queue = &CorruptingQueue{}
+	h.Run(workertest.NewMessage("viewed", workertest.Attribute("event", "audit"))).Succeeded()
+	if actual := h.Worker.Stats().SendChecksumMismatches; actual != 0 {
+		t.Error("Actual: ", actual, "Expected: ", 0)
+	}
+
+	sink := sqsworker.NewQueueSink(&CorruptingQueue{}, sqsworker.QueueConfig{QueueURL: workertest.QueueURL + "-out", VerifyMD5: true})
+	if err := sink.Send(context.Background(), workertest.NewMessage("hello"), &sns.PublishInput{Message: aws.String("hello")}); err != nil || sink.ChecksumMismatches() != 1 {
+		t.Error("unexpected send: ", err, sink.ChecksumMismatches())
+	}
+}
@@ -0,0 +1,215 @@
+package sqsworker
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"sync/atomic"
+)
+
+// Diagnostic message attributes added to messages sent to the dead-letter or quarantine queue
+const (
+	// ErrorAttribute holds the error of the handler or Validator
+	ErrorAttribute = "Error"
+	// SourceQueueAttribute holds the url of the queue the message was received from
+	SourceQueueAttribute = "SourceQueue"
+	// SourceMessageIDAttribute holds the id of the message on the source queue
+	SourceMessageIDAttribute = "SourceMessageId"
+)
+
+// ErrSkip is returned by a Processor to acknowledge a message without publishing a result, for
+// messages it recognizes as irrelevant. The message is deleted and not counted as a failure.
+var ErrSkip = errors.New("sqsworker: skip message")
+
+// fatalError is an error the message cannot recover from on redelivery
+type fatalError struct {
+	err error
+}
+
+func (f *fatalError) Error() string {
+	return f.err.Error()
+}
+
+func (f *fatalError) Unwrap() error {
+	return f.err
+}
+
+// Fatal wraps an error returned by a Processor to send the message to the DeadLetterQueueURL
+// immediately, bypassing the remaining receives and the ErrorClassifier.
+func Fatal(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &fatalError{err}
+}
+
+// IsFatal reports whether the error, or an error it wraps, was wrapped with Fatal
+func IsFatal(err error) bool {
+	var fatal *fatalError
+	return errors.As(err, &fatal)
+}
+
+// Validator checks a message before it is processed
+type Validator func(*sqs.Message) error
+
+// invalidError is an error returned by a Validator
+type invalidError struct {
+	err error
+}
+
+func (i *invalidError) Error() string {
+	return i.err.Error()
+}
+
+func (i *invalidError) Unwrap() error {
+	return i.err
+}
+
+// Invalid wraps an error returned by a Processor for a malformed message, to send it to the
+// QuarantineQueueURL like messages failing the Validator
+func Invalid(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &invalidError{err}
+}
+
+// IsInvalid reports whether the error was returned by the Validator or a Decoder, was wrapped
+// with Invalid, or is a checksum mismatch, itself or wrapped in another error
+func IsInvalid(err error) bool {
+	var invalid *invalidError
+	return errors.As(err, &invalid)
+}
+
+// maxMessageAttributes is the number of message attributes SQS accepts per message
+const maxMessageAttributes = 10
+
+// Outcome is what happens to a message after its handler failed
+type Outcome int
+
+const (
+	// Retry leaves the message on the queue, to be received again after its visibility timeout
+	Retry Outcome = iota
+	// Drop deletes the message
+	Drop
+	// DLQ sends the message to the DeadLetterQueueURL and deletes it
+	DLQ
+	// Quarantine sends the message to the QuarantineQueueURL and deletes it
+	Quarantine
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Retry:
+		return "retry"
+	case Drop:
+		return "drop"
+	case DLQ:
+		return "dlq"
+	case Quarantine:
+		return "quarantine"
+	}
+	return "unknown"
+}
+
+// ErrorClassifier decides the Outcome of a handler error
+type ErrorClassifier func(error) Outcome
+
+// classify returns the Outcome of a handler error: Quarantine for invalid messages, DLQ for fatal
+// errors, otherwise Retry unless an ErrorClassifier says otherwise. Messages whose checksums did
+// not match are dropped without a QuarantineQueueURL.
+func (w *Worker) classify(err error) Outcome {
+	// a message corrupted in transit is dropped unless it can be quarantined
+	if IsChecksumMismatch(err) && w.QuarantineQueueURL == "" {
+		return Drop
+	}
+	if IsInvalid(err) {
+		return Quarantine
+	}
+	if IsFatal(err) {
+		return DLQ
+	}
+	if w.ErrorClassifier == nil {
+		return Retry
+	}
+	return w.ErrorClassifier(err)
+}
+
+// settle applies the Outcome of a failed message, reporting whether it was deleted
+func (w *Worker) settle(ctx context.Context, state *consumerState, msg message, err error) bool {
+	switch w.classify(err) {
+	case Drop:
+		atomic.AddInt64(&w.stats.dropped, 1)
+		return w.settleDelete(ctx, state, msg)
+	case DLQ:
+		if w.forward(state, msg, w.DeadLetterQueueURL, "dead-letter", err) {
+			atomic.AddInt64(&w.stats.deadLettered, 1)
+			return w.settleDelete(ctx, state, msg)
+		}
+	case Quarantine:
+		if w.forward(state, msg, w.QuarantineQueueURL, "quarantine", err) {
+			atomic.AddInt64(&w.stats.quarantined, 1)
+			return w.settleDelete(ctx, state, msg)
+		}
+	}
+	return false
+}
+
+// settleDelete deletes a failed message, reporting whether it was deleted
+func (w *Worker) settleDelete(ctx context.Context, state *consumerState, msg message) bool {
+	if err := w.delete(ctx, state, msg); err != nil {
+		w.logConsumerError(state, "delete message failed!", err)
+		return false
+	}
+	return true
+}
+
+// forward sends a copy of the message to another queue, reporting whether it was sent.
+// The message is retried when the queue is not configured or the send fails.
+func (w *Worker) forward(state *consumerState, msg message, queueURL, kind string, cause error) bool {
+	if queueURL == "" {
+		w.logConsumerError(state, "no "+kind+" queue configured, retrying!", cause)
+		return false
+	}
+	atomic.AddInt64(&w.stats.api.sends, 1)
+	if _, err := sendChecked(w.Queue, forwardInput(queueURL, msg, cause), w.sendCheck()); err != nil {
+		w.logConsumerError(state, "send to "+kind+" queue failed!", err)
+		return false
+	}
+	return true
+}
+
+// forwardInput copies a message to send to queueURL, adding the diagnostic attributes
+// while there is room for them
+func forwardInput(queueURL string, msg message, cause error) *sqs.SendMessageInput {
+	attributes := make(map[string]*sqs.MessageAttributeValue, len(msg.MessageAttributes)+3)
+	for name, value := range msg.MessageAttributes {
+		attributes[name] = value
+	}
+	diagnostics := []struct{ name, value string }{
+		{ErrorAttribute, cause.Error()},
+		{SourceQueueAttribute, msg.queueURL},
+		{SourceMessageIDAttribute, aws.StringValue(msg.MessageId)},
+	}
+	for _, d := range diagnostics {
+		if len(attributes) < maxMessageAttributes && d.value != "" {
+			attributes[d.name] = &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(d.value),
+			}
+		}
+	}
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:          aws.String(queueURL),
+		MessageBody:       msg.Body,
+		MessageAttributes: attributes,
+	}
+	// FIFO queues require a group, keep the one the message was sent with
+	if group, ok := msg.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]; ok {
+		input.MessageGroupId = group
+		input.MessageDeduplicationId = msg.MessageId
+	}
+	return input
+}
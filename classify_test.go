@@ -0,0 +1,160 @@
+package sqsworker_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"testing"
+)
+
+var errMalformed = errors.New("malformed")
+var errPoison = errors.New("poison")
+
+// FailingWorker fails every message with Err
+type FailingWorker struct {
+	Err error
+}
+
+func (f *FailingWorker) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	return nil, f.Err
+}
+
+func classify(err error) sqsworker.Outcome {
+	switch err {
+	case errMalformed:
+		return sqsworker.Drop
+	case errPoison:
+		return sqsworker.DLQ
+	}
+	return sqsworker.Retry
+}
+
+func TestErrorClassifier(t *testing.T) {
+	dlq := "https://sqs.us-east-1.amazonaws.com/88888888888/DLQ"
+	cases := []struct {
+		err     error
+		outcome sqsworker.Outcome
+	}{
+		{errors.New("timeout"), sqsworker.Retry},
+		{errMalformed, sqsworker.Drop},
+		{errPoison, sqsworker.DLQ},
+	}
+
+	for _, c := range cases {
+		t.Run(c.outcome.String(), func(t *testing.T) {
+			h := workertest.New(t, sqsworker.WorkerConfig{
+				Processor:          &FailingWorker{Err: c.err},
+				ErrorClassifier:    classify,
+				DeadLetterQueueURL: dlq,
+			})
+			result := h.Run(workertest.NewMessage("hello", workertest.Attribute("Kind", "test"))).Failed()
+
+			switch c.outcome {
+			case sqsworker.Retry:
+				result.Retried()
+			case sqsworker.Drop:
+				result.Deleted()
+				if len(result.Sent) != 0 {
+					t.Error("Expected dropped message not to be sent")
+				}
+			case sqsworker.DLQ:
+				result.Deleted().DeadLettered()
+				sent := result.Sent[0]
+				if actual := aws.StringValue(sent.MessageAttributes[sqsworker.ErrorAttribute].StringValue); actual != "poison" {
+					t.Error("Actual: ", actual, "Expected: ", "poison")
+				}
+				if actual := aws.StringValue(sent.MessageAttributes["Kind"].StringValue); actual != "test" {
+					t.Error("Actual: ", actual, "Expected: ", "test")
+				}
+			}
+		})
+	}
+}
+
+func TestErrorClassifierWithoutDLQ(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor:       &FailingWorker{Err: errPoison},
+		ErrorClassifier: classify,
+	})
+	h.Run(workertest.NewMessage("hello")).Failed().Retried()
+}
+
+func TestErrSkip(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:  "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: &FailingWorker{Err: sqsworker.ErrSkip},
+	})
+	h.Run(workertest.NewMessage("hello")).Succeeded().Deleted().NotPublished()
+
+	h.Worker.Processor = &FailingWorker{Err: fmt.Errorf("unknown event: %w", sqsworker.ErrSkip)}
+	h.Run(workertest.NewMessage("hello")).Succeeded().Deleted().NotPublished()
+
+	stats := h.Worker.Stats()
+	if stats.Skipped != 2 || stats.Failed != 0 || stats.Processed != 0 {
+		t.Error("unexpected stats: ", stats)
+	}
+}
+
+func TestFatal(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor:          &FailingWorker{Err: sqsworker.Fatal(errors.New("unsupported version"))},
+		ErrorClassifier:    func(error) sqsworker.Outcome { return sqsworker.Retry },
+		DeadLetterQueueURL: "https://sqs.us-east-1.amazonaws.com/88888888888/DLQ",
+	})
+	result := h.Run(workertest.NewMessage("hello")).Failed().Deleted().DeadLettered()
+
+	if !sqsworker.IsFatal(result.Err) {
+		t.Error("Expected a fatal error")
+	}
+	if actual := aws.StringValue(result.Sent[0].MessageAttributes[sqsworker.ErrorAttribute].StringValue); actual != "unsupported version" {
+		t.Error("Actual: ", actual, "Expected: ", "unsupported version")
+	}
+	if sqsworker.Fatal(nil) != nil {
+		t.Error("Expected Fatal(nil) to be nil")
+	}
+	if wrapped := fmt.Errorf("decoding: %w", sqsworker.Fatal(errMalformed)); !sqsworker.IsFatal(wrapped) {
+		t.Error("Expected a wrapped fatal error to be fatal")
+	}
+	if wrapped := fmt.Errorf("decoding: %w", sqsworker.Invalid(errMalformed)); !sqsworker.IsInvalid(wrapped) {
+		t.Error("Expected a wrapped invalid error to be invalid")
+	}
+}
+
+func TestValidatorQuarantine(t *testing.T) {
+	quarantine := "https://sqs.us-east-1.amazonaws.com/88888888888/Quarantine"
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: &HelloWorld{},
+		Callback:  func(sqsworker.Result) {},
+		Validator: func(m *sqs.Message) error {
+			if aws.StringValue(m.Body) == "" {
+				return errors.New("empty body")
+			}
+			return nil
+		},
+		QuarantineQueueURL: quarantine,
+	})
+	h.Run(workertest.NewMessage("hello")).Succeeded().Deleted()
+	result := h.Run(workertest.NewMessage("")).Failed().Deleted().Quarantined()
+
+	if !sqsworker.IsInvalid(result.Err) {
+		t.Error("Expected an invalid message error")
+	}
+	attributes := result.Sent[0].MessageAttributes
+	if actual := aws.StringValue(attributes[sqsworker.ErrorAttribute].StringValue); actual != "empty body" {
+		t.Error("Actual: ", actual, "Expected: ", "empty body")
+	}
+	if actual := aws.StringValue(attributes[sqsworker.SourceQueueAttribute].StringValue); actual != workertest.QueueURL {
+		t.Error("Actual: ", actual, "Expected: ", workertest.QueueURL)
+	}
+	if actual := aws.StringValue(attributes[sqsworker.SourceMessageIDAttribute].StringValue); actual != aws.StringValue(result.Message.MessageId) {
+		t.Error("Actual: ", actual, "Expected: ", aws.StringValue(result.Message.MessageId))
+	}
+	if stats := h.Worker.Stats(); stats.Quarantined != 1 || stats.Processed != 1 {
+		t.Error("unexpected stats: ", stats)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"time"
+)
+
+// Config is the JSON config file read by worker run
+type Config struct {
+	Name     string `json:"name"`
+	Region   string `json:"region"`
+	QueueURL string `json:"queue_url"`
+	TopicArn string `json:"topic_arn"`
+	Workers  int    `json:"workers"`
+	// VisibilityTimeout in seconds for received messages
+	VisibilityTimeout int64 `json:"visibility_timeout"`
+	// Timeout for each handler invocation, such as "30s"
+	Timeout string `json:"timeout"`
+	// GracePeriod given to in-flight messages on SIGINT or SIGTERM, such as "30s"
+	GracePeriod string `json:"grace_period"`
+	// Command is executed once per message, receiving the body on stdin and
+	// writing the result to stdout.
+	Command []string `json:"command"`
+	// Plugin is the path to a go plugin exporting a sqsworker.Processor named by Symbol
+	Plugin string `json:"plugin"`
+	Symbol string `json:"symbol"`
+	// AdminAddr is the address the admin API listens on, disabled when empty
+	AdminAddr string `json:"admin_addr"`
+	// AdminToken is the bearer token of the admin API, required unless it listens on localhost
+	AdminToken string `json:"admin_token"`
+}
+
+// DefaultSymbol name of the Processor looked up in a plugin
+const DefaultSymbol = "Processor"
+
+// LoadConfig reads and validates a config file
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+
+	if len(c.Command) == 0 && c.Plugin == "" {
+		return nil, errors.New("config: one of command or plugin is required")
+	}
+	if len(c.Command) > 0 && c.Plugin != "" {
+		return nil, errors.New("config: command and plugin are mutually exclusive")
+	}
+	if c.Symbol == "" {
+		c.Symbol = DefaultSymbol
+	}
+	if _, err := c.timeout(); err != nil {
+		return nil, err
+	}
+	if _, err := c.gracePeriod(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) timeout() (time.Duration, error) {
+	if c.Timeout == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(c.Timeout)
+}
+
+func (c *Config) gracePeriod() (time.Duration, error) {
+	if c.GracePeriod == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(c.GracePeriod)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ExecProcessor runs a command for every message. The message body is written to the
+// command's stdin and its stdout is published as the result. A non-zero exit status fails
+// the message. The message id and string attributes are passed as environment variables
+// SQS_MESSAGE_ID and SQS_ATTRIBUTE_<NAME>.
+type ExecProcessor struct {
+	Command []string
+	Timeout time.Duration
+}
+
+// Process runs the command for a single message
+func (e *ExecProcessor) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.Command[0], e.Command[1:]...)
+	cmd.Stdin = strings.NewReader(aws.StringValue(m.Body))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "SQS_MESSAGE_ID="+aws.StringValue(m.MessageId))
+	for name, attr := range m.MessageAttributes {
+		if attr.StringValue != nil {
+			cmd.Env = append(cmd.Env, "SQS_ATTRIBUTE_"+strings.ToUpper(name)+"="+*attr.StringValue)
+		}
+	}
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", e.Command[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return &sns.PublishInput{Message: aws.String(stdout.String())}, nil
+}
@@ -0,0 +1,142 @@
+// Command worker runs a sqsworker.Worker from a JSON config file, so handlers written in
+// any language can reuse the polling and retry machinery.
+//
+// Usage:
+//
+//	worker run -config worker.json
+//	worker peek -queue https://sqs.us-east-1.amazonaws.com/88888888888/In -n 10
+//
+// A config executes a command per message:
+//
+//	{
+//		"name": "resize",
+//		"region": "us-east-1",
+//		"queue_url": "https://sqs.us-east-1.amazonaws.com/88888888888/In",
+//		"topic_arn": "arn:aws:sns:us-east-1:88888888888:Out",
+//		"workers": 4,
+//		"visibility_timeout": 120,
+//		"timeout": "90s",
+//		"grace_period": "60s",
+//		"admin_addr": "localhost:8080",
+//		"command": ["python3", "handler.py"]
+//	}
+//
+// The message body is written to the command's stdin and its stdout is published to the
+// topic. Alternatively "plugin" loads a go plugin exporting a sqsworker.Processor. When
+// "admin_addr" is set, the admin API of sqsworker.AdminHandler is served on that address, with
+// "admin_token" as its bearer token. Without a token it only listens on localhost.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"os"
+	"plugin"
+)
+
+const usage = `usage: worker <command> [flags]
+
+commands:
+  run    run a worker from a config file
+  peek   print messages on a queue without consuming them
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "run":
+		err = run(os.Args[2:])
+	case "peek":
+		err = peek(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "worker:", err)
+		os.Exit(1)
+	}
+}
+
+func loadProcessor(c *Config) (sqsworker.Processor, error) {
+	if c.Plugin == "" {
+		timeout, _ := c.timeout()
+		return &ExecProcessor{Command: c.Command, Timeout: timeout}, nil
+	}
+
+	p, err := plugin.Open(c.Plugin)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(c.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	// Lookup returns a pointer to exported variables
+	if processor, ok := sym.(*sqsworker.Processor); ok {
+		return *processor, nil
+	}
+	if processor, ok := sym.(sqsworker.Processor); ok {
+		return processor, nil
+	}
+	return nil, fmt.Errorf("%s: %s is not a sqsworker.Processor", c.Plugin, c.Symbol)
+}
+
+func newSession(region string) (*session.Session, error) {
+	config := &aws.Config{}
+	if region != "" {
+		config.Region = aws.String(region)
+	}
+	return session.NewSession(config)
+}
+
+func run(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	path := flags.String("config", "worker.json", "path to the worker config file")
+	flags.Parse(args)
+
+	c, err := LoadConfig(*path)
+	if err != nil {
+		return err
+	}
+	processor, err := loadProcessor(c)
+	if err != nil {
+		return err
+	}
+	sess, err := newSession(c.Region)
+	if err != nil {
+		return err
+	}
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:          c.QueueURL,
+		TopicArn:          c.TopicArn,
+		Workers:           c.Workers,
+		VisibilityTimeout: c.VisibilityTimeout,
+		Processor:         processor,
+		Name:              c.Name,
+	})
+
+	if c.AdminAddr != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			if err := sqsworker.ServeAdmin(ctx, w, c.AdminAddr, c.AdminToken); err != nil {
+				fmt.Fprintln(os.Stderr, "worker: admin:", err)
+			}
+		}()
+	}
+
+	grace, _ := c.gracePeriod()
+	return sqsworker.RunUntilSignal(w, grace)
+}
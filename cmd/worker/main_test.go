@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestExecProcessor(t *testing.T) {
+	e := &ExecProcessor{Command: []string{"sh", "-c", `tr a-z A-Z; printf "$SQS_ATTRIBUTE_SUFFIX"`}}
+	m := &sqs.Message{
+		Body:      aws.String("hello"),
+		MessageId: aws.String("1"),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"suffix": {DataType: aws.String("String"), StringValue: aws.String("!")},
+		},
+	}
+	output, err := e.Process(context.Background(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := aws.StringValue(output.Message); result != "HELLO!" {
+		t.Error("Actual: ", result, "Expected: ", "HELLO!")
+	}
+}
+
+func TestExecProcessorFailure(t *testing.T) {
+	e := &ExecProcessor{Command: []string{"sh", "-c", "echo broken >&2; exit 3"}}
+	_, err := e.Process(context.Background(), &sqs.Message{Body: aws.String("")})
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+
+	e = &ExecProcessor{Command: []string{"sleep", "1"}, Timeout: 10 * time.Millisecond}
+	if _, err := e.Process(context.Background(), &sqs.Message{Body: aws.String("")}); err == nil {
+		t.Fatal("Expected timeout")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"name": "test", "workers": 2, "timeout": "5s", "command": ["cat"]}`)
+	f.Close()
+
+	c, err := LoadConfig(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Workers != 2 || c.Symbol != DefaultSymbol {
+		t.Error("unexpected config: ", c)
+	}
+	if timeout, _ := c.timeout(); timeout != 5*time.Second {
+		t.Error("Actual: ", timeout, "Expected: ", 5*time.Second)
+	}
+}
+
+func TestPrintMessages(t *testing.T) {
+	var out bytes.Buffer
+	printMessages(&out, []*sqs.Message{{
+		MessageId:  aws.String("1"),
+		Body:       aws.String("hello"),
+		Attributes: map[string]*string{sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String("3")},
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"b": {StringValue: aws.String("2")},
+			"a": {StringValue: aws.String("1")},
+		},
+	}})
+
+	expected := "MessageId: 1\nReceiveCount: 3\nAttribute a: 1\nAttribute b: 2\nBody: hello\n\n"
+	if out.String() != expected {
+		t.Errorf("Actual: %q Expected: %q", out.String(), expected)
+	}
+}
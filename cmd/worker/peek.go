@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"io"
+	"os"
+	"sort"
+)
+
+func peek(args []string) error {
+	flags := flag.NewFlagSet("peek", flag.ExitOnError)
+	queueURL := flags.String("queue", os.Getenv("QUEUE_URL"), "url of the queue to peek")
+	region := flags.String("region", "", "aws region of the queue")
+	n := flags.Int64("n", sqsworker.DefaultMaxNumberOfMessages, "maximum number of messages to peek, at most 10")
+	flags.Parse(args)
+
+	if *queueURL == "" {
+		return errors.New("peek: -queue is required")
+	}
+	sess, err := newSession(*region)
+	if err != nil {
+		return err
+	}
+
+	messages, err := sqsworker.Peek(*queueURL, *n, sqs.New(sess))
+	if err != nil {
+		return err
+	}
+	printMessages(os.Stdout, messages)
+	return nil
+}
+
+func printMessages(out io.Writer, messages []*sqs.Message) {
+	for _, m := range messages {
+		fmt.Fprintf(out, "MessageId: %s\n", aws.StringValue(m.MessageId))
+		fmt.Fprintf(out, "ReceiveCount: %s\n", aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]))
+
+		names := make([]string, 0, len(m.MessageAttributes))
+		for name := range m.MessageAttributes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(out, "Attribute %s: %s\n", name, aws.StringValue(m.MessageAttributes[name].StringValue))
+		}
+		fmt.Fprintf(out, "Body: %s\n\n", aws.StringValue(m.Body))
+	}
+}
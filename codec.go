@@ -0,0 +1,78 @@
+package sqsworker
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// ContentTypeAttribute is the message attribute holding the content type of a body
+const ContentTypeAttribute = "Content-Type"
+
+// JSONContentType is the content type of the JSON codec
+const JSONContentType = "application/json"
+
+// Codec serializes values to message bodies and back, in the format of its content type. The
+// worker's Codec decodes messages and encodes results for typed handlers, so wire formats such
+// as msgpack or CBOR slot in by implementing it. Formats that are not text, like those, must
+// encode their bodies, e.g. with base64, since message bodies are text.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(body []byte, v interface{}) error
+	ContentType() string
+}
+
+// JSON encodes values as JSON, and is the codec of workers without one
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(body []byte, v interface{}) error {
+	return json.Unmarshal(body, v)
+}
+
+func (jsonCodec) ContentType() string {
+	return JSONContentType
+}
+
+// ContextCodec returns the Codec of the worker processing a message, JSON for contexts not
+// created by a Worker or workers without a Codec
+func ContextCodec(ctx context.Context) Codec {
+	if c, ok := ctx.Value(metadataKey{}).(*messageContext); ok && c.codec != nil {
+		return c.codec
+	}
+	return JSON
+}
+
+// Unmarshal decodes the body of a message into v with the Codec of the worker processing it.
+// Bodies that cannot be decoded are Invalid, and quarantined.
+func Unmarshal(ctx context.Context, m *sqs.Message, v interface{}) error {
+	return Invalid(ContextCodec(ctx).Unmarshal([]byte(aws.StringValue(m.Body)), v))
+}
+
+// Marshal encodes v into a result with the Codec of the worker processing a message, setting
+// its content type in the ContentTypeAttribute. Values that cannot be encoded fail with a Fatal
+// error.
+func Marshal(ctx context.Context, v interface{}) (*sns.PublishInput, error) {
+	return encode(ContextCodec(ctx), v)
+}
+
+// encode encodes v into a result with a codec
+func encode(codec Codec, v interface{}) (*sns.PublishInput, error) {
+	body, err := codec.Marshal(v)
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	return &sns.PublishInput{
+		Message: aws.String(string(body)),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{
+			ContentTypeAttribute: {DataType: aws.String("String"), StringValue: aws.String(codec.ContentType())},
+		},
+	}, nil
+}
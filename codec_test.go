@@ -0,0 +1,75 @@
+package sqsworker_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"testing"
+)
+
+// Base64JSON is a custom codec, encoding values as base64 encoded JSON
+type Base64JSON struct{}
+
+func (Base64JSON) Marshal(v interface{}) ([]byte, error) {
+	body, err := json.Marshal(v)
+	return []byte(base64.StdEncoding.EncodeToString(body)), err
+}
+
+func (Base64JSON) Unmarshal(body []byte, v interface{}) error {
+	decoded, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decoded, v)
+}
+
+func (Base64JSON) ContentType() string {
+	return "application/x-base64-json"
+}
+
+type Greeting struct {
+	Name string `json:"name"`
+}
+
+// greet decodes a greeting and encodes a reply with the worker's codec
+func greet(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	var g Greeting
+	if err := sqsworker.Unmarshal(ctx, m, &g); err != nil {
+		return nil, err
+	}
+	return sqsworker.Marshal(ctx, Greeting{Name: "hello " + g.Name})
+}
+
+func TestCodec(t *testing.T) {
+	if sqsworker.ContextCodec(context.Background()) != sqsworker.JSON {
+		t.Error("Expected JSON outside of a worker")
+	}
+
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:           "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor:          sqsworker.ProcessorFunc(greet),
+		QuarantineQueueURL: workertest.QueueURL + "-quarantine",
+	})
+	result := h.Run(workertest.NewMessage(`{"name":"world"}`)).Succeeded().Published(`{"name":"hello world"}`)
+	if actual := aws.StringValue(result.Publishes[0].MessageAttributes[sqsworker.ContentTypeAttribute].StringValue); actual != sqsworker.JSONContentType {
+		t.Error("Actual: ", actual, "Expected: ", sqsworker.JSONContentType)
+	}
+	h.Run(workertest.NewMessage("not json")).Failed().Quarantined()
+
+	h = workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:  "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: sqsworker.ProcessorFunc(greet),
+		Codec:     Base64JSON{},
+	})
+	body, _ := Base64JSON{}.Marshal(Greeting{Name: "world"})
+	reply, _ := Base64JSON{}.Marshal(Greeting{Name: "hello world"})
+	result = h.Run(workertest.NewMessage(string(body))).Succeeded().Published(string(reply))
+	if actual := aws.StringValue(result.Publishes[0].MessageAttributes[sqsworker.ContentTypeAttribute].StringValue); actual != "application/x-base64-json" {
+		t.Error("unexpected content type: ", actual)
+	}
+}
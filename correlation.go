@@ -0,0 +1,44 @@
+package sqsworker
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// correlationID returns the correlation ID of a message, its MessageId when it has none
+func (w *Worker) correlationID(msg message) string {
+	if w.CorrelationAttr == "" {
+		return ""
+	}
+	if attr, ok := msg.MessageAttributes[w.CorrelationAttr]; ok && attr != nil && aws.StringValue(attr.StringValue) != "" {
+		return *attr.StringValue
+	}
+	return aws.StringValue(msg.MessageId)
+}
+
+// correlate returns the result with the correlation ID of its message as an attribute, unless
+// the Processor set the attribute. The result is copied to the consumer's output before it is
+// changed.
+func (w *Worker) correlate(state *consumerState, output *sns.PublishInput) *sns.PublishInput {
+	if state.correlationID == "" {
+		return output
+	}
+	if _, ok := output.MessageAttributes[w.CorrelationAttr]; ok {
+		return output
+	}
+
+	attributes := make(map[string]*sns.MessageAttributeValue, len(output.MessageAttributes)+1)
+	for name, value := range output.MessageAttributes {
+		attributes[name] = value
+	}
+	attributes[w.CorrelationAttr] = &sns.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(state.correlationID),
+	}
+	if output != &state.output {
+		state.output = *output
+		output = &state.output
+	}
+	output.MessageAttributes = attributes
+	return output
+}
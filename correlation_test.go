@@ -0,0 +1,58 @@
+package sqsworker_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"testing"
+)
+
+func TestCorrelationID(t *testing.T) {
+	var ids []string
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn: "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			ids = append(ids, sqsworker.CorrelationID(ctx))
+			return &sns.PublishInput{Message: m.Body}, nil
+		}),
+		CorrelationAttr: "CorrelationId",
+	})
+
+	result := h.Run(workertest.NewMessage("hello", workertest.Attribute("CorrelationId", "request-1"))).Succeeded().Published("hello")
+	if actual := aws.StringValue(result.Publishes[0].MessageAttributes["CorrelationId"].StringValue); actual != "request-1" {
+		t.Error("Actual: ", actual, "Expected: ", "request-1")
+	}
+
+	// Messages without an ID are given their MessageId
+	m := workertest.NewMessage("hello")
+	result = h.Run(m).Succeeded().Published("hello")
+	if actual := aws.StringValue(result.Publishes[0].MessageAttributes["CorrelationId"].StringValue); actual != *m.MessageId {
+		t.Error("Actual: ", actual, "Expected: ", *m.MessageId)
+	}
+	if len(ids) != 2 || ids[0] != "request-1" || ids[1] != *m.MessageId {
+		t.Error("unexpected correlation ids: ", ids)
+	}
+}
+
+func TestCorrelationIDLogged(t *testing.T) {
+	core, logs := observer.New(zap.ErrorLevel)
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			return nil, errors.New("failed")
+		}),
+		Logger:          zap.New(core),
+		CorrelationAttr: "CorrelationId",
+	})
+
+	h.Run(workertest.NewMessage("hello", workertest.Attribute("CorrelationId", "request-1"))).Failed()
+	entries := logs.All()
+	if len(entries) != 1 || entries[0].ContextMap()["correlation_id"] != "request-1" {
+		t.Error("unexpected log entries: ", entries)
+	}
+}
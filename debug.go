@@ -0,0 +1,95 @@
+package sqsworker
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"go.uber.org/zap"
+	"math"
+	"math/rand"
+	"sync/atomic"
+)
+
+// debugSample returns the fraction of messages traced
+func (w *Worker) debugSample() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&w.settings.debugSample))
+}
+
+// setDebugSample changes the fraction of messages traced
+func (w *Worker) setDebugSample(fraction float64) {
+	atomic.StoreUint64(&w.settings.debugSample, sampleBits(fraction))
+}
+
+// sampleBits returns the bits of a fraction limited to between 0 and 1
+func sampleBits(fraction float64) uint64 {
+	return math.Float64bits(math.Max(0, math.Min(1, fraction)))
+}
+
+// sampled reports whether the lifecycle of the next message is traced
+func (w *Worker) sampled() bool {
+	fraction := w.debugSample()
+	return fraction >= 1 || fraction > 0 && rand.Float64() < fraction
+}
+
+// debugFields returns the fields identifying a traced message in its log entries
+func (w *Worker) debugFields(state *consumerState, msg message) []zap.Field {
+	fields := []zap.Field{
+		zap.String("app", w.Name),
+		zap.String("queue", msg.queueURL),
+		zap.String("message_id", aws.StringValue(msg.MessageId)),
+	}
+	if state.stats != nil {
+		fields = append(fields, zap.Int("consumer", state.id))
+	}
+	if state.correlationID != "" {
+		fields = append(fields, zap.String("correlation_id", state.correlationID))
+	}
+	return fields
+}
+
+// traceReceived logs a traced message as it was received, with its attributes and its body
+// after the Redactor removed sensitive data from it
+func (w *Worker) traceReceived(state *consumerState, msg message) {
+	if w.Logger == nil {
+		return
+	}
+	attributes := make(map[string]string, len(msg.MessageAttributes))
+	for name, value := range msg.MessageAttributes {
+		if value.StringValue != nil {
+			attributes[name] = *value.StringValue
+		} else {
+			attributes[name] = aws.StringValue(value.DataType)
+		}
+	}
+	w.Logger.Info("debug: message received", append(w.debugFields(state, msg),
+		zap.Any("attributes", attributes),
+		zap.Any("system_attributes", aws.StringValueMap(msg.Attributes)),
+		zap.String("body", w.Redactor.Redact(aws.StringValue(msg.Body))),
+	)...)
+}
+
+// traceResult logs how a traced message was handled: how long the Processor took, where its
+// result was published and whether the message was deleted
+func (w *Worker) traceResult(state *consumerState, msg message, result Result) {
+	if w.Logger == nil {
+		return
+	}
+	fields := append(w.debugFields(state, msg),
+		zap.Duration("handler_duration", result.Duration),
+		zap.Bool("filtered", result.Filtered),
+		zap.Bool("expired", result.Expired),
+		zap.Bool("deduplicated", result.Deduplicated),
+		zap.Bool("published", result.Published),
+		zap.Bool("duplicate", result.Duplicate),
+		zap.Bool("deleted", result.Deleted),
+	)
+	if result.Output != nil {
+		fields = append(fields, zap.String("result", w.Redactor.Redact(aws.StringValue(result.Output.Message))))
+	}
+	if result.Publish != nil {
+		fields = append(fields, zap.String("publish_id", aws.StringValue(result.Publish.MessageId)))
+	}
+	if result.Err != nil {
+		_, field := w.errorField(result.Err)
+		fields = append(fields, field)
+	}
+	w.Logger.Info("debug: message handled", fields...)
+}
@@ -0,0 +1,54 @@
+package sqsworker_test
+
+import (
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"regexp"
+	"testing"
+)
+
+func TestDebugSample(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:    "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor:   &EventWorker{Output: func(m *sqs.Message) *sns.PublishInput { return &sns.PublishInput{Message: m.Body} }},
+		Logger:      zap.New(core),
+		DebugSample: 1,
+		Redactor:    &sqsworker.Redactor{Patterns: []*regexp.Regexp{regexp.MustCompile(`secret`)}},
+	})
+
+	m := workertest.NewMessage("a secret", workertest.Attribute("event", "order"))
+	h.Run(m).Succeeded().Deleted().Published("a secret")
+	received := logs.FilterMessage("debug: message received").All()
+	if len(received) != 1 {
+		t.Fatal("unexpected log entries: ", logs.All())
+	}
+	fields := received[0].ContextMap()
+	if fields["message_id"] != *m.MessageId || fields["body"] != "a [REDACTED]" {
+		t.Error("unexpected received entry: ", fields)
+	}
+	if attributes, _ := fields["attributes"].(map[string]string); attributes["event"] != "order" {
+		t.Error("unexpected attributes: ", fields["attributes"])
+	}
+
+	handled := logs.FilterMessage("debug: message handled").All()
+	if len(handled) != 1 {
+		t.Fatal("unexpected log entries: ", logs.All())
+	}
+	fields = handled[0].ContextMap()
+	if fields["published"] != true || fields["deleted"] != true || fields["result"] != "a [REDACTED]" {
+		t.Error("unexpected handled entry: ", fields)
+	}
+
+	// Tracing is turned off while the worker runs
+	h.Worker.Apply(sqsworker.Settings{DebugSample: aws.Float64(0)})
+	h.Run(workertest.NewMessage("hello")).Succeeded()
+	if n := logs.FilterMessage("debug: message received").Len(); n != 1 {
+		t.Error("Expected no more traced messages, got: ", n)
+	}
+}
@@ -0,0 +1,60 @@
+package sqsworker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"io/ioutil"
+)
+
+// Decoder transforms a message body before it is validated and processed, e.g. to unwrap the
+// base64 encoded, gzipped bodies CloudWatch Logs subscriptions deliver. Decoders compose in
+// order, so Base64 followed by Gzip decodes those bodies.
+type Decoder func([]byte) ([]byte, error)
+
+// Base64 decodes standard base64 encoded bodies
+func Base64(body []byte) ([]byte, error) {
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(body)))
+	n, err := base64.StdEncoding.Decode(decoded, body)
+	return decoded[:n], err
+}
+
+// Gzip decompresses gzipped bodies
+func Gzip(body []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// decode returns the message with its body decoded by the Decoders. The message itself is not
+// changed, so failed messages are forwarded with the body they were sent with. Bodies that
+// cannot be decoded are invalid, and quarantined like messages failing the Validator, unless the
+// error is temporary, e.g. a key or certificate failing to download, and the message is retried.
+func (w *Worker) decode(msg message) (message, error) {
+	body := []byte(*msg.Body)
+	for _, decoder := range w.Decoders {
+		var err error
+		if body, err = decoder(body); err != nil {
+			if temporary(err) {
+				return msg, err
+			}
+			return msg, &invalidError{err}
+		}
+	}
+
+	decoded := *msg.Message
+	decoded.Body = aws.String(string(body))
+	msg.Message = &decoded
+	return msg, nil
+}
+
+// temporary reports whether an error has a Temporary method reporting true, like net.Error
+func temporary(err error) bool {
+	var t interface{ Temporary() bool }
+	return errors.As(err, &t) && t.Temporary()
+}
@@ -0,0 +1,48 @@
+package sqsworker_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"testing"
+)
+
+// logsBody encodes a body like a CloudWatch Logs subscription
+func logsBody(t *testing.T, body string) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestDecoders(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:           "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor:          &LowerCaseWorker{},
+		Decoders:           []sqsworker.Decoder{sqsworker.Base64, sqsworker.Gzip},
+		QuarantineQueueURL: workertest.QueueURL + "-quarantine",
+	})
+
+	m := workertest.NewMessage(logsBody(t, `{"LOGEVENTS":[]}`))
+	body := *m.Body
+	h.Run(m).Succeeded().Deleted().Published(`{"logevents":[]}`)
+	if *m.Body != body {
+		t.Error("Expected the received message to be unchanged")
+	}
+
+	// Bodies that cannot be decoded are quarantined as they were received
+	for _, body := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("not gzip"))} {
+		result := h.Run(workertest.NewMessage(body)).Failed().Quarantined().NotPublished()
+		if !sqsworker.IsInvalid(result.Err) || aws.StringValue(result.Sent[0].MessageBody) != body {
+			t.Error("unexpected result: ", result.Err, result.Sent)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package sqsworker
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDedupAttr is the message attribute keying messages for deduplication when the worker
+// has no DedupKey. FIFO messages fall back to their MessageDeduplicationId.
+const DefaultDedupAttr = "MessageDeduplicationId"
+
+// DedupStore remembers the keys of the messages processed within a window, so duplicates sent
+// to a standard queue are not processed twice
+type DedupStore interface {
+	// Seen reports whether a message with the key was processed within the window
+	Seen(ctx context.Context, key string) (bool, error)
+	// MarkSeen records that a message with the key was processed
+	MarkSeen(ctx context.Context, key string) error
+}
+
+// dedupKey returns the key deduplicating a message, by default its DefaultDedupAttr attribute
+func (w *Worker) dedupKey(m *sqs.Message) string {
+	if w.DedupKey != nil {
+		return w.DedupKey(m)
+	}
+	if attr, ok := m.MessageAttributes[DefaultDedupAttr]; ok && attr.StringValue != nil {
+		return *attr.StringValue
+	}
+	return aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameMessageDeduplicationId])
+}
+
+// duplicate reports whether a message with the key was already processed. Messages are
+// processed when the DedupStore fails.
+func (w *Worker) duplicate(ctx context.Context, state *consumerState, key string) bool {
+	if key == "" {
+		return false
+	}
+	seen, err := w.DedupStore.Seen(ctx, key)
+	if err != nil {
+		w.logConsumerError(state, "dedup store failed!", err)
+	}
+	return seen
+}
+
+// markSeen records a processed message in the DedupStore
+func (w *Worker) markSeen(ctx context.Context, state *consumerState, key string) {
+	if err := w.DedupStore.MarkSeen(ctx, key); err != nil {
+		w.logConsumerError(state, "dedup store failed!", err)
+	}
+}
+
+// deduplicate deletes a duplicate message without processing it
+func (w *Worker) deduplicate(ctx context.Context, state *consumerState, msg message, result *Result) error {
+	atomic.AddInt64(&w.stats.deduplicated, 1)
+	result.Deduplicated = true
+	err := w.delete(ctx, state, msg)
+	if err != nil {
+		w.logConsumerError(state, "delete message failed!", err)
+	}
+	result.Deleted = err == nil
+	return err
+}
+
+// MemoryDedupStore is an in-process DedupStore with a rolling window. It only detects the
+// duplicates received by the same process.
+type MemoryDedupStore struct {
+	Window time.Duration
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	swept  time.Time
+}
+
+// NewMemoryDedupStore creates a MemoryDedupStore remembering keys for the window
+func NewMemoryDedupStore(window time.Duration) *MemoryDedupStore {
+	return &MemoryDedupStore{Window: window, seen: make(map[string]time.Time), swept: time.Now()}
+}
+
+// Seen reports whether the key was marked as seen within the window
+func (m *MemoryDedupStore) Seen(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	at, ok := m.seen[key]
+	return ok && time.Since(at) < m.Window, nil
+}
+
+// MarkSeen records the key as seen, forgetting the keys older than the window
+func (m *MemoryDedupStore) MarkSeen(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mark(key, time.Now())
+	return nil
+}
+
+// mark records the key as seen at now, forgetting the keys older than the window
+func (m *MemoryDedupStore) mark(key string, now time.Time) {
+	if now.Sub(m.swept) > m.Window {
+		for k, at := range m.seen {
+			if now.Sub(at) >= m.Window {
+				delete(m.seen, k)
+			}
+		}
+		m.swept = now
+	}
+	m.seen[key] = now
+}
+
+// repeat reports whether the key was seen within the window, marking it as seen
+func (m *MemoryDedupStore) repeat(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	at, ok := m.seen[key]
+	m.mark(key, now)
+	return ok && now.Sub(at) < m.Window
+}
+
+// observeDelivery counts the redeliveries of a message, and the repeats of its MessageId within
+// the DuplicateWindow
+func (w *Worker) observeDelivery(m *sqs.Message) {
+	if attributeInt(m.Attributes, sqs.MessageSystemAttributeNameApproximateReceiveCount) > 1 {
+		atomic.AddInt64(&w.stats.redelivered, 1)
+	}
+	if w.deliveries != nil && m.MessageId != nil && w.deliveries.repeat(*m.MessageId) {
+		atomic.AddInt64(&w.stats.repeatedIDs, 1)
+	}
+}
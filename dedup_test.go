@@ -0,0 +1,65 @@
+package sqsworker_test
+
+import (
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"testing"
+	"time"
+)
+
+func TestDedupWindow(t *testing.T) {
+	var results []sqsworker.Result
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:    "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor:   &LowerCaseWorker{},
+		DedupWindow: time.Minute,
+		Callback:    func(r sqsworker.Result) { results = append(results, r) },
+	})
+
+	order := workertest.Attribute(sqsworker.DefaultDedupAttr, "order-1")
+	h.Run(workertest.NewMessage("FIRST", order)).Succeeded().Deleted().Published("first")
+	h.Run(workertest.NewMessage("SECOND", order)).Succeeded().Deleted().NotPublished()
+	h.Run(workertest.NewMessage("OTHER", workertest.Attribute(sqsworker.DefaultDedupAttr, "order-2"))).Succeeded().Published("other")
+	h.Run(workertest.NewMessage("UNKEYED")).Succeeded().Published("unkeyed")
+	h.Run(workertest.NewMessage("UNKEYED")).Succeeded().Published("unkeyed")
+
+	if stats := h.Worker.Stats(); stats.Deduplicated != 1 || stats.Processed != 4 {
+		t.Error("unexpected stats: ", stats)
+	}
+	if len(results) != 5 || results[0].Deduplicated || !results[1].Deduplicated || !results[1].Deleted {
+		t.Error("unexpected results: ", results)
+	}
+}
+
+func TestDedupKeyFailed(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor:   &FailingWorker{Err: errPoison},
+		DedupWindow: time.Minute,
+		DedupKey:    sqsworker.AttributeKey("order"),
+	})
+
+	// A failed message is not remembered, so its redelivery is processed
+	m := workertest.NewMessage("order", workertest.Attribute("order", "1"))
+	h.Run(m).Failed().NotDeleted()
+	h.Run(m).Failed().NotDeleted()
+	if stats := h.Worker.Stats(); stats.Deduplicated != 0 || stats.Failed != 2 {
+		t.Error("unexpected stats: ", stats)
+	}
+}
+
+func TestDuplicateWindow(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor:       &FailingWorker{Err: errPoison},
+		DuplicateWindow: time.Minute,
+	})
+
+	m := workertest.NewMessage("order")
+	h.Run(m).Failed()
+	workertest.SystemAttribute(sqs.MessageSystemAttributeNameApproximateReceiveCount, "2")(m)
+	h.Run(m).Failed()
+	h.Run(workertest.NewMessage("order")).Failed()
+	if stats := h.Worker.Stats(); stats.Redelivered != 1 || stats.RepeatedIDs != 1 || stats.Deduplicated != 0 {
+		t.Error("unexpected stats: ", stats)
+	}
+}
@@ -0,0 +1,156 @@
+package sqsworker
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDeleteBackoff is the wait before the first delete retry, doubled after each attempt
+const DefaultDeleteBackoff = 100 * time.Millisecond
+
+// DeleteFailure describes a message that could not be deleted after every retry, so it will
+// be received again once its visibility timeout expires
+type DeleteFailure struct {
+	QueueURL      string
+	MessageID     string
+	ReceiptHandle string
+	Err           error
+	Time          time.Time
+}
+
+// DeleteFailureFunc is called for every message that could not be deleted
+type DeleteFailureFunc func(DeleteFailure)
+
+// delete removes a message from the queue it was received from, retrying up to DeleteRetries
+// times with exponential backoff until the context is done
+func (w *Worker) delete(ctx context.Context, state *consumerState, msg message) error {
+	state.queueURL = msg.queueURL
+	state.deleteInput.QueueUrl = &state.queueURL
+	state.deleteInput.ReceiptHandle = msg.ReceiptHandle
+	err := w.deleteMessage(&state.deleteInput)
+	if err == nil {
+		return nil
+	}
+
+	backoff := w.DeleteBackoff
+retry:
+	for attempt := 0; attempt < w.DeleteRetries && retryableDelete(err); attempt++ {
+		w.logConsumerError(state, "delete message failed, retrying!", err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			break retry
+		case <-timer.C:
+		}
+		backoff *= 2
+		if err = w.deleteMessage(&state.deleteInput); err == nil {
+			return nil
+		}
+	}
+
+	atomic.AddInt64(&w.stats.deleteErrors, 1)
+	if w.OnDeleteFailure != nil {
+		w.OnDeleteFailure(DeleteFailure{
+			QueueURL:      msg.queueURL,
+			MessageID:     aws.StringValue(msg.MessageId),
+			ReceiptHandle: aws.StringValue(msg.ReceiptHandle),
+			Err:           err,
+			Time:          time.Now(),
+		})
+	}
+	return err
+}
+
+// retryableDelete reports whether a delete error may succeed when retried. A receipt handle
+// rejected by SQS will be rejected again.
+func retryableDelete(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() != sqs.ErrCodeReceiptHandleIsInvalid
+	}
+	return true
+}
+
+// DeleteLog records the messages that could not be deleted, so they can be deleted later with
+// Flush. Its Record method is a DeleteFailureFunc.
+type DeleteLog struct {
+	mu       sync.Mutex
+	failures []DeleteFailure
+}
+
+// Record adds a failure to the log
+func (d *DeleteLog) Record(f DeleteFailure) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failures = append(d.failures, f)
+}
+
+// Failures returns the recorded failures not yet deleted by Flush
+func (d *DeleteLog) Failures() []DeleteFailure {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]DeleteFailure(nil), d.failures...)
+}
+
+// Flush deletes the recorded messages in batches, keeping the ones that fail again. Receipt
+// handles are only valid until the message is received again, so Flush should run well within
+// the visibility timeout.
+func (d *DeleteLog) Flush(sqsc sqsiface.SQSAPI) error {
+	d.mu.Lock()
+	failures := d.failures
+	d.failures = nil
+	d.mu.Unlock()
+
+	var remaining []DeleteFailure
+	var lastErr error
+	byQueue := make(map[string][]DeleteFailure)
+	for _, f := range failures {
+		byQueue[f.QueueURL] = append(byQueue[f.QueueURL], f)
+	}
+	for queueURL, queued := range byQueue {
+		for len(queued) > 0 {
+			n := len(queued)
+			if n > DefaultMaxNumberOfMessages {
+				n = DefaultMaxNumberOfMessages
+			}
+			batch := queued[:n]
+			queued = queued[n:]
+
+			input := &sqs.DeleteMessageBatchInput{QueueUrl: aws.String(queueURL)}
+			for i, f := range batch {
+				input.Entries = append(input.Entries, &sqs.DeleteMessageBatchRequestEntry{
+					Id:            aws.String(strconv.Itoa(i)),
+					ReceiptHandle: aws.String(f.ReceiptHandle),
+				})
+			}
+			out, err := sqsc.DeleteMessageBatch(input)
+			if err != nil {
+				lastErr = err
+				remaining = append(remaining, batch...)
+				continue
+			}
+			for _, failed := range out.Failed {
+				i, _ := strconv.Atoi(aws.StringValue(failed.Id))
+				f := batch[i]
+				f.Err = awserr.New(aws.StringValue(failed.Code), aws.StringValue(failed.Message), nil)
+				lastErr = f.Err
+				if !aws.BoolValue(failed.SenderFault) {
+					remaining = append(remaining, f)
+				}
+			}
+		}
+	}
+
+	d.mu.Lock()
+	d.failures = append(remaining, d.failures...)
+	d.mu.Unlock()
+	return lastErr
+}
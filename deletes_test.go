@@ -0,0 +1,93 @@
+package sqsworker_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"testing"
+	"time"
+)
+
+// FlakyDeleteQueue fails the first Failures deletes and records batch deletes
+type FlakyDeleteQueue struct {
+	*workertest.Queue
+	Failures int
+	Batches  []*sqs.DeleteMessageBatchInput
+}
+
+func (f *FlakyDeleteQueue) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	if f.Failures > 0 {
+		f.Failures--
+		return nil, errors.New("service unavailable")
+	}
+	return f.Queue.DeleteMessage(input)
+}
+
+func (f *FlakyDeleteQueue) DeleteMessageBatch(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	f.Batches = append(f.Batches, input)
+	return &sqs.DeleteMessageBatchOutput{}, nil
+}
+
+func TestDeleteRetries(t *testing.T) {
+	log := &sqsworker.DeleteLog{}
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor:       &NoOP{},
+		DeleteRetries:   2,
+		DeleteBackoff:   time.Millisecond,
+		OnDeleteFailure: log.Record,
+	})
+	queue := &FlakyDeleteQueue{Queue: h.Queue, Failures: 2}
+	h.Worker.Queue = queue
+	h.Run(workertest.NewMessage("hello")).Succeeded().Deleted()
+
+	queue.Failures = 3
+	m := workertest.NewMessage("hello")
+	h.Run(m).Failed().NotDeleted()
+
+	failures := log.Failures()
+	if len(failures) != 1 || failures[0].ReceiptHandle != *m.ReceiptHandle || failures[0].QueueURL != workertest.QueueURL {
+		t.Fatal("unexpected delete failures: ", failures)
+	}
+	if stats := h.Worker.Stats(); stats.DeleteErrors != 1 {
+		t.Error("Actual: ", stats.DeleteErrors, "Expected: ", 1)
+	}
+
+	if err := log.Flush(queue); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Batches) != 1 || aws.StringValue(queue.Batches[0].Entries[0].ReceiptHandle) != *m.ReceiptHandle {
+		t.Error("unexpected batch deletes: ", queue.Batches)
+	}
+	if len(log.Failures()) != 0 {
+		t.Error("Expected the flushed failures to be removed")
+	}
+}
+
+func TestDeleteRetriesCanceled(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor:     &NoOP{},
+		DeleteRetries: 3,
+		DeleteBackoff: time.Hour,
+	})
+	h.Worker.Queue = &FlakyDeleteQueue{Queue: h.Queue, Failures: 4}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan error)
+	go func() { done <- h.Worker.Handle(ctx, workertest.NewMessage("hello")) }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected the delete to fail")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the canceled context to stop the delete retries")
+	}
+	if stats := h.Worker.Stats(); stats.DeleteErrors != 1 {
+		t.Error("Actual: ", stats.DeleteErrors, "Expected: ", 1)
+	}
+}
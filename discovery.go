@@ -0,0 +1,337 @@
+package sqsworker
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDiscoveryInterval is how often a worker lists the queues of its Discovery
+const DefaultDiscoveryInterval = time.Minute
+
+// Discovery finds the input queues of a worker by listing them, e.g. for a queue per customer,
+// so queues created after the worker started are polled without redeploying it
+type Discovery struct {
+	// Prefix the names of the queues start with, every queue of the account when empty
+	Prefix string
+	// Tags the queues must have, with the same values
+	Tags map[string]string
+	// Interval defaults to DefaultDiscoveryInterval
+	Interval time.Duration
+}
+
+// DiscoverQueues lists the urls of the queues whose name starts with the prefix and that have
+// every tag, sorted
+func DiscoverQueues(sqsc sqsiface.SQSAPI, prefix string, tags map[string]string) ([]string, error) {
+	return discoverQueues(sqsc, prefix, tags, new(int64))
+}
+
+// discoverQueues lists the queues matching the prefix and tags, adding the requests it made to
+// requests
+func discoverQueues(sqsc sqsiface.SQSAPI, prefix string, tags map[string]string, requests *int64) ([]string, error) {
+	input := &sqs.ListQueuesInput{MaxResults: aws.Int64(1000)}
+	if prefix != "" {
+		input.QueueNamePrefix = aws.String(prefix)
+	}
+
+	var queueURLs []string
+	for {
+		atomic.AddInt64(requests, 1)
+		out, err := sqsc.ListQueues(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, queueURL := range aws.StringValueSlice(out.QueueUrls) {
+			if len(tags) > 0 {
+				atomic.AddInt64(requests, 1)
+				matched, err := queueTagged(sqsc, queueURL, tags)
+				if err != nil {
+					return nil, err
+				}
+				if !matched {
+					continue
+				}
+			}
+			queueURLs = append(queueURLs, queueURL)
+		}
+		if aws.StringValue(out.NextToken) == "" {
+			break
+		}
+		input.NextToken = out.NextToken
+	}
+	sort.Strings(queueURLs)
+	return queueURLs, nil
+}
+
+// queueTagged reports whether the queue has every tag
+func queueTagged(sqsc sqsiface.SQSAPI, queueURL string, tags map[string]string) (bool, error) {
+	out, err := sqsc.ListQueueTags(&sqs.ListQueueTagsInput{QueueUrl: aws.String(queueURL)})
+	if err != nil {
+		return false, err
+	}
+	for key, value := range tags {
+		if tag, ok := out.Tags[key]; !ok || aws.StringValue(tag) != value {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// QueueHandler creates the Processors of the discovered queues whose name matches its Pattern,
+// so a worker serves queues with different handlers
+type QueueHandler struct {
+	// Pattern matches queue names with the syntax of path.Match, e.g. "orders-*"
+	Pattern string
+	// New creates the Processor of a queue when it is first discovered. A queue whose Processor
+	// can't be created is not polled, and New is called again when the queue is next listed.
+	New func(queueURL string) (Processor, error)
+}
+
+// discoveredQueue is a queue found by a Discovery
+type discoveredQueue struct {
+	processor Processor
+	paused    bool
+	stats     *handlerStats
+}
+
+// discovered tracks the queues found by a Discovery and their pollers
+type discovered struct {
+	mu      sync.Mutex
+	queues  map[string]*discoveredQueue
+	pollers map[string]context.CancelFunc
+	// listed are the queue urls last listed, polled again when changed receives
+	listed  []string
+	changed chan struct{}
+}
+
+// stop stops polling the queue until it is listed again
+func (d *discovered) stop(queueURL string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if cancel, ok := d.pollers[queueURL]; ok {
+		cancel()
+		delete(d.pollers, queueURL)
+	}
+}
+
+// discover polls the queues listed by the Discovery, listing them again every Interval until
+// the context is done
+func (w *Worker) discover(ctx context.Context, out chan message, pollers *sync.WaitGroup) {
+	interval := w.Discovery.Interval
+	if interval == 0 {
+		interval = DefaultDiscoveryInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	w.discovered.mu.Lock()
+	if w.discovered.changed == nil {
+		w.discovered.changed = make(chan struct{}, 1)
+	}
+	changed := w.discovered.changed
+	w.discovered.mu.Unlock()
+	defer func() {
+		w.discovered.mu.Lock()
+		w.discovered.pollers = nil
+		w.discovered.mu.Unlock()
+	}()
+
+	for {
+		queueURLs, err := discoverQueues(w.Queue, w.Discovery.Prefix, w.Discovery.Tags, &w.stats.api.queueLists)
+		if err != nil {
+			w.logError("discover queues failed!", err)
+		} else {
+			w.pollDiscovered(ctx, out, queueURLs, pollers)
+		}
+
+		for waiting := true; waiting; {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				waiting = false
+			case <-changed:
+				w.discovered.mu.Lock()
+				listed := w.discovered.listed
+				w.discovered.mu.Unlock()
+				w.pollDiscovered(ctx, out, listed, pollers)
+			}
+		}
+	}
+}
+
+// pollDiscovered starts polling the queues that were not listed before or were resumed, and
+// stops polling those no longer listed. Their messages already received are still processed.
+func (w *Worker) pollDiscovered(ctx context.Context, out chan message, queueURLs []string, pollers *sync.WaitGroup) {
+	// the Processors of the new queues are created without the lock, QueueHandlers may be slow
+	// or read the worker's stats
+	w.discovered.mu.Lock()
+	var missing []string
+	for _, queueURL := range queueURLs {
+		if q, ok := w.discovered.queues[queueURL]; !ok || q.processor == nil {
+			missing = append(missing, queueURL)
+		}
+	}
+	w.discovered.mu.Unlock()
+	created := make(map[string]Processor, len(missing))
+	for _, queueURL := range missing {
+		processor, err := w.newQueueProcessor(queueURL)
+		if err != nil {
+			w.logError("create queue processor failed!", err)
+			continue
+		}
+		created[queueURL] = processor
+	}
+
+	w.discovered.mu.Lock()
+	defer w.discovered.mu.Unlock()
+	if w.discovered.queues == nil {
+		w.discovered.queues = make(map[string]*discoveredQueue)
+	}
+	if w.discovered.pollers == nil {
+		w.discovered.pollers = make(map[string]context.CancelFunc)
+	}
+	w.discovered.listed = queueURLs
+
+	listed := make(map[string]bool, len(queueURLs))
+	for _, queueURL := range queueURLs {
+		listed[queueURL] = true
+		q, ok := w.discovered.queues[queueURL]
+		if !ok {
+			q = &discoveredQueue{stats: newHandlerStats()}
+			w.discovered.queues[queueURL] = q
+		}
+		if q.processor == nil {
+			q.processor = created[queueURL]
+		}
+		if q.processor == nil {
+			continue
+		}
+		if _, ok := w.discovered.pollers[queueURL]; ok || q.paused {
+			continue
+		}
+		pollCtx, cancel := context.WithCancel(ctx)
+		w.discovered.pollers[queueURL] = cancel
+		w.logInfo("Polling discovered queue " + queueURL)
+		pollers.Add(1)
+		go func(queueURL string) {
+			defer pollers.Done()
+			w.pollQueues(pollCtx, out, []string{queueURL}, 0, pollers)
+		}(queueURL)
+	}
+	for queueURL, cancel := range w.discovered.pollers {
+		if !listed[queueURL] {
+			w.logInfo("Stopped polling queue " + queueURL)
+			cancel()
+			delete(w.discovered.pollers, queueURL)
+		}
+	}
+	for queueURL := range w.discovered.queues {
+		if !listed[queueURL] {
+			delete(w.discovered.queues, queueURL)
+		}
+	}
+}
+
+// newQueueProcessor creates the Processor of a discovered queue with the first QueueHandler
+// matching its name, by default it is the worker's Processor
+func (w *Worker) newQueueProcessor(queueURL string) (Processor, error) {
+	name := queueURL[strings.LastIndex(queueURL, "/")+1:]
+	for _, h := range w.QueueHandlers {
+		if matched, err := path.Match(h.Pattern, name); err != nil || !matched {
+			continue
+		}
+		return h.New(queueURL)
+	}
+	return w.Processor, nil
+}
+
+// queueProcessor returns the Processor of the queue a message was received from
+func (w *Worker) queueProcessor(queueURL string) Processor {
+	w.discovered.mu.Lock()
+	defer w.discovered.mu.Unlock()
+	if q, ok := w.discovered.queues[queueURL]; ok && q.processor != nil {
+		return q.processor
+	}
+	return w.Processor
+}
+
+// observeQueue counts a message processed from a discovered queue and records its latencies
+func (w *Worker) observeQueue(msg message, err error, duration time.Duration) {
+	w.discovered.mu.Lock()
+	q, ok := w.discovered.queues[msg.queueURL]
+	w.discovered.mu.Unlock()
+	if ok {
+		q.stats.observe(msg.Message, err, duration)
+	}
+}
+
+// PauseQueue stops polling a discovered queue while the worker keeps polling the others, until
+// ResumeQueue is called. Its messages already received are still processed. Only the queues of
+// a worker with a Discovery are paused, once they are discovered.
+func (w *Worker) PauseQueue(queueURL string) {
+	w.discovered.mu.Lock()
+	defer w.discovered.mu.Unlock()
+	q, ok := w.discovered.queues[queueURL]
+	if !ok {
+		return
+	}
+	q.paused = true
+	if cancel, ok := w.discovered.pollers[queueURL]; ok {
+		w.logInfo("Paused polling queue " + queueURL)
+		cancel()
+		delete(w.discovered.pollers, queueURL)
+	}
+}
+
+// ResumeQueue resumes polling a discovered queue paused by PauseQueue
+func (w *Worker) ResumeQueue(queueURL string) {
+	w.discovered.mu.Lock()
+	defer w.discovered.mu.Unlock()
+	q, ok := w.discovered.queues[queueURL]
+	if !ok || !q.paused {
+		return
+	}
+	q.paused = false
+	select {
+	case w.discovered.changed <- struct{}{}:
+	default:
+	}
+}
+
+// queueStats returns a snapshot of the counters of the discovered queues
+func (w *Worker) queueStats() map[string]QueueStats {
+	w.discovered.mu.Lock()
+	defer w.discovered.mu.Unlock()
+	if len(w.discovered.queues) == 0 {
+		return nil
+	}
+	queues := make(map[string]QueueStats, len(w.discovered.queues))
+	for queueURL, q := range w.discovered.queues {
+		queues[queueURL] = QueueStats{HandlerStats: q.stats.snapshot(), Paused: q.paused}
+	}
+	return queues
+}
+
+// Queues returns the urls of the queues the worker polls, those currently discovered and not
+// paused when it has a Discovery
+func (w *Worker) Queues() []string {
+	if w.Discovery == nil {
+		return w.QueueURLs
+	}
+	w.discovered.mu.Lock()
+	defer w.discovered.mu.Unlock()
+	queueURLs := make([]string, 0, len(w.discovered.pollers))
+	for queueURL := range w.discovered.pollers {
+		queueURLs = append(queueURLs, queueURL)
+	}
+	sort.Strings(queueURLs)
+	return queueURLs
+}
@@ -0,0 +1,220 @@
+package sqsworker_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"go.uber.org/zap"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// DiscoveryQueue lists its queues one per page, each queue holding the bodies of its messages
+type DiscoveryQueue struct {
+	sqsiface.SQSAPI
+	mu       sync.Mutex
+	Messages map[string][]string
+	Tags     map[string]map[string]*string
+}
+
+func (d *DiscoveryQueue) ListQueues(input *sqs.ListQueuesInput) (*sqs.ListQueuesOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var queueURLs []string
+	for queueURL := range d.Messages {
+		if strings.HasPrefix(queueURL, queueBase+aws.StringValue(input.QueueNamePrefix)) {
+			queueURLs = append(queueURLs, queueURL)
+		}
+	}
+	sort.Strings(queueURLs)
+	page, _ := strconv.Atoi(aws.StringValue(input.NextToken))
+	out := &sqs.ListQueuesOutput{}
+	if page < len(queueURLs) {
+		out.QueueUrls = aws.StringSlice(queueURLs[page : page+1])
+	}
+	if page+1 < len(queueURLs) {
+		out.NextToken = aws.String(strconv.Itoa(page + 1))
+	}
+	return out, nil
+}
+
+func (d *DiscoveryQueue) ListQueueTags(input *sqs.ListQueueTagsInput) (*sqs.ListQueueTagsOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return &sqs.ListQueueTagsOutput{Tags: d.Tags[*input.QueueUrl]}, nil
+}
+
+func (d *DiscoveryQueue) ReceiveMessageRequest(input *sqs.ReceiveMessageInput) (*request.Request, *sqs.ReceiveMessageOutput) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := &sqs.ReceiveMessageOutput{}
+	bodies := d.Messages[*input.QueueUrl]
+	if len(bodies) == 0 {
+		time.Sleep(time.Millisecond)
+		return newRequest(), out
+	}
+	d.Messages[*input.QueueUrl] = bodies[1:]
+	out.Messages = []*sqs.Message{{Body: aws.String(bodies[0])}}
+	return newRequest(), out
+}
+
+func (d *DiscoveryQueue) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	return nil, nil
+}
+
+func (d *DiscoveryQueue) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+}
+
+func (d *DiscoveryQueue) Set(queueURL string, bodies ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Messages[queueURL] = bodies
+}
+
+func (d *DiscoveryQueue) Remove(queueURL string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.Messages, queueURL)
+}
+
+func TestDiscoverQueues(t *testing.T) {
+	queue := &DiscoveryQueue{
+		Messages: map[string][]string{
+			queueBase + "customer-a": nil,
+			queueBase + "customer-b": nil,
+			queueBase + "customer-c": nil,
+			queueBase + "internal":   nil,
+		},
+		Tags: map[string]map[string]*string{
+			queueBase + "customer-a": {"team": aws.String("billing")},
+			queueBase + "customer-c": {"team": aws.String("billing"), "env": aws.String("prod")},
+		},
+	}
+
+	queueURLs, err := sqsworker.DiscoverQueues(queue, "customer-", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{queueBase + "customer-a", queueBase + "customer-b", queueBase + "customer-c"}
+	if !reflect.DeepEqual(queueURLs, expected) {
+		t.Error("Actual: ", queueURLs, "Expected: ", expected)
+	}
+
+	queueURLs, err = sqsworker.DiscoverQueues(queue, "", map[string]string{"team": "billing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{queueBase + "customer-a", queueBase + "customer-c"}
+	if !reflect.DeepEqual(queueURLs, expected) {
+		t.Error("Actual: ", queueURLs, "Expected: ", expected)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	queue := &DiscoveryQueue{Messages: map[string][]string{
+		queueBase + "customer-a": {"a1"},
+		queueBase + "internal":   {"i1"},
+	}}
+	handler := &RecordingWorker{Bodies: make(chan string, 10)}
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		Discovery: &sqsworker.Discovery{Prefix: "customer-", Interval: 10 * time.Millisecond},
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: handler,
+		Name:      "TestApp",
+	})
+	w.Queue = queue
+	go w.Run()
+	defer w.Close()
+
+	if body := <-handler.Bodies; body != "a1" {
+		t.Error("Actual: ", body, "Expected: ", "a1")
+	}
+	// a queue created while the worker runs is polled once it is listed
+	queue.Set(queueBase+"customer-b", "b1")
+	if body := <-handler.Bodies; body != "b1" {
+		t.Error("Actual: ", body, "Expected: ", "b1")
+	}
+
+	queue.Remove(queueBase + "customer-a")
+	expected := []string{queueBase + "customer-b"}
+	deadline := time.Now().Add(time.Second)
+	for !reflect.DeepEqual(w.Queues(), expected) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if queues := w.Queues(); !reflect.DeepEqual(queues, expected) {
+		t.Error("Actual: ", queues, "Expected: ", expected)
+	}
+	if lists := w.Stats().API.QueueLists; lists < 2 {
+		t.Error("Expected the queues to be listed again, listed ", lists)
+	}
+}
+
+func TestQueueHandlers(t *testing.T) {
+	queue := &DiscoveryQueue{Messages: map[string][]string{
+		queueBase + "orders-1":   {"o1"},
+		queueBase + "invoices-1": {"i1"},
+	}}
+	bodies := make(chan string, 10)
+	var w *sqsworker.Worker
+	handler := func(prefix string) sqsworker.QueueHandler {
+		return sqsworker.QueueHandler{
+			Pattern: prefix + "-*",
+			New: func(queueURL string) (sqsworker.Processor, error) {
+				// the Processors are created without holding the discovered queues
+				w.Stats()
+				return sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+					bodies <- prefix + ":" + *m.Body
+					return nil, nil
+				}), nil
+			},
+		}
+	}
+
+	w = sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		Discovery:     &sqsworker.Discovery{Interval: 10 * time.Millisecond},
+		QueueHandlers: []sqsworker.QueueHandler{handler("orders"), handler("invoices")},
+		Workers:       1,
+		Logger:        zap.NewNop(),
+		Name:          "TestApp",
+	})
+	w.Queue = queue
+	go w.Run()
+	defer w.Close()
+
+	received := []string{<-bodies, <-bodies}
+	sort.Strings(received)
+	expected := []string{"invoices:i1", "orders:o1"}
+	if !reflect.DeepEqual(received, expected) {
+		t.Error("Actual: ", received, "Expected: ", expected)
+	}
+
+	orders := queueBase + "orders-1"
+	w.PauseQueue(orders)
+	queue.Set(orders, "o2")
+	select {
+	case body := <-bodies:
+		t.Error("Expected the paused queue not to be polled, received ", body)
+	case <-time.After(50 * time.Millisecond):
+	}
+	stats := w.Stats().Queues[orders]
+	if !stats.Paused || stats.Processed != 1 {
+		t.Error("Actual: ", stats, "Expected the paused queue to have processed 1 message")
+	}
+
+	w.ResumeQueue(orders)
+	if body := <-bodies; body != "orders:o2" {
+		t.Error("Actual: ", body, "Expected: ", "orders:o2")
+	}
+}
@@ -13,7 +13,7 @@
 // To use this package, you must implement the following interface:
 //
 //	type Processor interface {
-//		Process(context.Context, *sqs.Message, *sns.PublishInput) error
+//		Process(context.Context, *sqs.Message) (*sns.PublishInput, error)
 //	}
 //
 // For example:
@@ -30,9 +30,8 @@
 //	type LowerCaseWorker struct {
 //	}
 //
-//	func (l *LowerCaseWorker) Process(ctx context.Context, m *sqs.Message, w *sns.PublishInput) error {
-//		*w.Message = strings.ToLower(*m.Body)
-//		return nil
+//	func (l *LowerCaseWorker) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+//		return &sns.PublishInput{Message: aws.String(strings.ToLower(*m.Body))}, nil
 //	}
 //	func ExampleWorker() {
 //		lowerCaseWorker := &LowerCaseWorker{}
@@ -55,11 +54,32 @@
 // define an outbound topic, and number of concurrent workers. If the number of workers
 // is not set, the number of workers defaults to runtime.NumCPU().  There are helper functions
 // provided for getting or creating topcis and queues.
-// The worker will send messages to the TopicArn on successful runs.
+// The worker will send messages to the TopicArn on successful runs. The Processor returns the full
+// PublishInput, so it can set a subject and message attributes, and a TopicArn to publish to another topic.
+// Returning nil publishes nothing.
 //
 // Concurrency
 //
 // The Process function defined by the Processor interface will be called concurrently by multiple workers depending on the configuration.
 // It is best to ensure that Process functions can be executed concurrently.
 //
+// Shutdown
+//
+// RunUntilSignal runs a worker until SIGINT or SIGTERM is received, then stops polling and
+// finishes the messages already received within a grace period before returning.
+//
+// Priority Queues
+//
+// Multiple input queues can be set with QueueURLs, in strict priority order. A lower priority
+// queue is only polled when every queue ahead of it is empty, and only the last queue is long-polled.
+// Set StarvationLimit to poll the lower priority queues after that many consecutive receives from the
+// highest priority queue. Set QueueWeights instead to interleave the queues in proportion to their
+// weights, so a flooded queue cannot starve the others.
+//
+// Backends
+//
+// Set a Backend to consume a broker other than SQS, such as Pub/Sub with the pubsub package,
+// or channels of the process with the inproc package.
+// The worker's concurrency, retries, middleware and metrics are the same whatever the transport.
+//
 package sqsworker
@@ -0,0 +1,144 @@
+package sqsworker
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultEventBuffer is the number of events buffered for a subscriber by Events
+const DefaultEventBuffer = 1024
+
+// EventType is the stage of the lifecycle of a message an Event reports
+type EventType int
+
+const (
+	// EventReceived is sent when a message is handed to a consumer
+	EventReceived EventType = iota
+	// EventPublished is sent once the result of a message was published
+	EventPublished
+	// EventDeleted is sent once a message was deleted from its queue
+	EventDeleted
+	// EventSucceeded is sent when a message was handled without an error
+	EventSucceeded
+	// EventFailed is sent when handling a message failed, with the error
+	EventFailed
+	// EventPollerError is sent when receiving messages failed, with the error
+	EventPollerError
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventReceived:
+		return "received"
+	case EventPublished:
+		return "published"
+	case EventDeleted:
+		return "deleted"
+	case EventSucceeded:
+		return "succeeded"
+	case EventFailed:
+		return "failed"
+	case EventPollerError:
+		return "poller-error"
+	}
+	return "unknown"
+}
+
+// Event reports a stage of the lifecycle of a message, or an error of the poller
+type Event struct {
+	Type     EventType
+	Time     time.Time
+	QueueURL string
+	// MessageID is empty for poller errors
+	MessageID string
+	// Err is set for EventFailed and EventPollerError
+	Err error
+}
+
+// subscribers holds the channels events are sent to
+type subscribers struct {
+	mu     sync.RWMutex
+	n      int32
+	chans  []chan Event
+	closed bool
+}
+
+// Events subscribes to the lifecycle events of the worker's messages, buffering up to buffer
+// events, DefaultEventBuffer when it is 0. Events are dropped rather than holding up the
+// worker when the buffer is full, and counted in Stats.DroppedEvents. The channel is closed
+// once the worker stopped running.
+func (w *Worker) Events(buffer int) <-chan Event {
+	if buffer <= 0 {
+		buffer = DefaultEventBuffer
+	}
+	c := make(chan Event, buffer)
+	s := &w.subscribers
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		close(c)
+		return c
+	}
+	s.chans = append(s.chans, c)
+	atomic.AddInt32(&s.n, 1)
+	return c
+}
+
+// emit sends an event to every subscriber
+func (w *Worker) emit(t EventType, msg message, err error) {
+	s := &w.subscribers
+	if atomic.LoadInt32(&s.n) == 0 {
+		return
+	}
+	e := Event{Type: t, Time: time.Now(), QueueURL: msg.queueURL, Err: err}
+	if msg.Message != nil {
+		e.MessageID = aws.StringValue(msg.MessageId)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	for _, c := range s.chans {
+		select {
+		case c <- e:
+		default:
+			atomic.AddInt64(&w.stats.droppedEvents, 1)
+		}
+	}
+}
+
+// emitResult sends the events of a handled message
+func (w *Worker) emitResult(msg message, result Result) {
+	if atomic.LoadInt32(&w.subscribers.n) == 0 {
+		return
+	}
+	if result.Published && !result.Duplicate {
+		w.emit(EventPublished, msg, nil)
+	}
+	if result.Deleted {
+		w.emit(EventDeleted, msg, nil)
+	}
+	if result.Err != nil {
+		w.emit(EventFailed, msg, result.Err)
+	} else {
+		w.emit(EventSucceeded, msg, nil)
+	}
+}
+
+// closeEvents closes the channels of the subscribers once the worker stopped
+func (w *Worker) closeEvents() {
+	s := &w.subscribers
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for _, c := range s.chans {
+		close(c)
+	}
+}
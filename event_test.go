@@ -0,0 +1,109 @@
+package sqsworker_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn: "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			if *m.Body == "fail" {
+				return nil, errors.New("failed")
+			}
+			return &sns.PublishInput{Message: m.Body}, nil
+		}),
+	})
+	events := h.Worker.Events(0)
+
+	m, failed := workertest.NewMessage("hello"), workertest.NewMessage("fail")
+	h.Run(m).Succeeded()
+	h.Run(failed).Failed()
+	h.Worker.Close()
+
+	expected := []struct {
+		Type      sqsworker.EventType
+		MessageID string
+	}{
+		{sqsworker.EventReceived, *m.MessageId},
+		{sqsworker.EventPublished, *m.MessageId},
+		{sqsworker.EventDeleted, *m.MessageId},
+		{sqsworker.EventSucceeded, *m.MessageId},
+		{sqsworker.EventReceived, *failed.MessageId},
+		{sqsworker.EventFailed, *failed.MessageId},
+	}
+	var actual []sqsworker.Event
+	for e := range events {
+		actual = append(actual, e)
+	}
+	if len(actual) != len(expected) {
+		t.Fatal("unexpected events: ", actual)
+	}
+	for i, e := range actual {
+		if e.Type != expected[i].Type || e.MessageID != expected[i].MessageID {
+			t.Error("Actual: ", e.Type, e.MessageID, "Expected: ", expected[i].Type, expected[i].MessageID)
+		}
+	}
+	if err := actual[5].Err; err == nil || err.Error() != "failed" {
+		t.Error("Expected the error of the failed message, got: ", err)
+	}
+
+	// Subscribers after the worker stopped get a closed channel
+	if _, ok := <-h.Worker.Events(0); ok {
+		t.Error("Expected the events channel to be closed")
+	}
+}
+
+// FailingQueue fails every receive
+type FailingQueue struct {
+	sqsiface.SQSAPI
+}
+
+func (f *FailingQueue) ReceiveMessageRequest(input *sqs.ReceiveMessageInput) (*request.Request, *sqs.ReceiveMessageOutput) {
+	time.Sleep(time.Millisecond)
+	return &request.Request{Error: errors.New("throttled")}, &sqs.ReceiveMessageOutput{}
+}
+
+func TestPollerErrorEvents(t *testing.T) {
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+	})
+	w.Queue = &FailingQueue{}
+	events := w.Events(1)
+	go w.Run()
+
+	select {
+	case e := <-events:
+		if e.Type != sqsworker.EventPollerError || e.QueueURL != workerQueueURL || e.Err == nil {
+			t.Error("unexpected event: ", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a poller error event")
+	}
+	w.Close()
+
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("Expected the events channel to be closed")
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package sqsworker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"time"
+)
+
+// MaxEventBridgeBatchSize is the most events PutEvents accepts at once
+const MaxEventBridgeBatchSize = 10
+
+// EventBridgeConfig settings for an EventBridgeSink
+type EventBridgeConfig struct {
+	// EventBusName defaults to the account's default event bus
+	EventBusName string
+	// Source and DetailType of the events. A result with a Subject uses it as its detail type.
+	Source     string
+	DetailType string
+	// BatchSize and BatchWindow batch events as described on Sink. BatchSize is at most
+	// MaxEventBridgeBatchSize. Events that fail are not retried, the message is left on the
+	// queue to be processed again.
+	BatchSize   int
+	BatchWindow time.Duration
+}
+
+// EventBridgeSink is a Sink putting results on an EventBridge event bus. The message of each
+// result is the event detail, and must be a JSON object. EventBridge events have no attributes,
+// so results with message attributes are rejected.
+type EventBridgeSink struct {
+	Client eventbridgeiface.EventBridgeAPI
+	config EventBridgeConfig
+	batch  *batcher
+}
+
+// NewEventBridgeSink creates an EventBridgeSink
+func NewEventBridgeSink(client eventbridgeiface.EventBridgeAPI, config EventBridgeConfig) *EventBridgeSink {
+	if config.BatchSize > MaxEventBridgeBatchSize {
+		config.BatchSize = MaxEventBridgeBatchSize
+	}
+	s := &EventBridgeSink{Client: client, config: config}
+	s.batch = newBatcher(config.BatchSize, config.BatchWindow, s.put)
+	return s
+}
+
+// Send puts the result on the event bus. Results that cannot be converted to an event fail with
+// a Fatal error.
+func (s *EventBridgeSink) Send(ctx context.Context, m *sqs.Message, output *sns.PublishInput) error {
+	entry, err := s.entry(output)
+	if err != nil {
+		return err
+	}
+	return s.batch.send(ctx, entry)
+}
+
+// entry converts a result to an event
+func (s *EventBridgeSink) entry(output *sns.PublishInput) (*eventbridge.PutEventsRequestEntry, error) {
+	if len(output.MessageAttributes) > 0 {
+		return nil, Fatal(errors.New("sqsworker: results put on EventBridge cannot have message attributes"))
+	}
+	var detail map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(aws.StringValue(output.Message)), &detail); err != nil {
+		return nil, Fatal(fmt.Errorf("sqsworker: EventBridge event detail must be a JSON object: %v", err))
+	}
+
+	entry := &eventbridge.PutEventsRequestEntry{
+		Source:     aws.String(s.config.Source),
+		DetailType: aws.String(s.config.DetailType),
+		Detail:     output.Message,
+		Time:       aws.Time(time.Now()),
+	}
+	if output.Subject != nil {
+		entry.DetailType = output.Subject
+	}
+	if s.config.EventBusName != "" {
+		entry.EventBusName = aws.String(s.config.EventBusName)
+	}
+	return entry, nil
+}
+
+// put sends a batch of events, returning the error of each
+func (s *EventBridgeSink) put(ctx context.Context, values []interface{}) []error {
+	input := &eventbridge.PutEventsInput{Entries: make([]*eventbridge.PutEventsRequestEntry, len(values))}
+	for i, value := range values {
+		input.Entries[i] = value.(*eventbridge.PutEventsRequestEntry)
+	}
+
+	errs := make([]error, len(values))
+	out, err := s.Client.PutEvents(input)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	for i, result := range out.Entries {
+		if i < len(errs) && result.ErrorCode != nil {
+			errs[i] = fmt.Errorf("sqsworker: put event failed: %s: %s", *result.ErrorCode, aws.StringValue(result.ErrorMessage))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,125 @@
+package sqsworker_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"sync"
+	"testing"
+	"time"
+)
+
+// EventBus records the events put on it, failing the events whose detail type is "fail"
+type EventBus struct {
+	eventbridgeiface.EventBridgeAPI
+	mu      sync.Mutex
+	Batches [][]*eventbridge.PutEventsRequestEntry
+}
+
+func (e *EventBus) PutEvents(input *eventbridge.PutEventsInput) (*eventbridge.PutEventsOutput, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.Batches = append(e.Batches, input.Entries)
+
+	out := &eventbridge.PutEventsOutput{FailedEntryCount: aws.Int64(0)}
+	for _, entry := range input.Entries {
+		if aws.StringValue(entry.DetailType) == "fail" {
+			*out.FailedEntryCount++
+			out.Entries = append(out.Entries, &eventbridge.PutEventsResultEntry{
+				ErrorCode:    aws.String("InternalFailure"),
+				ErrorMessage: aws.String("failed"),
+			})
+			continue
+		}
+		out.Entries = append(out.Entries, &eventbridge.PutEventsResultEntry{EventId: aws.String("event")})
+	}
+	return out, nil
+}
+
+func (e *EventBus) batches() [][]*eventbridge.PutEventsRequestEntry {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.Batches
+}
+
+func TestEventBridgeSink(t *testing.T) {
+	bus := &EventBus{}
+	sink := sqsworker.NewEventBridgeSink(bus, sqsworker.EventBridgeConfig{
+		EventBusName: "orders",
+		Source:       "com.example.orders",
+		DetailType:   "OrderPlaced",
+	})
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:  "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: &EventWorker{Output: func(m *sqs.Message) *sns.PublishInput { return &sns.PublishInput{Message: m.Body} }},
+		Sink:      sink,
+	})
+
+	h.Run(workertest.NewMessage(`{"id":1}`)).Succeeded().Deleted().NotPublished()
+	batches := bus.batches()
+	if len(batches) != 1 || len(batches[0]) != 1 {
+		t.Fatal("unexpected events: ", batches)
+	}
+	entry := batches[0][0]
+	if aws.StringValue(entry.EventBusName) != "orders" || aws.StringValue(entry.Source) != "com.example.orders" ||
+		aws.StringValue(entry.DetailType) != "OrderPlaced" || aws.StringValue(entry.Detail) != `{"id":1}` {
+		t.Error("unexpected event: ", entry)
+	}
+
+	// Details that are not JSON objects cannot be put
+	result := h.Run(workertest.NewMessage("hello")).Failed().NotDeleted()
+	if !sqsworker.IsFatal(result.Err) || len(bus.batches()) != 1 {
+		t.Error("Expected the invalid event not to be put")
+	}
+}
+
+func TestEventBridgeSinkBatches(t *testing.T) {
+	bus := &EventBus{}
+	sink := sqsworker.NewEventBridgeSink(bus, sqsworker.EventBridgeConfig{
+		Source:      "com.example.orders",
+		DetailType:  "OrderPlaced",
+		BatchSize:   4,
+		BatchWindow: time.Second,
+	})
+
+	subjects := []string{"OrderPlaced", "fail", "OrderPlaced", "OrderPlaced"}
+	errs := make([]error, len(subjects))
+	var wg sync.WaitGroup
+	for i, subject := range subjects {
+		wg.Add(1)
+		go func(i int, subject string) {
+			defer wg.Done()
+			output := &sns.PublishInput{Message: aws.String(`{}`), Subject: aws.String(subject)}
+			errs[i] = sink.Send(context.Background(), workertest.NewMessage("in"), output)
+		}(i, subject)
+	}
+	wg.Wait()
+
+	// A full batch is sent without waiting for the window
+	if batches := bus.batches(); len(batches) != 1 || len(batches[0]) != 4 {
+		t.Fatal("Expected a single batch of 4 events, got: ", batches)
+	}
+	for i, err := range errs {
+		if (err != nil) != (subjects[i] == "fail") {
+			t.Error("unexpected error for ", subjects[i], ": ", err)
+		}
+	}
+
+	// A partial batch is sent after the window
+	start := time.Now()
+	sink = sqsworker.NewEventBridgeSink(bus, sqsworker.EventBridgeConfig{BatchSize: 4, BatchWindow: 20 * time.Millisecond})
+	if err := sink.Send(context.Background(), workertest.NewMessage("in"), &sns.PublishInput{Message: aws.String(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Error("Expected the batch to wait for the window, sent after ", elapsed)
+	}
+	if batches := bus.batches(); len(batches) != 2 || len(batches[1]) != 1 {
+		t.Error("unexpected batches: ", batches)
+	}
+}
@@ -0,0 +1,59 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"time"
+)
+
+// EventBridgeEvent is an event delivered to an SQS target by an EventBridge rule. Detail is
+// left as JSON for the handler of its detail type to decode.
+type EventBridgeEvent struct {
+	Version    string          `json:"version"`
+	ID         string          `json:"id"`
+	DetailType string          `json:"detail-type"`
+	Source     string          `json:"source"`
+	Account    string          `json:"account"`
+	Time       time.Time       `json:"time"`
+	Region     string          `json:"region"`
+	Resources  []string        `json:"resources"`
+	Detail     json.RawMessage `json:"detail"`
+}
+
+// EventHandler handles an EventBridge event, returning the result to publish like a Processor
+type EventHandler func(ctx context.Context, e EventBridgeEvent) (*sns.PublishInput, error)
+
+// ParseEventBridgeEvent parses the event a message holds, delivered by EventBridge directly or
+// through an SNS topic. Messages that are not events fail with a Fatal error.
+func ParseEventBridgeEvent(m *sqs.Message) (EventBridgeEvent, error) {
+	return decodeEventBridge(unwrapSNS([]byte(aws.StringValue(m.Body))))
+}
+
+func decodeEventBridge(body []byte) (EventBridgeEvent, error) {
+	var event EventBridgeEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return event, sqsworker.Fatal(fmt.Errorf("events: invalid EventBridge event: %v", err))
+	}
+	if event.DetailType == "" || event.Source == "" {
+		return event, sqsworker.Fatal(fmt.Errorf("events: EventBridge event %s has no source or detail type", event.ID))
+	}
+	return event, nil
+}
+
+// DetailTypeRouter is a Router choosing the destination of a result by the detail type of the
+// EventBridge event it was produced from, using the destination names by detail type. Results
+// of other events, or of messages that are not events, go to the default destination.
+func DetailTypeRouter(names map[string]string) sqsworker.Router {
+	return func(m *sqs.Message, output *sns.PublishInput) string {
+		event, err := ParseEventBridgeEvent(m)
+		if err != nil {
+			return ""
+		}
+		return names[event.DetailType]
+	}
+}
@@ -0,0 +1,63 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/events"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"testing"
+)
+
+func eventBody(detailType, detail string) string {
+	return `{"version":"0","id":"event-1","detail-type":"` + detailType + `","source":"com.example.orders",
+"account":"88888888888","time":"2019-07-01T12:00:00Z","region":"us-east-1","resources":[],"detail":` + detail + `}`
+}
+
+const ordersTopicArn = "arn:aws:sns:us-east-1:88888888888:Orders"
+
+func TestMuxEventBridge(t *testing.T) {
+	mux := events.NewMux()
+	mux.EventBridge("OrderPlaced", func(ctx context.Context, e events.EventBridgeEvent) (*sns.PublishInput, error) {
+		var detail struct{ ID string }
+		if err := json.Unmarshal(e.Detail, &detail); err != nil {
+			return nil, err
+		}
+		return &sns.PublishInput{Message: aws.String("placed " + detail.ID)}, nil
+	})
+	mux.EventBridge("OrderViewed", func(ctx context.Context, e events.EventBridgeEvent) (*sns.PublishInput, error) {
+		return &sns.PublishInput{Message: aws.String("viewed")}, nil
+	})
+
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:           "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor:          mux,
+		Destinations:       map[string]sqsworker.Destination{"order": {TopicArn: ordersTopicArn}},
+		Router:             events.DetailTypeRouter(map[string]string{"OrderPlaced": "order"}),
+		DeadLetterQueueURL: workertest.QueueURL + "-dlq",
+	})
+
+	result := h.Run(workertest.NewMessage(eventBody("OrderPlaced", `{"ID":"1"}`))).Succeeded().Deleted().Published("placed 1")
+	if actual := aws.StringValue(result.Publishes[0].TopicArn); actual != ordersTopicArn {
+		t.Error("Actual: ", actual, "Expected: ", ordersTopicArn)
+	}
+	result = h.Run(workertest.NewMessage(eventBody("OrderViewed", `{}`))).Succeeded().Published("viewed")
+	if actual := aws.StringValue(result.Publishes[0].TopicArn); actual != "arn:aws:sns:us-east-1:88888888888:Out" {
+		t.Error("Actual: ", actual, "Expected: ", "arn:aws:sns:us-east-1:88888888888:Out")
+	}
+
+	// Events without a handler are skipped unless there is a Default
+	h.Run(workertest.NewMessage(eventBody("OrderRefunded", `{}`))).Succeeded().Deleted().NotPublished()
+	mux.Default = sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+		return &sns.PublishInput{Message: aws.String("default")}, nil
+	})
+	h.Run(workertest.NewMessage(eventBody("OrderRefunded", `{}`))).Succeeded().Published("default")
+
+	h.Run(workertest.NewMessage(`{"hello":"world"}`)).Succeeded().Published("default")
+
+	mux.Default = nil
+	h.Run(workertest.NewMessage(`{"hello":"world"}`)).Failed().DeadLettered()
+}
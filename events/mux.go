@@ -0,0 +1,173 @@
+// Package events adapts the notifications AWS services deliver to SQS, directly or through an
+// SNS topic, to typed handlers.
+//
+// A Mux is a Processor for a queue receiving the events of several services, dispatching each
+// to the handler registered for its kind:
+//
+//	mux := events.NewMux()
+//	mux.S3(func(ctx context.Context, records []events.S3EventRecord) error { ... })
+//	mux.CloudWatchAlarm(func(ctx context.Context, alarm events.CloudWatchAlarm) error { ... })
+//	mux.EventBridge("OrderPlaced", func(ctx context.Context, e events.EventBridgeEvent) (*sns.PublishInput, error) { ... })
+//
+// S3Events and SESEvents adapt a single handler for queues receiving one kind of event.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"sync"
+)
+
+// Mux is a Processor dispatching the events of a mixed queue to the handler registered for their
+// kind: S3 event notifications, SES notifications, CloudWatch alarm notifications, and
+// EventBridge events by detail type. Events without a handler are passed to Default, or skipped
+// when it is nil. Messages that are not events of a known kind are passed to Default, or fail
+// with a Fatal error.
+type Mux struct {
+	// Verifier checks the signatures of the notifications delivered through SNS
+	Verifier    *SNSVerifier
+	Default     sqsworker.Processor
+	mu          sync.RWMutex
+	s3          func(context.Context, []S3EventRecord) error
+	ses         func(context.Context, SESNotification) error
+	alarm       func(context.Context, CloudWatchAlarm) error
+	eventBridge map[string]EventHandler
+}
+
+// NewMux creates a Mux
+func NewMux() *Mux {
+	return &Mux{eventBridge: make(map[string]EventHandler)}
+}
+
+// S3 registers the handler of S3 event notifications
+func (x *Mux) S3(fn func(ctx context.Context, records []S3EventRecord) error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.s3 = fn
+}
+
+// SES registers the handler of SES notifications
+func (x *Mux) SES(fn func(ctx context.Context, n SESNotification) error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.ses = fn
+}
+
+// CloudWatchAlarm registers the handler of CloudWatch alarm notifications
+func (x *Mux) CloudWatchAlarm(fn func(ctx context.Context, alarm CloudWatchAlarm) error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.alarm = fn
+}
+
+// EventBridge registers the handler of the EventBridge events of a detail type, replacing any
+// previous handler
+func (x *Mux) EventBridge(detailType string, handler EventHandler) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.eventBridge[detailType] = handler
+}
+
+// ECSTaskStateChange registers the handler of ECS task state change events
+func (x *Mux) ECSTaskStateChange(fn func(ctx context.Context, change ECSTaskStateChange) error) {
+	x.EventBridge(ECSTaskStateChangeDetailType, func(ctx context.Context, e EventBridgeEvent) (*sns.PublishInput, error) {
+		change, err := DecodeECSTaskStateChange(e)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fn(ctx, change)
+	})
+}
+
+// kind identifies the service an event is from by the fields only its events have
+type kind struct {
+	DetailType string `json:"detail-type"`
+	Source     string `json:"source"`
+	Records    []struct {
+		EventSource string `json:"eventSource"`
+	}
+	Event            string
+	NotificationType string          `json:"notificationType"`
+	EventType        string          `json:"eventType"`
+	Mail             json.RawMessage `json:"mail"`
+	AlarmName        string
+	NewStateValue    string
+}
+
+// Process decodes the event and calls the handler of its kind
+func (x *Mux) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	body, _, err := x.Verifier.unwrap(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	var k kind
+	if err := json.Unmarshal(body, &k); err != nil {
+		return x.unknown(ctx, m)
+	}
+
+	// the handlers are looked up under the lock, and called without it so that registering a
+	// handler does not wait for the events being processed
+	x.mu.RLock()
+	onEvent, onS3, onSES, onAlarm := x.eventBridge[k.DetailType], x.s3, x.ses, x.alarm
+	x.mu.RUnlock()
+
+	switch {
+	case k.DetailType != "" && k.Source != "":
+		if onEvent == nil {
+			return x.unhandled(ctx, m)
+		}
+		event, err := decodeEventBridge(body)
+		if err != nil {
+			return nil, err
+		}
+		return onEvent(ctx, event)
+	case k.Event == "s3:TestEvent" || len(k.Records) > 0 && k.Records[0].EventSource == "aws:s3":
+		if onS3 == nil {
+			return x.unhandled(ctx, m)
+		}
+		records, err := decodeS3(body)
+		if err != nil {
+			return nil, err
+		}
+		return nil, onS3(ctx, records)
+	case len(k.Mail) > 0 && (k.NotificationType != "" || k.EventType != "") || k.NotificationType == "AmazonSnsSubscriptionSucceeded":
+		if onSES == nil {
+			return x.unhandled(ctx, m)
+		}
+		n, err := decodeSES(body)
+		if err != nil {
+			return nil, err
+		}
+		return nil, onSES(ctx, n)
+	case k.AlarmName != "" && k.NewStateValue != "":
+		if onAlarm == nil {
+			return x.unhandled(ctx, m)
+		}
+		alarm, err := decodeAlarm(body)
+		if err != nil {
+			return nil, err
+		}
+		return nil, onAlarm(ctx, alarm)
+	}
+	return x.unknown(ctx, m)
+}
+
+// unhandled passes an event without a handler to Default, or skips it
+func (x *Mux) unhandled(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	if x.Default == nil {
+		return nil, sqsworker.ErrSkip
+	}
+	return x.Default.Process(ctx, m)
+}
+
+// unknown passes a message that is not an event to Default, or fails it
+func (x *Mux) unknown(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	if x.Default == nil {
+		return nil, sqsworker.Fatal(errors.New("events: message is not an event of a known kind"))
+	}
+	return x.Default.Process(ctx, m)
+}
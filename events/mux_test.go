@@ -0,0 +1,82 @@
+package events_test
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/events"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"strings"
+	"testing"
+)
+
+func TestMux(t *testing.T) {
+	s := newSigner(t)
+	var handled []string
+	mux := events.NewMux()
+	mux.Verifier = &events.SNSVerifier{Certificate: func(string) (*x509.Certificate, error) { return s.cert, nil }}
+	mux.S3(func(ctx context.Context, records []events.S3EventRecord) error {
+		handled = append(handled, "s3 "+records[0].S3.Bucket.Name)
+		return nil
+	})
+	mux.SES(func(ctx context.Context, n events.SESNotification) error {
+		handled = append(handled, "ses "+n.NotificationType)
+		return nil
+	})
+	mux.CloudWatchAlarm(func(ctx context.Context, alarm events.CloudWatchAlarm) error {
+		handled = append(handled, "alarm "+alarm.AlarmName)
+		return nil
+	})
+	mux.ECSTaskStateChange(func(ctx context.Context, change events.ECSTaskStateChange) error {
+		handled = append(handled, "ecs "+change.LastStatus)
+		return nil
+	})
+
+	h := workertest.New(t, sqsworker.WorkerConfig{Processor: mux, DeadLetterQueueURL: workertest.QueueURL + "-dlq"})
+	bodies := []string{
+		s3Notification,
+		s.notification(t, sesBounce),
+		s.notification(t, alarmNotification),
+		eventBody(events.ECSTaskStateChangeDetailType, `{"lastStatus":"STOPPED"}`),
+		// events without a handler are skipped
+		eventBody("OrderPlaced", `{}`),
+		`{"Service":"Amazon S3","Event":"s3:TestEvent","Bucket":"uploads"}`,
+	}
+	for _, body := range bodies {
+		h.Run(workertest.NewMessage(body)).Succeeded().Deleted()
+	}
+	expected := []string{"s3 uploads", "ses Bounce", "alarm queue-age", "ecs STOPPED"}
+	if len(handled) != len(expected) {
+		t.Fatal("Actual: ", handled, "Expected: ", expected)
+	}
+	for i := range expected {
+		if handled[i] != expected[i] {
+			t.Error("Actual: ", handled[i], "Expected: ", expected[i])
+		}
+	}
+
+	// Unknown messages and forged notifications are dead-lettered
+	var forged map[string]string
+	json.Unmarshal([]byte(s.notification(t, alarmNotification)), &forged)
+	forged["Message"] = strings.Replace(forged["Message"], "ALARM", "OK", 1)
+	forgedBody, _ := json.Marshal(forged)
+	for _, body := range []string{`{"hello":"world"}`, "not json", string(forgedBody)} {
+		h.Run(workertest.NewMessage(body)).Failed().DeadLettered()
+	}
+}
+
+func TestMuxRegisterWhileProcessing(t *testing.T) {
+	mux := events.NewMux()
+	// a handler registering another one would deadlock if the Mux held its lock while calling it
+	mux.S3(func(ctx context.Context, records []events.S3EventRecord) error {
+		mux.EventBridge("OrderPlaced", func(ctx context.Context, e events.EventBridgeEvent) (*sns.PublishInput, error) {
+			return nil, nil
+		})
+		return nil
+	})
+	h := workertest.New(t, sqsworker.WorkerConfig{Processor: mux})
+	h.Run(workertest.NewMessage(s3Notification)).Succeeded().Deleted()
+	h.Run(workertest.NewMessage(eventBody("OrderPlaced", `{}`))).Succeeded().Deleted()
+}
@@ -0,0 +1,129 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"time"
+)
+
+// CloudWatch alarm states
+const (
+	AlarmStateOK               = "OK"
+	AlarmStateAlarm            = "ALARM"
+	AlarmStateInsufficientData = "INSUFFICIENT_DATA"
+)
+
+// ECSTaskStateChangeDetailType is the detail type of ECS task state change events
+const ECSTaskStateChangeDetailType = "ECS Task State Change"
+
+// alarmTimeLayout is the layout of the times in CloudWatch alarm notifications
+const alarmTimeLayout = "2006-01-02T15:04:05.000-0700"
+
+// CloudWatchAlarm is the state change notification a CloudWatch alarm publishes to an SNS topic
+type CloudWatchAlarm struct {
+	AlarmName        string
+	AlarmDescription string
+	AlarmArn         string
+	AWSAccountID     string `json:"AWSAccountId"`
+	Region           string
+	NewStateValue    string
+	NewStateReason   string
+	OldStateValue    string
+	// StateChangeTime is formatted like 2019-07-01T12:00:00.000+0000, ChangedAt parses it
+	StateChangeTime string
+	Trigger         AlarmTrigger
+}
+
+// ChangedAt returns the StateChangeTime
+func (c CloudWatchAlarm) ChangedAt() (time.Time, error) {
+	return time.Parse(alarmTimeLayout, c.StateChangeTime)
+}
+
+// AlarmTrigger is the metric and threshold of a CloudWatch alarm. Alarms on metric math
+// expressions have Metrics instead of a MetricName.
+type AlarmTrigger struct {
+	MetricName         string
+	Namespace          string
+	StatisticType      string
+	Statistic          string
+	Unit               string
+	Dimensions         []AlarmDimension
+	Period             int64
+	EvaluationPeriods  int64
+	ComparisonOperator string
+	Threshold          float64
+	TreatMissingData   string
+	Metrics            []json.RawMessage
+}
+
+// AlarmDimension is a dimension of an alarm's metric
+type AlarmDimension struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// DecodeCloudWatchAlarm decodes the CloudWatch alarm notification a message holds, delivered
+// through an SNS topic with or without raw message delivery. Messages that are not alarm
+// notifications fail with a Fatal error, so a Processor can return it.
+func DecodeCloudWatchAlarm(m *sqs.Message) (CloudWatchAlarm, error) {
+	return decodeAlarm(unwrapSNS([]byte(aws.StringValue(m.Body))))
+}
+
+func decodeAlarm(body []byte) (CloudWatchAlarm, error) {
+	var alarm CloudWatchAlarm
+	if err := json.Unmarshal(body, &alarm); err != nil {
+		return alarm, sqsworker.Fatal(fmt.Errorf("events: invalid CloudWatch alarm notification: %v", err))
+	}
+	if alarm.AlarmName == "" || alarm.NewStateValue == "" {
+		return alarm, sqsworker.Fatal(errors.New("events: message is not a CloudWatch alarm notification"))
+	}
+	return alarm, nil
+}
+
+// ECSTaskStateChange is the detail of an ECS task state change event
+type ECSTaskStateChange struct {
+	ClusterArn        string         `json:"clusterArn"`
+	TaskArn           string         `json:"taskArn"`
+	TaskDefinitionArn string         `json:"taskDefinitionArn"`
+	Group             string         `json:"group"`
+	LaunchType        string         `json:"launchType"`
+	LastStatus        string         `json:"lastStatus"`
+	DesiredStatus     string         `json:"desiredStatus"`
+	StopCode          string         `json:"stopCode"`
+	StoppedReason     string         `json:"stoppedReason"`
+	Containers        []ECSContainer `json:"containers"`
+	CreatedAt         time.Time      `json:"createdAt"`
+	StartedAt         time.Time      `json:"startedAt"`
+	StoppingAt        time.Time      `json:"stoppingAt"`
+	StoppedAt         time.Time      `json:"stoppedAt"`
+	Version           int64          `json:"version"`
+}
+
+// ECSContainer is a container of an ECS task state change. ExitCode is nil until the
+// container stopped.
+type ECSContainer struct {
+	ContainerArn string `json:"containerArn"`
+	Name         string `json:"name"`
+	Image        string `json:"image"`
+	LastStatus   string `json:"lastStatus"`
+	ExitCode     *int   `json:"exitCode"`
+	Reason       string `json:"reason"`
+}
+
+// DecodeECSTaskStateChange decodes the detail of an ECS task state change event, e.g. in an
+// EventHandler registered for ECSTaskStateChangeDetailType. Other events fail with a Fatal
+// error, so a Processor can return it.
+func DecodeECSTaskStateChange(event EventBridgeEvent) (ECSTaskStateChange, error) {
+	var change ECSTaskStateChange
+	if event.DetailType != ECSTaskStateChangeDetailType {
+		return change, sqsworker.Fatal(fmt.Errorf("events: event %s is a %s, not an ECS task state change", event.ID, event.DetailType))
+	}
+	if err := json.Unmarshal(event.Detail, &change); err != nil {
+		return change, sqsworker.Fatal(fmt.Errorf("events: invalid ECS task state change: %v", err))
+	}
+	return change, nil
+}
@@ -0,0 +1,60 @@
+package events_test
+
+import (
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/events"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"testing"
+	"time"
+)
+
+const alarmNotification = `{"AlarmName":"queue-age","AlarmDescription":"messages are old","AWSAccountId":"88888888888",
+"NewStateValue":"ALARM","NewStateReason":"Threshold Crossed","StateChangeTime":"2019-07-01T12:00:00.000+0000",
+"Region":"US East (N. Virginia)","AlarmArn":"arn:aws:cloudwatch:us-east-1:88888888888:alarm:queue-age","OldStateValue":"OK",
+"Trigger":{"MetricName":"ApproximateAgeOfOldestMessage","Namespace":"AWS/SQS","StatisticType":"Statistic","Statistic":"MAXIMUM",
+"Unit":null,"Dimensions":[{"value":"In","name":"QueueName"}],"Period":60,"EvaluationPeriods":5,
+"ComparisonOperator":"GreaterThanThreshold","Threshold":300.0,"TreatMissingData":"","EvaluateLowSampleCountPercentile":""}}`
+
+func TestDecodeCloudWatchAlarm(t *testing.T) {
+	alarm, err := events.DecodeCloudWatchAlarm(workertest.NewMessage(alarmNotification))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alarm.AlarmName != "queue-age" || alarm.NewStateValue != events.AlarmStateAlarm || alarm.OldStateValue != events.AlarmStateOK ||
+		alarm.Trigger.Threshold != 300 || alarm.Trigger.Dimensions[0].Value != "In" {
+		t.Error("unexpected alarm: ", alarm)
+	}
+	changed, err := alarm.ChangedAt()
+	if expected := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC); err != nil || !changed.Equal(expected) {
+		t.Error("Actual: ", changed, err, "Expected: ", expected)
+	}
+
+	if _, err := events.DecodeCloudWatchAlarm(workertest.NewMessage(`{"hello":"world"}`)); !sqsworker.IsFatal(err) {
+		t.Error("Expected a fatal error, got: ", err)
+	}
+}
+
+func TestDecodeECSTaskStateChange(t *testing.T) {
+	detail := `{"clusterArn":"arn:aws:ecs:us-east-1:88888888888:cluster/default","taskArn":"arn:aws:ecs:us-east-1:88888888888:task/1",
+"lastStatus":"STOPPED","desiredStatus":"STOPPED","stopCode":"EssentialContainerExited","stoppedAt":"2019-07-01T12:00:00.000Z",
+"containers":[{"name":"app","lastStatus":"STOPPED","exitCode":137},{"name":"sidecar","lastStatus":"RUNNING"}]}`
+	event, err := events.ParseEventBridgeEvent(workertest.NewMessage(eventBody(events.ECSTaskStateChangeDetailType, detail)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	change, err := events.DecodeECSTaskStateChange(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.LastStatus != "STOPPED" || change.StopCode != "EssentialContainerExited" || change.StoppedAt.IsZero() || len(change.Containers) != 2 {
+		t.Error("unexpected task state change: ", change)
+	}
+	if code := change.Containers[0].ExitCode; code == nil || *code != 137 || change.Containers[1].ExitCode != nil {
+		t.Error("unexpected containers: ", change.Containers)
+	}
+
+	event.DetailType = "OrderPlaced"
+	if _, err := events.DecodeECSTaskStateChange(event); !sqsworker.IsFatal(err) {
+		t.Error("Expected a fatal error, got: ", err)
+	}
+}
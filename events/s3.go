@@ -0,0 +1,88 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"net/url"
+	"time"
+)
+
+// S3EventRecord is a record of an S3 event notification
+type S3EventRecord struct {
+	EventVersion string    `json:"eventVersion"`
+	EventSource  string    `json:"eventSource"`
+	AWSRegion    string    `json:"awsRegion"`
+	EventTime    time.Time `json:"eventTime"`
+	// EventName is the event type, e.g. ObjectCreated:Put
+	EventName string   `json:"eventName"`
+	S3        S3Entity `json:"s3"`
+}
+
+// S3Entity is the bucket and object of an S3 event record
+type S3Entity struct {
+	ConfigurationID string   `json:"configurationId"`
+	Bucket          S3Bucket `json:"bucket"`
+	Object          S3Object `json:"object"`
+}
+
+// S3Bucket is the bucket of an S3 event record
+type S3Bucket struct {
+	Name string `json:"name"`
+	Arn  string `json:"arn"`
+}
+
+// S3Object is the object of an S3 event record. Its Key is URL encoded, as S3 sends it.
+type S3Object struct {
+	Key       string `json:"key"`
+	Size      int64  `json:"size"`
+	ETag      string `json:"eTag"`
+	VersionID string `json:"versionId"`
+	Sequencer string `json:"sequencer"`
+}
+
+// DecodedKey returns the object key with its URL encoding removed
+func (o S3Object) DecodedKey() (string, error) {
+	return url.QueryUnescape(o.Key)
+}
+
+// s3Event is the body of an S3 event notification, or of the test event S3 sends when the
+// notification is configured
+type s3Event struct {
+	Records []S3EventRecord
+	Event   string
+}
+
+// S3Events adapts a function handling S3 event notification records to a Processor. Both
+// notifications sent to the queue directly and through an SNS topic are parsed. The test event
+// S3 sends when a notification is configured is skipped, and bodies that are not notifications
+// fail with a Fatal error. The Processor publishes nothing.
+func S3Events(fn func(ctx context.Context, records []S3EventRecord) error) sqsworker.Processor {
+	return sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+		records, err := decodeS3(unwrapSNS([]byte(aws.StringValue(m.Body))))
+		if err != nil {
+			return nil, err
+		}
+		return nil, fn(ctx, records)
+	})
+}
+
+// decodeS3 returns the records of an S3 event notification, ErrSkip for the test event
+func decodeS3(body []byte) ([]S3EventRecord, error) {
+	var event s3Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, sqsworker.Fatal(fmt.Errorf("events: invalid S3 event notification: %v", err))
+	}
+	if event.Event == "s3:TestEvent" {
+		return nil, sqsworker.ErrSkip
+	}
+	if len(event.Records) == 0 {
+		return nil, sqsworker.Fatal(errors.New("events: S3 event notification has no records"))
+	}
+	return event.Records, nil
+}
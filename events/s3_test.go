@@ -0,0 +1,53 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/events"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"testing"
+)
+
+const s3Notification = `{"Records":[{"eventVersion":"2.1","eventSource":"aws:s3","awsRegion":"us-east-1",
+"eventTime":"2019-07-01T12:00:00.000Z","eventName":"ObjectCreated:Put","s3":{"configurationId":"uploads",
+"bucket":{"name":"uploads","arn":"arn:aws:s3:::uploads"},
+"object":{"key":"reports/July+2019.csv","size":1024,"eTag":"abc","sequencer":"0055AED6DCD90281E5"}}}]}`
+
+func TestS3Events(t *testing.T) {
+	var records []events.S3EventRecord
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: events.S3Events(func(ctx context.Context, r []events.S3EventRecord) error {
+			records = append(records, r...)
+			return nil
+		}),
+		DeadLetterQueueURL: workertest.QueueURL + "-dlq",
+	})
+
+	envelope, _ := json.Marshal(map[string]string{
+		"Type":      "Notification",
+		"MessageId": "notification",
+		"TopicArn":  "arn:aws:sns:us-east-1:88888888888:Uploads",
+		"Message":   s3Notification,
+	})
+	h.Run(workertest.NewMessage(s3Notification)).Succeeded().Deleted().NotPublished()
+	h.Run(workertest.NewMessage(string(envelope))).Succeeded().Deleted()
+	if len(records) != 2 {
+		t.Fatal("unexpected records: ", records)
+	}
+	for _, record := range records {
+		key, err := record.S3.Object.DecodedKey()
+		if record.EventName != "ObjectCreated:Put" || record.S3.Bucket.Name != "uploads" || record.S3.Object.Size != 1024 ||
+			key != "reports/July 2019.csv" || err != nil || record.EventTime.Year() != 2019 {
+			t.Error("unexpected record: ", record, key, err)
+		}
+	}
+
+	// The test event is skipped, other bodies are dead-lettered
+	h.Run(workertest.NewMessage(`{"Service":"Amazon S3","Event":"s3:TestEvent","Bucket":"uploads"}`)).Succeeded().Deleted()
+	h.Run(workertest.NewMessage("not json")).Failed().DeadLettered()
+	h.Run(workertest.NewMessage(`{"Records":[]}`)).Failed().DeadLettered()
+	if len(records) != 2 {
+		t.Error("unexpected records: ", records)
+	}
+}
@@ -0,0 +1,148 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"time"
+)
+
+// SES notification types
+const (
+	SESBounceNotification    = "Bounce"
+	SESComplaintNotification = "Complaint"
+	SESDeliveryNotification  = "Delivery"
+)
+
+// SESNotification is an SES bounce, complaint or delivery notification. Only the field of its
+// NotificationType is set.
+type SESNotification struct {
+	NotificationType string        `json:"notificationType"`
+	Mail             SESMail       `json:"mail"`
+	Bounce           *SESBounce    `json:"bounce"`
+	Complaint        *SESComplaint `json:"complaint"`
+	Delivery         *SESDelivery  `json:"delivery"`
+	// EventType is the type of notifications published by a configuration set, it is copied to
+	// NotificationType
+	EventType string `json:"eventType"`
+}
+
+// SESMail is the original mail a notification is about
+type SESMail struct {
+	Timestamp        time.Time           `json:"timestamp"`
+	MessageID        string              `json:"messageId"`
+	Source           string              `json:"source"`
+	SourceArn        string              `json:"sourceArn"`
+	SendingAccountID string              `json:"sendingAccountId"`
+	Destination      []string            `json:"destination"`
+	HeadersTruncated bool                `json:"headersTruncated"`
+	Headers          []SESHeader         `json:"headers"`
+	CommonHeaders    SESCommonHeaders    `json:"commonHeaders"`
+	Tags             map[string][]string `json:"tags"`
+}
+
+// SESHeader is a header of the original mail
+type SESHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// SESCommonHeaders are the headers of the original mail SES parses
+type SESCommonHeaders struct {
+	From      []string `json:"from"`
+	To        []string `json:"to"`
+	Date      string   `json:"date"`
+	MessageID string   `json:"messageId"`
+	Subject   string   `json:"subject"`
+}
+
+// SESBounce is the bounce of a Bounce notification
+type SESBounce struct {
+	// BounceType is Undetermined, Permanent or Transient
+	BounceType        string                `json:"bounceType"`
+	BounceSubType     string                `json:"bounceSubType"`
+	BouncedRecipients []SESBouncedRecipient `json:"bouncedRecipients"`
+	Timestamp         time.Time             `json:"timestamp"`
+	FeedbackID        string                `json:"feedbackId"`
+	RemoteMtaIP       string                `json:"remoteMtaIp"`
+	ReportingMTA      string                `json:"reportingMTA"`
+}
+
+// SESBouncedRecipient is a recipient whose mail bounced
+type SESBouncedRecipient struct {
+	EmailAddress   string `json:"emailAddress"`
+	Action         string `json:"action"`
+	Status         string `json:"status"`
+	DiagnosticCode string `json:"diagnosticCode"`
+}
+
+// SESComplaint is the complaint of a Complaint notification
+type SESComplaint struct {
+	ComplainedRecipients  []SESRecipient `json:"complainedRecipients"`
+	Timestamp             time.Time      `json:"timestamp"`
+	FeedbackID            string         `json:"feedbackId"`
+	ComplaintSubType      string         `json:"complaintSubType"`
+	UserAgent             string         `json:"userAgent"`
+	ComplaintFeedbackType string         `json:"complaintFeedbackType"`
+	ArrivalDate           string         `json:"arrivalDate"`
+}
+
+// SESRecipient is a recipient of the original mail
+type SESRecipient struct {
+	EmailAddress string `json:"emailAddress"`
+}
+
+// SESDelivery is the delivery of a Delivery notification
+type SESDelivery struct {
+	Timestamp            time.Time `json:"timestamp"`
+	ProcessingTimeMillis int64     `json:"processingTimeMillis"`
+	Recipients           []string  `json:"recipients"`
+	SMTPResponse         string    `json:"smtpResponse"`
+	ReportingMTA         string    `json:"reportingMTA"`
+	RemoteMtaIP          string    `json:"remoteMtaIp"`
+}
+
+// SESEvents adapts a function handling SES notifications delivered through an SNS topic to a
+// Processor. With a verifier, the SNS signature of every notification is checked, and messages
+// that are not signed SNS notifications, including those of subscriptions with raw message
+// delivery, fail with a Fatal error. The notification SES sends when the topic is configured is
+// skipped. The Processor publishes nothing.
+func SESEvents(fn func(ctx context.Context, n SESNotification) error, verifier *SNSVerifier) sqsworker.Processor {
+	return sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+		body, signed, err := verifier.unwrap(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		if verifier != nil && !signed {
+			return nil, sqsworker.Fatal(errors.New("events: SES notification is not a signed SNS notification"))
+		}
+		n, err := decodeSES(body)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fn(ctx, n)
+	})
+}
+
+// decodeSES returns an SES notification, ErrSkip for the notification SES sends when the topic
+// is configured
+func decodeSES(body []byte) (SESNotification, error) {
+	var n SESNotification
+	if err := json.Unmarshal(body, &n); err != nil {
+		return n, sqsworker.Fatal(fmt.Errorf("events: invalid SES notification: %v", err))
+	}
+	if n.NotificationType == "" {
+		n.NotificationType = n.EventType
+	}
+	switch n.NotificationType {
+	case "AmazonSnsSubscriptionSucceeded":
+		return n, sqsworker.ErrSkip
+	case "":
+		return n, sqsworker.Fatal(errors.New("events: SES notification has no type"))
+	}
+	return n, nil
+}
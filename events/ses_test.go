@@ -0,0 +1,145 @@
+package events_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/events"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"math/big"
+	"testing"
+	"time"
+)
+
+const (
+	sesBounce = `{"notificationType":"Bounce","bounce":{"bounceType":"Permanent","bounceSubType":"General",
+"bouncedRecipients":[{"emailAddress":"nobody@example.com","action":"failed","status":"5.1.1"}],
+"timestamp":"2019-07-01T12:00:00.000Z","feedbackId":"feedback"},
+"mail":{"timestamp":"2019-07-01T11:59:00.000Z","messageId":"mail","source":"sender@example.com","destination":["nobody@example.com"]}}`
+	certURL = "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-test.pem"
+)
+
+// signer signs SNS notifications with a self-signed certificate
+type signer struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newSigner(t *testing.T) *signer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &signer{key: key, cert: cert}
+}
+
+// notification returns the body of a signed SNS notification of the message
+func (s *signer) notification(t *testing.T, message string) string {
+	n := map[string]string{
+		"Type":             "Notification",
+		"MessageId":        "notification",
+		"TopicArn":         "arn:aws:sns:us-east-1:88888888888:Bounces",
+		"Message":          message,
+		"Timestamp":        "2019-07-01T12:00:01.000Z",
+		"SignatureVersion": "2",
+		"SigningCertURL":   certURL,
+	}
+	signed := "Message\n" + n["Message"] + "\nMessageId\n" + n["MessageId"] + "\nTimestamp\n" + n["Timestamp"] +
+		"\nTopicArn\n" + n["TopicArn"] + "\nType\n" + n["Type"] + "\n"
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	n["Signature"] = base64.StdEncoding.EncodeToString(signature)
+	body, _ := json.Marshal(n)
+	return string(body)
+}
+
+func TestSESEvents(t *testing.T) {
+	s := newSigner(t)
+	var fetched []string
+	verifier := &events.SNSVerifier{Certificate: func(url string) (*x509.Certificate, error) {
+		fetched = append(fetched, url)
+		return s.cert, nil
+	}}
+
+	var notifications []events.SESNotification
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: events.SESEvents(func(ctx context.Context, n events.SESNotification) error {
+			notifications = append(notifications, n)
+			return nil
+		}, verifier),
+		DeadLetterQueueURL: workertest.QueueURL + "-dlq",
+	})
+
+	body := s.notification(t, sesBounce)
+	h.Run(workertest.NewMessage(body)).Succeeded().Deleted().NotPublished()
+	h.Run(workertest.NewMessage(body)).Succeeded().Deleted()
+	if len(notifications) != 2 || len(fetched) != 1 {
+		t.Fatal("unexpected notifications: ", notifications, fetched)
+	}
+	n := notifications[0]
+	if n.NotificationType != events.SESBounceNotification || n.Bounce == nil || n.Bounce.BounceType != "Permanent" ||
+		n.Bounce.BouncedRecipients[0].EmailAddress != "nobody@example.com" || n.Mail.Source != "sender@example.com" {
+		t.Error("unexpected notification: ", n)
+	}
+
+	// Forged, unsigned and raw notifications are dead-lettered
+	var forged map[string]string
+	json.Unmarshal([]byte(body), &forged)
+	forged["Message"] = `{"notificationType":"Complaint"}`
+	forgedBody, _ := json.Marshal(forged)
+	for _, body := range []string{string(forgedBody), sesBounce} {
+		result := h.Run(workertest.NewMessage(body)).Failed().DeadLettered()
+		if !sqsworker.IsFatal(result.Err) {
+			t.Error("Expected a fatal error, got: ", result.Err)
+		}
+	}
+	forged = map[string]string{}
+	json.Unmarshal([]byte(body), &forged)
+	forged["SigningCertURL"] = "https://attacker.example.com/sns.pem"
+	forgedBody, _ = json.Marshal(forged)
+	h.Run(workertest.NewMessage(string(forgedBody))).Failed().DeadLettered()
+	if len(notifications) != 2 || len(fetched) != 1 {
+		t.Error("unexpected notifications: ", notifications, fetched)
+	}
+}
+
+func TestSESEventsRaw(t *testing.T) {
+	var notifications []events.SESNotification
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: events.SESEvents(func(ctx context.Context, n events.SESNotification) error {
+			notifications = append(notifications, n)
+			return nil
+		}, nil),
+	})
+
+	h.Run(workertest.NewMessage(sesBounce)).Succeeded().Deleted()
+	h.Run(workertest.NewMessage(`{"eventType":"Delivery","delivery":{"recipients":["someone@example.com"]}}`)).Succeeded().Deleted()
+	h.Run(workertest.NewMessage(`{"notificationType":"AmazonSnsSubscriptionSucceeded"}`)).Succeeded().Deleted()
+	if len(notifications) != 2 || notifications[1].NotificationType != events.SESDeliveryNotification || notifications[1].Delivery == nil {
+		t.Error("unexpected notifications: ", notifications)
+	}
+}
@@ -0,0 +1,264 @@
+package events
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// DefaultCertificateTimeout bounds the download of an SNS signing certificate by an SNSVerifier
+// without a Client
+const DefaultCertificateTimeout = 10 * time.Second
+
+// snsEnvelope is the JSON body of a message delivered to SQS by an SNS subscription without
+// raw message delivery
+type snsEnvelope struct {
+	Type             string
+	MessageID        string `json:"MessageId"`
+	TopicArn         string
+	Subject          string
+	Message          string
+	Timestamp        string
+	SignatureVersion string
+	Signature        string
+	SigningCertURL   string
+}
+
+// parseSNS returns the SNS notification a body holds, false when it was not delivered through SNS
+func parseSNS(body []byte) (snsEnvelope, bool) {
+	var envelope snsEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.Type != "Notification" || envelope.TopicArn == "" {
+		return envelope, false
+	}
+	return envelope, true
+}
+
+// unwrapSNS returns the message of an SNS notification, or the body itself when it was not
+// delivered through SNS
+func unwrapSNS(body []byte) []byte {
+	if envelope, ok := parseSNS(body); ok {
+		return []byte(envelope.Message)
+	}
+	return body
+}
+
+// unwrap returns the message of an SNS notification, checking its signature unless the verifier
+// is nil, and whether it was signed. Bodies not delivered through SNS are returned as they are.
+func (v *SNSVerifier) unwrap(ctx context.Context, m *sqs.Message) ([]byte, bool, error) {
+	body := []byte(aws.StringValue(m.Body))
+	envelope, ok := parseSNS(body)
+	if !ok {
+		return body, false, nil
+	}
+	if v != nil {
+		if err := v.verify(ctx, envelope); err != nil {
+			return nil, false, err
+		}
+	}
+	return []byte(envelope.Message), v != nil, nil
+}
+
+// snsCertHost matches the hosts SNS serves its signing certificates from
+var snsCertHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// SNSVerifier checks the signatures of SNS notifications, so a message claiming to come from
+// SNS cannot be forged by anyone able to send to the queue.
+type SNSVerifier struct {
+	// Certificate returns the signing certificate at a SigningCertURL, by default fetched with
+	// the Client. The URL is checked to be an SNS endpoint before it is fetched, and
+	// certificates are cached.
+	Certificate func(certURL string) (*x509.Certificate, error)
+	// Client fetches the certificates, by default a client with the DefaultCertificateTimeout
+	Client *http.Client
+	mu     sync.Mutex
+	certs  map[string]*x509.Certificate
+	// fetches are the certificates being fetched by URL, the consumers verifying messages
+	// signed with the same certificate wait for a single fetch
+	fetches map[string]*certFetch
+}
+
+// certFetch is a certificate being fetched, done is closed once cert or err is set
+type certFetch struct {
+	done chan struct{}
+	cert *x509.Certificate
+	err  error
+}
+
+// defaultCertClient fetches the certificates of the SNSVerifiers without a Client
+var defaultCertClient = &http.Client{Timeout: DefaultCertificateTimeout}
+
+// verify checks the signature of a notification. Notifications with a missing or invalid
+// signature fail with a Fatal error, the certificate failing to download does not.
+func (v *SNSVerifier) verify(ctx context.Context, envelope snsEnvelope) error {
+	var h hash.Hash
+	var algorithm crypto.Hash
+	switch envelope.SignatureVersion {
+	case "1":
+		h, algorithm = sha1.New(), crypto.SHA1
+	case "2":
+		h, algorithm = sha256.New(), crypto.SHA256
+	default:
+		return sqsworker.Fatal(fmt.Errorf("events: unsupported SNS signature version %q", envelope.SignatureVersion))
+	}
+	signature, err := base64.StdEncoding.DecodeString(envelope.Signature)
+	if err != nil {
+		return sqsworker.Fatal(fmt.Errorf("events: invalid SNS signature: %v", err))
+	}
+	u, err := url.Parse(envelope.SigningCertURL)
+	if err != nil || u.Scheme != "https" || !snsCertHost.MatchString(u.Host) {
+		return sqsworker.Fatal(fmt.Errorf("events: SNS signing certificate %s is not from SNS", envelope.SigningCertURL))
+	}
+
+	cert, err := v.certificate(ctx, envelope.SigningCertURL)
+	if err != nil {
+		return &certError{err}
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return sqsworker.Fatal(errors.New("events: SNS signing certificate does not have an RSA key"))
+	}
+
+	// the signed string is the notification's fields in alphabetical order, see
+	// https://docs.aws.amazon.com/sns/latest/dg/sns-verify-signature-of-message.html
+	fields := []struct{ name, value string }{
+		{"Message", envelope.Message},
+		{"MessageId", envelope.MessageID},
+		{"Subject", envelope.Subject},
+		{"Timestamp", envelope.Timestamp},
+		{"TopicArn", envelope.TopicArn},
+		{"Type", envelope.Type},
+	}
+	for _, f := range fields {
+		if f.name == "Subject" && f.value == "" {
+			continue
+		}
+		h.Write([]byte(f.name + "\n" + f.value + "\n"))
+	}
+	if err := rsa.VerifyPKCS1v15(key, algorithm, h.Sum(nil), signature); err != nil {
+		return sqsworker.Fatal(fmt.Errorf("events: invalid SNS signature: %v", err))
+	}
+	return nil
+}
+
+// Decode is a sqsworker.Decoder for queues fed by an SNS subscription without raw message
+// delivery. It checks the signature of the notification a body holds and returns its message.
+// Bodies that are not SNS notifications, or whose signature is invalid, fail to decode and are
+// quarantined. A certificate failing to download is temporary, and the message is retried.
+func (v *SNSVerifier) Decode(body []byte) ([]byte, error) {
+	envelope, ok := parseSNS(body)
+	if !ok {
+		return nil, errors.New("events: message is not an SNS notification")
+	}
+	if err := v.verify(context.Background(), envelope); err != nil {
+		return nil, err
+	}
+	return []byte(envelope.Message), nil
+}
+
+// certError is a signing certificate failing to download
+type certError struct {
+	err error
+}
+
+func (c *certError) Error() string {
+	return "events: fetching SNS signing certificate: " + c.err.Error()
+}
+
+func (c *certError) Unwrap() error {
+	return c.err
+}
+
+// Temporary reports that the certificate may download on another attempt
+func (c *certError) Temporary() bool {
+	return true
+}
+
+// certificate returns a cached signing certificate, fetching it when it is not cached. A
+// certificate is fetched once at a time, by the first caller, and the others wait for it.
+func (v *SNSVerifier) certificate(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	v.mu.Lock()
+	if cert, ok := v.certs[certURL]; ok {
+		v.mu.Unlock()
+		return cert, nil
+	}
+	if f, ok := v.fetches[certURL]; ok {
+		v.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.cert, f.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	f := &certFetch{done: make(chan struct{})}
+	if v.fetches == nil {
+		v.fetches = make(map[string]*certFetch)
+	}
+	v.fetches[certURL] = f
+	v.mu.Unlock()
+
+	if v.Certificate != nil {
+		f.cert, f.err = v.Certificate(certURL)
+	} else {
+		client := v.Client
+		if client == nil {
+			client = defaultCertClient
+		}
+		f.cert, f.err = fetchCertificate(ctx, client, certURL)
+	}
+
+	v.mu.Lock()
+	delete(v.fetches, certURL)
+	if f.err == nil {
+		if v.certs == nil {
+			v.certs = make(map[string]*x509.Certificate)
+		}
+		v.certs[certURL] = f.cert
+	}
+	v.mu.Unlock()
+	close(f.done)
+	return f.cert, f.err
+}
+
+// fetchCertificate downloads a PEM encoded certificate
+func fetchCertificate(ctx context.Context, client *http.Client, certURL string) (*x509.Certificate, error) {
+	req, err := http.NewRequest(http.MethodGet, certURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, errors.New("events: SNS signing certificate is not PEM encoded")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
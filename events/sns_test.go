@@ -0,0 +1,106 @@
+package events_test
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/events"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSNSVerifierDecode(t *testing.T) {
+	s := newSigner(t)
+	fetches := 0
+	var fetchErr error
+	verifier := &events.SNSVerifier{Certificate: func(string) (*x509.Certificate, error) {
+		fetches++
+		return s.cert, fetchErr
+	}}
+
+	var bodies []string
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			bodies = append(bodies, *m.Body)
+			return nil, nil
+		}),
+		Decoders:           []sqsworker.Decoder{verifier.Decode},
+		QuarantineQueueURL: workertest.QueueURL + "-quarantine",
+	})
+
+	// A certificate failing to download is retried
+	fetchErr = errors.New("connection reset")
+	result := h.Run(workertest.NewMessage(s.notification(t, "hello"))).Failed().NotDeleted()
+	if sqsworker.IsInvalid(result.Err) {
+		t.Error("Expected a temporary error, got: ", result.Err)
+	}
+	fetchErr = nil
+
+	h.Run(workertest.NewMessage(s.notification(t, "hello"))).Succeeded().Deleted()
+	h.Run(workertest.NewMessage(s.notification(t, "world"))).Succeeded().Deleted()
+	if len(bodies) != 2 || bodies[0] != "hello" || bodies[1] != "world" {
+		t.Error("unexpected bodies: ", bodies)
+	}
+	if fetches != 2 {
+		t.Error("Expected the certificate to be cached, fetched: ", fetches)
+	}
+
+	// Forged notifications, and bodies that were not delivered by SNS, are quarantined
+	var forged map[string]string
+	json.Unmarshal([]byte(s.notification(t, "hello")), &forged)
+	forged["Message"] = "goodbye"
+	forgedBody, _ := json.Marshal(forged)
+	var untrusted map[string]string
+	json.Unmarshal([]byte(s.notification(t, "hello")), &untrusted)
+	untrusted["SigningCertURL"] = "https://example.com/cert.pem"
+	untrustedBody, _ := json.Marshal(untrusted)
+	for _, body := range []string{string(forgedBody), string(untrustedBody), "hello"} {
+		result := h.Run(workertest.NewMessage(body)).Failed().Quarantined()
+		if !sqsworker.IsInvalid(result.Err) || aws.StringValue(result.Sent[0].MessageBody) != body {
+			t.Error("unexpected result: ", result.Err, result.Sent)
+		}
+	}
+	if len(bodies) != 2 {
+		t.Error("Expected untrusted bodies not to be processed, got: ", bodies)
+	}
+}
+
+func TestSNSVerifierFetchesOnce(t *testing.T) {
+	s := newSigner(t)
+	var fetches int32
+	started, release := make(chan bool, 1), make(chan bool)
+	verifier := &events.SNSVerifier{Certificate: func(string) (*x509.Certificate, error) {
+		atomic.AddInt32(&fetches, 1)
+		started <- true
+		<-release
+		return s.cert, nil
+	}}
+
+	// the notifications verified while the certificate downloads wait for the one download
+	body := []byte(s.notification(t, "hello"))
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			_, err := verifier.Decode(body)
+			errs <- err
+		}()
+	}
+	<-started
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	for i := 0; i < 5; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if fetches := atomic.LoadInt32(&fetches); fetches != 1 {
+		t.Error("Actual: ", fetches, "Expected: ", 1)
+	}
+}
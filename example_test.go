@@ -13,9 +13,8 @@ import (
 type LowerCaseWorker struct {
 }
 
-func (l *LowerCaseWorker) Process(ctx context.Context, m *sqs.Message, w *sns.PublishInput) error {
-	*w.Message = strings.ToLower(*m.Body)
-	return nil
+func (l *LowerCaseWorker) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	return &sns.PublishInput{Message: aws.String(strings.ToLower(*m.Body))}, nil
 }
 
 func ExampleWorker() {
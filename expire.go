@@ -0,0 +1,44 @@
+package sqsworker
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"sync/atomic"
+	"time"
+)
+
+// ErrExpired is the error attribute of the messages sent to the ExpiredQueueURL, and the error
+// of an expired message that could not be sent there
+var ErrExpired = errors.New("sqsworker: message expired")
+
+// messageAge is the time since the message was sent, according to its SentTimestamp, or zero
+// when it has none
+func messageAge(m *sqs.Message) time.Duration {
+	sent := attributeInt(m.Attributes, sqs.MessageSystemAttributeNameSentTimestamp)
+	if sent == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, sent*int64(time.Millisecond)))
+}
+
+// expired reports whether the message is older than the MessageTTL
+func (w *Worker) expired(m *sqs.Message) bool {
+	return w.MessageTTL > 0 && messageAge(m) > w.MessageTTL
+}
+
+// expire deletes a message older than the MessageTTL, sending it to the ExpiredQueueURL first
+// when one is set. A message that cannot be sent there is left on the queue to be retried.
+func (w *Worker) expire(ctx context.Context, state *consumerState, msg message, result *Result) error {
+	atomic.AddInt64(&w.stats.expired, 1)
+	result.Expired = true
+	if w.ExpiredQueueURL != "" && !w.forward(state, msg, w.ExpiredQueueURL, "expired", ErrExpired) {
+		return ErrExpired
+	}
+	err := w.delete(ctx, state, msg)
+	if err != nil {
+		w.logConsumerError(state, "delete message failed!", err)
+	}
+	result.Deleted = err == nil
+	return err
+}
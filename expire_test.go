@@ -0,0 +1,51 @@
+package sqsworker_test
+
+import (
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// sentAt sets the SentTimestamp of a message
+func sentAt(t time.Time) workertest.MessageOption {
+	return workertest.SystemAttribute(sqs.MessageSystemAttributeNameSentTimestamp, strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10))
+}
+
+func TestMessageTTL(t *testing.T) {
+	var results []sqsworker.Result
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:   "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor:  &LowerCaseWorker{},
+		MessageTTL: time.Hour,
+		Callback:   func(r sqsworker.Result) { results = append(results, r) },
+	})
+
+	h.Run(workertest.NewMessage("FRESH", sentAt(time.Now().Add(-time.Minute)))).Succeeded().Deleted().Published("fresh")
+	h.Run(workertest.NewMessage("STALE", sentAt(time.Now().Add(-72*time.Hour)))).Succeeded().Deleted().NotPublished()
+	if len(results) != 2 || results[0].Expired || !results[1].Expired || !results[1].Deleted {
+		t.Error("unexpected results: ", results)
+	}
+	if stats := h.Worker.Stats(); stats.Expired != 1 || stats.Processed != 1 {
+		t.Error("unexpected stats: ", stats)
+	}
+}
+
+func TestMessageTTLExpiredQueue(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor:       &LowerCaseWorker{},
+		MessageTTL:      time.Hour,
+		ExpiredQueueURL: "https://sqs.us-east-1.amazonaws.com/88888888888/expired",
+	})
+
+	h.Run(workertest.NewMessage("STALE", sentAt(time.Now().Add(-72*time.Hour)))).Succeeded().Deleted()
+	if len(h.Queue.Sent) != 1 || aws.StringValue(h.Queue.Sent[0].QueueUrl) != h.Worker.ExpiredQueueURL {
+		t.Fatal("Expected the message to be sent to the expired queue, got ", h.Queue.Sent)
+	}
+	if cause := h.Queue.Sent[0].MessageAttributes[sqsworker.ErrorAttribute]; aws.StringValue(cause.StringValue) != sqsworker.ErrExpired.Error() {
+		t.Error("unexpected error attribute: ", cause)
+	}
+}
@@ -0,0 +1,34 @@
+package sqsworker
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+)
+
+// ExpvarPrefix namespace of the variables published by PublishExpvar
+const ExpvarPrefix = "worker."
+
+// expvarMu makes checking and publishing a name atomic, expvar.Publish panics on a name
+// published by a concurrent PublishExpvar
+var expvarMu sync.Mutex
+
+// PublishExpvar publishes the worker's Stats and Settings with expvar under worker.<name>,
+// so they are served by the expvar handler at /debug/vars. Names must be unique per process:
+// expvar variables cannot be unpublished, so a worker recreated with the same Name can never
+// publish again, and keeps serving the first worker's values.
+func (w *Worker) PublishExpvar() error {
+	name := ExpvarPrefix + w.Name
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("sqsworker: expvar %s already published", name)
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return struct {
+			Stats
+			Settings Settings
+		}{w.Stats(), w.Settings()}
+	}))
+	return nil
+}
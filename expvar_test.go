@@ -0,0 +1,63 @@
+package sqsworker_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"go.uber.org/zap"
+	"sync/atomic"
+	"testing"
+)
+
+// expvarApps numbers the published workers, since expvar names stay published for the life
+// of the test binary
+var expvarApps int32
+
+func TestPublishExpvar(t *testing.T) {
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   2,
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+		Name:      fmt.Sprint("ExpvarApp", atomic.AddInt32(&expvarApps, 1)),
+	})
+	if err := w.PublishExpvar(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.PublishExpvar(); err == nil {
+		t.Error("Expected an error publishing the same name twice")
+	}
+
+	var vars struct {
+		Processed int64
+		Settings  sqsworker.Settings
+	}
+	if err := json.Unmarshal([]byte(expvar.Get(sqsworker.ExpvarPrefix+w.Name).String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars.Settings.Consumers != 2 {
+		t.Error("Actual: ", vars.Settings.Consumers, "Expected: ", 2)
+	}
+}
+
+func TestPublishExpvarConcurrently(t *testing.T) {
+	name := fmt.Sprint("ExpvarApp", atomic.AddInt32(&expvarApps, 1))
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			// workers sharing a name publish it once, and the others fail instead of panicking
+			w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{QueueURL: workerQueueURL, Logger: zap.NewNop(), Name: name})
+			errs <- w.PublishExpvar()
+		}()
+	}
+	published := 0
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err == nil {
+			published++
+		}
+	}
+	if published != 1 {
+		t.Error("Actual: ", published, "Expected: ", 1)
+	}
+}
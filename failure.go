@@ -0,0 +1,117 @@
+package sqsworker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// FailureClass is the kind of failure of a message, telling failures caused by the message or the
+// Processor apart from outages of the services it depends on
+type FailureClass int
+
+const (
+	// OtherFailure is any error not in another class
+	OtherFailure FailureClass = iota
+	// TimeoutFailure is a context deadline or a network timeout
+	TimeoutFailure
+	// DecodeFailure is a message that failed the Decoders or Validator, or could not be unmarshaled
+	DecodeFailure
+	// DownstreamFailure is a 5xx response from AWS or another HTTP service
+	DownstreamFailure
+	// PanicFailure is a Processor that panicked
+	PanicFailure
+)
+
+func (c FailureClass) String() string {
+	switch c {
+	case OtherFailure:
+		return "other"
+	case TimeoutFailure:
+		return "timeout"
+	case DecodeFailure:
+		return "decode"
+	case DownstreamFailure:
+		return "downstream"
+	case PanicFailure:
+		return "panic"
+	}
+	return "unknown"
+}
+
+// FailureCounts are the failed messages by FailureClass
+type FailureCounts struct {
+	Other      int64
+	Timeout    int64
+	Decode     int64
+	Downstream int64
+	Panic      int64
+}
+
+// PanicError is the error of a Processor that panicked, with the stack of the panic
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("sqsworker: processor panicked: %v", p.Value)
+}
+
+// recoverPanic sets the error of a Processor that panicked
+func recoverPanic(err *error) {
+	if value := recover(); value != nil {
+		*err = &PanicError{Value: value, Stack: debug.Stack()}
+	}
+}
+
+// statusCoder is an error with the status code of an HTTP response, such as awserr.RequestFailure
+type statusCoder interface {
+	StatusCode() int
+}
+
+// ClassifyFailure returns the class of the error of a failed message, unwrapping the error to
+// find its cause
+func ClassifyFailure(err error) FailureClass {
+	var panicked *PanicError
+	var invalid *invalidError
+	var syntax *json.SyntaxError
+	var unmarshal *json.UnmarshalTypeError
+	var corrupt base64.CorruptInputError
+	var netErr net.Error
+	var status statusCoder
+	switch {
+	case err == nil:
+		return OtherFailure
+	case errors.As(err, &panicked):
+		return PanicFailure
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return TimeoutFailure
+	case errors.As(err, &invalid), errors.As(err, &syntax), errors.As(err, &unmarshal), errors.As(err, &corrupt):
+		return DecodeFailure
+	case errors.As(err, &status) && status.StatusCode() >= 500:
+		return DownstreamFailure
+	}
+	return OtherFailure
+}
+
+// countFailure counts a failed message by the class of its error
+func (s *stats) countFailure(err error) {
+	atomic.AddInt64(&s.failures[ClassifyFailure(err)], 1)
+}
+
+// failureCounts returns the failed messages by class
+func (s *stats) failureCounts() FailureCounts {
+	return FailureCounts{
+		Other:      atomic.LoadInt64(&s.failures[OtherFailure]),
+		Timeout:    atomic.LoadInt64(&s.failures[TimeoutFailure]),
+		Decode:     atomic.LoadInt64(&s.failures[DecodeFailure]),
+		Downstream: atomic.LoadInt64(&s.failures[DownstreamFailure]),
+		Panic:      atomic.LoadInt64(&s.failures[PanicFailure]),
+	}
+}
@@ -0,0 +1,61 @@
+package sqsworker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"testing"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	var syntax error = &json.SyntaxError{}
+	cases := []struct {
+		err      error
+		expected sqsworker.FailureClass
+	}{
+		{errors.New("failed"), sqsworker.OtherFailure},
+		{fmt.Errorf("lookup: %w", context.DeadlineExceeded), sqsworker.TimeoutFailure},
+		{fmt.Errorf("decode order: %w", syntax), sqsworker.DecodeFailure},
+		{sqsworker.Fatal(awserr.NewRequestFailure(awserr.New("InternalError", "failed", nil), 503, "id")), sqsworker.DownstreamFailure},
+		{awserr.NewRequestFailure(awserr.New("NotFound", "failed", nil), 404, "id"), sqsworker.OtherFailure},
+		{&sqsworker.PanicError{Value: "boom"}, sqsworker.PanicFailure},
+	}
+	for _, c := range cases {
+		if actual := sqsworker.ClassifyFailure(c.err); actual != c.expected {
+			t.Error("Actual: ", actual, "Expected: ", c.expected, "for: ", c.err)
+		}
+	}
+}
+
+func TestFailureCounts(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			switch *m.Body {
+			case "panic":
+				panic("boom")
+			case "timeout":
+				return nil, context.DeadlineExceeded
+			}
+			var order struct{}
+			return nil, json.Unmarshal([]byte(*m.Body), &order)
+		}),
+	})
+
+	result := h.Run(workertest.NewMessage("panic")).Failed().NotDeleted()
+	if panicked, ok := result.Err.(*sqsworker.PanicError); !ok || panicked.Value != "boom" || len(panicked.Stack) == 0 {
+		t.Error("Expected a panic error, got: ", result.Err)
+	}
+	h.Run(workertest.NewMessage("timeout")).Failed()
+	h.Run(workertest.NewMessage("{")).Failed()
+
+	expected := sqsworker.FailureCounts{Timeout: 1, Decode: 1, Panic: 1}
+	if actual := h.Worker.Stats().Failures; actual != expected {
+		t.Error("Actual: ", actual, "Expected: ", expected)
+	}
+}
@@ -0,0 +1,49 @@
+package sqsworker
+
+// weightedRoundRobin interleaves the input queues in proportion to their weights, spreading the
+// receives of each queue over the cycle rather than polling them in runs
+type weightedRoundRobin struct {
+	weights []int
+	current []int
+	total   int
+}
+
+// newWeightedRoundRobin creates a weightedRoundRobin over n queues, the queues without a positive
+// weight have a weight of one
+func newWeightedRoundRobin(weights []int, n int) *weightedRoundRobin {
+	r := &weightedRoundRobin{weights: make([]int, n), current: make([]int, n)}
+	for i := range r.weights {
+		r.weights[i] = 1
+		if i < len(weights) && weights[i] > 0 {
+			r.weights[i] = weights[i]
+		}
+		r.total += r.weights[i]
+	}
+	return r
+}
+
+// next returns the index of the queue whose turn it is
+func (r *weightedRoundRobin) next() int {
+	best := 0
+	for i, weight := range r.weights {
+		r.current[i] += weight
+		if r.current[i] > r.current[best] {
+			best = i
+		}
+	}
+	r.current[best] -= r.total
+	return best
+}
+
+// order appends to buf the queue whose turn it is followed by the others, which are polled in
+// turn when it has no messages
+func (r *weightedRoundRobin) order(buf []int) []int {
+	first := r.next()
+	buf = append(buf, first)
+	for i := range r.weights {
+		if i != first {
+			buf = append(buf, i)
+		}
+	}
+	return buf
+}
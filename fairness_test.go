@@ -0,0 +1,40 @@
+package sqsworker_test
+
+import (
+	"github.com/ajbeach2/sqsworker"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestQueueWeights(t *testing.T) {
+	queue := &PriorityQueue{Messages: map[string][]string{
+		queueBase + "Flooded": {"f1", "f2", "f3", "f4", "f5", "f6"},
+		queueBase + "Quiet":   {"q1", "q2"},
+	}}
+	// the flooded queue is received from twice for each receive from the quiet queue, until the
+	// quiet queue is empty
+	expected := []string{"f1", "q1", "f2", "f3", "q2", "f4", "f5", "f6"}
+	handler := &RecordingWorker{Bodies: make(chan string, len(expected))}
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURLs:    []string{queueBase + "Flooded", queueBase + "Quiet"},
+		QueueWeights: []int{2, 1},
+		Workers:      1,
+		Logger:       zap.NewNop(),
+		Processor:    handler,
+		Name:         "TestApp",
+	})
+	w.Queue = queue
+
+	go func() {
+		for i, body := range expected {
+			actual := <-handler.Bodies
+			if actual != body {
+				t.Error("Message ", i, "Actual: ", actual, "Expected: ", body)
+			}
+		}
+		w.Close()
+	}()
+
+	w.Run()
+}
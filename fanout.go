@@ -0,0 +1,125 @@
+package sqsworker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"strconv"
+)
+
+// Publish is one of the results of a FanOut
+type Publish struct {
+	// Destination is the name of one of the worker's Destinations. A result without one is
+	// routed like the result of any other Processor.
+	Destination string
+	Output      *sns.PublishInput
+}
+
+// FanOut adapts a function producing several results for each message, each with its own body
+// and destination, to a Processor. Every destination is checked before anything is published,
+// so a result with an unknown destination fails the message with a Fatal error. The message is
+// deleted once every result was published; when some fail it is left on the queue with a
+// *FanOutError, and with a PublishStore only the failed results are published again on its
+// next receive.
+type FanOut func(ctx context.Context, m *sqs.Message) ([]Publish, error)
+
+// errFanOut is returned when a FanOut is called with a context not created by a Worker
+var errFanOut = errors.New("sqsworker: FanOut must be run by a Worker")
+
+// Process calls f and hands its results to the worker handling the message
+func (f FanOut) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	publishes, err := f(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := ctx.Value(metadataKey{}).(*messageContext)
+	if !ok {
+		return nil, errFanOut
+	}
+	c.publishes = publishes
+	return nil, nil
+}
+
+// fannedOut returns the results of a FanOut run with the context
+func fannedOut(ctx context.Context) []Publish {
+	if c, ok := ctx.Value(metadataKey{}).(*messageContext); ok {
+		return c.publishes
+	}
+	return nil
+}
+
+// FanOutError is returned when some of the results of a FanOut failed to publish
+type FanOutError struct {
+	// Errs holds the error of each result, nil for the results that were published
+	Errs []error
+}
+
+func (e *FanOutError) Error() string {
+	failed := 0
+	var first error
+	for _, err := range e.Errs {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	return fmt.Sprintf("sqsworker: %d of %d results failed to publish: %v", failed, len(e.Errs), first)
+}
+
+// routeFanOut finds the destination of each result of a FanOut before any is published
+func (w *Worker) routeFanOut(state *consumerState, msg message, publishes []Publish) error {
+	state.fanOut = state.fanOut[:0]
+	for _, p := range publishes {
+		var dest Destination
+		var err error
+		if p.Destination != "" && p.Output != nil {
+			dest, err = w.destination(p.Destination, p.Output)
+		} else {
+			dest, err = w.route(msg, p.Output)
+		}
+		if err != nil {
+			return err
+		}
+		state.fanOut = append(state.fanOut, dest)
+	}
+	return nil
+}
+
+// publishFanOut publishes every result of a FanOut, recording each in the PublishStore under the
+// message id and its index, and returns a *FanOutError when any failed. Consecutive results for
+// the same topic are published in batches, unless they are written to an Outbox.
+func (w *Worker) publishFanOut(ctx context.Context, state *consumerState, msg message, result *Result) error {
+	errs := make([]error, len(result.FanOut))
+	batch := &publishBatch{}
+	for i, p := range result.FanOut {
+		var key string
+		if msg.MessageId != nil {
+			key = *msg.MessageId + "/" + strconv.Itoa(i)
+		}
+		output, dest, err := w.prepare(state, msg, key, p.Output, state.fanOut[i])
+		switch {
+		case output == nil || err != nil:
+			errs[i] = err
+		case w.Outbox != nil || dest.QueueURL != "" || dest.Sink != nil:
+			errs[i] = w.publishOnce(ctx, state, msg, key, output, dest, result)
+		case !w.alreadyPublished(ctx, state, key, result):
+			if !batch.fits(aws.StringValue(output.TopicArn), output) {
+				w.publishBatch(ctx, state, batch, errs, result)
+			}
+			batch.add(i, key, output)
+		}
+	}
+	w.publishBatch(ctx, state, batch, errs, result)
+
+	for _, err := range errs {
+		if err != nil {
+			return &FanOutError{Errs: errs}
+		}
+	}
+	return nil
+}
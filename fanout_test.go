@@ -0,0 +1,82 @@
+package sqsworker_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fanOutOrder publishes an order to the worker's topic, the orders topic and the audit queue
+func fanOutOrder(ctx context.Context, m *sqs.Message) ([]sqsworker.Publish, error) {
+	return []sqsworker.Publish{
+		{Output: &sns.PublishInput{Message: m.Body}},
+		{Destination: "order", Output: &sns.PublishInput{Message: aws.String(strings.ToUpper(*m.Body))}},
+		{Destination: "audit", Output: &sns.PublishInput{Message: aws.String("audit " + *m.Body)}},
+	}, nil
+}
+
+func TestFanOut(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:     "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor:    sqsworker.FanOut(fanOutOrder),
+		Destinations: destinations,
+	})
+
+	result := h.Run(workertest.NewMessage("placed")).Succeeded().Deleted().Published("placed", "PLACED")
+	if actual := aws.StringValue(result.Publishes[1].TopicArn); actual != ordersTopicArn {
+		t.Error("Actual: ", actual, "Expected: ", ordersTopicArn)
+	}
+	if len(result.Sent) != 1 || *result.Sent[0].QueueUrl != auditQueueURL || *result.Sent[0].MessageBody != "audit placed" {
+		t.Error("Expected the audit result to be sent to the queue, got ", result.Sent)
+	}
+
+	// Every destination is checked before anything is published
+	h.Worker.Processor = sqsworker.FanOut(func(ctx context.Context, m *sqs.Message) ([]sqsworker.Publish, error) {
+		return []sqsworker.Publish{
+			{Output: &sns.PublishInput{Message: m.Body}},
+			{Destination: "unknown", Output: &sns.PublishInput{Message: m.Body}},
+		}, nil
+	})
+	result = h.Run(workertest.NewMessage("placed")).Failed().NotDeleted().NotPublished()
+	if !sqsworker.IsFatal(result.Err) {
+		t.Error("Expected a fatal error, got ", result.Err)
+	}
+
+	if _, err := sqsworker.FanOut(fanOutOrder).Process(context.Background(), workertest.NewMessage("placed")); err == nil {
+		t.Error("Expected a FanOut outside of a worker to fail")
+	}
+}
+
+func TestFanOutPartialFailure(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:     "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor:    sqsworker.FanOut(fanOutOrder),
+		Destinations: destinations,
+		PublishStore: sqsworker.NewMemoryPublishStore(time.Minute),
+	})
+	h.Topic.PublishErr = errors.New("throttled")
+
+	m := workertest.NewMessage("placed")
+	result := h.Run(m).Failed().NotDeleted().NotPublished()
+	fanOutErr, ok := result.Err.(*sqsworker.FanOutError)
+	if !ok || fanOutErr.Errs[0] == nil || fanOutErr.Errs[1] == nil || fanOutErr.Errs[2] != nil {
+		t.Fatal("Expected the topic results to fail, got ", result.Err)
+	}
+	if len(result.Sent) != 1 {
+		t.Error("Expected the audit result to be sent, got ", result.Sent)
+	}
+
+	// Only the failed results are published again
+	h.Topic.PublishErr = nil
+	result = h.Run(m).Succeeded().Deleted().Published("placed", "PLACED")
+	if len(result.Sent) != 0 {
+		t.Error("Expected the audit result not to be sent again, got ", result.Sent)
+	}
+}
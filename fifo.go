@@ -0,0 +1,71 @@
+package sqsworker
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"strings"
+)
+
+// FIFOFunc derives the message group or deduplication ID of a result from the message it was
+// produced from. An empty ID falls back to the default.
+type FIFOFunc func(*sqs.Message, *sns.PublishInput) string
+
+// FIFOConfig settings for results sent to FIFO topics and queues. The group defaults to the group
+// of the inbound message, and the deduplication ID to its MessageId unless the destination has
+// ContentBasedDeduplication.
+type FIFOConfig struct {
+	MessageGroupID            FIFOFunc
+	DeduplicationID           FIFOFunc
+	ContentBasedDeduplication bool
+}
+
+// fifo returns the result with the MessageGroupId and MessageDeduplicationId a FIFO topic or
+// queue requires, when the Processor did not set them. The group defaults to the group of the
+// inbound message, and the deduplication ID to its key, the MessageId or the MessageId and index
+// of the results of a FanOut, so a redelivered message is not published twice within the
+// deduplication interval. The result is copied to the consumer's output before it is changed.
+func (w *Worker) fifo(state *consumerState, msg message, key string, output *sns.PublishInput, dest Destination) (*sns.PublishInput, error) {
+	target := dest.QueueURL
+	if target == "" {
+		target = aws.StringValue(output.TopicArn)
+	}
+	if !strings.HasSuffix(target, ".fifo") {
+		return output, nil
+	}
+
+	group := aws.StringValue(output.MessageGroupId)
+	if group == "" && w.FIFO.MessageGroupID != nil {
+		group = w.FIFO.MessageGroupID(msg.Message, output)
+	}
+	if group == "" {
+		group = aws.StringValue(msg.Attributes[sqs.MessageSystemAttributeNameMessageGroupId])
+	}
+	if group == "" {
+		return output, Fatal(errors.New("sqsworker: results sent to " + target + " need a MessageGroupId"))
+	}
+
+	dedup := aws.StringValue(output.MessageDeduplicationId)
+	if dedup == "" && !w.FIFO.ContentBasedDeduplication {
+		if w.FIFO.DeduplicationID != nil {
+			dedup = w.FIFO.DeduplicationID(msg.Message, output)
+		}
+		if dedup == "" {
+			dedup = key
+		}
+	}
+
+	if group == aws.StringValue(output.MessageGroupId) && dedup == aws.StringValue(output.MessageDeduplicationId) {
+		return output, nil
+	}
+	if output != &state.output {
+		state.output = *output
+		output = &state.output
+	}
+	output.MessageGroupId = aws.String(group)
+	if dedup != "" {
+		output.MessageDeduplicationId = aws.String(dedup)
+	}
+	return output, nil
+}
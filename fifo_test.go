@@ -0,0 +1,72 @@
+package sqsworker_test
+
+import (
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"testing"
+)
+
+const fifoTopicArn = "arn:aws:sns:us-east-1:88888888888:Out.fifo"
+
+func TestFIFOTopic(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:  fifoTopicArn,
+		Processor: &LowerCaseWorker{},
+	})
+
+	// The group and deduplication ID default to the inbound message
+	m := workertest.NewMessage("HELLO", workertest.SystemAttribute("MessageGroupId", "customer-1"))
+	result := h.Run(m).Succeeded().Published("hello")
+	published := result.Publishes[0]
+	if aws.StringValue(published.MessageGroupId) != "customer-1" || aws.StringValue(published.MessageDeduplicationId) != *m.MessageId {
+		t.Error("unexpected FIFO fields: ", published)
+	}
+
+	// Messages from a standard queue have no group to default to
+	result = h.Run(workertest.NewMessage("HELLO")).Failed().NotPublished()
+	if !sqsworker.IsFatal(result.Err) {
+		t.Error("Expected a fatal error, got: ", result.Err)
+	}
+
+	// Standard topics are unchanged
+	h = workertest.New(t, sqsworker.WorkerConfig{TopicArn: "arn:aws:sns:us-east-1:88888888888:Out", Processor: &LowerCaseWorker{}})
+	published = h.Run(m).Succeeded().Publishes[0]
+	if published.MessageGroupId != nil || published.MessageDeduplicationId != nil {
+		t.Error("unexpected FIFO fields: ", published)
+	}
+}
+
+func TestFIFOHooks(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:  fifoTopicArn,
+		Processor: &LowerCaseWorker{},
+		FIFO: sqsworker.FIFOConfig{
+			MessageGroupID: func(m *sqs.Message, output *sns.PublishInput) string {
+				return aws.StringValue(m.MessageAttributes["customer"].StringValue)
+			},
+			ContentBasedDeduplication: true,
+		},
+	})
+
+	published := h.Run(workertest.NewMessage("HELLO", workertest.Attribute("customer", "customer-2"))).Succeeded().Publishes[0]
+	if aws.StringValue(published.MessageGroupId) != "customer-2" || published.MessageDeduplicationId != nil {
+		t.Error("unexpected FIFO fields: ", published)
+	}
+
+	// IDs set by the Processor are kept
+	output := &sns.PublishInput{Message: aws.String("result"), MessageGroupId: aws.String("group"), MessageDeduplicationId: aws.String("dedup")}
+	h = workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:  fifoTopicArn,
+		Processor: &EventWorker{Output: func(*sqs.Message) *sns.PublishInput { return output }},
+		FIFO: sqsworker.FIFOConfig{
+			DeduplicationID: func(*sqs.Message, *sns.PublishInput) string { return "hook" },
+		},
+	})
+	published = h.Run(workertest.NewMessage("HELLO")).Succeeded().Publishes[0]
+	if aws.StringValue(published.MessageGroupId) != "group" || aws.StringValue(published.MessageDeduplicationId) != "dedup" {
+		t.Error("unexpected FIFO fields: ", published)
+	}
+}
@@ -0,0 +1,552 @@
+// Package filequeue provides a durable on-disk sqsworker.Backend, so the whole worker pipeline
+// runs on a laptop with no network. Each queue is a directory of the Backend's Dir, created
+// when it is first used, and results are sent to its queues with a QueueSink:
+//
+//	backend, err := filequeue.New("/tmp/queues")
+//	if err != nil {
+//		return err
+//	}
+//	defer backend.Close()
+//	w := sqsworker.NewWorker(nil, sqsworker.WorkerConfig{
+//		Backend:   backend,
+//		QueueURL:  "orders",
+//		Processor: processor,
+//		Sink:      sqsworker.NewQueueSink(sqsworker.NewBackendQueue(backend), sqsworker.QueueConfig{QueueURL: "processed"}),
+//	})
+//
+// A queue is an append-only log of JSON lines, one record for each message sent, received,
+// made visible or deleted, split in numbered segment files that can be inspected with any
+// text tool. The log is replayed when a queue is opened, so messages and their visibility
+// leases survive restarts. Segments are removed once every message sent to them and to the
+// segments before them was deleted. Only one Backend may use a directory at a time.
+package filequeue
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSegmentSize is the size in bytes after which a new segment is started
+const DefaultSegmentSize = 4 << 20
+
+// DefaultVisibility is the visibility timeout of messages received with none
+const DefaultVisibility = sqsworker.DefaultVisibilityTimeout * time.Second
+
+// SegmentExt is the extension of the segment files
+const SegmentExt = ".jsonl"
+
+// ErrNotReceived is returned for a receipt handle of a message that was deleted or received
+// again since
+var ErrNotReceived = errors.New("filequeue: the message is not received")
+
+// ErrInvalidName is returned for queue names that are not a plain directory name
+var ErrInvalidName = errors.New("filequeue: invalid queue name")
+
+// Record operations
+const (
+	opSend       = "send"
+	opReceive    = "receive"
+	opVisibility = "visibility"
+	opDelete     = "delete"
+)
+
+// record is a line of a segment
+type record struct {
+	Op         string               `json:"op"`
+	ID         string               `json:"id"`
+	Body       string               `json:"body,omitempty"`
+	Attributes map[string]attribute `json:"attributes,omitempty"`
+	Group      string               `json:"group,omitempty"`
+	Sent       *time.Time           `json:"sent,omitempty"`
+	Visible    *time.Time           `json:"visible,omitempty"`
+	Count      int                  `json:"count,omitempty"`
+}
+
+// attribute is a message attribute of a record
+type attribute struct {
+	Type   string  `json:"type"`
+	String *string `json:"string,omitempty"`
+	Binary []byte  `json:"binary,omitempty"`
+}
+
+// message is a message of a queue that was not deleted
+type message struct {
+	id         string
+	body       string
+	attributes map[string]*sqs.MessageAttributeValue
+	group      string
+	sent       time.Time
+	visible    time.Time
+	count      int
+	segment    int
+}
+
+// segment counts the messages sent to a segment file that were not deleted
+type segment struct {
+	number int
+	live   int
+}
+
+// queue is the state of an open queue, replayed from its segments
+type queue struct {
+	dir      string
+	messages []*message
+	byID     map[string]*message
+	segments []segment
+	active   *os.File
+	size     int64
+	// notify is closed when messages may have become visible
+	notify chan struct{}
+}
+
+// Backend is a sqsworker.Backend keeping queues in directories of Dir
+type Backend struct {
+	Dir string
+	// SegmentSize defaults to DefaultSegmentSize
+	SegmentSize int64
+	// Sync flushes every record to the disk before returning, so messages also survive a
+	// crash of the machine and not only of the process
+	Sync   bool
+	mu     sync.Mutex
+	queues map[string]*queue
+}
+
+// New creates a Backend keeping its queues in dir, creating it when it does not exist
+func New(dir string) (*Backend, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Backend{Dir: dir, queues: make(map[string]*queue)}, nil
+}
+
+// queue returns an open queue, opening it when it is first used. It is called with the lock
+// held.
+func (b *Backend) queue(name string) (*queue, error) {
+	if q, ok := b.queues[name]; ok {
+		return q, nil
+	}
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return nil, ErrInvalidName
+	}
+	if b.queues == nil {
+		b.queues = make(map[string]*queue)
+	}
+	q := &queue{dir: filepath.Join(b.Dir, name), byID: make(map[string]*message), notify: make(chan struct{})}
+	if err := q.open(); err != nil {
+		return nil, err
+	}
+	b.queues[name] = q
+	return q, nil
+}
+
+// open replays the segments of the queue and opens the last one for appending
+func (q *queue) open() error {
+	if err := os.MkdirAll(q.dir, 0755); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+	var numbers []int
+	for _, f := range files {
+		if n, err := strconv.Atoi(strings.TrimSuffix(f.Name(), SegmentExt)); err == nil && strings.HasSuffix(f.Name(), SegmentExt) {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	for _, n := range numbers {
+		q.segments = append(q.segments, segment{number: n})
+		if err := q.replay(n); err != nil {
+			return err
+		}
+	}
+	if len(q.segments) == 0 {
+		q.segments = append(q.segments, segment{number: 1})
+	}
+	q.compact()
+	return q.openActive()
+}
+
+// replay applies the records of a segment. A line cut short by a crash is skipped.
+func (q *queue) replay(n int) error {
+	f, err := os.Open(q.segmentPath(n))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+	for scanner.Scan() {
+		var r record
+		if json.Unmarshal(scanner.Bytes(), &r) != nil {
+			continue
+		}
+		q.apply(&r)
+	}
+	return scanner.Err()
+}
+
+func (q *queue) segmentPath(n int) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%08d%s", n, SegmentExt))
+}
+
+// openActive opens the last segment for appending, ending a line cut short by a crash so the
+// next record starts on its own line
+func (q *queue) openActive() error {
+	f, err := os.OpenFile(q.segmentPath(q.segments[len(q.segments)-1].number), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	size := info.Size()
+	if size > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, size-1); err != nil {
+			f.Close()
+			return err
+		}
+		if last[0] != '\n' {
+			n, err := f.Write([]byte{'\n'})
+			if err != nil {
+				f.Close()
+				return err
+			}
+			size += int64(n)
+		}
+	}
+	q.active, q.size = f, size
+	return nil
+}
+
+// apply applies a record to the state of the queue, records of messages that were deleted
+// are ignored
+func (q *queue) apply(r *record) {
+	if r.Op == opSend {
+		m := &message{id: r.ID, body: r.Body, group: r.Group, segment: q.segments[len(q.segments)-1].number}
+		for name, value := range r.Attributes {
+			if m.attributes == nil {
+				m.attributes = make(map[string]*sqs.MessageAttributeValue, len(r.Attributes))
+			}
+			m.attributes[name] = &sqs.MessageAttributeValue{DataType: aws.String(value.Type), StringValue: value.String, BinaryValue: value.Binary}
+		}
+		if r.Sent != nil {
+			m.sent = *r.Sent
+		}
+		if r.Visible != nil {
+			m.visible = *r.Visible
+		}
+		q.messages = append(q.messages, m)
+		q.byID[m.id] = m
+		q.segments[len(q.segments)-1].live++
+		return
+	}
+	m, ok := q.byID[r.ID]
+	if !ok {
+		return
+	}
+	switch r.Op {
+	case opReceive, opVisibility:
+		if r.Visible != nil {
+			m.visible = *r.Visible
+		}
+		if r.Count > 0 {
+			m.count = r.Count
+		}
+	case opDelete:
+		delete(q.byID, m.id)
+		for i, other := range q.messages {
+			if other == m {
+				q.messages = append(q.messages[:i], q.messages[i+1:]...)
+				break
+			}
+		}
+		q.segments[m.segment-q.segments[0].number].live--
+	}
+}
+
+// append writes a record to the active segment and applies it, starting a new segment once
+// the active one is full
+func (b *Backend) append(q *queue, r *record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	n, err := q.active.Write(append(line, '\n'))
+	if err != nil {
+		// a record written in part is cut off, or ended when it cannot be, so the next record
+		// starts on its own line
+		if n > 0 && q.active.Truncate(q.size) != nil {
+			end, _ := q.active.Write([]byte{'\n'})
+			q.size += int64(n + end)
+		}
+		return err
+	}
+	q.size += int64(n)
+	if b.Sync {
+		if err := q.active.Sync(); err != nil {
+			return err
+		}
+	}
+	q.apply(r)
+	if r.Op != opReceive {
+		close(q.notify)
+		q.notify = make(chan struct{})
+	}
+
+	size := b.SegmentSize
+	if size == 0 {
+		size = DefaultSegmentSize
+	}
+	if q.size >= size {
+		if err := q.active.Close(); err != nil {
+			return err
+		}
+		q.segments = append(q.segments, segment{number: q.segments[len(q.segments)-1].number + 1})
+		if err := q.openActive(); err != nil {
+			return err
+		}
+	}
+	q.compact()
+	return nil
+}
+
+// compact removes the segments before the active one whose messages, and those of the
+// segments before them, were all deleted
+func (q *queue) compact() {
+	for len(q.segments) > 1 && q.segments[0].live == 0 {
+		if err := os.Remove(q.segmentPath(q.segments[0].number)); err != nil && !os.IsNotExist(err) {
+			return
+		}
+		q.segments = q.segments[1:]
+	}
+}
+
+// Receive returns up to max visible messages in the order they were sent, waiting up to wait
+// for the first one, and hides them for the visibility timeout. The messages of a
+// MessageGroupId are received one at a time.
+func (b *Backend) Receive(ctx context.Context, name string, max int64, wait, visibility time.Duration) ([]*sqs.Message, error) {
+	if visibility == 0 {
+		visibility = DefaultVisibility
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		b.mu.Lock()
+		q, err := b.queue(name)
+		if err != nil {
+			b.mu.Unlock()
+			return nil, err
+		}
+		messages, next, err := b.receive(q, max, visibility)
+		notify := q.notify
+		b.mu.Unlock()
+		if err != nil || len(messages) > 0 {
+			return messages, err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, nil
+		}
+		if next > 0 && next < remaining {
+			remaining = next
+		}
+		timer := time.NewTimer(remaining)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// receive hides up to max visible messages, returning them and how long until the next hidden
+// message is visible
+func (b *Backend) receive(q *queue, max int64, visibility time.Duration) ([]*sqs.Message, time.Duration, error) {
+	now := time.Now()
+	var received []*message
+	var next time.Duration
+	held := make(map[string]bool)
+	for _, m := range q.messages {
+		if int64(len(received)) >= max {
+			break
+		}
+		if m.group != "" && held[m.group] {
+			continue
+		}
+		if m.group != "" {
+			held[m.group] = true
+		}
+		if wait := m.visible.Sub(now); wait > 0 {
+			if next == 0 || wait < next {
+				next = wait
+			}
+			continue
+		}
+		received = append(received, m)
+	}
+
+	visible := now.Add(visibility)
+	messages := make([]*sqs.Message, 0, len(received))
+	for _, m := range received {
+		if err := b.append(q, &record{Op: opReceive, ID: m.id, Visible: &visible, Count: m.count + 1}); err != nil {
+			return messages, 0, err
+		}
+		messages = append(messages, m.message())
+	}
+	return messages, next, nil
+}
+
+// message converts a message, its ReceiptHandle is its id and receive count
+func (m *message) message() *sqs.Message {
+	count := strconv.Itoa(m.count)
+	msg := &sqs.Message{
+		MessageId:         aws.String(m.id),
+		ReceiptHandle:     aws.String(m.id + "/" + count),
+		Body:              aws.String(m.body),
+		MessageAttributes: m.attributes,
+		Attributes: map[string]*string{
+			sqs.MessageSystemAttributeNameSentTimestamp:           aws.String(strconv.FormatInt(m.sent.UnixNano()/int64(time.Millisecond), 10)),
+			sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String(count),
+		},
+	}
+	if m.group != "" {
+		msg.Attributes[sqs.MessageSystemAttributeNameMessageGroupId] = aws.String(m.group)
+	}
+	return msg
+}
+
+// received returns the message of a receipt handle when it was not received again since.
+// It is called with the lock held.
+func (b *Backend) received(name, receiptHandle string) (*queue, *message, error) {
+	q, err := b.queue(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	i := strings.LastIndexByte(receiptHandle, '/')
+	if i < 0 {
+		return nil, nil, ErrNotReceived
+	}
+	m, ok := q.byID[receiptHandle[:i]]
+	if !ok || strconv.Itoa(m.count) != receiptHandle[i+1:] {
+		return nil, nil, ErrNotReceived
+	}
+	return q, m, nil
+}
+
+// Delete deletes the message
+func (b *Backend) Delete(ctx context.Context, name, receiptHandle string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, m, err := b.received(name, receiptHandle)
+	if err != nil {
+		return err
+	}
+	return b.append(q, &record{Op: opDelete, ID: m.id})
+}
+
+// ChangeVisibility makes the message visible once the timeout has passed
+func (b *Backend) ChangeVisibility(ctx context.Context, name, receiptHandle string, timeout time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, m, err := b.received(name, receiptHandle)
+	if err != nil {
+		return err
+	}
+	visible := time.Now().Add(timeout)
+	return b.append(q, &record{Op: opVisibility, ID: m.id, Visible: &visible})
+}
+
+// Send appends the message to the queue, visible once its delay has passed
+func (b *Backend) Send(ctx context.Context, name string, input *sqs.SendMessageInput) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	visible := now.Add(time.Duration(aws.Int64Value(input.DelaySeconds)) * time.Second)
+	r := &record{
+		Op:      opSend,
+		ID:      hex.EncodeToString(id),
+		Body:    aws.StringValue(input.MessageBody),
+		Group:   aws.StringValue(input.MessageGroupId),
+		Sent:    &now,
+		Visible: &visible,
+	}
+	for name, value := range input.MessageAttributes {
+		if r.Attributes == nil {
+			r.Attributes = make(map[string]attribute, len(input.MessageAttributes))
+		}
+		r.Attributes[name] = attribute{Type: aws.StringValue(value.DataType), String: value.StringValue, Binary: value.BinaryValue}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, err := b.queue(name)
+	if err != nil {
+		return "", err
+	}
+	if err := b.append(q, r); err != nil {
+		return "", err
+	}
+	return r.ID, nil
+}
+
+// Depth counts the messages of the queue: those received and hidden are not visible, those
+// never received and hidden are delayed
+func (b *Backend) Depth(ctx context.Context, name string) (sqsworker.QueueDepth, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, err := b.queue(name)
+	if err != nil {
+		return sqsworker.QueueDepth{}, err
+	}
+	depth := sqsworker.QueueDepth{Updated: time.Now()}
+	for _, m := range q.messages {
+		switch {
+		case !m.visible.After(depth.Updated):
+			depth.Visible++
+		case m.count > 0:
+			depth.NotVisible++
+		default:
+			depth.Delayed++
+		}
+	}
+	return depth, nil
+}
+
+// Close closes the segments of the open queues
+func (b *Backend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var err error
+	for name, q := range b.queues {
+		if cerr := q.active.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(b.queues, name)
+	}
+	return err
+}
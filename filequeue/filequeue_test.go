@@ -0,0 +1,240 @@
+package filequeue_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/filequeue"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newBackend(t *testing.T) (*filequeue.Backend, string) {
+	dir, err := ioutil.TempDir("", "filequeue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend, err := filequeue.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend, dir
+}
+
+func receive(t *testing.T, backend *filequeue.Backend, visibility time.Duration) []*sqs.Message {
+	messages, err := backend.Receive(context.Background(), "orders", 10, 0, visibility)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+func TestVisibility(t *testing.T) {
+	backend, dir := newBackend(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	if _, err := backend.Send(ctx, "orders", &sqs.SendMessageInput{
+		MessageBody:       aws.String("Hello"),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{"tenant": {DataType: aws.String("String"), StringValue: aws.String("acme")}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Send(ctx, "orders", &sqs.SendMessageInput{MessageBody: aws.String("Later"), DelaySeconds: aws.Int64(60)}); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := receive(t, backend, time.Minute)
+	if len(messages) != 1 || aws.StringValue(messages[0].Body) != "Hello" ||
+		aws.StringValue(messages[0].MessageAttributes["tenant"].StringValue) != "acme" ||
+		aws.StringValue(messages[0].Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]) != "1" {
+		t.Fatalf("unexpected receive %v", messages)
+	}
+	first := messages[0]
+	if depth, err := backend.Depth(ctx, "orders"); err != nil || depth.Visible != 0 || depth.NotVisible != 1 || depth.Delayed != 1 {
+		t.Errorf("unexpected depth %v %v", depth, err)
+	}
+
+	// a message made visible is received again, and its old receipt handle is stale
+	if err := backend.ChangeVisibility(ctx, "orders", *first.ReceiptHandle, 0); err != nil {
+		t.Fatal(err)
+	}
+	messages = receive(t, backend, time.Minute)
+	if len(messages) != 1 || aws.StringValue(messages[0].Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]) != "2" {
+		t.Fatalf("unexpected receive %v", messages)
+	}
+	if err := backend.Delete(ctx, "orders", *first.ReceiptHandle); err != filequeue.ErrNotReceived {
+		t.Errorf("expected the receipt handle to be stale, got %v", err)
+	}
+
+	// the lease survives reopening the queue
+	if err := backend.Close(); err != nil {
+		t.Fatal(err)
+	}
+	backend, err := filequeue.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	if messages := receive(t, backend, time.Minute); len(messages) != 0 {
+		t.Errorf("expected the messages to be hidden, got %v", messages)
+	}
+	if err := backend.Delete(ctx, "orders", *messages[0].ReceiptHandle); err != nil {
+		t.Fatal(err)
+	}
+	if depth, _ := backend.Depth(ctx, "orders"); depth.Visible != 0 || depth.NotVisible != 0 || depth.Delayed != 1 {
+		t.Errorf("unexpected depth %v", depth)
+	}
+
+	// a receive waits for the message to be visible
+	if _, err := backend.Send(ctx, "orders", &sqs.SendMessageInput{MessageBody: aws.String("Soon")}); err != nil {
+		t.Fatal(err)
+	}
+	soon := receive(t, backend, 30*time.Millisecond)
+	if messages, err := backend.Receive(ctx, "orders", 10, time.Second, time.Minute); err != nil || len(messages) != 1 || *messages[0].MessageId != *soon[0].MessageId {
+		t.Errorf("unexpected receive %v %v", messages, err)
+	}
+
+	if _, err := backend.Receive(ctx, "../orders", 10, 0, 0); err != filequeue.ErrInvalidName {
+		t.Errorf("expected an invalid name to fail, got %v", err)
+	}
+}
+
+func TestSegments(t *testing.T) {
+	backend, dir := newBackend(t)
+	defer os.RemoveAll(dir)
+	defer backend.Close()
+	backend.SegmentSize = 1
+	ctx := context.Background()
+
+	for _, group := range []string{"a", "a", "b"} {
+		if _, err := backend.Send(ctx, "orders", &sqs.SendMessageInput{MessageBody: aws.String(group), MessageGroupId: aws.String(group)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	segments, _ := filepath.Glob(filepath.Join(dir, "orders", "*"+filequeue.SegmentExt))
+	if len(segments) != 4 {
+		t.Errorf("expected a segment per record, got %v", segments)
+	}
+	data, _ := ioutil.ReadFile(segments[0])
+	if !strings.Contains(string(data), `"op":"send"`) || !strings.Contains(string(data), `"body":"a"`) {
+		t.Errorf("unexpected segment %s", data)
+	}
+
+	// the messages of a group are received one at a time
+	for _, expected := range [][]string{{"a", "b"}, {"a"}} {
+		messages := receive(t, backend, time.Minute)
+		if len(messages) != len(expected) {
+			t.Fatalf("expected %v, got %v", expected, messages)
+		}
+		for i, m := range messages {
+			if *m.Body != expected[i] || *m.Attributes[sqs.MessageSystemAttributeNameMessageGroupId] != expected[i] {
+				t.Errorf("expected %v, got %v", expected, messages)
+			}
+			if err := backend.Delete(ctx, "orders", *m.ReceiptHandle); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// the segments of deleted messages are removed
+	segments, _ = filepath.Glob(filepath.Join(dir, "orders", "*"+filequeue.SegmentExt))
+	if len(segments) != 1 {
+		t.Errorf("expected only the active segment, got %v", segments)
+	}
+}
+
+func TestPartialRecord(t *testing.T) {
+	backend, dir := newBackend(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	if _, err := backend.Send(ctx, "orders", &sqs.SendMessageInput{MessageBody: aws.String("first")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a record cut short by a crash does not take the next record with it
+	segments, _ := filepath.Glob(filepath.Join(dir, "orders", "*"+filequeue.SegmentExt))
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"send","id":"cut`)
+	f.Close()
+	backend, err = filequeue.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Send(ctx, "orders", &sqs.SendMessageInput{MessageBody: aws.String("second")}); err != nil {
+		t.Fatal(err)
+	}
+	backend.Close()
+
+	backend, err = filequeue.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	if messages := receive(t, backend, time.Minute); len(messages) != 2 || *messages[0].Body != "first" || *messages[1].Body != "second" {
+		t.Errorf("unexpected messages %v", messages)
+	}
+}
+
+func TestWorker(t *testing.T) {
+	backend, dir := newBackend(t)
+	defer os.RemoveAll(dir)
+	defer backend.Close()
+	ctx := context.Background()
+	for _, body := range []string{"Hello", "World"} {
+		if _, err := backend.Send(ctx, "orders", &sqs.SendMessageInput{MessageBody: aws.String(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := sqsworker.NewWorker(nil, sqsworker.WorkerConfig{
+		Backend:  backend,
+		QueueURL: "orders",
+		Workers:  2,
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			return &sns.PublishInput{Message: aws.String(strings.ToLower(*m.Body))}, nil
+		}),
+		Sink:   sqsworker.NewQueueSink(sqsworker.NewBackendQueue(backend), sqsworker.QueueConfig{QueueURL: "processed"}),
+		Logger: zap.NewNop(),
+	})
+	go w.Run()
+	defer w.Close()
+
+	var bodies []string
+	for len(bodies) < 2 {
+		messages, err := backend.Receive(ctx, "processed", 10, time.Second, time.Minute)
+		if err != nil || len(messages) == 0 {
+			t.Fatalf("results were not sent %v", err)
+		}
+		for _, m := range messages {
+			bodies = append(bodies, *m.Body)
+		}
+	}
+	if bodies[0] != "hello" && bodies[0] != "world" || bodies[0] == bodies[1] {
+		t.Errorf("unexpected results %v", bodies)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		depth, _ := backend.Depth(ctx, "orders")
+		if depth.Visible == 0 && depth.NotVisible == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("messages were not deleted %v", depth)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
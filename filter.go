@@ -0,0 +1,96 @@
+package sqsworker
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"strings"
+	"sync/atomic"
+)
+
+// Condition matches a message attribute. The attribute's string value must equal Equals or
+// start with Prefix when they are set, otherwise the attribute only has to exist.
+type Condition struct {
+	Attribute string
+	Equals    string
+	Prefix    string
+}
+
+// AttributeEquals matches messages whose attribute has the value
+func AttributeEquals(name, value string) Condition {
+	return Condition{Attribute: name, Equals: value}
+}
+
+// AttributeExists matches messages that have the attribute
+func AttributeExists(name string) Condition {
+	return Condition{Attribute: name}
+}
+
+// AttributePrefix matches messages whose attribute starts with the prefix
+func AttributePrefix(name, prefix string) Condition {
+	return Condition{Attribute: name, Prefix: prefix}
+}
+
+func (c Condition) matches(m *sqs.Message) bool {
+	attr, ok := m.MessageAttributes[c.Attribute]
+	if !ok || attr == nil {
+		return false
+	}
+	value := aws.StringValue(attr.StringValue)
+	switch {
+	case c.Equals != "":
+		return value == c.Equals
+	case c.Prefix != "":
+		return strings.HasPrefix(value, c.Prefix)
+	}
+	return true
+}
+
+// DefaultReturnDelay is how many seconds the messages returned by a Filter stay invisible when it
+// has no ReturnDelay, so the worker does not receive them again at once
+const DefaultReturnDelay = 5
+
+// Filter selects the messages a worker handles by their attributes, e.g. while several workers
+// share a queue during a migration. Messages that do not match are not passed to the
+// Processor, and are deleted, or returned to the queue for another worker when Return is set.
+type Filter struct {
+	// Match lists the conditions a message must all match
+	Match  []Condition
+	Return bool
+	// ReturnDelay is how many seconds returned messages stay invisible, DefaultReturnDelay when
+	// it is not positive
+	ReturnDelay int64
+}
+
+// Matches reports whether the message matches every condition
+func (f *Filter) Matches(m *sqs.Message) bool {
+	for _, c := range f.Match {
+		if !c.matches(m) {
+			return false
+		}
+	}
+	return true
+}
+
+// filter deletes or returns a message that does not match the Filter
+func (w *Worker) filter(ctx context.Context, state *consumerState, msg message, result *Result) error {
+	atomic.AddInt64(&w.stats.filtered, 1)
+	result.Filtered = true
+	if w.Filter.Return {
+		delay := w.Filter.ReturnDelay
+		if delay <= 0 {
+			delay = DefaultReturnDelay
+		}
+		err := w.changeVisibility(msg, delay)
+		if err != nil {
+			w.logConsumerError(state, "reset visibility failed!", err)
+		}
+		return err
+	}
+	err := w.delete(ctx, state, msg)
+	if err != nil {
+		w.logConsumerError(state, "delete message failed!", err)
+	}
+	result.Deleted = err == nil
+	return err
+}
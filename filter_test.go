@@ -0,0 +1,58 @@
+package sqsworker_test
+
+import (
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	filter := &sqsworker.Filter{Match: []sqsworker.Condition{
+		sqsworker.AttributeEquals("version", "2"),
+		sqsworker.AttributeExists("tenant"),
+		sqsworker.AttributePrefix("event", "order."),
+	}}
+
+	var results []sqsworker.Result
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:  "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: &LowerCaseWorker{},
+		Filter:    filter,
+		Callback:  func(r sqsworker.Result) { results = append(results, r) },
+	})
+
+	match := []workertest.MessageOption{
+		workertest.Attribute("version", "2"),
+		workertest.Attribute("tenant", "acme"),
+		workertest.Attribute("event", "order.placed"),
+	}
+	h.Run(workertest.NewMessage("MATCHED", match...)).Succeeded().Deleted().Published("matched")
+
+	unmatched := [][]workertest.MessageOption{
+		{workertest.Attribute("version", "1"), match[1], match[2]},
+		{match[0], match[2]},
+		{match[0], match[1], workertest.Attribute("event", "refund.issued")},
+	}
+	for _, opts := range unmatched {
+		h.Run(workertest.NewMessage("SKIPPED", opts...)).Succeeded().Deleted().NotPublished()
+	}
+	if stats := h.Worker.Stats(); stats.Filtered != 3 || stats.Processed != 1 {
+		t.Error("unexpected stats: ", stats)
+	}
+	if len(results) != 4 || results[0].Filtered || !results[1].Filtered || !results[1].Deleted {
+		t.Error("unexpected results: ", results)
+	}
+
+	// Returned messages are made visible again for another worker
+	filter.Return = true
+	result := h.Run(workertest.NewMessage("RETURNED")).Succeeded().NotDeleted().NotPublished()
+	if len(result.Visibility) != 1 || aws.Int64Value(result.Visibility[0].VisibilityTimeout) != sqsworker.DefaultReturnDelay {
+		t.Error("unexpected visibility changes: ", result.Visibility)
+	}
+	filter.ReturnDelay = 30
+	result = h.Run(workertest.NewMessage("RETURNED")).Succeeded().NotDeleted().NotPublished()
+	if len(result.Visibility) != 1 || aws.Int64Value(result.Visibility[0].VisibilityTimeout) != 30 {
+		t.Error("unexpected visibility changes: ", result.Visibility)
+	}
+}
@@ -0,0 +1,115 @@
+package sqsworker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/firehose/firehoseiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"time"
+)
+
+// MaxFirehoseBatchSize is the most records PutRecordBatch accepts at once
+const MaxFirehoseBatchSize = 500
+
+// FirehoseConfig settings for a FirehoseSink
+type FirehoseConfig struct {
+	DeliveryStreamName string
+	// Record converts a result to a record, by default its message followed by a newline, so
+	// records delivered to S3 are newline delimited
+	Record func(*sqs.Message, *sns.PublishInput) []byte
+	// BatchSize, BatchWindow, Retries and Backoff batch and retry records as described on Sink.
+	// BatchSize is at most MaxFirehoseBatchSize, and Firehose limits a batch to 4 MiB.
+	BatchSize   int
+	BatchWindow time.Duration
+	Retries     int
+	Backoff     time.Duration
+}
+
+// FirehoseSink is a Sink writing results to a Kinesis Data Firehose delivery stream, e.g. to
+// archive them to S3 or Redshift. It is usually the worker's Mirror.
+type FirehoseSink struct {
+	Client firehoseiface.FirehoseAPI
+	config FirehoseConfig
+	batch  *batcher
+}
+
+// NewFirehoseSink creates a FirehoseSink
+func NewFirehoseSink(client firehoseiface.FirehoseAPI, config FirehoseConfig) *FirehoseSink {
+	if config.BatchSize > MaxFirehoseBatchSize {
+		config.BatchSize = MaxFirehoseBatchSize
+	}
+	if config.Record == nil {
+		config.Record = messageRecord
+	}
+	if config.Retries == 0 {
+		config.Retries = DefaultKinesisRetries
+	}
+	if config.Backoff == 0 {
+		config.Backoff = DefaultPublishBackoff
+	}
+	s := &FirehoseSink{Client: client, config: config}
+	s.batch = newBatcher(config.BatchSize, config.BatchWindow, s.put)
+	return s
+}
+
+// messageRecord is the message of a result on its own line
+func messageRecord(m *sqs.Message, output *sns.PublishInput) []byte {
+	return []byte(aws.StringValue(output.Message) + "\n")
+}
+
+// Send writes the result to the delivery stream
+func (s *FirehoseSink) Send(ctx context.Context, m *sqs.Message, output *sns.PublishInput) error {
+	return s.batch.send(ctx, &firehose.Record{Data: s.config.Record(m, output)})
+}
+
+// put sends a batch of records, sending the records that failed again until they succeed or the
+// retries run out, and returns the error of each
+func (s *FirehoseSink) put(ctx context.Context, values []interface{}) []error {
+	return retryFailed(ctx, len(values), s.config.Retries, s.config.Backoff, func(indexes []int) []error {
+		input := &firehose.PutRecordBatchInput{
+			DeliveryStreamName: aws.String(s.config.DeliveryStreamName),
+			Records:            make([]*firehose.Record, len(indexes)),
+		}
+		for i, index := range indexes {
+			input.Records[i] = values[index].(*firehose.Record)
+		}
+
+		errs := make([]error, len(indexes))
+		out, err := s.Client.PutRecordBatch(input)
+		for i := range errs {
+			switch {
+			case err != nil:
+				errs[i] = err
+			case i < len(out.RequestResponses) && out.RequestResponses[i].ErrorCode != nil:
+				errs[i] = fmt.Errorf("sqsworker: put record failed: %s: %s", *out.RequestResponses[i].ErrorCode, aws.StringValue(out.RequestResponses[i].ErrorMessage))
+			}
+		}
+		return errs
+	})
+}
+
+// FirehoseAudit is an AuditSink writing the records to a Kinesis Data Firehose delivery stream
+// as lines of JSON, batched like a FirehoseSink, so the processing history lands in S3. The
+// records are not chained like those of a JSONAudit, the bucket should use S3 Object Lock to
+// keep them from being changed.
+type FirehoseAudit struct {
+	sink *FirehoseSink
+}
+
+// NewFirehoseAudit creates a FirehoseAudit. The Record of the config is not used.
+func NewFirehoseAudit(client firehoseiface.FirehoseAPI, config FirehoseConfig) *FirehoseAudit {
+	return &FirehoseAudit{sink: NewFirehoseSink(client, config)}
+}
+
+// Audit writes the record to the delivery stream
+func (a *FirehoseAudit) Audit(ctx context.Context, record AuditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return a.sink.batch.send(ctx, &firehose.Record{Data: append(data, '\n')})
+}
@@ -0,0 +1,117 @@
+package sqsworker_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/firehose/firehoseiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// DeliveryStream records the records written to it, failing every batch when Err is set
+type DeliveryStream struct {
+	firehoseiface.FirehoseAPI
+	Err     error
+	mu      sync.Mutex
+	Records []*firehose.Record
+}
+
+func (d *DeliveryStream) PutRecordBatch(input *firehose.PutRecordBatchInput) (*firehose.PutRecordBatchOutput, error) {
+	if d.Err != nil {
+		return nil, d.Err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Records = append(d.Records, input.Records...)
+	out := &firehose.PutRecordBatchOutput{FailedPutCount: aws.Int64(0)}
+	for range input.Records {
+		out.RequestResponses = append(out.RequestResponses, &firehose.PutRecordBatchResponseEntry{RecordId: aws.String("record")})
+	}
+	return out, nil
+}
+
+func (d *DeliveryStream) records() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	records := make([]string, len(d.Records))
+	for i, record := range d.Records {
+		records[i] = string(record.Data)
+	}
+	return records
+}
+
+func TestMirror(t *testing.T) {
+	stream := &DeliveryStream{}
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:  "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: &EventWorker{Output: eventOutput},
+		Mirror:    sqsworker.NewFirehoseSink(stream, sqsworker.FirehoseConfig{DeliveryStreamName: "archive"}),
+	})
+
+	h.Run(workertest.NewMessage("placed", workertest.Attribute("event", "order"))).Succeeded().Deleted().Published("placed")
+	if records := stream.records(); len(records) != 1 || records[0] != "placed\n" {
+		t.Error("unexpected records: ", records)
+	}
+
+	// Results that are not published are not mirrored
+	h.Topic.PublishErr = errors.New("publish failed")
+	h.Run(workertest.NewMessage("failed", workertest.Attribute("event", "order"))).Failed().NotDeleted()
+	if records := stream.records(); len(records) != 1 {
+		t.Error("unexpected records: ", records)
+	}
+}
+
+func TestMirrorErrors(t *testing.T) {
+	stream := &DeliveryStream{Err: errors.New("firehose unavailable")}
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: &EventWorker{Output: func(m *sqs.Message) *sns.PublishInput { return &sns.PublishInput{Message: m.Body} }},
+		Mirror:    sqsworker.NewFirehoseSink(stream, sqsworker.FirehoseConfig{Retries: -1}),
+	})
+
+	// The message completes even though its result could not be mirrored
+	h.Run(workertest.NewMessage("hello")).Succeeded().Deleted()
+	if stats := h.Worker.Stats(); stats.MirrorErrors != 1 {
+		t.Error("Actual: ", stats.MirrorErrors, "Expected: ", 1)
+	}
+}
+
+func TestFirehoseAudit(t *testing.T) {
+	stream := &DeliveryStream{}
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: &NoOP{},
+		Audit:     sqsworker.NewFirehoseAudit(stream, sqsworker.FirehoseConfig{DeliveryStreamName: "audit", BatchSize: 2}),
+	})
+	ms := []*sqs.Message{workertest.NewMessage("hello"), workertest.NewMessage("world")}
+	var wg sync.WaitGroup
+	for _, m := range ms {
+		wg.Add(1)
+		go func(m *sqs.Message) {
+			defer wg.Done()
+			h.Run(m).Succeeded()
+		}(m)
+	}
+	wg.Wait()
+
+	records := stream.records()
+	if len(records) != 2 {
+		t.Fatal("unexpected records: ", records)
+	}
+	ids := make(map[string]bool)
+	for _, data := range records {
+		var record sqsworker.AuditRecord
+		if err := json.Unmarshal([]byte(data), &record); err != nil || !strings.HasSuffix(data, "\n") {
+			t.Fatal("unexpected record: ", data, err)
+		}
+		ids[record.MessageID] = record.Outcome == sqsworker.AuditProcessed
+	}
+	if !ids[*ms[0].MessageId] || !ids[*ms[1].MessageId] {
+		t.Error("unexpected records: ", records)
+	}
+}
@@ -0,0 +1,193 @@
+package sqsworker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultRequestPrice is the price in dollars of an SQS request, used to estimate costs. Each
+// request is billed per 64KB chunk of its payload.
+const DefaultRequestPrice = 0.40 / 1000000
+
+// Defaults of PollingGovernor
+const (
+	DefaultMaxIdleDelay = 30 * time.Second
+	DefaultMinWaitTime  = 5
+)
+
+// hoursPerMonth is the average number of hours in a month, used to estimate monthly costs
+const hoursPerMonth = 730
+
+// PollingGovernor adjusts polling to the share of receives that return no messages. Busy queues
+// are polled by up to MaxPollers concurrent pollers with a MinWaitTime long poll, while idle
+// queues are polled by a single poller that long polls for DefaultWaitTimeSeconds and waits up
+// to MaxIdleDelay between empty receives, so an idle worker makes fewer ReceiveMessage calls.
+// A message sent to an idle queue waits at most MaxIdleDelay longer to be received.
+type PollingGovernor struct {
+	// MaxPollers is the most receives in flight at once, by default one
+	MaxPollers int
+	// MaxIdleDelay defaults to DefaultMaxIdleDelay. The delay doubles from a second on each
+	// consecutive idle receive, and is reset by any message.
+	MaxIdleDelay time.Duration
+	// MinWaitTime in seconds defaults to DefaultMinWaitTime
+	MinWaitTime int64
+	// RequestPrice in dollars estimates the monthly cost of the receives, by default
+	// DefaultRequestPrice
+	RequestPrice float64
+}
+
+// PollingStats describes the current polling of a governed worker
+type PollingStats struct {
+	Pollers         int
+	WaitTimeSeconds int64
+	IdleDelay       time.Duration
+	// EmptyRatio is a moving average of the share of receives that returned no messages
+	EmptyRatio float64
+	// Receives made since the worker started, and their estimated monthly cost in dollars at
+	// the rate they were made
+	Receives    int64
+	MonthlyCost float64
+}
+
+// governor tracks the results of receives to adjust polling
+type governor struct {
+	config  PollingGovernor
+	started time.Time
+	mu      sync.Mutex
+	empty   float64
+	delay   time.Duration
+	// extra is the number of pollers running besides the first, wanted the number needed
+	extra    int
+	wanted   int
+	receives int64
+}
+
+func newGovernor(config PollingGovernor) *governor {
+	if config.MaxPollers < 1 {
+		config.MaxPollers = 1
+	}
+	if config.MaxIdleDelay == 0 {
+		config.MaxIdleDelay = DefaultMaxIdleDelay
+	}
+	if config.MinWaitTime <= 0 || config.MinWaitTime > DefaultWaitTimeSeconds {
+		config.MinWaitTime = DefaultMinWaitTime
+	}
+	if config.RequestPrice == 0 {
+		config.RequestPrice = DefaultRequestPrice
+	}
+	return &governor{config: config, started: time.Now()}
+}
+
+// observe records the number of messages a receive returned, returning the id of the poller to
+// start when another one is needed, or zero
+func (g *governor) observe(n int) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.receives++
+	empty := 0.0
+	if n == 0 {
+		empty = 1
+	}
+	g.empty += (empty - g.empty) / 8
+
+	switch {
+	case n == DefaultMaxNumberOfMessages && g.empty < 0.1 && g.wanted < g.config.MaxPollers-1:
+		g.wanted++
+	case n == 0 && g.empty > 0.5 && g.wanted > 0:
+		g.wanted--
+	}
+	if n > 0 {
+		g.delay = 0
+	}
+	if g.extra < g.wanted {
+		g.extra++
+		return g.extra
+	}
+	return 0
+}
+
+// leave reports whether the poller with the id, counting from one for the pollers started by
+// observe, is no longer needed. Only the last poller started leaves, so ids stay contiguous.
+func (g *governor) leave(id int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if id == g.extra && g.extra > g.wanted {
+		g.extra--
+		return true
+	}
+	return false
+}
+
+// stopped records a poller returning because polling stopped
+func (g *governor) stopped() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.extra--
+	if g.wanted > g.extra {
+		g.wanted = g.extra
+	}
+}
+
+// wait returns the WaitTimeSeconds of the long poll
+func (g *governor) wait() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.waitTime()
+}
+
+// waitTime is MinWaitTime while the queues are busy, the caller holds the lock
+func (g *governor) waitTime() int64 {
+	if g.empty < 0.5 {
+		return g.config.MinWaitTime
+	}
+	return DefaultWaitTimeSeconds
+}
+
+// idle returns how long to wait after a cycle of receives returned no messages
+func (g *governor) idle() time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch {
+	case g.empty <= 0.5:
+		return 0
+	case g.delay == 0:
+		g.delay = time.Second
+	default:
+		g.delay *= 2
+	}
+	if g.delay > g.config.MaxIdleDelay {
+		g.delay = g.config.MaxIdleDelay
+	}
+	return g.delay
+}
+
+// stats returns a snapshot of the polling
+func (g *governor) stats() PollingStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := PollingStats{
+		Pollers:         g.extra + 1,
+		WaitTimeSeconds: g.waitTime(),
+		IdleDelay:       g.delay,
+		EmptyRatio:      g.empty,
+		Receives:        g.receives,
+	}
+	if hours := time.Since(g.started).Hours(); hours > 0 {
+		s.MonthlyCost = float64(g.receives) / hours * hoursPerMonth * g.config.RequestPrice
+	}
+	return s
+}
+
+// sleep waits for the delay, returning early when the context is done
+func sleep(ctx context.Context, delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
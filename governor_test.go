@@ -0,0 +1,85 @@
+package sqsworker_test
+
+import (
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// BusyQueue returns a full batch of messages on every receive
+type BusyQueue struct {
+	workertest.Queue
+}
+
+func (b *BusyQueue) ReceiveMessageRequest(input *sqs.ReceiveMessageInput) (*request.Request, *sqs.ReceiveMessageOutput) {
+	time.Sleep(time.Millisecond)
+	messages := make([]*sqs.Message, sqsworker.DefaultMaxNumberOfMessages)
+	for i := range messages {
+		messages[i] = workertest.NewMessage("busy")
+	}
+	return newRequest(), &sqs.ReceiveMessageOutput{Messages: messages}
+}
+
+func (b *BusyQueue) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+}
+
+func TestPollingGovernorBusy(t *testing.T) {
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:        workerQueueURL,
+		Workers:         4,
+		Logger:          zap.NewNop(),
+		Processor:       &NoOP{},
+		PollingGovernor: &sqsworker.PollingGovernor{MaxPollers: 3},
+	})
+	w.Queue = &BusyQueue{}
+	go w.Run()
+	defer w.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for w.Stats().Polling.Pollers < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	polling := w.Stats().Polling
+	if polling.Pollers != 3 || polling.WaitTimeSeconds != sqsworker.DefaultMinWaitTime || polling.IdleDelay != 0 {
+		t.Error("Expected a busy queue to be polled by every poller, got ", polling)
+	}
+}
+
+func TestPollingGovernorIdle(t *testing.T) {
+	queue := &CountingQueue{}
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+		PollingGovernor: &sqsworker.PollingGovernor{
+			MaxPollers:   3,
+			MaxIdleDelay: 20 * time.Millisecond,
+		},
+	})
+	w.Queue = queue
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		w.Close()
+	}()
+	w.Run()
+
+	// Without a governor the queue is received from about every millisecond
+	if receives := atomic.LoadInt32(&queue.Receives); receives > 20 {
+		t.Error("Expected an idle queue to be received from less often, got ", receives)
+	}
+	polling := w.Stats().Polling
+	if polling.Pollers != 1 || polling.WaitTimeSeconds != sqsworker.DefaultWaitTimeSeconds || polling.IdleDelay != 20*time.Millisecond {
+		t.Error("Expected an idle queue to be polled by a single poller, got ", polling)
+	}
+	if polling.Receives == 0 || polling.MonthlyCost <= 0 {
+		t.Error("Expected the cost of the receives to be estimated, got ", polling)
+	}
+}
@@ -0,0 +1,74 @@
+package sqsworker
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"reflect"
+)
+
+// Handle adapts a typed function to a Processor, decoding each message body into its input and
+// encoding its output with the worker's Codec. In and Out are any types the Codec handles, and
+// may be pointers. A nil Out publishes no result, and an Out of *sns.PublishInput is published
+// as it is. Bodies that cannot be decoded are Invalid, and quarantined, and outputs that cannot
+// be encoded fail with a Fatal error, so handlers need neither decoding code nor their own
+// handling of malformed data.
+func Handle[In, Out any](handler func(ctx context.Context, in In) (Out, error)) Processor {
+	return &typedHandler[In, Out]{handler: handler}
+}
+
+// HandleJSON is Handle with JSON as the codec, whatever the worker's Codec is
+func HandleJSON[In, Out any](handler func(ctx context.Context, in In) (Out, error)) Processor {
+	return &typedHandler[In, Out]{handler: handler, codec: JSON}
+}
+
+// Consume is Handle for handlers that publish no result
+func Consume[In any](handler func(ctx context.Context, in In) error) Processor {
+	return Handle(func(ctx context.Context, in In) (*sns.PublishInput, error) {
+		return nil, handler(ctx, in)
+	})
+}
+
+// typedHandler decodes the input of a handler and encodes its output
+type typedHandler[In, Out any] struct {
+	handler func(context.Context, In) (Out, error)
+	codec   Codec
+}
+
+func (h *typedHandler[In, Out]) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	codec := h.codec
+	if codec == nil {
+		codec = ContextCodec(ctx)
+	}
+
+	// a pointer input is decoded into a new value, not through a pointer to a nil pointer,
+	// which not every Codec allocates
+	var in In
+	var target interface{} = &in
+	if t := reflect.TypeOf(in); t != nil && t.Kind() == reflect.Ptr {
+		in = reflect.New(t.Elem()).Interface().(In)
+		target = in
+	}
+	if err := codec.Unmarshal([]byte(aws.StringValue(m.Body)), target); err != nil {
+		return nil, Invalid(err)
+	}
+
+	out, err := h.handler(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	switch v := interface{}(out).(type) {
+	case nil:
+		return nil, nil
+	case *sns.PublishInput:
+		return v, nil
+	}
+	switch v := reflect.ValueOf(out); v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			return nil, nil
+		}
+	}
+	return encode(codec, out)
+}
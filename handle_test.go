@@ -0,0 +1,69 @@
+package sqsworker_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"testing"
+)
+
+func TestHandleJSON(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn: "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: sqsworker.HandleJSON(func(ctx context.Context, g Greeting) (*Greeting, error) {
+			switch g.Name {
+			case "nobody":
+				return nil, nil
+			case "error":
+				return nil, errors.New("unavailable")
+			}
+			return &Greeting{Name: "hello " + g.Name}, nil
+		}),
+		QuarantineQueueURL: workertest.QueueURL + "-quarantine",
+		// HandleJSON ignores the worker's codec
+		Codec: Base64JSON{},
+	})
+
+	result := h.Run(workertest.NewMessage(`{"name":"world"}`)).Succeeded().Deleted().Published(`{"name":"hello world"}`)
+	if actual := aws.StringValue(result.Publishes[0].MessageAttributes[sqsworker.ContentTypeAttribute].StringValue); actual != sqsworker.JSONContentType {
+		t.Error("Actual: ", actual, "Expected: ", sqsworker.JSONContentType)
+	}
+	h.Run(workertest.NewMessage(`{"name":"nobody"}`)).Succeeded().Deleted().NotPublished()
+	h.Run(workertest.NewMessage(`{"name":"error"}`)).Failed().NotDeleted()
+
+	// Malformed bodies are quarantined without calling the handler
+	result = h.Run(workertest.NewMessage(`{"name":`)).Failed().Quarantined()
+	if !sqsworker.IsInvalid(result.Err) {
+		t.Error("Expected an invalid error, got: ", result.Err)
+	}
+	h.Run(workertest.NewMessage(`{"name":3}`)).Failed().Quarantined()
+}
+
+func TestHandle(t *testing.T) {
+	var greeted []string
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn: "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: sqsworker.Consume(func(ctx context.Context, g *Greeting) error {
+			greeted = append(greeted, g.Name)
+			return nil
+		}),
+		Codec: Base64JSON{},
+	})
+	body, _ := Base64JSON{}.Marshal(Greeting{Name: "world"})
+	h.Run(workertest.NewMessage(string(body))).Succeeded().Deleted().NotPublished()
+	if len(greeted) != 1 || greeted[0] != "world" {
+		t.Error("unexpected greetings: ", greeted)
+	}
+
+	// Results built by the handler are published as they are
+	h = workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn: "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: sqsworker.Handle(func(ctx context.Context, g Greeting) (*sns.PublishInput, error) {
+			return &sns.PublishInput{Message: aws.String(g.Name)}, nil
+		}),
+	})
+	h.Run(workertest.NewMessage(`{"name":"world"}`)).Succeeded().Published("world")
+}
@@ -0,0 +1,35 @@
+package sqsworker
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// IdlePollInterval how often WaitForIdle checks whether the worker is idle
+const IdlePollInterval = 10 * time.Millisecond
+
+// idle reports whether the last poll of every queue returned no messages and nothing is in flight
+func (w *Worker) idle() bool {
+	return atomic.LoadInt32(&w.stats.idle) == 1 && atomic.LoadInt64(&w.stats.inFlight) == 0
+}
+
+// WaitForIdle blocks until the worker's queues are drained and no messages are in flight, which
+// is when a receive from every input queue returned no messages and every received message has
+// been processed. It returns early with the context's error when the context is done, and with
+// nil when the worker stops. Because receives are long-polled, an idle worker is only detected
+// once a long poll returns empty.
+func (w *Worker) WaitForIdle(ctx context.Context) error {
+	ticker := time.NewTicker(IdlePollInterval)
+	defer ticker.Stop()
+	for !w.idle() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.stopped:
+			return nil
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package sqsworker_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestWaitForIdle(t *testing.T) {
+	queue := &PriorityQueue{Messages: map[string][]string{
+		workerQueueURL: {"a", "b", "c"},
+	}}
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   2,
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+		Name:      "TestApp",
+	})
+	w.Queue = queue
+	go w.Run()
+	defer w.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := w.WaitForIdle(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := w.Stats()
+	if stats.Processed != 3 || stats.InFlight != 0 {
+		t.Error("unexpected stats: ", stats)
+	}
+}
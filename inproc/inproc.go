@@ -0,0 +1,293 @@
+// Package inproc provides an in-process sqsworker.Backend on Go channels, so libraries embed
+// the worker's retries, concurrency and middleware for internal task queues with no broker.
+// Queues are created when they are first used, and results are sent to them with a QueueSink:
+//
+//	backend := inproc.New()
+//	defer backend.Close()
+//	w := sqsworker.NewWorker(nil, sqsworker.WorkerConfig{
+//		Backend:   backend,
+//		QueueURL:  "tasks",
+//		Processor: processor,
+//	})
+//	go w.Run()
+//	backend.Send(ctx, "tasks", &sqs.SendMessageInput{MessageBody: aws.String("resize 42")})
+//
+// Visible messages wait in a buffered channel per queue, and sending to a full queue blocks.
+// A received message is hidden for the visibility timeout and put back in its channel when it
+// passes without the message being deleted, like an SQS message. Messages only live as long
+// as the process, and are received in no particular order once they were redelivered.
+package inproc
+
+import (
+	"context"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultBuffer is the number of visible messages a queue holds before sends block
+const DefaultBuffer = 1000
+
+// DefaultVisibility is the visibility timeout of messages received with none
+const DefaultVisibility = sqsworker.DefaultVisibilityTimeout * time.Second
+
+// ErrClosed is returned by the Backend once it was closed
+var ErrClosed = errors.New("inproc: the backend is closed")
+
+// ErrNotReceived is returned for a receipt handle of a message that was deleted or became
+// visible again since
+var ErrNotReceived = errors.New("inproc: the message is not received")
+
+// message is a message of a queue
+type message struct {
+	id         string
+	body       string
+	attributes map[string]*sqs.MessageAttributeValue
+	group      string
+	sent       time.Time
+	count      int
+}
+
+// lease hides a received message until its timer puts it back in its queue
+type lease struct {
+	message *message
+	timer   *time.Timer
+}
+
+// queue holds the visible messages of a queue in a channel, and the others in leases
+type queue struct {
+	ready   chan *message
+	leases  map[string]*lease
+	delayed int64
+}
+
+// Backend is a sqsworker.Backend keeping its queues in memory
+type Backend struct {
+	// Buffer is the number of visible messages of each queue, by default DefaultBuffer
+	Buffer int
+	mu     sync.Mutex
+	queues map[string]*queue
+	sent   int64
+	closed chan struct{}
+	once   sync.Once
+}
+
+// New creates a Backend
+func New() *Backend {
+	return &Backend{queues: make(map[string]*queue), closed: make(chan struct{})}
+}
+
+// queue returns a queue, creating it when it is first used. It is called with the lock held.
+func (b *Backend) queue(name string) *queue {
+	q, ok := b.queues[name]
+	if !ok {
+		buffer := b.Buffer
+		if buffer == 0 {
+			buffer = DefaultBuffer
+		}
+		q = &queue{ready: make(chan *message, buffer), leases: make(map[string]*lease)}
+		b.queues[name] = q
+	}
+	return q
+}
+
+// Receive returns up to max visible messages, waiting up to wait for the first one, and hides
+// them for the visibility timeout
+func (b *Backend) Receive(ctx context.Context, name string, max int64, wait, visibility time.Duration) ([]*sqs.Message, error) {
+	if visibility == 0 {
+		visibility = DefaultVisibility
+	}
+	select {
+	case <-b.closed:
+		return nil, ErrClosed
+	default:
+	}
+	b.mu.Lock()
+	q := b.queue(name)
+	b.mu.Unlock()
+
+	var first *message
+	select {
+	case first = <-q.ready:
+	default:
+		if wait <= 0 {
+			return nil, nil
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case first = <-q.ready:
+		case <-timer.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-b.closed:
+			return nil, ErrClosed
+		}
+	}
+
+	received := []*message{first}
+drain:
+	for int64(len(received)) < max {
+		select {
+		case m := <-q.ready:
+			received = append(received, m)
+		default:
+			break drain
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	messages := make([]*sqs.Message, len(received))
+	for i, m := range received {
+		m.count++
+		messages[i] = m.message()
+		b.hold(q, *messages[i].ReceiptHandle, m, visibility)
+	}
+	return messages, nil
+}
+
+// hold leases a message, putting it back in its queue once the timeout passes. It is called
+// with the lock held.
+func (b *Backend) hold(q *queue, receiptHandle string, m *message, timeout time.Duration) {
+	l := &lease{message: m}
+	l.timer = time.AfterFunc(timeout, func() {
+		b.mu.Lock()
+		current, ok := q.leases[receiptHandle]
+		if ok && current == l {
+			delete(q.leases, receiptHandle)
+		}
+		b.mu.Unlock()
+		if ok && current == l {
+			b.push(context.Background(), q, m)
+		}
+	})
+	q.leases[receiptHandle] = l
+}
+
+// push puts a message in its queue, blocking while the queue is full
+func (b *Backend) push(ctx context.Context, q *queue, m *message) error {
+	select {
+	case q.ready <- m:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.closed:
+		return ErrClosed
+	}
+}
+
+// message converts a message, its ReceiptHandle is its id and receive count
+func (m *message) message() *sqs.Message {
+	count := strconv.Itoa(m.count)
+	msg := &sqs.Message{
+		MessageId:         aws.String(m.id),
+		ReceiptHandle:     aws.String(m.id + "/" + count),
+		Body:              aws.String(m.body),
+		MessageAttributes: m.attributes,
+		Attributes: map[string]*string{
+			sqs.MessageSystemAttributeNameSentTimestamp:           aws.String(strconv.FormatInt(m.sent.UnixNano()/int64(time.Millisecond), 10)),
+			sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String(count),
+		},
+	}
+	if m.group != "" {
+		msg.Attributes[sqs.MessageSystemAttributeNameMessageGroupId] = aws.String(m.group)
+	}
+	return msg
+}
+
+// release ends the lease of a received message, returning it
+func (b *Backend) release(name, receiptHandle string) (*queue, *message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q := b.queue(name)
+	l, ok := q.leases[receiptHandle]
+	if !ok {
+		return nil, nil, ErrNotReceived
+	}
+	l.timer.Stop()
+	delete(q.leases, receiptHandle)
+	return q, l.message, nil
+}
+
+// Delete deletes the message
+func (b *Backend) Delete(ctx context.Context, name, receiptHandle string) error {
+	_, _, err := b.release(name, receiptHandle)
+	return err
+}
+
+// ChangeVisibility puts the message back in its queue once the timeout has passed
+func (b *Backend) ChangeVisibility(ctx context.Context, name, receiptHandle string, timeout time.Duration) error {
+	q, m, err := b.release(name, receiptHandle)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.hold(q, receiptHandle, m, timeout)
+	return nil
+}
+
+// Send puts the message in the queue, blocking while it is full, or once its delay has passed
+func (b *Backend) Send(ctx context.Context, name string, input *sqs.SendMessageInput) (string, error) {
+	select {
+	case <-b.closed:
+		return "", ErrClosed
+	default:
+	}
+	b.mu.Lock()
+	q := b.queue(name)
+	b.sent++
+	m := &message{
+		id:         strconv.FormatInt(b.sent, 10),
+		body:       aws.StringValue(input.MessageBody),
+		attributes: input.MessageAttributes,
+		group:      aws.StringValue(input.MessageGroupId),
+		sent:       time.Now(),
+	}
+	b.mu.Unlock()
+
+	if delay := time.Duration(aws.Int64Value(input.DelaySeconds)) * time.Second; delay > 0 {
+		b.mu.Lock()
+		q.delayed++
+		b.mu.Unlock()
+		time.AfterFunc(delay, func() {
+			b.mu.Lock()
+			q.delayed--
+			b.mu.Unlock()
+			b.push(context.Background(), q, m)
+		})
+		return m.id, nil
+	}
+	if err := b.push(ctx, q, m); err != nil {
+		return "", err
+	}
+	return m.id, nil
+}
+
+// Depth counts the messages of the queue
+func (b *Backend) Depth(ctx context.Context, name string) (sqsworker.QueueDepth, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q := b.queue(name)
+	return sqsworker.QueueDepth{
+		Visible:    int64(len(q.ready)),
+		NotVisible: int64(len(q.leases)),
+		Delayed:    q.delayed,
+		Updated:    time.Now(),
+	}, nil
+}
+
+// Close stops the Backend, its receives and sends fail with ErrClosed and the messages it
+// holds are dropped
+func (b *Backend) Close() error {
+	b.once.Do(func() {
+		close(b.closed)
+	})
+	return nil
+}
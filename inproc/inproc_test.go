@@ -0,0 +1,126 @@
+package inproc_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/inproc"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVisibility(t *testing.T) {
+	backend := inproc.New()
+	defer backend.Close()
+	ctx := context.Background()
+
+	// a receive waits for a message to be sent
+	time.AfterFunc(20*time.Millisecond, func() {
+		backend.Send(ctx, "tasks", &sqs.SendMessageInput{MessageBody: aws.String("Hello")})
+	})
+	messages, err := backend.Receive(ctx, "tasks", 10, time.Second, 30*time.Millisecond)
+	if err != nil || len(messages) != 1 || aws.StringValue(messages[0].Body) != "Hello" {
+		t.Fatalf("unexpected receive %v %v", messages, err)
+	}
+	first := messages[0]
+	if depth, _ := backend.Depth(ctx, "tasks"); depth.Visible != 0 || depth.NotVisible != 1 {
+		t.Errorf("unexpected depth %v", depth)
+	}
+
+	// the message is redelivered once its visibility timeout passes
+	messages, err = backend.Receive(ctx, "tasks", 10, time.Second, time.Minute)
+	if err != nil || len(messages) != 1 || aws.StringValue(messages[0].Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]) != "2" {
+		t.Fatalf("expected the message to be redelivered, got %v %v", messages, err)
+	}
+	if err := backend.Delete(ctx, "tasks", *first.ReceiptHandle); err != inproc.ErrNotReceived {
+		t.Errorf("expected the receipt handle to be stale, got %v", err)
+	}
+
+	// a zero visibility makes the message visible immediately
+	if err := backend.ChangeVisibility(ctx, "tasks", *messages[0].ReceiptHandle, 0); err != nil {
+		t.Fatal(err)
+	}
+	messages, _ = backend.Receive(ctx, "tasks", 10, time.Second, time.Minute)
+	if len(messages) != 1 {
+		t.Fatalf("expected the message to be visible, got %v", messages)
+	}
+	if err := backend.Delete(ctx, "tasks", *messages[0].ReceiptHandle); err != nil {
+		t.Fatal(err)
+	}
+	if messages, _ := backend.Receive(ctx, "tasks", 10, 20*time.Millisecond, time.Minute); len(messages) != 0 {
+		t.Errorf("expected the message to be deleted, got %v", messages)
+	}
+
+	if _, err := backend.Send(ctx, "tasks", &sqs.SendMessageInput{MessageBody: aws.String("Later"), DelaySeconds: aws.Int64(60)}); err != nil {
+		t.Fatal(err)
+	}
+	if depth, _ := backend.Depth(ctx, "tasks"); depth.Visible != 0 || depth.NotVisible != 0 || depth.Delayed != 1 {
+		t.Errorf("unexpected depth %v", depth)
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	backend := inproc.New()
+	backend.Buffer = 1
+	ctx := context.Background()
+
+	if _, err := backend.Send(ctx, "tasks", &sqs.SendMessageInput{MessageBody: aws.String("Hello")}); err != nil {
+		t.Fatal(err)
+	}
+	// sending to a full queue blocks until the context is done
+	full, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := backend.Send(full, "tasks", &sqs.SendMessageInput{MessageBody: aws.String("World")}); err != context.DeadlineExceeded {
+		t.Errorf("expected the send to block, got %v", err)
+	}
+
+	backend.Close()
+	if _, err := backend.Receive(ctx, "tasks", 10, time.Second, time.Minute); err != inproc.ErrClosed {
+		t.Errorf("expected the backend to be closed, got %v", err)
+	}
+	if _, err := backend.Send(ctx, "tasks", &sqs.SendMessageInput{MessageBody: aws.String("Again")}); err != inproc.ErrClosed {
+		t.Errorf("expected the backend to be closed, got %v", err)
+	}
+}
+
+func TestWorker(t *testing.T) {
+	backend := inproc.New()
+	defer backend.Close()
+	ctx := context.Background()
+	for _, body := range []string{"Hello", "World"} {
+		if _, err := backend.Send(ctx, "tasks", &sqs.SendMessageInput{MessageBody: aws.String(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := sqsworker.NewWorker(nil, sqsworker.WorkerConfig{
+		Backend:  backend,
+		QueueURL: "tasks",
+		Workers:  2,
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			return &sns.PublishInput{Message: aws.String(strings.ToLower(*m.Body))}, nil
+		}),
+		Sink:   sqsworker.NewQueueSink(sqsworker.NewBackendQueue(backend), sqsworker.QueueConfig{QueueURL: "results"}),
+		Logger: zap.NewNop(),
+	})
+	go w.Run()
+	defer w.Close()
+
+	var bodies []string
+	for len(bodies) < 2 {
+		messages, err := backend.Receive(ctx, "results", 10, time.Second, time.Minute)
+		if err != nil || len(messages) == 0 {
+			t.Fatalf("results were not sent %v", err)
+		}
+		for _, m := range messages {
+			bodies = append(bodies, *m.Body)
+		}
+	}
+	if bodies[0] != "hello" && bodies[0] != "world" || bodies[0] == bodies[1] {
+		t.Errorf("unexpected results %v", bodies)
+	}
+}
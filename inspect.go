@@ -0,0 +1,156 @@
+package sqsworker
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"strconv"
+)
+
+// PeekVisibilityTimeout visibility timeout in seconds of messages received by Peek,
+// in case returning their visibility fails.
+const PeekVisibilityTimeout = 1
+
+// Peek receives up to max messages (at most 10) without consuming them. The messages are
+// received with a tiny visibility timeout, including all system and message attributes, and
+// their visibility is returned immediately so other consumers can receive them.
+// A peek is still a receive: it increments each message's ApproximateReceiveCount, so peeking
+// a queue with a redrive policy can move messages to its dead-letter queue.
+func Peek(queueURL string, max int64, sqsc sqsiface.SQSAPI) ([]*sqs.Message, error) {
+	if max <= 0 || max > DefaultMaxNumberOfMessages {
+		max = DefaultMaxNumberOfMessages
+	}
+	out, err := sqsc.ReceiveMessage(&sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(queueURL),
+		MaxNumberOfMessages:   aws.Int64(max),
+		VisibilityTimeout:     aws.Int64(PeekVisibilityTimeout),
+		WaitTimeSeconds:       aws.Int64(0),
+		AttributeNames:        aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+		MessageAttributeNames: aws.StringSlice([]string{"All"}),
+	})
+	if err != nil || len(out.Messages) == 0 {
+		return nil, err
+	}
+
+	err = returnVisibility(queueURL, out.Messages, sqsc)
+	return out.Messages, err
+}
+
+// PurgeVisibilityTimeout visibility timeout in seconds of messages received by DeleteMatching.
+// Messages that are kept stay invisible for this long so they are not received again during
+// the scan.
+const PurgeVisibilityTimeout = 300
+
+// Predicate reports whether a message matches
+type Predicate func(*sqs.Message) bool
+
+// PurgeQueue deletes every message in a queue
+func PurgeQueue(queueURL string, sqsc sqsiface.SQSAPI) error {
+	_, err := sqsc.PurgeQueue(&sqs.PurgeQueueInput{QueueUrl: aws.String(queueURL)})
+	return err
+}
+
+// DeleteMatching scans a queue and deletes the messages matching the predicate, returning the
+// matched messages. Messages that do not match are made visible again once the scan finishes.
+// With dryRun set nothing is deleted, which can be used to preview the matches. The scan stops
+// when a receive returns no messages or the context is done.
+//
+// Kept messages are only hidden for PurgeVisibilityTimeout, so a longer scan receives them
+// again; they are recognized by their MessageId and neither matched nor kept twice. Every
+// message scanned is received, including during a dry run, which increments its
+// ApproximateReceiveCount and can move it to the queue's dead-letter queue.
+func DeleteMatching(ctx context.Context, queueURL string, match Predicate, dryRun bool, sqsc sqsiface.SQSAPI) ([]*sqs.Message, error) {
+	var matched, kept []*sqs.Message
+	var err error
+	// seen holds the messages received by MessageId
+	seen := make(map[string]*sqs.Message)
+
+	params := &sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(queueURL),
+		MaxNumberOfMessages:   aws.Int64(DefaultMaxNumberOfMessages),
+		VisibilityTimeout:     aws.Int64(PurgeVisibilityTimeout),
+		WaitTimeSeconds:       aws.Int64(1),
+		AttributeNames:        aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+		MessageAttributeNames: aws.StringSlice([]string{"All"}),
+	}
+
+	for ctx.Err() == nil {
+		var out *sqs.ReceiveMessageOutput
+		out, err = sqsc.ReceiveMessageWithContext(ctx, params)
+		if err != nil || len(out.Messages) == 0 {
+			break
+		}
+
+		var batch []*sqs.Message
+		for _, m := range out.Messages {
+			if prev, ok := seen[aws.StringValue(m.MessageId)]; ok {
+				// only the latest receipt handle can return its visibility
+				prev.ReceiptHandle = m.ReceiptHandle
+				continue
+			}
+			seen[aws.StringValue(m.MessageId)] = m
+
+			if match(m) {
+				matched = append(matched, m)
+				batch = append(batch, m)
+			} else {
+				kept = append(kept, m)
+			}
+		}
+
+		if dryRun {
+			kept = append(kept, batch...)
+		} else if err = deleteBatch(queueURL, batch, sqsc); err != nil {
+			break
+		}
+	}
+
+	if verr := returnVisibility(queueURL, kept, sqsc); err == nil {
+		err = verr
+	}
+	return matched, err
+}
+
+func deleteBatch(queueURL string, messages []*sqs.Message, sqsc sqsiface.SQSAPI) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	input := &sqs.DeleteMessageBatchInput{QueueUrl: aws.String(queueURL)}
+	for i, m := range messages {
+		input.Entries = append(input.Entries, &sqs.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(strconv.Itoa(i)),
+			ReceiptHandle: m.ReceiptHandle,
+		})
+	}
+	out, err := sqsc.DeleteMessageBatch(input)
+	if err == nil && len(out.Failed) > 0 {
+		err = fmt.Errorf("failed to delete %d messages: %s", len(out.Failed), aws.StringValue(out.Failed[0].Message))
+	}
+	return err
+}
+
+// returnVisibility makes messages visible again, in batches of DefaultMaxNumberOfMessages
+func returnVisibility(queueURL string, messages []*sqs.Message, sqsc sqsiface.SQSAPI) error {
+	for len(messages) > 0 {
+		n := len(messages)
+		if n > DefaultMaxNumberOfMessages {
+			n = DefaultMaxNumberOfMessages
+		}
+
+		input := &sqs.ChangeMessageVisibilityBatchInput{QueueUrl: aws.String(queueURL)}
+		for i, m := range messages[:n] {
+			input.Entries = append(input.Entries, &sqs.ChangeMessageVisibilityBatchRequestEntry{
+				Id:                aws.String(strconv.Itoa(i)),
+				ReceiptHandle:     m.ReceiptHandle,
+				VisibilityTimeout: aws.Int64(0),
+			})
+		}
+		if _, err := sqsc.ChangeMessageVisibilityBatch(input); err != nil {
+			return err
+		}
+		messages = messages[n:]
+	}
+	return nil
+}
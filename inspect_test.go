@@ -0,0 +1,183 @@
+package sqsworker_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"strings"
+	"testing"
+)
+
+type PeekQueue struct {
+	sqsiface.SQSAPI
+	Messages []*sqs.Message
+	Receive  *sqs.ReceiveMessageInput
+	Returned *sqs.ChangeMessageVisibilityBatchInput
+}
+
+func (p *PeekQueue) ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	p.Receive = input
+	return &sqs.ReceiveMessageOutput{Messages: p.Messages}, nil
+}
+
+func (p *PeekQueue) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	p.Returned = input
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+}
+
+func TestPeek(t *testing.T) {
+	queue := &PeekQueue{Messages: []*sqs.Message{
+		{Body: aws.String("a"), ReceiptHandle: aws.String("1")},
+		{Body: aws.String("b"), ReceiptHandle: aws.String("2")},
+	}}
+
+	messages, err := sqsworker.Peek(workerQueueURL, 5, queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Error("Actual: ", len(messages), "Expected: ", 2)
+	}
+	if *queue.Receive.MaxNumberOfMessages != 5 || *queue.Receive.VisibilityTimeout != sqsworker.PeekVisibilityTimeout {
+		t.Error("unexpected receive: ", queue.Receive)
+	}
+	if len(queue.Returned.Entries) != 2 || *queue.Returned.Entries[1].ReceiptHandle != "2" {
+		t.Error("visibility not returned: ", queue.Returned)
+	}
+	if *queue.Returned.Entries[0].VisibilityTimeout != 0 {
+		t.Error("Actual: ", *queue.Returned.Entries[0].VisibilityTimeout, "Expected: ", 0)
+	}
+}
+
+// ScanQueue holds messages with simplified visibility semantics
+type ScanQueue struct {
+	sqsiface.SQSAPI
+	Visible  []*sqs.Message
+	InFlight map[string]*sqs.Message
+	Deleted  []string
+	Purged   bool
+}
+
+func NewScanQueue(bodies ...string) *ScanQueue {
+	q := &ScanQueue{InFlight: map[string]*sqs.Message{}}
+	for i, body := range bodies {
+		q.Visible = append(q.Visible, &sqs.Message{
+			MessageId:     aws.String(fmt.Sprint("id-", i)),
+			Body:          aws.String(body),
+			ReceiptHandle: aws.String(fmt.Sprint(i)),
+		})
+	}
+	return q
+}
+
+func (q *ScanQueue) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	n := int(*input.MaxNumberOfMessages)
+	if n > len(q.Visible) {
+		n = len(q.Visible)
+	}
+	out := &sqs.ReceiveMessageOutput{Messages: q.Visible[:n]}
+	for _, m := range out.Messages {
+		q.InFlight[*m.ReceiptHandle] = m
+	}
+	q.Visible = q.Visible[n:]
+	return out, nil
+}
+
+func (q *ScanQueue) DeleteMessageBatch(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	for _, entry := range input.Entries {
+		q.Deleted = append(q.Deleted, *q.InFlight[*entry.ReceiptHandle].Body)
+		delete(q.InFlight, *entry.ReceiptHandle)
+	}
+	return &sqs.DeleteMessageBatchOutput{}, nil
+}
+
+func (q *ScanQueue) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	for _, entry := range input.Entries {
+		q.Visible = append(q.Visible, q.InFlight[*entry.ReceiptHandle])
+		delete(q.InFlight, *entry.ReceiptHandle)
+	}
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+}
+
+func (q *ScanQueue) PurgeQueue(input *sqs.PurgeQueueInput) (*sqs.PurgeQueueOutput, error) {
+	q.Purged = true
+	return &sqs.PurgeQueueOutput{}, nil
+}
+
+func isBad(m *sqs.Message) bool {
+	return strings.HasPrefix(*m.Body, "bad")
+}
+
+func TestDeleteMatching(t *testing.T) {
+	var bodies []string
+	for i := 0; i < 25; i++ {
+		if i%5 == 0 {
+			bodies = append(bodies, fmt.Sprint("bad", i))
+		} else {
+			bodies = append(bodies, fmt.Sprint("good", i))
+		}
+	}
+	queue := NewScanQueue(bodies...)
+
+	matched, err := sqsworker.DeleteMatching(context.Background(), workerQueueURL, isBad, false, queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matched) != 5 || len(queue.Deleted) != 5 {
+		t.Error("Actual: ", len(matched), len(queue.Deleted), "Expected: ", 5)
+	}
+	if len(queue.Visible) != 20 || len(queue.InFlight) != 0 {
+		t.Error("Actual: ", len(queue.Visible), "Expected: ", 20)
+	}
+}
+
+func TestDeleteMatchingDryRun(t *testing.T) {
+	queue := NewScanQueue("bad1", "good1", "bad2")
+
+	matched, err := sqsworker.DeleteMatching(context.Background(), workerQueueURL, isBad, true, queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matched) != 2 || len(queue.Deleted) != 0 || len(queue.Visible) != 3 {
+		t.Error("unexpected dry run: ", len(matched), queue.Deleted, len(queue.Visible))
+	}
+}
+
+func TestDeleteMatchingRedelivered(t *testing.T) {
+	queue := NewScanQueue("bad1", "good1", "good2")
+	// the kept messages become visible again during the scan, with new receipt handles
+	for i, m := range queue.Visible[1:] {
+		queue.Visible = append(queue.Visible, &sqs.Message{
+			MessageId:     m.MessageId,
+			Body:          m.Body,
+			ReceiptHandle: aws.String(fmt.Sprint("again-", i)),
+		})
+	}
+
+	matched, err := sqsworker.DeleteMatching(context.Background(), workerQueueURL, isBad, true, queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matched) != 1 {
+		t.Error("Actual: ", len(matched), "Expected: ", 1)
+	}
+	if len(queue.Visible) != 3 {
+		t.Error("Actual: ", len(queue.Visible), "Expected: ", 3)
+	}
+	for _, m := range queue.Visible {
+		if m == nil {
+			t.Fatal("visibility returned with a stale receipt handle")
+		}
+	}
+}
+
+func TestPurgeQueue(t *testing.T) {
+	queue := NewScanQueue("a")
+	if err := sqsworker.PurgeQueue(workerQueueURL, queue); err != nil || !queue.Purged {
+		t.Error("queue not purged", err)
+	}
+}
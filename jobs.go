@@ -0,0 +1,171 @@
+package sqsworker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"sync"
+	"time"
+)
+
+// DefaultJobIDAttr is the message attribute holding the job ID of the messages sent by Enqueue,
+// and the worker's JobIDAttr when it has a JobStore
+const DefaultJobIDAttr = "job_id"
+
+// JobState is a state in the lifecycle of a job
+type JobState string
+
+// A job is queued by Enqueue, running while the worker processes it, and then succeeded or
+// failed. An attempt that fails and is retried queues the job again, with the error of the
+// attempt, and the job only fails once its message is dropped, dead-lettered or quarantined.
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// JobStatus is the state of a job, and its result or error once it is done
+type JobStatus struct {
+	ID    string
+	State JobState
+	// Result is the message of the result of a succeeded job
+	Result string
+	// Error is the error of a failed job
+	Error string
+	// Attempts is the number of times the job was received
+	Attempts int
+	Updated  time.Time
+}
+
+// JobStore records the status of jobs
+type JobStore interface {
+	SetStatus(ctx context.Context, status JobStatus) error
+	// Status returns the status of a job, false when the job is unknown
+	Status(ctx context.Context, id string) (JobStatus, bool, error)
+}
+
+// Jobs enqueues jobs on a worker's queue and reads their status. The worker records the status
+// of the jobs it processes in the same JobStore.
+type Jobs struct {
+	Queue    sqsiface.SQSAPI
+	QueueURL string
+	Store    JobStore
+	// IDAttr is the worker's JobIDAttr, by default DefaultJobIDAttr
+	IDAttr string
+}
+
+// NewJobs creates Jobs for the queue of a worker with the store as its JobStore
+func NewJobs(queue sqsiface.SQSAPI, queueURL string, store JobStore) *Jobs {
+	return &Jobs{Queue: queue, QueueURL: queueURL, Store: store}
+}
+
+// newJobID returns a random job ID
+func newJobID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Enqueue sends a job to the queue and returns its ID. The job is recorded as queued before it
+// is sent, so its status can be read as soon as Enqueue returns, and a job that fails to be sent
+// is recorded as failed with the error of the send.
+func (j *Jobs) Enqueue(ctx context.Context, body string, attributes map[string]*sqs.MessageAttributeValue) (string, error) {
+	id, err := newJobID()
+	if err != nil {
+		return "", err
+	}
+	if err := j.Store.SetStatus(ctx, JobStatus{ID: id, State: JobQueued, Updated: time.Now()}); err != nil {
+		return "", err
+	}
+
+	idAttr := j.IDAttr
+	if idAttr == "" {
+		idAttr = DefaultJobIDAttr
+	}
+	attrs := make(map[string]*sqs.MessageAttributeValue, len(attributes)+1)
+	for name, value := range attributes {
+		attrs[name] = value
+	}
+	attrs[idAttr] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(id)}
+	_, err = j.Queue.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:          aws.String(j.QueueURL),
+		MessageBody:       aws.String(body),
+		MessageAttributes: attrs,
+	})
+	if err != nil {
+		status := JobStatus{ID: id, State: JobFailed, Error: err.Error(), Updated: time.Now()}
+		j.Store.SetStatus(ctx, status)
+		return "", err
+	}
+	return id, nil
+}
+
+// JobStatus returns the status of a job, false when the job is unknown
+func (j *Jobs) JobStatus(ctx context.Context, id string) (JobStatus, bool, error) {
+	return j.Store.Status(ctx, id)
+}
+
+// jobID returns the job ID of a message, empty when it is not a job
+func (w *Worker) jobID(msg message) string {
+	if attr, ok := msg.MessageAttributes[w.JobIDAttr]; ok && w.JobIDAttr != "" {
+		return aws.StringValue(attr.StringValue)
+	}
+	return ""
+}
+
+// recordJob records the status of the job a message holds. Statuses are recorded on a best
+// effort basis: a status that fails to be recorded is logged, and does not fail the message.
+func (w *Worker) recordJob(ctx context.Context, state *consumerState, msg message, jobState JobState, output *sns.PublishInput, err error) {
+	id := w.jobID(msg)
+	if id == "" {
+		return
+	}
+	status := JobStatus{
+		ID:       id,
+		State:    jobState,
+		Attempts: int(attributeInt(msg.Attributes, sqs.MessageSystemAttributeNameApproximateReceiveCount)),
+		Updated:  time.Now(),
+	}
+	if output != nil {
+		status.Result = aws.StringValue(output.Message)
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	if err := w.JobStore.SetStatus(ctx, status); err != nil {
+		w.logConsumerError(state, "record job status failed!", err)
+	}
+}
+
+// MemoryJobStore is an in-process JobStore for development and tests. Its statuses are lost
+// when the process exits.
+type MemoryJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]JobStatus
+}
+
+// SetStatus stores the status of a job
+func (m *MemoryJobStore) SetStatus(ctx context.Context, status JobStatus) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.jobs == nil {
+		m.jobs = make(map[string]JobStatus)
+	}
+	m.jobs[status.ID] = status
+	return nil
+}
+
+// Status returns the status of a job
+func (m *MemoryJobStore) Status(ctx context.Context, id string) (JobStatus, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status, ok := m.jobs[id]
+	return status, ok, nil
+}
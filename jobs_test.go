@@ -0,0 +1,124 @@
+package sqsworker_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"testing"
+)
+
+// received builds the message a worker receives for a message sent to its queue
+func received(input *sqs.SendMessageInput) *sqs.Message {
+	m := workertest.NewMessage(aws.StringValue(input.MessageBody))
+	m.MessageAttributes = input.MessageAttributes
+	return m
+}
+
+func TestJobs(t *testing.T) {
+	store := &sqsworker.MemoryJobStore{}
+	var running sqsworker.JobState
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn: "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			status, _, _ := store.Status(ctx, aws.StringValue(m.MessageAttributes["job_id"].StringValue))
+			running = status.State
+			return (&LowerCaseWorker{}).Process(ctx, m)
+		}),
+		JobStore: store,
+	})
+	jobs := sqsworker.NewJobs(h.Queue, workertest.QueueURL, store)
+	ctx := context.Background()
+
+	id, err := jobs.Enqueue(ctx, "REPORT", nil)
+	if err != nil || id == "" {
+		t.Fatal("Enqueue failed: ", id, err)
+	}
+	if status, ok, _ := jobs.JobStatus(ctx, id); !ok || status.State != sqsworker.JobQueued {
+		t.Error("Expected a queued job, got ", status, ok)
+	}
+
+	h.Run(received(h.Queue.Sent[0])).Succeeded().Deleted().Published("report")
+	if running != sqsworker.JobRunning {
+		t.Error("Expected the job to be running while processed, got ", running)
+	}
+	status, ok, err := jobs.JobStatus(ctx, id)
+	if err != nil || !ok || status.State != sqsworker.JobSucceeded || status.Result != "report" {
+		t.Error("unexpected status: ", status, ok, err)
+	}
+	if _, ok, _ := jobs.JobStatus(ctx, "unknown"); ok {
+		t.Error("Expected no status for an unknown job")
+	}
+}
+
+func TestJobsFailed(t *testing.T) {
+	store := &sqsworker.MemoryJobStore{}
+	processor := &FailingWorker{Err: errors.New("report failed")}
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor:          processor,
+		JobStore:           store,
+		DeadLetterQueueURL: workertest.QueueURL + "-dlq",
+	})
+	jobs := sqsworker.NewJobs(h.Queue, workertest.QueueURL, store)
+	ctx := context.Background()
+
+	id, err := jobs.Enqueue(ctx, "REPORT", map[string]*sqs.MessageAttributeValue{
+		"tenant": {DataType: aws.String("String"), StringValue: aws.String("acme")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := h.Queue.Sent[0]
+	if aws.StringValue(sent.MessageAttributes["tenant"].StringValue) != "acme" {
+		t.Error("Expected the attributes to be sent with the job")
+	}
+
+	// a retried attempt queues the job again, and the job fails once it is dead-lettered
+	h.Run(received(sent)).Failed().NotDeleted()
+	status, _, _ := jobs.JobStatus(ctx, id)
+	if status.State != sqsworker.JobQueued || status.Error != "report failed" {
+		t.Error("unexpected status: ", status)
+	}
+	processor.Err = sqsworker.Fatal(errors.New("report failed"))
+	h.Run(received(sent)).Failed().DeadLettered()
+	status, _, _ = jobs.JobStatus(ctx, id)
+	if status.State != sqsworker.JobFailed || status.Error == "" {
+		t.Error("unexpected status: ", status)
+	}
+}
+
+// UnsentQueue fails every send
+type UnsentQueue struct {
+	*workertest.Queue
+}
+
+func (u *UnsentQueue) SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	return nil, errors.New("send failed")
+}
+
+// LastJobStore records the last status set
+type LastJobStore struct {
+	sqsworker.MemoryJobStore
+	Last sqsworker.JobStatus
+}
+
+func (l *LastJobStore) SetStatus(ctx context.Context, status sqsworker.JobStatus) error {
+	l.Last = status
+	return l.MemoryJobStore.SetStatus(ctx, status)
+}
+
+func TestJobsUnsent(t *testing.T) {
+	store := &LastJobStore{}
+	jobs := sqsworker.NewJobs(&UnsentQueue{&workertest.Queue{}}, workertest.QueueURL, store)
+	if _, err := jobs.Enqueue(context.Background(), "REPORT", nil); err == nil {
+		t.Fatal("Expected the send to fail")
+	}
+	// the job recorded as queued is not left queued
+	if store.Last.State != sqsworker.JobFailed || store.Last.Error != "send failed" {
+		t.Error("unexpected status: ", store.Last)
+	}
+}
@@ -0,0 +1,426 @@
+// Package kafka provides a Kafka sqsworker.Backend consuming topics with a consumer group, on
+// readers and writers of github.com/segmentio/kafka-go. The QueueURLs of the worker are topics:
+//
+//	reader := kafkago.NewReader(kafkago.ReaderConfig{Brokers: brokers, GroupID: "orders-worker", Topic: "orders"})
+//	writer := &kafkago.Writer{Addr: kafkago.TCP(brokers...)}
+//	backend := kafka.New(map[string]kafka.Reader{"orders": reader}, writer)
+//	defer backend.Close()
+//	w := sqsworker.NewWorker(nil, sqsworker.WorkerConfig{
+//		Backend:   backend,
+//		QueueURL:  "orders",
+//		Processor: processor,
+//		Sink:      kafka.NewProducer(writer, "orders-processed"),
+//	})
+//
+// Like the messages of a FIFO message group, the messages of a partition are processed one at
+// a time in offset order: their MessageGroupId is the partition, and the next message of a
+// partition is only received once the previous one was deleted. Deleting a message commits its
+// offset, so the group resumes after the last message handled successfully. A message that
+// is not deleted is received again once its visibility timeout passes, holding up the rest
+// of its partition.
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	kafkago "github.com/segmentio/kafka-go"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuffer is the most messages a Backend fetches ahead of the worker for each partition
+const DefaultBuffer = 1000
+
+// KeyAttribute is the message attribute holding the key of a message
+const KeyAttribute = "kafka.key"
+
+// fetchBackoff is the wait before fetching again after a fetch failed
+const fetchBackoff = time.Second
+
+// ErrDelay is returned for messages sent with a delay, which Kafka does not support
+var ErrDelay = errors.New("kafka: messages cannot be delayed")
+
+// ErrNotReceived is returned for a receipt handle that is not the message currently received
+// from its partition
+var ErrNotReceived = errors.New("kafka: the message is not received")
+
+// Reader is the part of a *kafkago.Reader of a consumer group the Backend uses
+type Reader interface {
+	FetchMessage(ctx context.Context) (kafkago.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafkago.Message) error
+}
+
+// Writer is the part of a *kafkago.Writer the Backend and Producer use
+type Writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafkago.Message) error
+}
+
+// Backend is a sqsworker.Backend consuming topics with their Readers, and sending messages
+// with the Writer, which must not have a Topic of its own
+type Backend struct {
+	Readers map[string]Reader
+	Writer  Writer
+	// Buffer caps the fetched messages of each partition, so a partition held up by a failing
+	// message cannot take the room of the others. The reader fetches every partition in one
+	// stream, which waits once it reaches a message of a full partition. Buffer defaults to
+	// DefaultBuffer.
+	Buffer int
+	ctx    context.Context
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	topics map[string]*topic
+}
+
+// New creates a Backend consuming the readers by topic, and sending messages with the writer
+func New(readers map[string]Reader, writer Writer) *Backend {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Backend{Readers: readers, Writer: writer, ctx: ctx, cancel: cancel, topics: make(map[string]*topic)}
+}
+
+// Close stops fetching messages, the readers are closed by their owner
+func (b *Backend) Close() error {
+	b.cancel()
+	return nil
+}
+
+// partition holds the fetched messages of a partition in offset order, the first one is
+// received until it is deleted
+type partition struct {
+	messages []kafkago.Message
+	// hidden is when the first message is visible again, and receives counts its receives
+	hidden   time.Time
+	receives int
+}
+
+// topic buffers the messages fetched from a topic's reader by partition
+type topic struct {
+	name       string
+	reader     Reader
+	buffer     int
+	mu         sync.Mutex
+	partitions map[int]*partition
+	// order lists the partitions in the order they were first fetched, so none is starved
+	order []int
+	err   error
+	ready chan struct{}
+	room  chan struct{}
+}
+
+// topic returns the buffer of the topic, starting to fetch it on the first call
+func (b *Backend) topic(name string) (*topic, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if t, ok := b.topics[name]; ok {
+		return t, nil
+	}
+	reader, ok := b.Readers[name]
+	if !ok {
+		return nil, fmt.Errorf("kafka: no reader for the topic %s", name)
+	}
+	buffer := b.Buffer
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	t := &topic{
+		name:       name,
+		reader:     reader,
+		buffer:     buffer,
+		partitions: make(map[int]*partition),
+		ready:      make(chan struct{}, 1),
+		room:       make(chan struct{}, 1),
+	}
+	b.topics[name] = t
+	go t.fetch(b.ctx)
+	return t, nil
+}
+
+// signal wakes up a waiter of the channel
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// fetch buffers the messages of the reader until the context is done or the reader is closed
+func (t *topic) fetch(ctx context.Context) {
+	// held is a fetched message waiting for room in its partition
+	var held *kafkago.Message
+	for {
+		if held == nil {
+			m, err := t.reader.FetchMessage(ctx)
+			if ctx.Err() != nil || err == io.EOF {
+				return
+			}
+			if err != nil {
+				t.mu.Lock()
+				t.err = err
+				t.mu.Unlock()
+				signal(t.ready)
+				select {
+				case <-time.After(fetchBackoff):
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			held = &m
+		}
+
+		t.mu.Lock()
+		p, ok := t.partitions[held.Partition]
+		if !ok {
+			p = &partition{}
+			t.partitions[held.Partition] = p
+			t.order = append(t.order, held.Partition)
+		}
+		full := len(p.messages) >= t.buffer
+		if !full {
+			p.messages = append(p.messages, *held)
+			held = nil
+		}
+		t.mu.Unlock()
+		if full {
+			select {
+			case <-t.room:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		signal(t.ready)
+	}
+}
+
+// receive leases the first message of up to max visible partitions, returning how long until
+// the next partition is visible again when none is, or zero
+func (t *topic) receive(max int64, visibility time.Duration) ([]*sqs.Message, time.Duration, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.err; err != nil {
+		t.err = nil
+		return nil, 0, err
+	}
+
+	now := time.Now()
+	var messages []*sqs.Message
+	var next time.Duration
+	for _, id := range t.order {
+		p := t.partitions[id]
+		if len(p.messages) == 0 {
+			continue
+		}
+		if wait := p.hidden.Sub(now); wait > 0 {
+			if next == 0 || wait < next {
+				next = wait
+			}
+			continue
+		}
+		p.hidden = now.Add(visibility)
+		p.receives++
+		messages = append(messages, message(p.messages[0], p.receives))
+		if int64(len(messages)) == max {
+			break
+		}
+	}
+	return messages, next, nil
+}
+
+// Receive returns the next message of up to max partitions, waiting up to wait for one
+func (b *Backend) Receive(ctx context.Context, queue string, max int64, wait, visibility time.Duration) ([]*sqs.Message, error) {
+	t, err := b.topic(queue)
+	if err != nil {
+		return nil, err
+	}
+	if visibility == 0 {
+		visibility = sqsworker.DefaultVisibilityTimeout * time.Second
+	}
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	for {
+		messages, next, err := t.receive(max, visibility)
+		if len(messages) > 0 || err != nil || wait == 0 {
+			return messages, err
+		}
+
+		// wait for new messages, a partition to be visible again, or the end of the wait
+		var visible <-chan time.Time
+		if next > 0 {
+			visible = time.After(next)
+		}
+		select {
+		case <-t.ready:
+		case <-visible:
+		case <-deadline.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// message converts a fetched message, its topic, partition and offset are its MessageId and
+// ReceiptHandle
+func message(m kafkago.Message, receives int) *sqs.Message {
+	id := m.Topic + "/" + strconv.Itoa(m.Partition) + "/" + strconv.FormatInt(m.Offset, 10)
+	msg := &sqs.Message{
+		MessageId:     aws.String(id),
+		ReceiptHandle: aws.String(id),
+		Body:          aws.String(string(m.Value)),
+		Attributes: map[string]*string{
+			sqs.MessageSystemAttributeNameMessageGroupId:          aws.String(strconv.Itoa(m.Partition)),
+			sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String(strconv.Itoa(receives)),
+		},
+	}
+	if !m.Time.IsZero() {
+		msg.Attributes[sqs.MessageSystemAttributeNameSentTimestamp] = aws.String(strconv.FormatInt(m.Time.UnixNano()/int64(time.Millisecond), 10))
+	}
+	if len(m.Key) > 0 || len(m.Headers) > 0 {
+		msg.MessageAttributes = make(map[string]*sqs.MessageAttributeValue, len(m.Headers)+1)
+	}
+	if len(m.Key) > 0 {
+		msg.MessageAttributes[KeyAttribute] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(string(m.Key))}
+	}
+	for _, h := range m.Headers {
+		msg.MessageAttributes[h.Key] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(string(h.Value))}
+	}
+	return msg
+}
+
+// received returns the partition of the message received with the receipt handle, the caller
+// holds the lock
+func (t *topic) received(receiptHandle string) (*partition, error) {
+	parts := strings.Split(receiptHandle, "/")
+	if len(parts) < 3 {
+		return nil, ErrNotReceived
+	}
+	id, err := strconv.Atoi(parts[len(parts)-2])
+	if err != nil {
+		return nil, ErrNotReceived
+	}
+	offset, err := strconv.ParseInt(parts[len(parts)-1], 10, 64)
+	if err != nil {
+		return nil, ErrNotReceived
+	}
+	p, ok := t.partitions[id]
+	if !ok || len(p.messages) == 0 || p.messages[0].Offset != offset || p.receives == 0 {
+		return nil, ErrNotReceived
+	}
+	return p, nil
+}
+
+// Delete commits the offset of the message, and makes the next message of its partition
+// visible
+func (b *Backend) Delete(ctx context.Context, queue, receiptHandle string) error {
+	t, err := b.topic(queue)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	p, err := t.received(receiptHandle)
+	if err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	m := p.messages[0]
+	t.mu.Unlock()
+
+	// the message stays first until its offset is committed, so it is received again when
+	// the commit fails
+	if err := t.reader.CommitMessages(ctx, m); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	if len(p.messages) > 0 && p.messages[0].Offset == m.Offset {
+		p.messages[0] = kafkago.Message{}
+		p.messages = p.messages[1:]
+		p.hidden, p.receives = time.Time{}, 0
+	}
+	t.mu.Unlock()
+	signal(t.room)
+	signal(t.ready)
+	return nil
+}
+
+// ChangeVisibility receives the message again once the timeout has passed, holding up the
+// rest of its partition
+func (b *Backend) ChangeVisibility(ctx context.Context, queue, receiptHandle string, timeout time.Duration) error {
+	t, err := b.topic(queue)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, err := t.received(receiptHandle)
+	if err != nil {
+		return err
+	}
+	p.hidden = time.Now().Add(timeout)
+	if timeout == 0 {
+		signal(t.ready)
+	}
+	return nil
+}
+
+// Send writes the message to the topic with its MessageGroupId as key, so the messages of a
+// group go to the same partition. The MessageId of sent messages is unknown until they are
+// received, so it is empty.
+func (b *Backend) Send(ctx context.Context, queue string, input *sqs.SendMessageInput) (string, error) {
+	if aws.Int64Value(input.DelaySeconds) > 0 {
+		return "", ErrDelay
+	}
+	m := kafkago.Message{Topic: queue, Value: []byte(aws.StringValue(input.MessageBody))}
+	if input.MessageGroupId != nil {
+		m.Key = []byte(*input.MessageGroupId)
+	}
+	for name, value := range input.MessageAttributes {
+		m.Headers = append(m.Headers, header(name, value.StringValue, value.BinaryValue))
+	}
+	return "", b.Writer.WriteMessages(ctx, m)
+}
+
+// header converts a message attribute to a header
+func header(name string, value *string, binary []byte) kafkago.Header {
+	if value != nil {
+		return kafkago.Header{Key: name, Value: []byte(*value)}
+	}
+	return kafkago.Header{Key: name, Value: binary}
+}
+
+// Producer is a sqsworker.Sink writing results to a Kafka topic, keyed by their
+// MessageGroupId, with their message attributes as headers
+type Producer struct {
+	Writer Writer
+	// Topic of the results, empty when the Writer has its own
+	Topic string
+}
+
+// NewProducer creates a Sink writing results to the topic
+func NewProducer(writer Writer, topic string) *Producer {
+	return &Producer{Writer: writer, Topic: topic}
+}
+
+// Send writes the result, keyed by default by the key of the message it was produced from, so
+// the results of a key keep their order
+func (p *Producer) Send(ctx context.Context, m *sqs.Message, output *sns.PublishInput) error {
+	msg := kafkago.Message{Topic: p.Topic, Value: []byte(aws.StringValue(output.Message))}
+	key := output.MessageGroupId
+	if attr, ok := m.MessageAttributes[KeyAttribute]; ok && key == nil {
+		key = attr.StringValue
+	}
+	if key != nil {
+		msg.Key = []byte(*key)
+	}
+	for name, value := range output.MessageAttributes {
+		msg.Headers = append(msg.Headers, header(name, value.StringValue, value.BinaryValue))
+	}
+	return p.Writer.WriteMessages(ctx, msg)
+}
@@ -0,0 +1,187 @@
+package kafka_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/kafka"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	kafkago "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Reader fetches the messages pushed to it, recording the commits
+type Reader struct {
+	messages chan kafkago.Message
+	Commits  chan kafkago.Message
+}
+
+func NewReader() *Reader {
+	return &Reader{messages: make(chan kafkago.Message, 100), Commits: make(chan kafkago.Message, 100)}
+}
+
+func (r *Reader) Push(partition int, offset int64, body string) {
+	r.messages <- kafkago.Message{Topic: "orders", Partition: partition, Offset: offset, Key: []byte("customer"), Value: []byte(body)}
+}
+
+func (r *Reader) FetchMessage(ctx context.Context) (kafkago.Message, error) {
+	select {
+	case m := <-r.messages:
+		return m, nil
+	case <-ctx.Done():
+		return kafkago.Message{}, ctx.Err()
+	}
+}
+
+func (r *Reader) CommitMessages(ctx context.Context, msgs ...kafkago.Message) error {
+	for _, m := range msgs {
+		r.Commits <- m
+	}
+	return nil
+}
+
+// Writer records the messages written
+type Writer struct {
+	mu       sync.Mutex
+	Messages []kafkago.Message
+}
+
+func (w *Writer) WriteMessages(ctx context.Context, msgs ...kafkago.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.Messages = append(w.Messages, msgs...)
+	return nil
+}
+
+// receive receives until n messages were returned
+func receive(t *testing.T, backend *kafka.Backend, n int) []*sqs.Message {
+	var messages []*sqs.Message
+	deadline := time.Now().Add(time.Second)
+	for len(messages) < n && time.Now().Before(deadline) {
+		received, err := backend.Receive(context.Background(), "orders", 10, 100*time.Millisecond, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, received...)
+	}
+	if len(messages) != n {
+		t.Fatalf("expected %d messages, got %d", n, len(messages))
+	}
+	return messages
+}
+
+func TestPartitions(t *testing.T) {
+	reader := NewReader()
+	backend := kafka.New(map[string]kafka.Reader{"orders": reader}, &Writer{})
+	defer backend.Close()
+	ctx := context.Background()
+
+	reader.Push(0, 0, "a")
+	reader.Push(0, 1, "b")
+	reader.Push(1, 0, "c")
+
+	// only the first message of each partition is received
+	messages := receive(t, backend, 2)
+	first := messages[0]
+	if aws.StringValue(first.Body) != "a" || aws.StringValue(first.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]) != "0" ||
+		aws.StringValue(first.MessageAttributes[kafka.KeyAttribute].StringValue) != "customer" {
+		t.Errorf("unexpected message %v", first)
+	}
+	if received, _ := backend.Receive(ctx, "orders", 10, 20*time.Millisecond, time.Minute); len(received) != 0 {
+		t.Errorf("expected the partitions to be held, got %v", received)
+	}
+
+	// deleting a message commits it, and receives the next one of its partition
+	if err := backend.Delete(ctx, "orders", *first.ReceiptHandle); err != nil {
+		t.Fatal(err)
+	}
+	if commit := <-reader.Commits; commit.Partition != 0 || commit.Offset != 0 {
+		t.Errorf("unexpected commit %v", commit)
+	}
+	if err := backend.Delete(ctx, "orders", *first.ReceiptHandle); err != kafka.ErrNotReceived {
+		t.Errorf("expected the message to be deleted, got %v", err)
+	}
+	second := receive(t, backend, 1)[0]
+	if aws.StringValue(second.Body) != "b" {
+		t.Errorf("unexpected message %v", second)
+	}
+
+	// a message made visible is received again
+	if err := backend.ChangeVisibility(ctx, "orders", *second.ReceiptHandle, 0); err != nil {
+		t.Fatal(err)
+	}
+	again := receive(t, backend, 1)[0]
+	if aws.StringValue(again.Body) != "b" || aws.StringValue(again.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]) != "2" {
+		t.Errorf("unexpected message %v", again)
+	}
+}
+
+func TestPartitionBuffer(t *testing.T) {
+	reader := NewReader()
+	backend := kafka.New(map[string]kafka.Reader{"orders": reader}, &Writer{})
+	backend.Buffer = 2
+	defer backend.Close()
+
+	// a partition filling its buffer leaves room for the others
+	reader.Push(0, 0, "a")
+	reader.Push(0, 1, "b")
+	reader.Push(1, 0, "c")
+	messages := receive(t, backend, 2)
+	if aws.StringValue(messages[0].Body) != "a" || aws.StringValue(messages[1].Body) != "c" {
+		t.Errorf("unexpected messages %v", messages)
+	}
+}
+
+func TestWorker(t *testing.T) {
+	reader := NewReader()
+	writer := &Writer{}
+	backend := kafka.New(map[string]kafka.Reader{"orders": reader}, writer)
+	defer backend.Close()
+	for offset := int64(0); offset < 5; offset++ {
+		reader.Push(0, offset, string(rune('A'+offset)))
+		reader.Push(1, offset, string(rune('V'+offset)))
+	}
+
+	var mu sync.Mutex
+	processed := map[string]string{}
+	w := sqsworker.NewWorker(nil, sqsworker.WorkerConfig{
+		Backend:  backend,
+		QueueURL: "orders",
+		Workers:  4,
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			mu.Lock()
+			processed[*m.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]] += *m.Body
+			mu.Unlock()
+			return &sns.PublishInput{Message: aws.String(strings.ToLower(*m.Body))}, nil
+		}),
+		Sink:   kafka.NewProducer(writer, "processed"),
+		Logger: zap.NewNop(),
+	})
+	go w.Run()
+	defer w.Close()
+
+	for i := 0; i < 10; i++ {
+		select {
+		case <-reader.Commits:
+		case <-time.After(time.Second):
+			t.Fatal("offsets were not committed")
+		}
+	}
+
+	// the messages of each partition were processed in order
+	mu.Lock()
+	defer mu.Unlock()
+	if processed["0"] != "ABCDE" || processed["1"] != "VWXYZ" {
+		t.Errorf("unexpected order %v", processed)
+	}
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	if len(writer.Messages) != 10 || writer.Messages[0].Topic != "processed" || string(writer.Messages[0].Key) != "customer" {
+		t.Errorf("unexpected results %v", writer.Messages)
+	}
+}
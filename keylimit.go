@@ -0,0 +1,68 @@
+package sqsworker
+
+import (
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultKeyDeferral is how many seconds the messages over MaxPerKey stay invisible when the
+// worker has no KeyDeferral. Redelivering them at once would only receive them again while
+// their key is still busy.
+const DefaultKeyDeferral = 5
+
+// KeyFunc derives a concurrency key from a message
+type KeyFunc func(*sqs.Message) string
+
+// AttributeKey returns a KeyFunc that uses the string value of the named message attribute.
+// Messages without the attribute share the empty key.
+func AttributeKey(name string) KeyFunc {
+	return func(m *sqs.Message) string {
+		if attr, ok := m.MessageAttributes[name]; ok && attr.StringValue != nil {
+			return *attr.StringValue
+		}
+		return ""
+	}
+}
+
+// deferKey returns a message whose key is over MaxPerKey to the queue for the KeyDeferral, so
+// the messages of other keys proceed
+func (w *Worker) deferKey(state *consumerState, msg message) error {
+	atomic.AddInt64(&w.stats.deferred, 1)
+	err := w.changeVisibility(msg, w.KeyDeferral)
+	if err != nil {
+		w.logConsumerError(state, "reset visibility failed!", err)
+	}
+	return err
+}
+
+// keyLimiter tracks in-flight messages per key
+type keyLimiter struct {
+	max      int
+	mu       sync.Mutex
+	inflight map[string]int
+}
+
+func newKeyLimiter(max int) *keyLimiter {
+	return &keyLimiter{max: max, inflight: make(map[string]int)}
+}
+
+func (k *keyLimiter) acquire(key string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.inflight[key] >= k.max {
+		return false
+	}
+	k.inflight[key]++
+	return true
+}
+
+func (k *keyLimiter) release(key string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.inflight[key] <= 1 {
+		delete(k.inflight, key)
+		return
+	}
+	k.inflight[key]--
+}
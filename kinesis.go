@@ -0,0 +1,112 @@
+package sqsworker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"time"
+)
+
+// MaxKinesisBatchSize is the most records PutRecords accepts at once
+const MaxKinesisBatchSize = 500
+
+// DefaultKinesisRetries is the number of times records that fail in a batch are retried
+const DefaultKinesisRetries = 3
+
+// PartitionKey derives the Kinesis partition key of a result from the message it was produced from
+type PartitionKey func(*sqs.Message, *sns.PublishInput) string
+
+// KinesisConfig settings for a KinesisSink
+type KinesisConfig struct {
+	StreamName string
+	// PartitionKey defaults to the MessageGroupId of FIFO messages, and the MessageId otherwise
+	PartitionKey PartitionKey
+	// BatchSize, BatchWindow, Retries and Backoff batch and retry records as described on Sink.
+	// BatchSize is at most MaxKinesisBatchSize.
+	BatchSize   int
+	BatchWindow time.Duration
+	Retries     int
+	Backoff     time.Duration
+}
+
+// KinesisSink is a Sink writing results to a Kinesis data stream. The message of each result is
+// the record data. Records have no attributes, so results with message attributes are rejected.
+type KinesisSink struct {
+	Client kinesisiface.KinesisAPI
+	config KinesisConfig
+	batch  *batcher
+}
+
+// NewKinesisSink creates a KinesisSink
+func NewKinesisSink(client kinesisiface.KinesisAPI, config KinesisConfig) *KinesisSink {
+	if config.BatchSize > MaxKinesisBatchSize {
+		config.BatchSize = MaxKinesisBatchSize
+	}
+	if config.PartitionKey == nil {
+		config.PartitionKey = messagePartitionKey
+	}
+	if config.Retries == 0 {
+		config.Retries = DefaultKinesisRetries
+	}
+	if config.Backoff == 0 {
+		config.Backoff = DefaultPublishBackoff
+	}
+	s := &KinesisSink{Client: client, config: config}
+	s.batch = newBatcher(config.BatchSize, config.BatchWindow, s.put)
+	return s
+}
+
+// messagePartitionKey keeps the records of a FIFO message group in order on one shard
+func messagePartitionKey(m *sqs.Message, output *sns.PublishInput) string {
+	if group := aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]); group != "" {
+		return group
+	}
+	return aws.StringValue(m.MessageId)
+}
+
+// Send writes the result to the stream. Results that cannot be converted to a record fail with
+// a Fatal error.
+func (s *KinesisSink) Send(ctx context.Context, m *sqs.Message, output *sns.PublishInput) error {
+	if len(output.MessageAttributes) > 0 {
+		return Fatal(errors.New("sqsworker: results written to Kinesis cannot have message attributes"))
+	}
+	key := s.config.PartitionKey(m, output)
+	if key == "" {
+		return Fatal(errors.New("sqsworker: Kinesis partition key is empty"))
+	}
+	return s.batch.send(ctx, &kinesis.PutRecordsRequestEntry{
+		Data:         []byte(aws.StringValue(output.Message)),
+		PartitionKey: aws.String(key),
+	})
+}
+
+// put sends a batch of records, sending the records that failed again until they succeed or the
+// retries run out, and returns the error of each
+func (s *KinesisSink) put(ctx context.Context, values []interface{}) []error {
+	return retryFailed(ctx, len(values), s.config.Retries, s.config.Backoff, func(indexes []int) []error {
+		input := &kinesis.PutRecordsInput{
+			StreamName: aws.String(s.config.StreamName),
+			Records:    make([]*kinesis.PutRecordsRequestEntry, len(indexes)),
+		}
+		for i, index := range indexes {
+			input.Records[i] = values[index].(*kinesis.PutRecordsRequestEntry)
+		}
+
+		errs := make([]error, len(indexes))
+		out, err := s.Client.PutRecords(input)
+		for i := range errs {
+			switch {
+			case err != nil:
+				errs[i] = err
+			case i < len(out.Records) && out.Records[i].ErrorCode != nil:
+				errs[i] = fmt.Errorf("sqsworker: put record failed: %s: %s", *out.Records[i].ErrorCode, aws.StringValue(out.Records[i].ErrorMessage))
+			}
+		}
+		return errs
+	})
+}
@@ -0,0 +1,129 @@
+package sqsworker_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Stream records the records written to it, throttling each record whose data is "throttled"
+// the first Throttles times it is written
+type Stream struct {
+	kinesisiface.KinesisAPI
+	Throttles int
+	mu        sync.Mutex
+	Batches   [][]*kinesis.PutRecordsRequestEntry
+	throttled int
+}
+
+func (s *Stream) PutRecords(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Batches = append(s.Batches, input.Records)
+
+	out := &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}
+	throttle := false
+	for _, record := range input.Records {
+		if string(record.Data) == "throttled" && s.throttled < s.Throttles {
+			throttle = true
+			*out.FailedRecordCount++
+			out.Records = append(out.Records, &kinesis.PutRecordsResultEntry{
+				ErrorCode:    aws.String(kinesis.ErrCodeProvisionedThroughputExceededException),
+				ErrorMessage: aws.String("rate exceeded"),
+			})
+			continue
+		}
+		out.Records = append(out.Records, &kinesis.PutRecordsResultEntry{ShardId: aws.String("shard"), SequenceNumber: aws.String("1")})
+	}
+	if throttle {
+		s.throttled++
+	}
+	return out, nil
+}
+
+func (s *Stream) batches() [][]*kinesis.PutRecordsRequestEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Batches
+}
+
+func TestKinesisSink(t *testing.T) {
+	stream := &Stream{}
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: &EventWorker{Output: func(m *sqs.Message) *sns.PublishInput { return &sns.PublishInput{Message: m.Body} }},
+		Sink:      sqsworker.NewKinesisSink(stream, sqsworker.KinesisConfig{StreamName: "analytics"}),
+	})
+
+	m := workertest.NewMessage("viewed")
+	h.Run(m).Succeeded().Deleted().NotPublished()
+	h.Run(workertest.NewMessage("grouped", workertest.SystemAttribute("MessageGroupId", "customer-1"))).Succeeded().Deleted()
+
+	batches := stream.batches()
+	if len(batches) != 2 {
+		t.Fatal("unexpected records: ", batches)
+	}
+	if record := batches[0][0]; string(record.Data) != "viewed" || aws.StringValue(record.PartitionKey) != *m.MessageId {
+		t.Error("unexpected record: ", record)
+	}
+	if key := aws.StringValue(batches[1][0].PartitionKey); key != "customer-1" {
+		t.Error("Actual: ", key, "Expected: ", "customer-1")
+	}
+}
+
+func TestKinesisSinkRetriesFailedRecords(t *testing.T) {
+	send := func(sink *sqsworker.KinesisSink, bodies []string) []error {
+		errs := make([]error, len(bodies))
+		var wg sync.WaitGroup
+		for i, body := range bodies {
+			wg.Add(1)
+			go func(i int, body string) {
+				defer wg.Done()
+				errs[i] = sink.Send(context.Background(), workertest.NewMessage(body), &sns.PublishInput{Message: aws.String(body)})
+			}(i, body)
+		}
+		wg.Wait()
+		return errs
+	}
+	bodies := []string{"a", "throttled", "b"}
+	config := sqsworker.KinesisConfig{StreamName: "analytics", BatchSize: 3, BatchWindow: time.Second, Backoff: time.Millisecond}
+
+	// Only the failed record is sent again
+	stream := &Stream{Throttles: 2}
+	for _, err := range send(sqsworker.NewKinesisSink(stream, config), bodies) {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	batches := stream.batches()
+	if len(batches) != 3 || len(batches[0]) != 3 || len(batches[1]) != 1 || string(batches[2][0].Data) != "throttled" {
+		t.Fatal("unexpected batches: ", batches)
+	}
+
+	// Records failing every retry fail their own message only
+	stream = &Stream{Throttles: 10}
+	errs := send(sqsworker.NewKinesisSink(stream, config), bodies)
+	if errs[0] != nil || errs[1] == nil || errs[2] != nil {
+		t.Error("unexpected errors: ", errs)
+	}
+	if batches := stream.batches(); len(batches) != sqsworker.DefaultKinesisRetries+1 {
+		t.Error("Actual: ", len(batches), "Expected: ", sqsworker.DefaultKinesisRetries+1)
+	}
+
+	// A record sent on its own stops waiting to be retried once its context is done
+	stream = &Stream{Throttles: 10}
+	sink := sqsworker.NewKinesisSink(stream, sqsworker.KinesisConfig{StreamName: "analytics", Backoff: time.Minute})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := sink.Send(ctx, workertest.NewMessage("throttled"), &sns.PublishInput{Message: aws.String("throttled")}); err == nil || time.Since(start) > time.Second {
+		t.Error("unexpected send: ", err, time.Since(start))
+	}
+}
@@ -0,0 +1,215 @@
+package sqsworker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed is returned by Err after the worker was closed by Close, Stop or RunUntilSignal
+var ErrClosed = errors.New("sqsworker: worker closed")
+
+// ErrNoProcessor is returned by Err when the worker was run without a Processor
+var ErrNoProcessor = errors.New("sqsworker: no Processor configured")
+
+// ErrNoQueue is returned by Err when the worker was run without a queue url
+var ErrNoQueue = errors.New("sqsworker: no queue url configured")
+
+// state of a Worker, which only moves forward: new, running, closed
+type state int
+
+const (
+	stateNew state = iota
+	stateRunning
+	stateClosed
+)
+
+// transition moves the worker from one state to another, reporting whether it was in the from state
+func (w *Worker) transition(from, to state) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.state != from {
+		return false
+	}
+	w.state = to
+	return true
+}
+
+// Close signals the worker to exit, canceling the context passed to the Processor. It is safe to
+// call more than once and before Run, in which case Run returns immediately.
+func (w *Worker) Close() {
+	w.closeOnce.Do(func() {
+		w.mu.Lock()
+		previous := w.state
+		w.state = stateClosed
+		if w.err == nil {
+			w.err = ErrClosed
+		}
+		w.mu.Unlock()
+
+		close(w.done)
+		if previous == stateNew {
+			w.closeEvents()
+			close(w.stopped)
+		}
+	})
+}
+
+// fail closes the worker because of an error it cannot recover from
+func (w *Worker) fail(err error) {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.mu.Unlock()
+	w.logError("worker stopped!", err)
+	w.Close()
+}
+
+// validate checks the config required to run
+func (w *Worker) validate() error {
+	if w.Processor == nil && w.QueueHandlers == nil {
+		return ErrNoProcessor
+	}
+	if w.QueueArn != "" && w.QueueURL == "" {
+		if _, err := QueueURLFromARN(w.QueueArn); err != nil {
+			return err
+		}
+	}
+	if w.QueueURL == "" && w.Discovery == nil {
+		return ErrNoQueue
+	}
+	return nil
+}
+
+// Done returns a channel that is closed once the worker has stopped, after its producer and
+// consumers have exited.
+func (w *Worker) Done() <-chan struct{} {
+	return w.stopped
+}
+
+// Err returns why the worker stopped: ErrClosed when it was closed, a config error such as
+// ErrNoProcessor, or the error that stopped it, such as a QueueDoesNotExist error when its
+// queue was deleted. Err returns nil until the worker is closed.
+func (w *Worker) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// polling tracks the producer so polling can be paused and resumed while the consumers keep running
+type polling struct {
+	mu     sync.Mutex
+	paused bool
+	cancel context.CancelFunc
+	// done is closed when the current producer has returned
+	done   chan struct{}
+	resume chan struct{}
+}
+
+// poll runs the producer until the context is done, restarting it whenever the worker is resumed
+func (w *Worker) poll(ctx context.Context, out chan message) {
+	for {
+		w.polling.mu.Lock()
+		pollCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		w.polling.cancel, w.polling.done = cancel, done
+		paused := w.polling.paused
+		w.polling.mu.Unlock()
+
+		if !paused {
+			w.producer(pollCtx, out)
+		}
+		cancel()
+		close(done)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.polling.resume:
+			w.logInfo("Resuming producer")
+		}
+	}
+}
+
+// pause stops the producer, returning a channel that is closed once it has returned
+func (w *Worker) pause() <-chan struct{} {
+	w.polling.mu.Lock()
+	defer w.polling.mu.Unlock()
+	w.polling.paused = true
+	if w.polling.cancel == nil {
+		// Run has not started, the producer starts paused.
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	w.polling.cancel()
+	return w.polling.done
+}
+
+// waitInFlight blocks until every message received has been processed
+func (w *Worker) waitInFlight(ctx context.Context) error {
+	ticker := time.NewTicker(IdlePollInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&w.stats.inFlight) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Drain stops polling and blocks until the messages already received have been processed. The
+// worker is left paused, with its consumers running, until Resume is called. If the context is
+// done first the context's error is returned and the remaining messages keep being processed.
+func (w *Worker) Drain(ctx context.Context) error {
+	select {
+	case <-w.pause():
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return w.waitInFlight(ctx)
+}
+
+// Resume restarts polling after Drain
+func (w *Worker) Resume() {
+	w.polling.mu.Lock()
+	defer w.polling.mu.Unlock()
+	if !w.polling.paused {
+		return
+	}
+	w.polling.paused = false
+	select {
+	case w.polling.resume <- struct{}{}:
+	default:
+	}
+}
+
+// Paused reports whether polling is paused by Drain
+func (w *Worker) Paused() bool {
+	w.polling.mu.Lock()
+	defer w.polling.mu.Unlock()
+	return w.polling.paused
+}
+
+// Stop stops polling and blocks until the messages already received have been processed and
+// the producer and consumers have exited. If the context is done first, the worker is closed,
+// canceling the context passed to the Processor, and the context's error is returned.
+func (w *Worker) Stop(ctx context.Context) error {
+	err := w.Drain(ctx)
+	w.Close()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-w.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
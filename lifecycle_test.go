@@ -0,0 +1,179 @@
+package sqsworker_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestDrainAndResume(t *testing.T) {
+	queue := GetMockeQueue()
+	handler := &RecordingWorker{Bodies: make(chan string, 10)}
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: handler,
+		Name:      "TestApp",
+	})
+	w.Queue = &PollQueue{queue}
+	go w.Run()
+	defer w.Close()
+
+	queue.Push("before")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := w.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Paused() {
+		t.Error("Expected worker to be paused")
+	}
+	if body := <-handler.Bodies; body != "before" {
+		t.Error("Actual: ", body, "Expected: ", "before")
+	}
+
+	// Nothing is received while paused
+	select {
+	case queue.In <- "during":
+		t.Error("Expected no receives while paused")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	w.Resume()
+	queue.Push("after")
+	if body := <-handler.Bodies; body != "after" {
+		t.Error("Actual: ", body, "Expected: ", "after")
+	}
+}
+
+func TestCloseIdempotent(t *testing.T) {
+	queue := GetMockeQueue()
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+		Name:      "TestApp",
+	})
+	w.Queue = &PollQueue{queue}
+
+	stopped := make(chan bool)
+	go func() {
+		w.Run()
+		close(stopped)
+	}()
+	w.Close()
+	w.Close()
+	<-stopped
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := w.Stop(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCloseBeforeRun(t *testing.T) {
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+		Name:      "TestApp",
+	})
+	w.Queue = &PollQueue{GetMockeQueue()}
+	w.Close()
+
+	// Run returns immediately on a closed worker
+	w.Run()
+	w.Close()
+}
+
+func TestStopBeforeRun(t *testing.T) {
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+		Name:      "TestApp",
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := w.Stop(ctx); err != nil {
+		t.Error(err)
+	}
+	w.Run()
+}
+
+// DeletedQueue fails every receive because the queue does not exist
+type DeletedQueue struct {
+	sqsiface.SQSAPI
+}
+
+func (d *DeletedQueue) ReceiveMessageRequest(input *sqs.ReceiveMessageInput) (*request.Request, *sqs.ReceiveMessageOutput) {
+	err := awserr.New(sqs.ErrCodeQueueDoesNotExist, "The specified queue does not exist", nil)
+	return &request.Request{Error: err}, &sqs.ReceiveMessageOutput{}
+}
+
+func TestDoneQueueDeleted(t *testing.T) {
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+		Name:      "TestApp",
+	})
+	w.Queue = &DeletedQueue{}
+	if err := w.Err(); err != nil {
+		t.Error("Actual: ", err, "Expected: ", nil)
+	}
+	go w.Run()
+
+	select {
+	case <-w.Done():
+	case <-time.After(time.Second):
+		t.Fatal("worker did not stop")
+	}
+	if aerr, ok := w.Err().(awserr.Error); !ok || aerr.Code() != sqs.ErrCodeQueueDoesNotExist {
+		t.Error("Actual: ", w.Err(), "Expected: ", sqs.ErrCodeQueueDoesNotExist)
+	}
+}
+
+func TestDoneConfigError(t *testing.T) {
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL: workerQueueURL,
+		Logger:   zap.NewNop(),
+		Name:     "TestApp",
+	})
+	w.Run()
+	<-w.Done()
+	if w.Err() != sqsworker.ErrNoProcessor {
+		t.Error("Actual: ", w.Err(), "Expected: ", sqsworker.ErrNoProcessor)
+	}
+}
+
+func TestDoneClosed(t *testing.T) {
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+		Name:      "TestApp",
+	})
+	w.Queue = &PollQueue{GetMockeQueue()}
+	go w.Run()
+	w.Close()
+	<-w.Done()
+	if w.Err() != sqsworker.ErrClosed {
+		t.Error("Actual: ", w.Err(), "Expected: ", sqsworker.ErrClosed)
+	}
+}
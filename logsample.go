@@ -0,0 +1,95 @@
+package sqsworker
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// DefaultLogInterval is the period in which LogSampling limits identical errors
+const DefaultLogInterval = time.Minute
+
+// LogSampling limits the errors logged when every message fails the same way, e.g. during an
+// outage of the topic the worker publishes to. Errors with the same message and text are logged
+// Burst times per Interval, and the number of errors suppressed is logged at the end of the
+// Interval.
+type LogSampling struct {
+	Burst    int
+	Interval time.Duration
+}
+
+// logCount counts the occurrences of an error in the current interval
+type logCount struct {
+	msg        string
+	text       string
+	logged     int
+	suppressed int
+}
+
+// logLimiter counts identical errors per interval
+type logLimiter struct {
+	burst    int
+	interval time.Duration
+	// summary is called with the errors suppressed at the end of their interval
+	summary func(msg, text string, suppressed int)
+	mu      sync.Mutex
+	counts  map[string]*logCount
+}
+
+func newLogLimiter(sampling LogSampling, summary func(msg, text string, suppressed int)) *logLimiter {
+	if sampling.Burst < 1 {
+		return nil
+	}
+	if sampling.Interval <= 0 {
+		sampling.Interval = DefaultLogInterval
+	}
+	return &logLimiter{
+		burst:    sampling.Burst,
+		interval: sampling.Interval,
+		summary:  summary,
+		counts:   make(map[string]*logCount),
+	}
+}
+
+// allow reports whether an error is logged, or counted as suppressed
+func (l *logLimiter) allow(msg, text string) bool {
+	key := msg + "\x00" + text
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count, ok := l.counts[key]
+	if !ok {
+		count = &logCount{msg: msg, text: text}
+		l.counts[key] = count
+		time.AfterFunc(l.interval, func() { l.expire(key) })
+	}
+	if count.logged < l.burst {
+		count.logged++
+		return true
+	}
+	count.suppressed++
+	return false
+}
+
+// expire ends the interval of an error, summarizing the errors suppressed in it
+func (l *logLimiter) expire(key string) {
+	l.mu.Lock()
+	count := l.counts[key]
+	delete(l.counts, key)
+	l.mu.Unlock()
+	if count.suppressed > 0 {
+		l.summary(count.msg, count.text, count.suppressed)
+	}
+}
+
+// logSuppressed logs the number of identical errors that were not logged
+func (w *Worker) logSuppressed(msg, text string, suppressed int) {
+	if w.Logger != nil {
+		w.Logger.Warn(fmt.Sprintf("suppressed %d similar errors", suppressed),
+			zap.String("app", w.Name),
+			zap.String("msg", msg),
+			zap.String("error", text),
+			zap.Int("suppressed", suppressed),
+		)
+	}
+}
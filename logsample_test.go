@@ -0,0 +1,58 @@
+package sqsworker_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"testing"
+	"time"
+)
+
+func TestLogSampling(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			if *m.Body == "other" {
+				return nil, errors.New("timeout")
+			}
+			return nil, errors.New("topic unavailable")
+		}),
+		Logger:      zap.New(core),
+		LogSampling: sqsworker.LogSampling{Burst: 2, Interval: 100 * time.Millisecond},
+	})
+
+	for i := 0; i < 5; i++ {
+		h.Run(workertest.NewMessage("hello")).Failed()
+	}
+	h.Run(workertest.NewMessage("other")).Failed()
+	if n := logs.FilterMessage("topic unavailable").Len(); n != 2 {
+		t.Error("Actual: ", n, "Expected: ", 2)
+	}
+	if n := logs.FilterMessage("timeout").Len(); n != 1 {
+		t.Error("Actual: ", n, "Expected: ", 1)
+	}
+
+	deadline := time.After(time.Second)
+	for logs.FilterMessage("suppressed 3 similar errors").Len() == 0 {
+		select {
+		case <-deadline:
+			t.Fatal("Expected a summary of the suppressed errors, got: ", logs.All())
+		case <-time.After(time.Millisecond):
+		}
+	}
+	summary := logs.FilterMessage("suppressed 3 similar errors").All()[0].ContextMap()
+	if summary["error"] != "topic unavailable" || summary["msg"] != "handler failed!" {
+		t.Error("unexpected summary: ", summary)
+	}
+
+	// A new interval logs the error again
+	h.Run(workertest.NewMessage("hello")).Failed()
+	if n := logs.FilterMessage("topic unavailable").Len(); n != 3 {
+		t.Error("Actual: ", n, "Expected: ", 3)
+	}
+}
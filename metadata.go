@@ -0,0 +1,84 @@
+package sqsworker
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"time"
+)
+
+// messageContext carries the message being handled, for the metadata accessors. It is a single
+// allocation per message, where context.WithValue would box the message as well.
+type messageContext struct {
+	context.Context
+	// msg and queueURL are the fields of the message the accessors use, its visibleAt would
+	// grow the allocation
+	msg           *sqs.Message
+	queueURL      string
+	correlationID string
+	codec         Codec
+	// publishes are the results of a FanOut Processor
+	publishes []Publish
+}
+
+// metadataKey is the context key of the message being handled
+type metadataKey struct{}
+
+func (c *messageContext) Value(key interface{}) interface{} {
+	if key == (metadataKey{}) {
+		return c
+	}
+	return c.Context.Value(key)
+}
+
+// withMessage returns a context carrying the message, and the codec of the worker handling it
+func withMessage(ctx context.Context, msg message, correlationID string, codec Codec) context.Context {
+	return &messageContext{Context: ctx, msg: msg.Message, queueURL: msg.queueURL, correlationID: correlationID, codec: codec}
+}
+
+// messageFrom returns the message carried by the context, the zero message when there is none
+func messageFrom(ctx context.Context) message {
+	if c, ok := ctx.Value(metadataKey{}).(*messageContext); ok {
+		return message{Message: c.msg, queueURL: c.queueURL}
+	}
+	return message{Message: &sqs.Message{}}
+}
+
+// CorrelationID returns the correlation ID of the message being processed, when the worker has
+// a CorrelationAttr
+func CorrelationID(ctx context.Context) string {
+	if c, ok := ctx.Value(metadataKey{}).(*messageContext); ok {
+		return c.correlationID
+	}
+	return ""
+}
+
+// MessageID returns the id of the message being processed, from the context passed to the
+// Processor. The accessors return zero values for contexts not created by a Worker.
+func MessageID(ctx context.Context) string {
+	return aws.StringValue(messageFrom(ctx).MessageId)
+}
+
+// ReceiveCount returns the ApproximateReceiveCount of the message being processed
+func ReceiveCount(ctx context.Context) int {
+	return int(attributeInt(messageFrom(ctx).Attributes, sqs.MessageSystemAttributeNameApproximateReceiveCount))
+}
+
+// SentTimestamp returns when the message being processed was sent
+func SentTimestamp(ctx context.Context) time.Time {
+	sent := attributeInt(messageFrom(ctx).Attributes, sqs.MessageSystemAttributeNameSentTimestamp)
+	if sent == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, sent*int64(time.Millisecond))
+}
+
+// SourceQueue returns the url of the queue the message being processed was received from
+func SourceQueue(ctx context.Context) string {
+	return messageFrom(ctx).queueURL
+}
+
+// TraceHeader returns the AWS X-Ray trace header the message being processed was sent with
+func TraceHeader(ctx context.Context) string {
+	return aws.StringValue(messageFrom(ctx).Attributes[sqs.MessageSystemAttributeNameAwstraceHeader])
+}
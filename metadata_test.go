@@ -0,0 +1,45 @@
+package sqsworker_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"testing"
+	"time"
+)
+
+func TestMetadata(t *testing.T) {
+	var id, queue, trace string
+	var receives int
+	var sent time.Time
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			id, queue, trace = sqsworker.MessageID(ctx), sqsworker.SourceQueue(ctx), sqsworker.TraceHeader(ctx)
+			receives, sent = sqsworker.ReceiveCount(ctx), sqsworker.SentTimestamp(ctx)
+			return nil, nil
+		}),
+	})
+
+	m := workertest.NewMessage("hello",
+		workertest.SystemAttribute("SentTimestamp", "1500000000000"),
+		workertest.SystemAttribute("AWSTraceHeader", "Root=1-5759e988-bd862e3fe1be46a994272793"),
+		workertest.SystemAttribute("ApproximateReceiveCount", "3"),
+	)
+	h.Run(m).Succeeded()
+	if id != *m.MessageId || queue != workertest.QueueURL || receives != 3 {
+		t.Error("unexpected metadata: ", id, queue, receives)
+	}
+	if trace != "Root=1-5759e988-bd862e3fe1be46a994272793" {
+		t.Error("Actual: ", trace, "Expected: ", "Root=1-5759e988-bd862e3fe1be46a994272793")
+	}
+	if expected := time.Unix(1500000000, 0); !sent.Equal(expected) {
+		t.Error("Actual: ", sent, "Expected: ", expected)
+	}
+
+	// Contexts not created by a worker have no metadata
+	if sqsworker.MessageID(context.Background()) != "" || !sqsworker.SentTimestamp(context.Background()).IsZero() {
+		t.Error("Expected no metadata")
+	}
+}
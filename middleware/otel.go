@@ -0,0 +1,201 @@
+package middleware
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"strconv"
+	"time"
+)
+
+// MeterName is the instrumentation name of the meter recording a worker's metrics
+const MeterName = "github.com/ajbeach2/sqsworker"
+
+// Outcomes of handled messages, recorded as the outcome attribute of the messages counter
+const (
+	OutcomePublished = "published"
+	OutcomeProcessed = "processed"
+	OutcomeFailed    = "failed"
+	OutcomeFiltered  = "filtered"
+	OutcomeExpired   = "expired"
+	OutcomeDuplicate = "duplicate"
+)
+
+// otelMetrics holds the instruments recording the results of a worker
+type otelMetrics struct {
+	// name is the worker attribute of every measurement, worker the option setting it, and
+	// outcomes the options setting it with an outcome attribute
+	name     attribute.KeyValue
+	worker   metric.MeasurementOption
+	outcomes map[string]metric.AddOption
+	messages metric.Int64Counter
+	handler  metric.Float64Histogram
+	endToEnd metric.Float64Histogram
+}
+
+// OTelMetrics records the messages handled by the worker as OpenTelemetry metrics, with meters
+// from the provider:
+//
+//	sqsworker.messages            counter of handled messages, by outcome and error_class
+//	sqsworker.handler.duration    histogram of the Processor's duration in seconds
+//	sqsworker.end_to_end.duration histogram of the seconds from sending to handling a message
+//	sqsworker.in_flight           gauge of the messages received and not yet handled
+//	sqsworker.api.requests        counter of the requests made to SQS and SNS, by api
+//	sqsworker.api.cost            counter of the estimated cost of the requests in USD
+//
+// Every measurement has the worker's Name as its worker attribute. Results named by the worker's
+// HandlerName also have a handler attribute. The worker's Callback is wrapped to record each
+// result, so OTelMetrics must be called before the worker is run.
+func OTelMetrics(w *sqsworker.Worker, provider metric.MeterProvider) error {
+	meter := provider.Meter(MeterName)
+	worker := attribute.String("worker", w.Name)
+	m := &otelMetrics{
+		name:     worker,
+		worker:   metric.WithAttributeSet(attribute.NewSet(worker)),
+		outcomes: make(map[string]metric.AddOption),
+	}
+	for _, outcome := range []string{OutcomePublished, OutcomeProcessed, OutcomeFailed, OutcomeFiltered, OutcomeExpired, OutcomeDuplicate} {
+		m.outcomes[outcome] = metric.WithAttributeSet(attribute.NewSet(worker, attribute.String("outcome", outcome)))
+	}
+
+	var err error
+	if m.messages, err = meter.Int64Counter("sqsworker.messages",
+		metric.WithDescription("Messages handled by outcome"),
+		metric.WithUnit("{message}"),
+	); err != nil {
+		return err
+	}
+	if m.handler, err = meter.Float64Histogram("sqsworker.handler.duration",
+		metric.WithDescription("Duration of the Processor"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(latencyBounds()...),
+	); err != nil {
+		return err
+	}
+	if m.endToEnd, err = meter.Float64Histogram("sqsworker.end_to_end.duration",
+		metric.WithDescription("Time from sending a message to handling it"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(latencyBounds()...),
+	); err != nil {
+		return err
+	}
+	apis := make(map[string]metric.ObserveOption)
+	for _, api := range []string{"ReceiveMessage", "DeleteMessage", "ChangeMessageVisibility", "SendMessage", "GetQueueAttributes", "ListQueues", "Publish", "PublishBatch"} {
+		apis[api] = metric.WithAttributeSet(attribute.NewSet(worker, attribute.String("api", api)))
+	}
+	if _, err = meter.Int64ObservableCounter("sqsworker.api.requests",
+		metric.WithDescription("Requests made to SQS and SNS by API"),
+		metric.WithUnit("{request}"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			usage := w.Stats().API
+			o.Observe(usage.Receives, apis["ReceiveMessage"])
+			o.Observe(usage.Deletes, apis["DeleteMessage"])
+			o.Observe(usage.VisibilityChanges, apis["ChangeMessageVisibility"])
+			o.Observe(usage.Sends, apis["SendMessage"])
+			o.Observe(usage.QueueAttributes, apis["GetQueueAttributes"])
+			o.Observe(usage.QueueLists, apis["ListQueues"])
+			o.Observe(usage.Publishes, apis["Publish"])
+			o.Observe(usage.PublishBatches, apis["PublishBatch"])
+			return nil
+		}),
+	); err != nil {
+		return err
+	}
+	if _, err = meter.Float64ObservableCounter("sqsworker.api.cost",
+		metric.WithDescription("Estimated cost of the requests made to SQS and SNS"),
+		metric.WithUnit("USD"),
+		metric.WithFloat64Callback(func(ctx context.Context, o metric.Float64Observer) error {
+			o.Observe(w.Stats().API.Cost(), m.worker)
+			return nil
+		}),
+	); err != nil {
+		return err
+	}
+	if _, err = meter.Int64ObservableGauge("sqsworker.in_flight",
+		metric.WithDescription("Messages received and not yet handled"),
+		metric.WithUnit("{message}"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			o.Observe(w.Stats().InFlight, m.worker)
+			return nil
+		}),
+	); err != nil {
+		return err
+	}
+
+	callback := w.Callback
+	w.Callback = func(r sqsworker.Result) {
+		m.record(r)
+		if callback != nil {
+			callback(r)
+		}
+	}
+	return nil
+}
+
+// record adds a result to the metrics
+func (m *otelMetrics) record(r sqsworker.Result) {
+	ctx := context.Background()
+	outcome := OutcomeProcessed
+	switch {
+	case r.Filtered:
+		outcome = OutcomeFiltered
+	case r.Expired:
+		outcome = OutcomeExpired
+	case r.Deduplicated:
+		outcome = OutcomeDuplicate
+	case r.Err != nil:
+		outcome = OutcomeFailed
+	case r.Published:
+		outcome = OutcomePublished
+	}
+	// results named by the worker's HandlerName are broken down by handler, and failures by
+	// the class of their error
+	worker, messages := m.worker, m.outcomes[outcome]
+	if r.Handler != "" || outcome == OutcomeFailed {
+		attrs := []attribute.KeyValue{m.name, attribute.String("outcome", outcome)}
+		if r.Handler != "" {
+			handler := attribute.String("handler", r.Handler)
+			worker = metric.WithAttributeSet(attribute.NewSet(m.name, handler))
+			attrs = append(attrs, handler)
+		}
+		if outcome == OutcomeFailed {
+			attrs = append(attrs, attribute.String("error_class", sqsworker.ClassifyFailure(r.Err).String()))
+		}
+		messages = metric.WithAttributeSet(attribute.NewSet(attrs...))
+	}
+	m.messages.Add(ctx, 1, messages)
+
+	if r.Duration > 0 {
+		m.handler.Record(ctx, r.Duration.Seconds(), worker)
+	}
+	if sent, ok := sentTime(r.Message); ok {
+		m.endToEnd.Record(ctx, time.Since(sent).Seconds(), worker)
+	}
+}
+
+// sentTime returns when a message was sent, from its SentTimestamp attribute
+func sentTime(m *sqs.Message) (time.Time, bool) {
+	if m == nil {
+		return time.Time{}, false
+	}
+	value, ok := m.Attributes[sqs.MessageSystemAttributeNameSentTimestamp]
+	if !ok || value == nil {
+		return time.Time{}, false
+	}
+	ms, err := strconv.ParseInt(*value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), true
+}
+
+// latencyBounds returns the worker's LatencyBuckets in seconds
+func latencyBounds() []float64 {
+	bounds := make([]float64, len(sqsworker.LatencyBuckets))
+	for i, bound := range sqsworker.LatencyBuckets {
+		bounds[i] = bound.Seconds()
+	}
+	return bounds
+}
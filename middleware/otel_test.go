@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMeter records the measurements of its instruments by instrument name and attributes
+type testMeter struct {
+	noop.Meter
+	mu       sync.Mutex
+	counts   map[string]int64
+	records  map[string][]float64
+	observed map[string]metric.Int64Callback
+}
+
+func newTestMeter() *testMeter {
+	return &testMeter{
+		counts:   make(map[string]int64),
+		records:  make(map[string][]float64),
+		observed: make(map[string]metric.Int64Callback),
+	}
+}
+
+func measurement(name string, attrs attribute.Set) string {
+	return name + "{" + attrs.Encoded(attribute.DefaultEncoder()) + "}"
+}
+
+type testProvider struct {
+	noop.MeterProvider
+	meter *testMeter
+}
+
+func (p testProvider) Meter(string, ...metric.MeterOption) metric.Meter {
+	return p.meter
+}
+
+type testCounter struct {
+	noop.Int64Counter
+	meter *testMeter
+	name  string
+}
+
+func (c testCounter) Add(ctx context.Context, incr int64, opts ...metric.AddOption) {
+	c.meter.mu.Lock()
+	defer c.meter.mu.Unlock()
+	c.meter.counts[measurement(c.name, metric.NewAddConfig(opts).Attributes())] += incr
+}
+
+type testHistogram struct {
+	noop.Float64Histogram
+	meter *testMeter
+	name  string
+}
+
+func (h testHistogram) Record(ctx context.Context, value float64, opts ...metric.RecordOption) {
+	h.meter.mu.Lock()
+	defer h.meter.mu.Unlock()
+	key := measurement(h.name, metric.NewRecordConfig(opts).Attributes())
+	h.meter.records[key] = append(h.meter.records[key], value)
+}
+
+type testObserver struct {
+	noop.Int64Observer
+	values map[string]int64
+	name   string
+}
+
+func (o testObserver) Observe(value int64, opts ...metric.ObserveOption) {
+	o.values[measurement(o.name, metric.NewObserveConfig(opts).Attributes())] = value
+}
+
+func (m *testMeter) Int64Counter(name string, opts ...metric.Int64CounterOption) (metric.Int64Counter, error) {
+	return testCounter{meter: m, name: name}, nil
+}
+
+func (m *testMeter) Float64Histogram(name string, opts ...metric.Float64HistogramOption) (metric.Float64Histogram, error) {
+	return testHistogram{meter: m, name: name}, nil
+}
+
+func (m *testMeter) Int64ObservableGauge(name string, opts ...metric.Int64ObservableGaugeOption) (metric.Int64ObservableGauge, error) {
+	m.observed[name] = metric.NewInt64ObservableGaugeConfig(opts...).Callbacks()[0]
+	return noop.Int64ObservableGauge{}, nil
+}
+
+func (m *testMeter) Int64ObservableCounter(name string, opts ...metric.Int64ObservableCounterOption) (metric.Int64ObservableCounter, error) {
+	m.observed[name] = metric.NewInt64ObservableCounterConfig(opts...).Callbacks()[0]
+	return noop.Int64ObservableCounter{}, nil
+}
+
+func TestOTelMetrics(t *testing.T) {
+	meter := newTestMeter()
+	var results int
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Name:     "orders",
+		TopicArn: "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			if *m.Body == "fail" {
+				return nil, errors.New("failed")
+			}
+			return &sns.PublishInput{Message: m.Body}, nil
+		}),
+		Callback: func(sqsworker.Result) { results++ },
+	})
+	if err := OTelMetrics(h.Worker, testProvider{meter: meter}); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := fmt.Sprint(time.Now().Add(-time.Second).UnixNano() / int64(time.Millisecond))
+	h.Run(workertest.NewMessage("hello", workertest.SystemAttribute(sqs.MessageSystemAttributeNameSentTimestamp, sent))).Succeeded()
+	h.Run(workertest.NewMessage("hello")).Succeeded()
+	h.Run(workertest.NewMessage("fail")).Failed()
+	if results != 3 {
+		t.Error("Expected the worker's Callback to be called, got: ", results)
+	}
+
+	published := measurement("sqsworker.messages", attribute.NewSet(attribute.String("outcome", OutcomePublished), attribute.String("worker", "orders")))
+	failed := measurement("sqsworker.messages", attribute.NewSet(attribute.String("error_class", "other"), attribute.String("outcome", OutcomeFailed), attribute.String("worker", "orders")))
+	if meter.counts[published] != 2 || meter.counts[failed] != 1 {
+		t.Error("unexpected counts: ", meter.counts)
+	}
+
+	worker := attribute.NewSet(attribute.String("worker", "orders"))
+	if handler := meter.records[measurement("sqsworker.handler.duration", worker)]; len(handler) != 3 {
+		t.Error("unexpected handler durations: ", meter.records)
+	}
+	if endToEnd := meter.records[measurement("sqsworker.end_to_end.duration", worker)]; len(endToEnd) != 1 || endToEnd[0] < 1 {
+		t.Error("unexpected end to end latencies: ", meter.records)
+	}
+
+	// Results named by the HandlerName are broken down by handler
+	h.Worker.HandlerName = func(m *sqs.Message) string { return "orders" }
+	h.Run(workertest.NewMessage("hello")).Succeeded()
+	handler := measurement("sqsworker.messages", attribute.NewSet(attribute.String("handler", "orders"), attribute.String("outcome", OutcomePublished), attribute.String("worker", "orders")))
+	if meter.counts[handler] != 1 || meter.counts[published] != 2 {
+		t.Error("unexpected counts: ", meter.counts)
+	}
+
+	observer := testObserver{values: make(map[string]int64), name: "sqsworker.in_flight"}
+	meter.observed["sqsworker.in_flight"](context.Background(), observer)
+	if value, ok := observer.values[measurement("sqsworker.in_flight", worker)]; !ok || value != 0 {
+		t.Error("unexpected in flight messages: ", observer.values)
+	}
+
+	observer = testObserver{values: make(map[string]int64), name: "sqsworker.api.requests"}
+	meter.observed["sqsworker.api.requests"](context.Background(), observer)
+	publishes := measurement("sqsworker.api.requests", attribute.NewSet(attribute.String("api", "Publish"), attribute.String("worker", "orders")))
+	deletes := measurement("sqsworker.api.requests", attribute.NewSet(attribute.String("api", "DeleteMessage"), attribute.String("worker", "orders")))
+	if observer.values[publishes] != 3 || observer.values[deletes] != 3 {
+		t.Error("unexpected requests: ", observer.values)
+	}
+}
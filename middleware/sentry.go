@@ -1,11 +1,13 @@
 package middleware
 
 import (
+	"github.com/ajbeach2/sqsworker"
 	"github.com/getsentry/sentry-go"
 )
 
-func SentryHandler(msg *string, err error) {
-	if err != nil {
-		sentry.CaptureException(err)
+// SentryHandler is a sqsworker.Callback that reports errors to sentry
+func SentryHandler(r sqsworker.Result) {
+	if r.Err != nil {
+		sentry.CaptureException(r.Err)
 	}
 }
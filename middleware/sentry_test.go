@@ -2,10 +2,10 @@ package middleware
 
 import (
 	"fmt"
+	"github.com/ajbeach2/sqsworker"
 	"testing"
 )
 
 func TestError(t *testing.T) {
-	message := "test"
-	SentryHandler(&message, fmt.Errorf("This is an error"))
+	SentryHandler(sqsworker.Result{Err: fmt.Errorf("This is an error")})
 }
@@ -0,0 +1,291 @@
+// Package nats provides a NATS JetStream sqsworker.Backend consuming pull consumers, so edge
+// deployments running NATS get the same Processors and middleware as on SQS. The QueueURLs of
+// the worker name its Consumers, and messages are sent to subjects:
+//
+//	js, err := jetstream.New(nc)
+//	if err != nil {
+//		return err
+//	}
+//	consumer, err := js.Consumer(ctx, "ORDERS", "orders-worker")
+//	if err != nil {
+//		return err
+//	}
+//	w := sqsworker.NewWorker(nil, sqsworker.WorkerConfig{
+//		Backend:   nats.New(map[string]nats.Consumer{"orders": consumer}, js),
+//		QueueURL:  "orders",
+//		Processor: processor,
+//		Sink:      nats.NewSubject(js, "orders.processed"),
+//	})
+//
+// Messages are acknowledged explicitly when they are deleted. A message that is not deleted
+// within the worker's VisibilityTimeout is nacked and redelivered, so the AckWait of the
+// consumer should be longer than the VisibilityTimeout. Changing the visibility of a message
+// nacks it with the timeout as delay, which is how the worker's deferrals and retries are
+// scheduled on the server.
+package nats
+
+import (
+	"context"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SubjectAttribute is the message attribute holding the subject of a message
+const SubjectAttribute = "nats.subject"
+
+// ErrDelay is returned for messages sent with a delay, which JetStream does not support
+var ErrDelay = errors.New("nats: messages cannot be delayed")
+
+// ErrNotReceived is returned for a receipt handle of a message that is no longer received,
+// because it was acknowledged or its visibility timeout passed
+var ErrNotReceived = errors.New("nats: the message is not received")
+
+// Consumer is the part of a jetstream.Consumer the Backend uses
+type Consumer interface {
+	Fetch(batch int, opts ...jetstream.FetchOpt) (jetstream.MessageBatch, error)
+	FetchNoWait(batch int) (jetstream.MessageBatch, error)
+	Info(ctx context.Context) (*jetstream.ConsumerInfo, error)
+}
+
+// Publisher is the part of a jetstream.JetStream the Backend and Subject use
+type Publisher interface {
+	PublishMsg(ctx context.Context, msg *natsgo.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error)
+}
+
+// Backend is a sqsworker.Backend fetching messages from its Consumers by name, and publishing
+// messages with the Publisher
+type Backend struct {
+	Consumers map[string]Consumer
+	Publisher Publisher
+	mu        sync.Mutex
+	leases    map[string]*lease
+}
+
+// lease holds a received message until it is acknowledged or its visibility timeout passes
+type lease struct {
+	msg   jetstream.Msg
+	timer *time.Timer
+}
+
+// New creates a Backend for the consumers by name, publishing with the publisher
+func New(consumers map[string]Consumer, publisher Publisher) *Backend {
+	return &Backend{Consumers: consumers, Publisher: publisher, leases: make(map[string]*lease)}
+}
+
+func (b *Backend) consumer(queue string) (Consumer, error) {
+	consumer, ok := b.Consumers[queue]
+	if !ok {
+		return nil, awserr.New(sqs.ErrCodeQueueDoesNotExist, "nats: no consumer named "+queue, nil)
+	}
+	return consumer, nil
+}
+
+// Receive fetches up to max messages, waiting up to wait for them. A consumer that was deleted
+// fails with the QueueDoesNotExist error of SQS, so the worker stops.
+func (b *Backend) Receive(ctx context.Context, queue string, max int64, wait, visibility time.Duration) ([]*sqs.Message, error) {
+	consumer, err := b.consumer(queue)
+	if err != nil {
+		return nil, err
+	}
+	var batch jetstream.MessageBatch
+	if wait > 0 {
+		batch, err = consumer.Fetch(int(max), jetstream.FetchMaxWait(wait))
+	} else {
+		batch, err = consumer.FetchNoWait(int(max))
+	}
+	if err != nil {
+		return nil, fetchError(err)
+	}
+
+	var messages []*sqs.Message
+	for {
+		select {
+		case msg, ok := <-batch.Messages():
+			if !ok {
+				if err := batch.Error(); err != nil && !errors.Is(err, jetstream.ErrNoMessages) {
+					return messages, fetchError(err)
+				}
+				return messages, nil
+			}
+			m, err := message(msg)
+			if err != nil {
+				msg.Nak()
+				continue
+			}
+			b.hold(msg, *m.ReceiptHandle, visibility)
+			messages = append(messages, m)
+		case <-ctx.Done():
+			return messages, ctx.Err()
+		}
+	}
+}
+
+// fetchError converts the errors of deleted consumers to the QueueDoesNotExist error of SQS
+func fetchError(err error) error {
+	if errors.Is(err, jetstream.ErrConsumerNotFound) || errors.Is(err, jetstream.ErrConsumerDeleted) {
+		return awserr.New(sqs.ErrCodeQueueDoesNotExist, err.Error(), err)
+	}
+	return err
+}
+
+// hold leases a message for the visibility timeout
+func (b *Backend) hold(msg jetstream.Msg, receiptHandle string, visibility time.Duration) {
+	l := &lease{msg: msg}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.leases[receiptHandle] = l
+	if visibility > 0 {
+		l.timer = time.AfterFunc(visibility, func() {
+			if l := b.release(receiptHandle); l != nil {
+				l.msg.Nak()
+			}
+		})
+	}
+}
+
+// release ends the lease of a message, returning it, or nil when it has already ended
+func (b *Backend) release(receiptHandle string) *lease {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l, ok := b.leases[receiptHandle]
+	if !ok {
+		return nil
+	}
+	delete(b.leases, receiptHandle)
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	return l
+}
+
+// message converts a JetStream message, its reply subject is its ReceiptHandle and its
+// headers are the message attributes
+func message(msg jetstream.Msg) (*sqs.Message, error) {
+	meta, err := msg.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	id := msg.Headers().Get(jetstream.MsgIDHeader)
+	if id == "" {
+		id = meta.Stream + "/" + strconv.FormatUint(meta.Sequence.Stream, 10)
+	}
+	m := &sqs.Message{
+		MessageId:     aws.String(id),
+		ReceiptHandle: aws.String(msg.Reply()),
+		Body:          aws.String(string(msg.Data())),
+		Attributes: map[string]*string{
+			sqs.MessageSystemAttributeNameSentTimestamp:           aws.String(strconv.FormatInt(meta.Timestamp.UnixNano()/int64(time.Millisecond), 10)),
+			sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String(strconv.FormatUint(meta.NumDelivered, 10)),
+		},
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			SubjectAttribute: {DataType: aws.String("String"), StringValue: aws.String(msg.Subject())},
+		},
+	}
+	for name, values := range msg.Headers() {
+		if name == jetstream.MsgIDHeader || len(values) == 0 {
+			continue
+		}
+		m.MessageAttributes[name] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(values[0])}
+	}
+	return m, nil
+}
+
+// Delete acknowledges the message
+func (b *Backend) Delete(ctx context.Context, queue, receiptHandle string) error {
+	l := b.release(receiptHandle)
+	if l == nil {
+		return ErrNotReceived
+	}
+	return l.msg.Ack()
+}
+
+// ChangeVisibility nacks the message, to be redelivered once the timeout has passed
+func (b *Backend) ChangeVisibility(ctx context.Context, queue, receiptHandle string, timeout time.Duration) error {
+	l := b.release(receiptHandle)
+	if l == nil {
+		return ErrNotReceived
+	}
+	if timeout == 0 {
+		return l.msg.Nak()
+	}
+	return l.msg.NakWithDelay(timeout)
+}
+
+// Send publishes the message to the subject, with its MessageDeduplicationId as the
+// Nats-Msg-Id deduplicating it in the stream, and its message attributes as headers. Its
+// MessageId is the stream and sequence it was stored at.
+func (b *Backend) Send(ctx context.Context, subject string, input *sqs.SendMessageInput) (string, error) {
+	if aws.Int64Value(input.DelaySeconds) > 0 {
+		return "", ErrDelay
+	}
+	msg := natsgo.NewMsg(subject)
+	msg.Data = []byte(aws.StringValue(input.MessageBody))
+	for name, value := range input.MessageAttributes {
+		msg.Header.Set(name, attribute(value.StringValue, value.BinaryValue))
+	}
+	if input.MessageDeduplicationId != nil {
+		msg.Header.Set(jetstream.MsgIDHeader, *input.MessageDeduplicationId)
+	}
+	ack, err := b.Publisher.PublishMsg(ctx, msg)
+	if err != nil {
+		return "", err
+	}
+	return ack.Stream + "/" + strconv.FormatUint(ack.Sequence, 10), nil
+}
+
+// attribute returns the string value of a message attribute
+func attribute(value *string, binary []byte) string {
+	if value != nil {
+		return *value
+	}
+	return string(binary)
+}
+
+// Depth returns the messages of the consumer not delivered yet as visible, and those waiting
+// for an ack as not visible
+func (b *Backend) Depth(ctx context.Context, queue string) (sqsworker.QueueDepth, error) {
+	consumer, err := b.consumer(queue)
+	if err != nil {
+		return sqsworker.QueueDepth{}, err
+	}
+	info, err := consumer.Info(ctx)
+	if err != nil {
+		return sqsworker.QueueDepth{}, err
+	}
+	return sqsworker.QueueDepth{Visible: int64(info.NumPending), NotVisible: int64(info.NumAckPending), Updated: time.Now()}, nil
+}
+
+// Subject is a sqsworker.Sink publishing results to a subject of a stream, with their
+// message attributes as headers
+type Subject struct {
+	Publisher Publisher
+	Name      string
+}
+
+// NewSubject creates a Sink publishing to the subject
+func NewSubject(publisher Publisher, name string) *Subject {
+	return &Subject{Publisher: publisher, Name: name}
+}
+
+// Send publishes the result and waits for the stream to store it
+func (s *Subject) Send(ctx context.Context, m *sqs.Message, output *sns.PublishInput) error {
+	msg := natsgo.NewMsg(s.Name)
+	msg.Data = []byte(aws.StringValue(output.Message))
+	for name, value := range output.MessageAttributes {
+		msg.Header.Set(name, attribute(value.StringValue, value.BinaryValue))
+	}
+	if output.MessageDeduplicationId != nil {
+		msg.Header.Set(jetstream.MsgIDHeader, *output.MessageDeduplicationId)
+	}
+	_, err := s.Publisher.PublishMsg(ctx, msg)
+	return err
+}
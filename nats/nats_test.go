@@ -0,0 +1,239 @@
+package nats_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/nats"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Msg is a message of the stream "ORDERS", recording its acks to the consumer
+type Msg struct {
+	consumer  *Consumer
+	seq       uint64
+	delivered uint64
+	data      string
+	headers   natsgo.Header
+}
+
+func (m *Msg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{
+		Sequence:     jetstream.SequencePair{Stream: m.seq},
+		NumDelivered: m.delivered,
+		Timestamp:    time.Unix(1, 0),
+		Stream:       "ORDERS",
+	}, nil
+}
+
+func (m *Msg) Data() []byte                        { return []byte(m.data) }
+func (m *Msg) Headers() natsgo.Header              { return m.headers }
+func (m *Msg) Subject() string                     { return "orders.created" }
+func (m *Msg) Reply() string                       { return fmt.Sprintf("$JS.ACK.ORDERS.worker.%d.%d", m.delivered, m.seq) }
+func (m *Msg) Ack() error                          { m.consumer.Acks <- fmt.Sprintf("ack %d", m.seq); return nil }
+func (m *Msg) DoubleAck(ctx context.Context) error { return m.Ack() }
+func (m *Msg) Nak() error                          { return m.NakWithDelay(0) }
+func (m *Msg) InProgress() error                   { return nil }
+func (m *Msg) Term() error                         { return nil }
+func (m *Msg) TermWithReason(reason string) error  { return nil }
+
+func (m *Msg) NakWithDelay(delay time.Duration) error {
+	m.consumer.Acks <- fmt.Sprintf("nak %d %v", m.seq, delay)
+	return nil
+}
+
+// Batch is a fetched MessageBatch
+type Batch struct {
+	messages chan jetstream.Msg
+	err      error
+}
+
+func (b *Batch) Messages() <-chan jetstream.Msg { return b.messages }
+func (b *Batch) Error() error                   { return b.err }
+
+// Consumer delivers the messages pushed to it, recording their acks
+type Consumer struct {
+	mu      sync.Mutex
+	pending chan *Msg
+	seq     uint64
+	Err     error
+	Acks    chan string
+}
+
+func NewConsumer() *Consumer {
+	return &Consumer{pending: make(chan *Msg, 10), Acks: make(chan string, 10)}
+}
+
+func (c *Consumer) Push(data string, headers natsgo.Header) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	c.pending <- &Msg{consumer: c, seq: c.seq, delivered: 1, data: data, headers: headers}
+}
+
+// Fetch waits briefly for a first message, then returns those pending
+func (c *Consumer) Fetch(n int, opts ...jetstream.FetchOpt) (jetstream.MessageBatch, error) {
+	batch := &Batch{messages: make(chan jetstream.Msg, n), err: jetstream.ErrNoMessages}
+	select {
+	case m := <-c.pending:
+		batch.messages <- m
+		batch.err = nil
+	case <-time.After(20 * time.Millisecond):
+	}
+	return c.drain(batch, n)
+}
+
+func (c *Consumer) FetchNoWait(n int) (jetstream.MessageBatch, error) {
+	return c.drain(&Batch{messages: make(chan jetstream.Msg, n), err: jetstream.ErrNoMessages}, n)
+}
+
+func (c *Consumer) drain(batch *Batch, n int) (jetstream.MessageBatch, error) {
+	for len(batch.messages) < n && len(c.pending) > 0 {
+		batch.messages <- <-c.pending
+		batch.err = nil
+	}
+	if c.Err != nil {
+		batch.err = c.Err
+	}
+	close(batch.messages)
+	return batch, nil
+}
+
+func (c *Consumer) Info(ctx context.Context) (*jetstream.ConsumerInfo, error) {
+	return &jetstream.ConsumerInfo{NumPending: uint64(len(c.pending)), NumAckPending: 1}, nil
+}
+
+// Publisher records the messages published
+type Publisher struct {
+	mu        sync.Mutex
+	Published []*natsgo.Msg
+}
+
+func (p *Publisher) PublishMsg(ctx context.Context, msg *natsgo.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Published = append(p.Published, msg)
+	return &jetstream.PubAck{Stream: "ORDERS", Sequence: uint64(len(p.Published))}, nil
+}
+
+func TestWorker(t *testing.T) {
+	consumer := NewConsumer()
+	consumer.Push("Hello", nil)
+	consumer.Push("World", nil)
+	publisher := &Publisher{}
+
+	w := sqsworker.NewWorker(nil, sqsworker.WorkerConfig{
+		Backend:  nats.New(map[string]nats.Consumer{"orders": consumer}, publisher),
+		QueueURL: "orders",
+		Workers:  2,
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			return &sns.PublishInput{Message: aws.String(strings.ToLower(*m.Body))}, nil
+		}),
+		Sink:   nats.NewSubject(publisher, "orders.processed"),
+		Logger: zap.NewNop(),
+	})
+	go w.Run()
+	defer w.Close()
+
+	acked := map[string]bool{}
+	for len(acked) < 2 {
+		select {
+		case ack := <-consumer.Acks:
+			acked[ack] = true
+		case <-time.After(time.Second):
+			t.Fatal("messages were not acknowledged")
+		}
+	}
+	if !acked["ack 1"] || !acked["ack 2"] {
+		t.Errorf("unexpected acks %v", acked)
+	}
+
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	if len(publisher.Published) != 2 {
+		t.Fatalf("unexpected publishes %v", publisher.Published)
+	}
+	for _, p := range publisher.Published {
+		if p.Subject != "orders.processed" || string(p.Data) != "hello" && string(p.Data) != "world" {
+			t.Errorf("unexpected publish %+v", p)
+		}
+	}
+}
+
+func TestVisibility(t *testing.T) {
+	consumer := NewConsumer()
+	publisher := &Publisher{}
+	backend := nats.New(map[string]nats.Consumer{"orders": consumer}, publisher)
+	ctx := context.Background()
+
+	consumer.Push("Hello", natsgo.Header{jetstream.MsgIDHeader: {"order-1"}, "Tenant": {"acme"}})
+	messages, err := backend.Receive(ctx, "orders", 10, time.Second, 20*time.Millisecond)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("unexpected receive %v %v", messages, err)
+	}
+	m := messages[0]
+	if aws.StringValue(m.MessageId) != "order-1" || aws.StringValue(m.MessageAttributes["Tenant"].StringValue) != "acme" ||
+		aws.StringValue(m.MessageAttributes[nats.SubjectAttribute].StringValue) != "orders.created" ||
+		aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]) != "1" {
+		t.Errorf("unexpected message %v", m)
+	}
+
+	// the message is nacked once its visibility timeout passes
+	select {
+	case ack := <-consumer.Acks:
+		if ack != "nak 1 0s" {
+			t.Errorf("unexpected ack %s", ack)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the message was not nacked")
+	}
+	if err := backend.Delete(ctx, "orders", *m.ReceiptHandle); err != nats.ErrNotReceived {
+		t.Errorf("expected the lease to be expired, got %v", err)
+	}
+
+	// changing the visibility nacks the message with a delay
+	consumer.Push("World", nil)
+	messages, _ = backend.Receive(ctx, "orders", 10, 0, time.Minute)
+	if len(messages) != 1 || aws.StringValue(messages[0].MessageId) != "ORDERS/2" {
+		t.Fatalf("unexpected receive %v", messages)
+	}
+	if err := backend.ChangeVisibility(ctx, "orders", *messages[0].ReceiptHandle, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if ack := <-consumer.Acks; ack != "nak 2 5s" {
+		t.Errorf("unexpected ack %s", ack)
+	}
+
+	if depth, err := backend.Depth(ctx, "orders"); err != nil || depth.Visible != 0 || depth.NotVisible != 1 {
+		t.Errorf("unexpected depth %v %v", depth, err)
+	}
+
+	// messages are sent deduplicated by their MessageDeduplicationId
+	id, err := backend.Send(ctx, "orders.created", &sqs.SendMessageInput{MessageBody: aws.String("Again"), MessageDeduplicationId: aws.String("order-3")})
+	if err != nil || id != "ORDERS/1" || publisher.Published[0].Header.Get(jetstream.MsgIDHeader) != "order-3" {
+		t.Errorf("unexpected send %s %v", id, err)
+	}
+	if _, err := backend.Send(ctx, "orders.created", &sqs.SendMessageInput{MessageBody: aws.String("Later"), DelaySeconds: aws.Int64(1)}); err != nats.ErrDelay {
+		t.Errorf("expected a delay to fail, got %v", err)
+	}
+
+	_, err = backend.Receive(ctx, "deleted", 10, 0, 0)
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != sqs.ErrCodeQueueDoesNotExist {
+		t.Errorf("expected a missing consumer to fail, got %v", err)
+	}
+	consumer.Err = jetstream.ErrConsumerDeleted
+	_, err = backend.Receive(ctx, "orders", 10, 0, 0)
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != sqs.ErrCodeQueueDoesNotExist {
+		t.Errorf("expected a deleted consumer to fail, got %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+package sqsworker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultRelayInterval is how often a Relay checks an empty outbox for results to publish
+const DefaultRelayInterval = time.Second
+
+// DefaultRelayBatchSize is the number of outbox entries a Relay reads at once
+const DefaultRelayBatchSize = 10
+
+// DefaultRelayRetryInterval is how long a Relay waits before publishing an entry that failed again
+const DefaultRelayRetryInterval = time.Minute
+
+// DefaultRelayLease is how long a Relay's claim on an entry keeps other relays from publishing it
+const DefaultRelayLease = 30 * time.Second
+
+// ErrClaimLost is returned by an Outbox deleting an entry no longer claimed by the relay
+var ErrClaimLost = errors.New("outbox entry is claimed by another relay")
+
+// OutboxEntry is a result waiting in an Outbox to be published
+type OutboxEntry struct {
+	// ID of the message that produced the result
+	ID    string
+	Input *sns.PublishInput
+	// QueueURL of the destination queue, empty when the result is published to its TopicArn
+	QueueURL string
+	Created  time.Time
+}
+
+// Outbox stores results until a Relay publishes them. With an Outbox configured the worker
+// writes results to it instead of publishing them, so a handler that stores its side effects
+// in the same database can write its result atomically with them. A handler that writes its
+// own entry must return a nil output, otherwise the worker writes the result again after the
+// Relay may already have published and deleted the first entry.
+type Outbox interface {
+	// Put stores an entry, replacing any entry with the same ID
+	Put(ctx context.Context, entry OutboxEntry) error
+	// Pending returns up to limit entries, oldest first where the store supports it
+	Pending(ctx context.Context, limit int) ([]OutboxEntry, error)
+	// Claim leases an entry to the owner until the time, with a conditional update. It returns
+	// false when the entry was deleted or another owner's lease has not expired.
+	Claim(ctx context.Context, id, owner string, until time.Time) (bool, error)
+	// Delete removes a published entry while it is claimed by the owner, and returns
+	// ErrClaimLost when it is not
+	Delete(ctx context.Context, id, owner string) error
+}
+
+// putOutbox writes a copy of the result to the Outbox
+func (w *Worker) putOutbox(ctx context.Context, msg message, output *sns.PublishInput, queueURL string) error {
+	input := *output
+	return w.Outbox.Put(ctx, OutboxEntry{
+		ID:       aws.StringValue(msg.MessageId),
+		Input:    &input,
+		QueueURL: queueURL,
+		Created:  time.Now(),
+	})
+}
+
+// Relay publishes the results stored in an Outbox, deleting each entry once it is published.
+// An entry that fails to publish is parked and retried after RetryInterval, so it does not
+// hold up the entries behind it. Each entry is claimed before it is published, so the relays
+// of several workers sharing an outbox do not publish it twice while their lease holds.
+type Relay struct {
+	Outbox Outbox
+	Topic  snsiface.SNSAPI
+	// Queue sends the entries with a QueueURL
+	Queue     sqsiface.SQSAPI
+	Logger    *zap.Logger
+	Interval  time.Duration
+	BatchSize int
+	// RetryInterval defaults to DefaultRelayRetryInterval
+	RetryInterval time.Duration
+	// Owner identifies the relay's claims, a random ID by default
+	Owner string
+	// Lease is how long a claim lasts, DefaultRelayLease by default. It must be longer than a
+	// publish takes, or another relay may publish the entry again.
+	Lease time.Duration
+	// parked holds the failed entries by ID, with the time they are retried
+	parked map[string]time.Time
+}
+
+// Run publishes the pending entries until the context is done, checking the outbox every
+// Interval while it is empty
+func (r *Relay) Run(ctx context.Context) {
+	interval, batchSize := r.Interval, r.BatchSize
+	if interval == 0 {
+		interval = DefaultRelayInterval
+	}
+	if batchSize == 0 {
+		batchSize = DefaultRelayBatchSize
+	}
+	if r.RetryInterval == 0 {
+		r.RetryInterval = DefaultRelayRetryInterval
+	}
+	if r.Lease == 0 {
+		r.Lease = DefaultRelayLease
+	}
+	if r.Owner == "" {
+		owner, err := newRelayOwner()
+		if err != nil {
+			r.logError("outbox relay owner failed!", err)
+			return
+		}
+		r.Owner = owner
+	}
+	r.parked = make(map[string]time.Time)
+
+	for {
+		n, err := r.relay(ctx, batchSize)
+		if err != nil {
+			r.logError("outbox relay failed!", err)
+		}
+		if n == batchSize && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// relay publishes one batch of pending entries, skipping the parked entries that are not due,
+// and returns the number of entries it tried to publish
+func (r *Relay) relay(ctx context.Context, batchSize int) (int, error) {
+	limit := batchSize + len(r.parked)
+	entries, err := r.Outbox.Pending(ctx, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool, len(entries))
+	n := 0
+	for _, entry := range entries {
+		seen[entry.ID] = true
+		if retry, ok := r.parked[entry.ID]; ok && now.Before(retry) {
+			continue
+		}
+		if n == batchSize {
+			break
+		}
+		n++
+
+		claimed, err := r.Outbox.Claim(ctx, entry.ID, r.Owner, now.Add(r.Lease))
+		if err != nil {
+			r.logError("outbox relay claim failed!", err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		if _, err := deliver(r.Topic, r.Queue, entry.QueueURL, entry.Input, nil); err != nil {
+			r.parked[entry.ID] = now.Add(r.RetryInterval)
+			r.logError("outbox relay publish failed, parking entry "+entry.ID+"!", err)
+			continue
+		}
+		delete(r.parked, entry.ID)
+		if err := r.Outbox.Delete(ctx, entry.ID, r.Owner); err != nil {
+			r.logError("outbox relay delete failed!", err)
+		}
+	}
+
+	// parked entries that are no longer pending were deleted elsewhere
+	if len(entries) < limit {
+		for id := range r.parked {
+			if !seen[id] {
+				delete(r.parked, id)
+			}
+		}
+	}
+	return n, nil
+}
+
+// newRelayOwner returns a random relay owner
+func newRelayOwner() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func (r *Relay) logError(msg string, err error) {
+	if r.Logger != nil {
+		r.Logger.Error(err.Error(),
+			zap.String("msg", msg),
+			zap.Error(err),
+		)
+	}
+}
+
+// MemoryOutbox is an in-process Outbox for development and tests. Its entries are lost when the
+// process exits, so it gives none of the guarantees of a durable outbox.
+type MemoryOutbox struct {
+	mu      sync.Mutex
+	entries map[string]OutboxEntry
+	leases  map[string]outboxLease
+}
+
+// outboxLease is the claim on an entry of a MemoryOutbox
+type outboxLease struct {
+	owner string
+	until time.Time
+}
+
+// Put stores the entry
+func (m *MemoryOutbox) Put(ctx context.Context, entry OutboxEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entries == nil {
+		m.entries = make(map[string]OutboxEntry)
+	}
+	m.entries[entry.ID] = entry
+	delete(m.leases, entry.ID)
+	return nil
+}
+
+// Pending returns the oldest entries
+func (m *MemoryOutbox) Pending(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := make([]OutboxEntry, 0, len(m.entries))
+	for _, entry := range m.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Created.Before(entries[j].Created) })
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// Claim leases the entry to the owner
+func (m *MemoryOutbox) Claim(ctx context.Context, id, owner string, until time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[id]; !ok {
+		return false, nil
+	}
+	if lease, ok := m.leases[id]; ok && lease.owner != owner && time.Now().Before(lease.until) {
+		return false, nil
+	}
+	if m.leases == nil {
+		m.leases = make(map[string]outboxLease)
+	}
+	m.leases[id] = outboxLease{owner: owner, until: until}
+	return true, nil
+}
+
+// Delete removes the entry claimed by the owner
+func (m *MemoryOutbox) Delete(ctx context.Context, id, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.leases[id].owner != owner {
+		return ErrClaimLost
+	}
+	delete(m.entries, id)
+	delete(m.leases, id)
+	return nil
+}
@@ -0,0 +1,171 @@
+// Package outbox provides a DynamoDB implementation of sqsworker.Outbox.
+//
+// The table has a string partition key named id. A handler that stores its own side effects
+// in DynamoDB includes the result in the same transaction with TransactPut, and returns a nil
+// output so the worker does not write the result to the outbox a second time. The Relay
+// publishes the input as it is stored, so it must set its TopicArn:
+//
+//	input := &sns.PublishInput{TopicArn: aws.String(topicArn), Message: aws.String(result)}
+//	item, err := box.TransactPut(sqsworker.OutboxEntry{ID: *m.MessageId, Input: input})
+//	if err != nil {
+//		return nil, err
+//	}
+//	_, err = db.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+//		TransactItems: []*dynamodb.TransactWriteItem{order, item},
+//	})
+//	return nil, err
+//
+// A Relay claims an entry before publishing it by setting its lease_owner and lease_until with a
+// conditional update, and deletes it only while the claim is still its own.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DynamoDB is a sqsworker.Outbox storing entries in a DynamoDB table
+type DynamoDB struct {
+	Client dynamodbiface.DynamoDBAPI
+	Table  string
+}
+
+// New creates a DynamoDB outbox for the table
+func New(client dynamodbiface.DynamoDBAPI, table string) *DynamoDB {
+	return &DynamoDB{Client: client, Table: table}
+}
+
+// item converts an entry to a DynamoDB item, the publish input is stored as JSON
+func item(entry sqsworker.OutboxEntry) (map[string]*dynamodb.AttributeValue, error) {
+	input, err := json.Marshal(entry.Input)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Created.IsZero() {
+		entry.Created = time.Now()
+	}
+	i := map[string]*dynamodb.AttributeValue{
+		"id":      {S: aws.String(entry.ID)},
+		"input":   {S: aws.String(string(input))},
+		"created": {N: aws.String(strconv.FormatInt(entry.Created.UnixNano(), 10))},
+	}
+	if entry.QueueURL != "" {
+		i["queue_url"] = &dynamodb.AttributeValue{S: aws.String(entry.QueueURL)}
+	}
+	return i, nil
+}
+
+// entry converts a DynamoDB item to an entry
+func entry(item map[string]*dynamodb.AttributeValue) (sqsworker.OutboxEntry, error) {
+	e := sqsworker.OutboxEntry{ID: aws.StringValue(item["id"].S), Input: &sns.PublishInput{}}
+	if err := json.Unmarshal([]byte(aws.StringValue(item["input"].S)), e.Input); err != nil {
+		return e, err
+	}
+	if queueURL, ok := item["queue_url"]; ok {
+		e.QueueURL = aws.StringValue(queueURL.S)
+	}
+	if created, ok := item["created"]; ok {
+		nanos, err := strconv.ParseInt(aws.StringValue(created.N), 10, 64)
+		if err != nil {
+			return e, err
+		}
+		e.Created = time.Unix(0, nanos)
+	}
+	return e, nil
+}
+
+// Put stores the entry
+func (d *DynamoDB) Put(ctx context.Context, e sqsworker.OutboxEntry) error {
+	i, err := item(e)
+	if err != nil {
+		return err
+	}
+	_, err = d.Client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(d.Table),
+		Item:      i,
+	})
+	return err
+}
+
+// TransactPut returns the write of the entry, to include in a handler's transaction
+func (d *DynamoDB) TransactPut(e sqsworker.OutboxEntry) (*dynamodb.TransactWriteItem, error) {
+	i, err := item(e)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+		TableName: aws.String(d.Table),
+		Item:      i,
+	}}, nil
+}
+
+// Pending scans up to limit entries. The scan is not ordered, the entries read are sorted
+// oldest first.
+func (d *DynamoDB) Pending(ctx context.Context, limit int) ([]sqsworker.OutboxEntry, error) {
+	out, err := d.Client.ScanWithContext(ctx, &dynamodb.ScanInput{
+		TableName:      aws.String(d.Table),
+		Limit:          aws.Int64(int64(limit)),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]sqsworker.OutboxEntry, 0, len(out.Items))
+	for _, i := range out.Items {
+		e, err := entry(i)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Created.Before(entries[j].Created) })
+	return entries, nil
+}
+
+// Claim leases the entry to the owner, unless another owner's lease has not expired
+func (d *DynamoDB) Claim(ctx context.Context, id, owner string, until time.Time) (bool, error) {
+	_, err := d.Client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(d.Table),
+		Key:                 map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}},
+		UpdateExpression:    aws.String("SET lease_owner = :owner, lease_until = :until"),
+		ConditionExpression: aws.String("attribute_exists(id) AND (attribute_not_exists(lease_until) OR lease_until < :now OR lease_owner = :owner)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {S: aws.String(owner)},
+			":until": {N: aws.String(strconv.FormatInt(until.UnixNano(), 10))},
+			":now":   {N: aws.String(strconv.FormatInt(time.Now().UnixNano(), 10))},
+		},
+	})
+	if conditionFailed(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete removes the entry while it is claimed by the owner
+func (d *DynamoDB) Delete(ctx context.Context, id, owner string) error {
+	_, err := d.Client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName:                 aws.String(d.Table),
+		Key:                       map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}},
+		ConditionExpression:       aws.String("lease_owner = :owner"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":owner": {S: aws.String(owner)}},
+	})
+	if conditionFailed(err) {
+		return sqsworker.ErrClaimLost
+	}
+	return err
+}
+
+func conditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
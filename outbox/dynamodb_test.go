@@ -0,0 +1,195 @@
+package outbox_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/outbox"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Table is an in-memory DynamoDB table keyed by id
+type Table struct {
+	dynamodbiface.DynamoDBAPI
+	mu    sync.Mutex
+	Items map[string]map[string]*dynamodb.AttributeValue
+	Puts  int
+}
+
+func (t *Table) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Puts++
+	t.Items[*input.Item["id"].S] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (t *Table) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, item := range input.TransactItems {
+		if item.Put != nil {
+			t.Items[*item.Put.Item["id"].S] = item.Put.Item
+		}
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func (t *Table) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := &dynamodb.ScanOutput{}
+	for _, item := range t.Items {
+		if int64(len(out.Items)) == *input.Limit {
+			break
+		}
+		out.Items = append(out.Items, item)
+	}
+	return out, nil
+}
+
+// UpdateItemWithContext sets the lease of an item under the condition of a claim
+func (t *Table) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	values := input.ExpressionAttributeValues
+	item, ok := t.Items[*input.Key["id"].S]
+	if !ok {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "no item", nil)
+	}
+	if until, ok := item["lease_until"]; ok && *item["lease_owner"].S != *values[":owner"].S && *until.N >= *values[":now"].N {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "leased", nil)
+	}
+	// a new item, the scanned ones are read without the lock
+	leased := map[string]*dynamodb.AttributeValue{"lease_owner": values[":owner"], "lease_until": values[":until"]}
+	for name, value := range item {
+		if _, ok := leased[name]; !ok {
+			leased[name] = value
+		}
+	}
+	t.Items[*input.Key["id"].S] = leased
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+// DeleteItemWithContext deletes an item under the condition of its lease owner
+func (t *Table) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id := *input.Key["id"].S
+	if owner, ok := t.Items[id]["lease_owner"]; !ok || *owner.S != *input.ExpressionAttributeValues[":owner"].S {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "not leased", nil)
+	}
+	delete(t.Items, id)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func TestDynamoDB(t *testing.T) {
+	ctx := context.Background()
+	table := &Table{Items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	box := outbox.New(table, "outbox")
+
+	created := time.Now()
+	for i, id := range []string{"second", "first"} {
+		err := box.Put(ctx, sqsworker.OutboxEntry{
+			ID:      id,
+			Input:   &sns.PublishInput{TopicArn: aws.String("arn:aws:sns:us-east-1:88888888888:Out"), Message: aws.String(id)},
+			Created: created.Add(-time.Duration(i) * time.Second),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := box.Pending(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ID != "first" || *entries[0].Input.Message != "first" {
+		t.Fatal("unexpected entries: ", entries)
+	}
+	if !entries[1].Created.Equal(created) {
+		t.Error("Actual: ", entries[1].Created, "Expected: ", created)
+	}
+
+	// an entry is claimed once until its lease expires, and deleted only by its owner
+	if claimed, err := box.Claim(ctx, "first", "a", time.Now().Add(time.Minute)); !claimed || err != nil {
+		t.Fatal("Expected the entry to be claimed: ", err)
+	}
+	if claimed, _ := box.Claim(ctx, "first", "b", time.Now().Add(time.Minute)); claimed {
+		t.Error("Expected a claimed entry not to be claimed again")
+	}
+	if err := box.Delete(ctx, "first", "b"); err != sqsworker.ErrClaimLost {
+		t.Error("Actual: ", err, "Expected: ", sqsworker.ErrClaimLost)
+	}
+	if err := box.Delete(ctx, "first", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := box.Pending(ctx, 10); len(entries) != 1 {
+		t.Error("Actual: ", len(entries), "Expected: ", 1)
+	}
+
+	item, err := box.TransactPut(sqsworker.OutboxEntry{ID: "third", Input: &sns.PublishInput{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *item.Put.TableName != "outbox" || *item.Put.Item["id"].S != "third" {
+		t.Error("unexpected transact item: ", item)
+	}
+}
+
+func TestTransactPutProcessor(t *testing.T) {
+	table := &Table{Items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	box := outbox.New(table, "outbox")
+	topicArn := "arn:aws:sns:us-east-1:88888888888:Out"
+
+	// The handler writes its result in its own transaction, and returns no output
+	processor := sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+		input := &sns.PublishInput{TopicArn: aws.String(topicArn), Message: aws.String(strings.ToUpper(*m.Body))}
+		item, err := box.TransactPut(sqsworker.OutboxEntry{ID: *m.MessageId, Input: input})
+		if err != nil {
+			return nil, err
+		}
+		_, err = table.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{item},
+		})
+		return nil, err
+	})
+	h := workertest.New(t, sqsworker.WorkerConfig{TopicArn: topicArn, Processor: processor, Outbox: box})
+	h.Run(workertest.NewMessage("hello")).Succeeded().Deleted().NotPublished()
+
+	if table.Puts != 0 || len(table.Items) != 1 {
+		t.Fatal("unexpected outbox writes: ", table.Puts, len(table.Items))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	relay := &sqsworker.Relay{Outbox: box, Topic: h.Topic, Interval: time.Millisecond}
+	go relay.Run(ctx)
+
+	deadline := time.After(time.Second)
+	for {
+		if entries, _ := box.Pending(context.Background(), 10); len(entries) == 0 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("Expected the relay to empty the outbox")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	cancel()
+
+	if len(h.Topic.Published) != 1 || aws.StringValue(h.Topic.Published[0].Message) != "HELLO" {
+		t.Error("unexpected published messages: ", h.Topic.Published)
+	}
+}
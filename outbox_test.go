@@ -0,0 +1,193 @@
+package sqsworker_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	outbox := &sqsworker.MemoryOutbox{}
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:  "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: &HelloWorld{},
+		Outbox:    outbox,
+	})
+	m := workertest.NewMessage("hello")
+	h.Run(m).Succeeded().Deleted().NotPublished()
+	h.Run(workertest.NewMessage("hello")).Succeeded().Deleted().NotPublished()
+
+	entries, _ := outbox.Pending(context.Background(), 10)
+	if len(entries) != 2 || entries[0].ID != *m.MessageId || *entries[0].Input.Message != "hello world" {
+		t.Fatal("unexpected outbox entries: ", entries)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	relay := &sqsworker.Relay{Outbox: outbox, Topic: h.Topic, Interval: time.Millisecond}
+	go relay.Run(ctx)
+
+	deadline := time.After(time.Second)
+	for {
+		if entries, _ := outbox.Pending(context.Background(), 10); len(entries) == 0 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("Expected the relay to empty the outbox")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	cancel()
+
+	if len(h.Topic.Published) != 2 || aws.StringValue(h.Topic.Published[0].Message) != "hello world" {
+		t.Error("unexpected published messages: ", h.Topic.Published)
+	}
+}
+
+// DeletedTopic fails the publishes to one topic, as if it were deleted
+type DeletedTopic struct {
+	*workertest.Topic
+	TopicArn string
+}
+
+func (d *DeletedTopic) Publish(input *sns.PublishInput) (*sns.PublishOutput, error) {
+	if aws.StringValue(input.TopicArn) == d.TopicArn {
+		return nil, errors.New("topic does not exist")
+	}
+	return d.Topic.Publish(input)
+}
+
+func TestRelayParksFailedEntries(t *testing.T) {
+	outbox := &sqsworker.MemoryOutbox{}
+	created := time.Now()
+	for i, topic := range []string{"Deleted", "Out", "Out"} {
+		outbox.Put(context.Background(), sqsworker.OutboxEntry{
+			ID:      fmt.Sprint(i),
+			Input:   &sns.PublishInput{TopicArn: aws.String("arn:aws:sns:us-east-1:88888888888:" + topic), Message: aws.String(topic)},
+			Created: created.Add(time.Duration(i) * time.Millisecond),
+		})
+	}
+
+	topic := &workertest.Topic{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	relay := &sqsworker.Relay{
+		Outbox:    outbox,
+		Topic:     &DeletedTopic{Topic: topic, TopicArn: "arn:aws:sns:us-east-1:88888888888:Deleted"},
+		Interval:  time.Millisecond,
+		BatchSize: 1,
+	}
+	go relay.Run(ctx)
+
+	deadline := time.After(time.Second)
+	for {
+		if entries, _ := outbox.Pending(context.Background(), 10); len(entries) == 1 {
+			if entries[0].ID != "0" {
+				t.Fatal("unexpected entry left in the outbox: ", entries[0])
+			}
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("Expected the relay to publish the entries behind the failed one")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	cancel()
+}
+
+// SlowTopic publishes after a delay, so relays sharing an outbox overlap
+type SlowTopic struct {
+	*workertest.Topic
+}
+
+func (s *SlowTopic) Publish(input *sns.PublishInput) (*sns.PublishOutput, error) {
+	time.Sleep(time.Millisecond)
+	return s.Topic.Publish(input)
+}
+
+func TestRelaysClaimEntries(t *testing.T) {
+	outbox := &sqsworker.MemoryOutbox{}
+	for i := 0; i < 20; i++ {
+		outbox.Put(context.Background(), sqsworker.OutboxEntry{
+			ID:    fmt.Sprint(i),
+			Input: &sns.PublishInput{TopicArn: aws.String("arn:aws:sns:us-east-1:88888888888:Out"), Message: aws.String(fmt.Sprint(i))},
+		})
+	}
+
+	topic := &workertest.Topic{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		relay := &sqsworker.Relay{Outbox: outbox, Topic: &SlowTopic{Topic: topic}, Interval: time.Millisecond}
+		go relay.Run(ctx)
+	}
+
+	deadline := time.After(3 * time.Second)
+	for {
+		if entries, _ := outbox.Pending(context.Background(), 10); len(entries) == 0 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("Expected the relays to empty the outbox")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	cancel()
+
+	// every entry is published by the one relay that claimed it
+	if len(topic.Published) != 20 {
+		t.Error("Actual: ", len(topic.Published), "Expected: ", 20)
+	}
+}
+
+// ChannelTopic sends the published inputs to a channel
+type ChannelTopic struct {
+	snsiface.SNSAPI
+	Published chan *sns.PublishInput
+}
+
+func (c *ChannelTopic) Publish(input *sns.PublishInput) (*sns.PublishOutput, error) {
+	select {
+	case c.Published <- input:
+	default:
+	}
+	return &sns.PublishOutput{}, nil
+}
+
+func TestRunRelaysWithoutTopicArn(t *testing.T) {
+	other := "arn:aws:sns:us-east-1:88888888888:Other"
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: &StaticWorker{Output: &sns.PublishInput{TopicArn: aws.String(other), Message: aws.String("static")}},
+		Name:      "TestApp",
+		Outbox:    &sqsworker.MemoryOutbox{},
+	})
+	w.Queue = &StaticQueue{Message: &sqs.Message{Body: aws.String("hello"), MessageId: aws.String("1")}}
+	topic := &ChannelTopic{Published: make(chan *sns.PublishInput, 1)}
+	w.Topic = topic
+	go w.Run()
+	defer w.Close()
+
+	select {
+	case input := <-topic.Published:
+		if aws.StringValue(input.TopicArn) != other {
+			t.Error("Actual: ", aws.StringValue(input.TopicArn), "Expected: ", other)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected the worker to relay the outbox without a TopicArn")
+	}
+}
@@ -0,0 +1,177 @@
+package sqsworker
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"sync"
+)
+
+// ErrNoStages is returned when a Pipeline without stages is built
+var ErrNoStages = errors.New("sqsworker: pipeline has no stages")
+
+// PipelineStage is a stage of a Pipeline: the queue it reads and the config of its worker
+type PipelineStage struct {
+	// Queue is the name of the queue the stage reads, created when it does not exist
+	Queue string
+	// Config is the config of the stage's worker. Its QueueURL, TopicArn and Sink are set by the
+	// pipeline, and its Name defaults to the queue name.
+	Config WorkerConfig
+}
+
+// Pipeline chains workers into a multi-stage pipeline. Each stage reads its own queue and sends
+// its results to the queue of the next stage, and the last stage publishes to the pipeline's
+// topic, if it has one:
+//
+//	p := sqsworker.NewPipeline(sess).
+//		Stage("orders-validate", validate).
+//		Stage("orders-enrich", enrich).
+//		Publish("orders-enriched")
+//	if err := p.Run(); err != nil {
+//		log.Fatal(err)
+//	}
+type Pipeline struct {
+	Session *session.Session
+	// Queue and Topic are the clients used to provision the queues and the topic, and by the
+	// workers of the stages
+	Queue  sqsiface.SQSAPI
+	Topic  snsiface.SNSAPI
+	Stages []PipelineStage
+	// TopicName is the name of the topic the last stage publishes to, created when it does not
+	// exist
+	TopicName string
+	// QueueURLs are the urls of the queues of the stages, and Workers their workers, in order,
+	// once the pipeline is built
+	QueueURLs []string
+	Workers   []*Worker
+	mu        sync.Mutex
+}
+
+// NewPipeline creates a Pipeline without stages
+func NewPipeline(sess *session.Session) *Pipeline {
+	return &Pipeline{Session: sess, Queue: sqs.New(sess), Topic: sns.New(sess)}
+}
+
+// Stage adds a stage reading the queue and handling its messages with the processor
+func (p *Pipeline) Stage(queue string, processor Processor) *Pipeline {
+	return p.StageConfig(queue, WorkerConfig{Processor: processor})
+}
+
+// StageConfig adds a stage reading the queue with a worker configured by wc
+func (p *Pipeline) StageConfig(queue string, wc WorkerConfig) *Pipeline {
+	p.Stages = append(p.Stages, PipelineStage{Queue: queue, Config: wc})
+	return p
+}
+
+// Publish sets the topic the last stage publishes to
+func (p *Pipeline) Publish(topic string) *Pipeline {
+	p.TopicName = topic
+	return p
+}
+
+// Build creates the queues and the topic of the pipeline, and the workers of its stages. It is
+// called by Run, and does nothing once the pipeline is built.
+func (p *Pipeline) Build() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Workers != nil {
+		return nil
+	}
+	if len(p.Stages) == 0 {
+		return ErrNoStages
+	}
+
+	queueURLs := make([]string, len(p.Stages))
+	for i, stage := range p.Stages {
+		queueURL, err := GetOrCreateQueue(stage.Queue, p.Queue)
+		if err != nil {
+			return err
+		}
+		queueURLs[i] = queueURL
+	}
+	topicArn, err := GetOrCreateTopic(p.TopicName, p.Topic)
+	if err != nil {
+		return err
+	}
+
+	workers := make([]*Worker, len(p.Stages))
+	for i, stage := range p.Stages {
+		wc := stage.Config
+		wc.QueueURL, wc.QueueURLs = queueURLs[i], nil
+		wc.TopicArn, wc.Sink = "", nil
+		if i < len(p.Stages)-1 {
+			wc.Sink = NewQueueSink(p.Queue, QueueConfig{QueueURL: queueURLs[i+1]})
+		} else {
+			wc.TopicArn = topicArn
+		}
+		if wc.Name == "" {
+			wc.Name = stage.Queue
+		}
+
+		w := NewWorker(p.Session, wc)
+		// A worker verifying checksums needs its own client, which does not verify them for it
+		if !wc.VerifyMD5 {
+			w.Queue = p.Queue
+		}
+		w.Topic = p.Topic
+		workers[i] = w
+	}
+	p.QueueURLs, p.Workers = queueURLs, workers
+	return nil
+}
+
+// Run builds the pipeline and runs the workers of every stage until they are closed. When a
+// worker stops with an error, the other workers are closed and the error is returned.
+func (p *Pipeline) Run() error {
+	if err := p.Build(); err != nil {
+		return err
+	}
+
+	stopped := make(chan *Worker, len(p.Workers))
+	for _, w := range p.Workers {
+		go func(w *Worker) {
+			w.Run()
+			<-w.Done()
+			stopped <- w
+		}(w)
+	}
+
+	var err error
+	for range p.Workers {
+		w := <-stopped
+		if werr := w.Err(); err == nil && werr != nil && werr != ErrClosed {
+			err = werr
+			p.Close()
+		}
+	}
+	return err
+}
+
+// Stop stops the stages in order, so the results of the messages a stage already received are
+// sent to the next stage before it stops. See Worker.Stop.
+func (p *Pipeline) Stop(ctx context.Context) error {
+	for _, w := range p.workers() {
+		if err := w.Stop(ctx); err != nil {
+			p.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the workers of every stage
+func (p *Pipeline) Close() {
+	for _, w := range p.workers() {
+		w.Close()
+	}
+}
+
+func (p *Pipeline) workers() []*Worker {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Workers
+}
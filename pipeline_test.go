@@ -0,0 +1,88 @@
+package sqsworker_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+	"strings"
+	"testing"
+)
+
+// PipelineQueue creates queues and records the messages sent to them
+type PipelineQueue struct {
+	workertest.Queue
+}
+
+func (p *PipelineQueue) GetQueueUrl(input *sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error) {
+	return nil, awserr.New(sqs.ErrCodeQueueDoesNotExist, "does not exist", nil)
+}
+
+func (p *PipelineQueue) CreateQueue(input *sqs.CreateQueueInput) (*sqs.CreateQueueOutput, error) {
+	return &sqs.CreateQueueOutput{QueueUrl: aws.String("https://sqs.us-east-1.amazonaws.com/88888888888/" + *input.QueueName)}, nil
+}
+
+// PipelineTopic creates topics and records the messages published to them
+type PipelineTopic struct {
+	workertest.Topic
+}
+
+func (p *PipelineTopic) CreateTopic(input *sns.CreateTopicInput) (*sns.CreateTopicOutput, error) {
+	return &sns.CreateTopicOutput{TopicArn: aws.String(topicBase + *input.Name)}, nil
+}
+
+func TestPipeline(t *testing.T) {
+	queue, topic := &PipelineQueue{}, &PipelineTopic{}
+	p := sqsworker.NewPipeline(session.Must(session.NewSession(&aws.Config{Region: aws.String("us-east-1")})))
+	p.Queue, p.Topic = queue, topic
+	p.Stage("Validate", &LowerCaseWorker{}).
+		StageConfig("Enrich", sqsworker.WorkerConfig{
+			Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+				return &sns.PublishInput{Message: aws.String(strings.Repeat(*m.Body, 2))}, nil
+			}),
+		}).
+		Publish("Enriched")
+	if err := p.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.Workers) != 2 || p.Workers[0].Name != "Validate" || p.Workers[1].QueueURL != p.QueueURLs[1] {
+		t.Fatal("unexpected workers: ", p.Workers)
+	}
+	if expected := topicBase + "Enriched"; p.Workers[1].TopicArn != expected {
+		t.Error("Actual: ", p.Workers[1].TopicArn, "Expected: ", expected)
+	}
+
+	ctx := context.Background()
+	if err := p.Workers[0].Handle(ctx, workertest.NewMessage("HELLO")); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Sent) != 1 || *queue.Sent[0].QueueUrl != p.QueueURLs[1] || *queue.Sent[0].MessageBody != "hello" {
+		t.Fatal("Expected the result to be sent to the next stage, got ", queue.Sent)
+	}
+	if err := p.Workers[1].Handle(ctx, workertest.NewMessage(*queue.Sent[0].MessageBody)); err != nil {
+		t.Fatal(err)
+	}
+	if len(topic.Published) != 1 || *topic.Published[0].Message != "hellohello" {
+		t.Error("Expected the result to be published, got ", topic.Published)
+	}
+}
+
+func TestPipelineRun(t *testing.T) {
+	p := sqsworker.NewPipeline(session.Must(session.NewSession(&aws.Config{Region: aws.String("us-east-1")})))
+	if err := p.Run(); err != sqsworker.ErrNoStages {
+		t.Error("Actual: ", err, "Expected: ", sqsworker.ErrNoStages)
+	}
+
+	// A stage that cannot run stops the pipeline
+	p.Queue, p.Topic = &PipelineQueue{}, &PipelineTopic{}
+	p.StageConfig("Validate", sqsworker.WorkerConfig{Logger: zap.NewNop()})
+	if err := p.Run(); err != sqsworker.ErrNoProcessor {
+		t.Error("Actual: ", err, "Expected: ", sqsworker.ErrNoProcessor)
+	}
+}
@@ -0,0 +1,269 @@
+package sqsworker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"strings"
+)
+
+// MaxMessageRetentionPeriod in seconds, the longest SQS keeps a message. Dead-letter and
+// quarantine queues are created with it so there is time to inspect their messages.
+const MaxMessageRetentionPeriod = 1209600
+
+// DefaultMaxReceiveCount is the number of receives after which SQS moves a message to the
+// dead-letter queue, when GetOrCreateDeadLetterQueue is given zero
+const DefaultMaxReceiveCount = 5
+
+// redrivePolicy is the RedrivePolicy attribute of a queue with a dead-letter queue
+type redrivePolicy struct {
+	DeadLetterTargetArn string `json:"deadLetterTargetArn"`
+	MaxReceiveCount     string `json:"maxReceiveCount"`
+}
+
+// GetOrCreateDeadLetterQueue gets or creates the SQS queue name, and sets it as the dead-letter
+// queue of queueURL, moving messages to it after maxReceiveCount receives.
+func GetOrCreateDeadLetterQueue(name, queueURL string, maxReceiveCount int, sqsc sqsiface.SQSAPI) (string, error) {
+	if maxReceiveCount == 0 {
+		maxReceiveCount = DefaultMaxReceiveCount
+	}
+
+	dlqURL, err := getOrCreateRetainedQueue(name, sqsc)
+	if err != nil {
+		return "", err
+	}
+	attributes, err := sqsc.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(dlqURL),
+		AttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameQueueArn}),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	policy, err := json.Marshal(redrivePolicy{
+		DeadLetterTargetArn: aws.StringValue(attributes.Attributes[sqs.QueueAttributeNameQueueArn]),
+		MaxReceiveCount:     fmt.Sprint(maxReceiveCount),
+	})
+	if err != nil {
+		return "", err
+	}
+	_, err = sqsc.SetQueueAttributes(&sqs.SetQueueAttributesInput{
+		QueueUrl:   aws.String(queueURL),
+		Attributes: map[string]*string{sqs.QueueAttributeNameRedrivePolicy: aws.String(string(policy))},
+	})
+	return dlqURL, err
+}
+
+// GetOrCreateQuarantineQueue gets or creates the SQS queue name to be used as a QuarantineQueueURL
+func GetOrCreateQuarantineQueue(name string, sqsc sqsiface.SQSAPI) (string, error) {
+	return getOrCreateRetainedQueue(name, sqsc)
+}
+
+// getOrCreateRetainedQueue gets or creates a queue keeping messages for MaxMessageRetentionPeriod
+func getOrCreateRetainedQueue(name string, sqsc sqsiface.SQSAPI) (string, error) {
+	queueURL, err := GetOrCreateQueue(name, sqsc)
+	if err != nil {
+		return "", err
+	}
+	_, err = sqsc.SetQueueAttributes(&sqs.SetQueueAttributesInput{
+		QueueUrl: aws.String(queueURL),
+		Attributes: map[string]*string{
+			sqs.QueueAttributeNameMessageRetentionPeriod: aws.String(fmt.Sprint(MaxMessageRetentionPeriod)),
+		},
+	})
+	return queueURL, err
+}
+
+// Provision resolves the input queue and output topic of a worker by name when it runs,
+// creating them when they do not exist, instead of configuring its QueueURL and TopicArn. The
+// attributes of an existing queue or topic are left unchanged.
+type Provision struct {
+	QueueName       string
+	QueueAttributes map[string]string
+	// TopicName is optional, the worker publishes its results to the TopicArn without it
+	TopicName       string
+	TopicAttributes map[string]string
+}
+
+// ProvisionError is returned by Err when the worker could not resolve or create the queue or
+// topic of its Provision, or resolve its QueueName
+type ProvisionError struct {
+	// Kind is "queue" or "topic"
+	Kind string
+	Name string
+	Err  error
+}
+
+func (e *ProvisionError) Error() string {
+	return fmt.Sprintf("sqsworker: provision %s %s failed: %v", e.Kind, e.Name, e.Err)
+}
+
+// Unwrap returns the error of the SQS or SNS request
+func (e *ProvisionError) Unwrap() error {
+	return e.Err
+}
+
+// ErrFIFOMismatch is returned when a FIFO queue was requested and the existing queue is a
+// standard queue, or the reverse, or their content-based deduplication differs
+var ErrFIFOMismatch = errors.New("sqsworker: existing queue does not match the requested FIFO attributes")
+
+// GetOrCreateQueueWithAttributes gets the SQS queue name, or creates it with the attributes. A
+// queue is a FIFO queue when its FifoQueue attribute is "true" or its name ends with .fifo: the
+// suffix is appended to its name and the attribute set when they are missing. When the
+// FifoQueue attribute is given, the existing queue must match it and its
+// ContentBasedDeduplication attribute, or ErrFIFOMismatch is returned.
+func GetOrCreateQueueWithAttributes(name string, attributes map[string]string, sqsc sqsiface.SQSAPI) (string, error) {
+	requested, explicit := attributes[sqs.QueueAttributeNameFifoQueue]
+	fifo := requested == "true" || !explicit && strings.HasSuffix(name, ".fifo")
+	if !fifo && strings.HasSuffix(name, ".fifo") {
+		return "", ErrFIFOMismatch
+	}
+	if fifo {
+		if !strings.HasSuffix(name, ".fifo") {
+			name += ".fifo"
+		}
+		attributes = withAttribute(attributes, sqs.QueueAttributeNameFifoQueue, "true")
+	} else if explicit {
+		// standard queues are created without the attribute
+		attributes = withAttribute(attributes, sqs.QueueAttributeNameFifoQueue, "")
+	}
+
+	queueOut, err := sqsc.GetQueueUrl(&sqs.GetQueueUrlInput{
+		QueueName: aws.String(name),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == sqs.ErrCodeQueueDoesNotExist {
+		result, err := sqsc.CreateQueue(&sqs.CreateQueueInput{
+			QueueName:  aws.String(name),
+			Attributes: aws.StringMap(attributes),
+		})
+		if err != nil {
+			return "", err
+		}
+		return *result.QueueUrl, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if explicit {
+		if err := checkFIFO(*queueOut.QueueUrl, fifo, attributes, sqsc); err != nil {
+			return "", err
+		}
+	}
+	return *queueOut.QueueUrl, nil
+}
+
+// GetOrCreateFIFOQueue gets or creates the FIFO queue name, appending the .fifo suffix when it
+// is missing. An existing queue must be a FIFO queue with the same content-based deduplication.
+func GetOrCreateFIFOQueue(name string, contentBasedDeduplication bool, sqsc sqsiface.SQSAPI) (string, error) {
+	return GetOrCreateQueueWithAttributes(name, map[string]string{
+		sqs.QueueAttributeNameFifoQueue:                 "true",
+		sqs.QueueAttributeNameContentBasedDeduplication: fmt.Sprint(contentBasedDeduplication),
+	}, sqsc)
+}
+
+// withAttribute returns a copy of the attributes with the attribute set, or removed when the
+// value is empty
+func withAttribute(attributes map[string]string, name, value string) map[string]string {
+	copied := make(map[string]string, len(attributes)+1)
+	for k, v := range attributes {
+		copied[k] = v
+	}
+	if value == "" {
+		delete(copied, name)
+	} else {
+		copied[name] = value
+	}
+	return copied
+}
+
+// checkFIFO checks that an existing queue is a FIFO queue when fifo is set, with the requested
+// content-based deduplication, and a standard queue otherwise
+func checkFIFO(queueURL string, fifo bool, attributes map[string]string, sqsc sqsiface.SQSAPI) error {
+	out, err := sqsc.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl: aws.String(queueURL),
+		AttributeNames: aws.StringSlice([]string{
+			sqs.QueueAttributeNameFifoQueue,
+			sqs.QueueAttributeNameContentBasedDeduplication,
+		}),
+	})
+	if err != nil {
+		return err
+	}
+	if (aws.StringValue(out.Attributes[sqs.QueueAttributeNameFifoQueue]) == "true") != fifo {
+		return ErrFIFOMismatch
+	}
+	if dedup, ok := attributes[sqs.QueueAttributeNameContentBasedDeduplication]; ok && fifo {
+		if (aws.StringValue(out.Attributes[sqs.QueueAttributeNameContentBasedDeduplication]) == "true") != (dedup == "true") {
+			return ErrFIFOMismatch
+		}
+	}
+	return nil
+}
+
+// createTopic creates the SNS topic name with the attributes, or returns the arn of the
+// existing topic
+func createTopic(name string, attributes map[string]string, snsc snsiface.SNSAPI) (string, error) {
+	snsOut, err := snsc.CreateTopic(&sns.CreateTopicInput{
+		Name:       aws.String(name),
+		Attributes: aws.StringMap(attributes),
+	})
+	if err != nil {
+		return "", err
+	}
+	return *snsOut.TopicArn, nil
+}
+
+// provision resolves or creates the queue and topic of the Provision, replacing the worker's
+// QueueURL and TopicArn
+func (w *Worker) provision() error {
+	if name := w.Provision.QueueName; name != "" {
+		queueURL, err := GetOrCreateQueueWithAttributes(name, w.Provision.QueueAttributes, w.Queue)
+		if err != nil {
+			return &ProvisionError{Kind: "queue", Name: name, Err: err}
+		}
+		w.setQueueURL(queueURL)
+	}
+	if name := w.Provision.TopicName; name != "" {
+		topicArn, err := createTopic(name, w.Provision.TopicAttributes, w.Topic)
+		if err != nil {
+			return &ProvisionError{Kind: "topic", Name: name, Err: err}
+		}
+		w.TopicArn = topicArn
+	}
+	return nil
+}
+
+// setQueueURL replaces the input queue, ahead of the lower priority QueueURLs
+func (w *Worker) setQueueURL(queueURL string) {
+	queueURLs := []string{queueURL}
+	if len(w.QueueURLs) > 1 {
+		queueURLs = append(queueURLs, w.QueueURLs[1:]...)
+	}
+	w.QueueURL, w.QueueURLs = queueURL, queueURLs
+}
+
+// resolveQueue resolves the QueueName to the QueueURL
+func (w *Worker) resolveQueue() error {
+	out, err := w.Queue.GetQueueUrl(&sqs.GetQueueUrlInput{QueueName: aws.String(w.QueueName)})
+	if err != nil {
+		return &ProvisionError{Kind: "queue", Name: w.QueueName, Err: err}
+	}
+	w.setQueueURL(*out.QueueUrl)
+	return nil
+}
+
+// recreated reports whether the queue named QueueName, that no longer existed when it was
+// polled, exists again at the same url
+func (w *Worker) recreated(queueURL string) bool {
+	if w.QueueName == "" || queueURL != w.QueueURL {
+		return false
+	}
+	out, err := w.Queue.GetQueueUrl(&sqs.GetQueueUrlInput{QueueName: aws.String(w.QueueName)})
+	return err == nil && aws.StringValue(out.QueueUrl) == queueURL
+}
@@ -0,0 +1,305 @@
+package sqsworker_test
+
+import (
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"go.uber.org/zap"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ProvisionQueue creates queues and records the attributes set on them
+type ProvisionQueue struct {
+	sqsiface.SQSAPI
+	Attributes map[string]map[string]string
+	// Created records the attributes the queues were created with
+	Created map[string]map[string]*string
+}
+
+func (p *ProvisionQueue) GetQueueUrl(input *sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error) {
+	return nil, awserr.New(sqs.ErrCodeQueueDoesNotExist, "does not exist", nil)
+}
+
+func (p *ProvisionQueue) CreateQueue(input *sqs.CreateQueueInput) (*sqs.CreateQueueOutput, error) {
+	if p.Created == nil {
+		p.Created = make(map[string]map[string]*string)
+	}
+	p.Created[*input.QueueName] = input.Attributes
+	return &sqs.CreateQueueOutput{QueueUrl: aws.String("https://sqs.us-east-1.amazonaws.com/88888888888/" + *input.QueueName)}, nil
+}
+
+func (p *ProvisionQueue) GetQueueAttributes(input *sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error) {
+	return &sqs.GetQueueAttributesOutput{Attributes: map[string]*string{
+		sqs.QueueAttributeNameQueueArn: aws.String("arn:aws:sqs:us-east-1:88888888888:DLQ"),
+	}}, nil
+}
+
+func (p *ProvisionQueue) SetQueueAttributes(input *sqs.SetQueueAttributesInput) (*sqs.SetQueueAttributesOutput, error) {
+	if p.Attributes == nil {
+		p.Attributes = make(map[string]map[string]string)
+	}
+	if p.Attributes[*input.QueueUrl] == nil {
+		p.Attributes[*input.QueueUrl] = make(map[string]string)
+	}
+	for name, value := range input.Attributes {
+		p.Attributes[*input.QueueUrl][name] = *value
+	}
+	return &sqs.SetQueueAttributesOutput{}, nil
+}
+
+func TestGetOrCreateDeadLetterQueue(t *testing.T) {
+	sqsc := &ProvisionQueue{}
+	dlqURL, err := sqsworker.GetOrCreateDeadLetterQueue("DLQ", workerQueueURL, 3, sqsc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "https://sqs.us-east-1.amazonaws.com/88888888888/DLQ"; dlqURL != expected {
+		t.Error("Actual: ", dlqURL, "Expected: ", expected)
+	}
+
+	expected := `{"deadLetterTargetArn":"arn:aws:sqs:us-east-1:88888888888:DLQ","maxReceiveCount":"3"}`
+	if actual := sqsc.Attributes[workerQueueURL][sqs.QueueAttributeNameRedrivePolicy]; actual != expected {
+		t.Error("Actual: ", actual, "Expected: ", expected)
+	}
+	if actual := sqsc.Attributes[dlqURL][sqs.QueueAttributeNameMessageRetentionPeriod]; actual != "1209600" {
+		t.Error("Actual: ", actual, "Expected: ", "1209600")
+	}
+}
+
+func TestGetOrCreateQuarantineQueue(t *testing.T) {
+	sqsc := &ProvisionQueue{}
+	queueURL, err := sqsworker.GetOrCreateQuarantineQueue("Quarantine", sqsc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := sqsc.Attributes[queueURL][sqs.QueueAttributeNameMessageRetentionPeriod]; actual != "1209600" {
+		t.Error("Actual: ", actual, "Expected: ", "1209600")
+	}
+}
+
+// ProvisionedQueue reports the queue urls it receives from
+type ProvisionedQueue struct {
+	ProvisionQueue
+	Received chan string
+}
+
+func (p *ProvisionedQueue) ReceiveMessageRequest(input *sqs.ReceiveMessageInput) (*request.Request, *sqs.ReceiveMessageOutput) {
+	select {
+	case p.Received <- *input.QueueUrl:
+	default:
+	}
+	time.Sleep(time.Millisecond)
+	return newRequest(), &sqs.ReceiveMessageOutput{}
+}
+
+func TestProvision(t *testing.T) {
+	queue := &ProvisionedQueue{Received: make(chan string, 1)}
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		Provision: &sqsworker.Provision{
+			QueueName:       "Orders",
+			QueueAttributes: map[string]string{sqs.QueueAttributeNameVisibilityTimeout: "60"},
+			TopicName:       "Results",
+		},
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+	})
+	w.Queue, w.Topic = queue, GetMockTopic()
+	go w.Run()
+
+	if queueURL := <-queue.Received; queueURL != queueBase+"Orders" {
+		t.Error("Actual: ", queueURL, "Expected: ", queueBase+"Orders")
+	}
+	w.Close()
+	<-w.Done()
+	if actual := aws.StringValue(queue.Created["Orders"][sqs.QueueAttributeNameVisibilityTimeout]); actual != "60" {
+		t.Error("Actual: ", actual, "Expected: ", "60")
+	}
+	if w.TopicArn != topicBase+"Results" {
+		t.Error("Actual: ", w.TopicArn, "Expected: ", topicBase+"Results")
+	}
+}
+
+// DeniedQueue fails every request as the credentials are not allowed to make it
+type DeniedQueue struct {
+	sqsiface.SQSAPI
+}
+
+func (d *DeniedQueue) GetQueueUrl(input *sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error) {
+	return nil, awserr.New("AccessDenied", "access denied", nil)
+}
+
+func TestProvisionFailure(t *testing.T) {
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		Provision: &sqsworker.Provision{QueueName: "Orders"},
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+	})
+	w.Queue = &DeniedQueue{}
+	w.Run()
+
+	var provisionErr *sqsworker.ProvisionError
+	if !errors.As(w.Err(), &provisionErr) || provisionErr.Kind != "queue" || provisionErr.Name != "Orders" {
+		t.Error("Actual: ", w.Err(), "Expected a ProvisionError of the queue Orders")
+	}
+}
+
+// RecreatedQueue is deleted once it is first received from, and recreated unless Gone
+type RecreatedQueue struct {
+	sqsiface.SQSAPI
+	Gone     bool
+	receives int32
+}
+
+func (r *RecreatedQueue) GetQueueUrl(input *sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error) {
+	if r.Gone && atomic.LoadInt32(&r.receives) > 0 {
+		return nil, awserr.New(sqs.ErrCodeQueueDoesNotExist, "does not exist", nil)
+	}
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String(queueBase + *input.QueueName)}, nil
+}
+
+func (r *RecreatedQueue) ReceiveMessageRequest(input *sqs.ReceiveMessageInput) (*request.Request, *sqs.ReceiveMessageOutput) {
+	switch atomic.AddInt32(&r.receives, 1) {
+	case 1:
+		err := awserr.New(sqs.ErrCodeQueueDoesNotExist, "The specified queue does not exist", nil)
+		return &request.Request{Error: err}, &sqs.ReceiveMessageOutput{}
+	case 2:
+		return newRequest(), &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{{Body: aws.String("recreated")}}}
+	}
+	time.Sleep(time.Millisecond)
+	return newRequest(), &sqs.ReceiveMessageOutput{}
+}
+
+func (r *RecreatedQueue) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	return nil, nil
+}
+
+func (r *RecreatedQueue) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+}
+
+func TestQueueName(t *testing.T) {
+	handler := &RecordingWorker{Bodies: make(chan string, 1)}
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueName: "Orders",
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: handler,
+	})
+	w.Queue = &RecreatedQueue{}
+	go w.Run()
+
+	// the queue was recreated after the first receive failed, so polling continues
+	if body := <-handler.Bodies; body != "recreated" {
+		t.Error("Actual: ", body, "Expected: ", "recreated")
+	}
+	w.Close()
+	<-w.Done()
+	if w.QueueURL != queueBase+"Orders" {
+		t.Error("Actual: ", w.QueueURL, "Expected: ", queueBase+"Orders")
+	}
+	if err := w.Err(); err != sqsworker.ErrClosed {
+		t.Error("Actual: ", err, "Expected: ", sqsworker.ErrClosed)
+	}
+}
+
+func TestQueueNameDeleted(t *testing.T) {
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueName: "Orders",
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+	})
+	w.Queue = &RecreatedQueue{Gone: true}
+	go w.Run()
+
+	select {
+	case <-w.Done():
+	case <-time.After(time.Second):
+		t.Fatal("worker did not stop")
+	}
+	if aerr, ok := w.Err().(awserr.Error); !ok || aerr.Code() != sqs.ErrCodeQueueDoesNotExist {
+		t.Error("Actual: ", w.Err(), "Expected: ", sqs.ErrCodeQueueDoesNotExist)
+	}
+}
+
+// FIFOProvisionQueue holds existing queues by name with their attributes, and records the
+// queues it creates
+type FIFOProvisionQueue struct {
+	sqsiface.SQSAPI
+	Existing map[string]map[string]*string
+	Created  map[string]map[string]*string
+}
+
+func (f *FIFOProvisionQueue) GetQueueUrl(input *sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error) {
+	if _, ok := f.Existing[*input.QueueName]; !ok {
+		return nil, awserr.New(sqs.ErrCodeQueueDoesNotExist, "does not exist", nil)
+	}
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String(queueBase + *input.QueueName)}, nil
+}
+
+func (f *FIFOProvisionQueue) GetQueueAttributes(input *sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error) {
+	return &sqs.GetQueueAttributesOutput{Attributes: f.Existing[strings.TrimPrefix(*input.QueueUrl, queueBase)]}, nil
+}
+
+func (f *FIFOProvisionQueue) CreateQueue(input *sqs.CreateQueueInput) (*sqs.CreateQueueOutput, error) {
+	if f.Created == nil {
+		f.Created = make(map[string]map[string]*string)
+	}
+	f.Created[*input.QueueName] = input.Attributes
+	return &sqs.CreateQueueOutput{QueueUrl: aws.String(queueBase + *input.QueueName)}, nil
+}
+
+func TestGetOrCreateFIFOQueue(t *testing.T) {
+	sqsc := &FIFOProvisionQueue{Existing: map[string]map[string]*string{
+		"Orders.fifo": {
+			sqs.QueueAttributeNameFifoQueue:                 aws.String("true"),
+			sqs.QueueAttributeNameContentBasedDeduplication: aws.String("false"),
+		},
+		"Invoices": {},
+	}}
+
+	// the suffix is appended, and the queue created as a FIFO queue
+	queueURL, err := sqsworker.GetOrCreateFIFOQueue("Payments", true, sqsc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queueURL != queueBase+"Payments.fifo" {
+		t.Error("Actual: ", queueURL, "Expected: ", queueBase+"Payments.fifo")
+	}
+	created := aws.StringValueMap(sqsc.Created["Payments.fifo"])
+	expected := map[string]string{"FifoQueue": "true", "ContentBasedDeduplication": "true"}
+	if !reflect.DeepEqual(created, expected) {
+		t.Error("Actual: ", created, "Expected: ", expected)
+	}
+
+	// a name ending with .fifo creates a FIFO queue
+	if _, err := sqsworker.GetOrCreateQueue("Refunds.fifo", sqsc); err != nil {
+		t.Fatal(err)
+	}
+	if fifo := aws.StringValue(sqsc.Created["Refunds.fifo"][sqs.QueueAttributeNameFifoQueue]); fifo != "true" {
+		t.Error("Actual: ", fifo, "Expected: ", "true")
+	}
+
+	if queueURL, err := sqsworker.GetOrCreateFIFOQueue("Orders", false, sqsc); err != nil || queueURL != queueBase+"Orders.fifo" {
+		t.Error("Actual: ", queueURL, err, "Expected: ", queueBase+"Orders.fifo")
+	}
+	if _, err := sqsworker.GetOrCreateFIFOQueue("Orders", true, sqsc); err != sqsworker.ErrFIFOMismatch {
+		t.Error("Actual: ", err, "Expected: ", sqsworker.ErrFIFOMismatch)
+	}
+	standard := map[string]string{sqs.QueueAttributeNameFifoQueue: "false"}
+	if _, err := sqsworker.GetOrCreateQueueWithAttributes("Orders.fifo", standard, sqsc); err != sqsworker.ErrFIFOMismatch {
+		t.Error("Actual: ", err, "Expected: ", sqsworker.ErrFIFOMismatch)
+	}
+	if _, err := sqsworker.GetOrCreateQueueWithAttributes("Invoices", standard, sqsc); err != nil {
+		t.Error(err)
+	}
+}
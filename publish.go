@@ -0,0 +1,251 @@
+package sqsworker
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultPublishBackoff is the wait before the first publish retry, doubled after each attempt
+const DefaultPublishBackoff = 100 * time.Millisecond
+
+// MaxPublishBackoff is the longest wait between publish retries
+const MaxPublishBackoff = 10 * time.Second
+
+// Delivery orders the publish and delete of a processed message
+type Delivery int
+
+const (
+	// AtLeastOnce publishes the result before deleting the message. A message is never lost,
+	// but its result is published again if the delete fails, unless a PublishStore is set.
+	AtLeastOnce Delivery = iota
+	// AtMostOnce deletes the message before publishing the result. A result is never
+	// published twice, but is lost if the publish fails.
+	AtMostOnce
+)
+
+// PublishStore records the messages whose result was published
+type PublishStore interface {
+	// Published reports whether the result of the message was published
+	Published(ctx context.Context, messageID string) (bool, error)
+	// MarkPublished records that the result of the message was published
+	MarkPublished(ctx context.Context, messageID string) error
+}
+
+// complete publishes the result of a processed message and deletes it, in the configured order,
+// then sends the result to the Mirror
+func (w *Worker) complete(ctx context.Context, state *consumerState, msg message, output *sns.PublishInput, dest Destination, result *Result) error {
+	if err := w.deliverAndDelete(ctx, state, msg, output, dest, result); err != nil {
+		return err
+	}
+	if w.Mirror == nil {
+		return nil
+	}
+	w.mirror(ctx, state, msg, output)
+	for _, p := range result.FanOut {
+		w.mirror(ctx, state, msg, p.Output)
+	}
+	return nil
+}
+
+// mirror sends a result to the Mirror, counting and logging the results it cannot mirror
+func (w *Worker) mirror(ctx context.Context, state *consumerState, msg message, output *sns.PublishInput) {
+	if output == nil || output.Message == nil {
+		return
+	}
+	if err := w.Mirror.Send(ctx, msg.Message, output); err != nil {
+		atomic.AddInt64(&w.stats.mirrorErrors, 1)
+		w.logConsumerError(state, "mirror result failed!", err)
+	}
+}
+
+func (w *Worker) deliverAndDelete(ctx context.Context, state *consumerState, msg message, output *sns.PublishInput, dest Destination, result *Result) error {
+	if w.Delivery == AtMostOnce {
+		if err := w.delete(ctx, state, msg); err != nil {
+			w.logConsumerError(state, "delete message failed!", err)
+			return err
+		}
+		result.Deleted = true
+		return w.publishResults(ctx, state, msg, output, dest, result)
+	}
+
+	// The message is left on the queue when the result cannot be published, so it
+	// is processed again rather than lost.
+	if err := w.publishResults(ctx, state, msg, output, dest, result); err != nil {
+		return err
+	}
+	if err := w.delete(ctx, state, msg); err != nil {
+		w.logConsumerError(state, "delete message failed!", err)
+		return err
+	}
+	result.Deleted = true
+	return nil
+}
+
+// publishResults publishes the result of a message, or each result of a FanOut
+func (w *Worker) publishResults(ctx context.Context, state *consumerState, msg message, output *sns.PublishInput, dest Destination, result *Result) error {
+	if len(result.FanOut) > 0 {
+		return w.publishFanOut(ctx, state, msg, result)
+	}
+	return w.publishOnce(ctx, state, msg, aws.StringValue(msg.MessageId), output, dest, result)
+}
+
+// publishOnce publishes the result to its destination, or writes it to the Outbox, unless the
+// PublishStore recorded it as already published under its key
+func (w *Worker) publishOnce(ctx context.Context, state *consumerState, msg message, key string, output *sns.PublishInput, dest Destination, result *Result) error {
+	output, dest, err := w.prepare(state, msg, key, output, dest)
+	if output == nil || err != nil {
+		return err
+	}
+
+	if w.alreadyPublished(ctx, state, key, result) {
+		return nil
+	}
+
+	if w.Outbox != nil && dest.Sink == nil {
+		err = w.putOutbox(ctx, msg, output, dest.QueueURL)
+	} else {
+		result.Publish, err = w.publish(ctx, state, msg, output, dest)
+	}
+	if err != nil {
+		w.logBodyError(state, "send message failed!", output.Message, err)
+		return err
+	}
+	result.Published = true
+	w.markPublished(ctx, state, key)
+	return nil
+}
+
+// alreadyPublished reports whether the PublishStore recorded the result with the key as published
+func (w *Worker) alreadyPublished(ctx context.Context, state *consumerState, key string, result *Result) bool {
+	if w.PublishStore == nil || key == "" {
+		return false
+	}
+	published, err := w.PublishStore.Published(ctx, key)
+	if err != nil {
+		w.logConsumerError(state, "publish store lookup failed!", err)
+		return false
+	}
+	if published {
+		result.Published, result.Duplicate = true, true
+	}
+	return published
+}
+
+// markPublished records the result with the key as published in the PublishStore
+func (w *Worker) markPublished(ctx context.Context, state *consumerState, key string) {
+	if w.PublishStore == nil || key == "" {
+		return
+	}
+	if err := w.PublishStore.MarkPublished(ctx, key); err != nil {
+		w.logConsumerError(state, "publish store update failed!", err)
+	}
+}
+
+// prepare returns the result as it is sent to its destination, nil when it has none
+func (w *Worker) prepare(state *consumerState, msg message, key string, output *sns.PublishInput, dest Destination) (*sns.PublishInput, Destination, error) {
+	if output == nil || output.Message == nil {
+		return nil, dest, nil
+	}
+	// The Processor may share its output between messages, so the topic is set on a copy
+	switch {
+	case dest.QueueURL != "" || dest.Sink != nil:
+		// sent to the queue or Sink, its TopicArn is ignored
+	case dest.TopicArn != "":
+		state.output = *output
+		state.output.TopicArn = aws.String(dest.TopicArn)
+		output = &state.output
+	case output.TopicArn == nil && w.Sink != nil:
+		dest.Sink = w.Sink
+	case output.TopicArn == nil:
+		if w.TopicArn == "" {
+			return nil, dest, nil
+		}
+		state.output = *output
+		state.output.TopicArn = &w.TopicArn
+		output = &state.output
+	}
+
+	// Sinks such as EventBridge and Kinesis have no attributes to carry the correlation ID
+	if dest.Sink == nil {
+		output = w.correlate(state, output)
+		var err error
+		if output, err = w.fifo(state, msg, key, output, dest); err != nil {
+			return nil, dest, err
+		}
+	}
+	return output, dest, nil
+}
+
+// publish sends the result to its destination, retrying up to PublishRetries times with exponential
+// backoff, at most MaxPublishBackoff. The last error is returned when every attempt failed, the
+// context is done, or the message would become visible again before the next attempt, since it is
+// received again then. Fatal errors are not retried.
+func (w *Worker) publish(ctx context.Context, state *consumerState, msg message, output *sns.PublishInput, dest Destination) (*sns.PublishOutput, error) {
+	backoff := w.PublishBackoff
+	for attempt := 0; ; attempt++ {
+		out, err := w.sendMessage(ctx, msg, output, dest)
+		if err == nil {
+			return out, nil
+		}
+		if attempt >= w.PublishRetries || IsFatal(err) || msg.visibleAt != 0 && time.Now().Add(backoff).UnixNano() > msg.visibleAt {
+			atomic.AddInt64(&w.stats.publishErrors, 1)
+			return out, err
+		}
+		w.logConsumerError(state, "send message failed, retrying!", err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			atomic.AddInt64(&w.stats.publishErrors, 1)
+			return out, err
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > MaxPublishBackoff {
+			backoff = MaxPublishBackoff
+		}
+	}
+}
+
+// MemoryPublishStore is an in-process PublishStore, remembering published messages for a TTL.
+// It only prevents duplicates for messages redelivered to the same process.
+type MemoryPublishStore struct {
+	TTL       time.Duration
+	mu        sync.Mutex
+	published map[string]time.Time
+	swept     time.Time
+}
+
+// NewMemoryPublishStore creates a MemoryPublishStore, the ttl should exceed the visibility timeout
+func NewMemoryPublishStore(ttl time.Duration) *MemoryPublishStore {
+	return &MemoryPublishStore{TTL: ttl, published: make(map[string]time.Time), swept: time.Now()}
+}
+
+// Published reports whether the message was marked as published within the TTL
+func (m *MemoryPublishStore) Published(ctx context.Context, messageID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	at, ok := m.published[messageID]
+	return ok && time.Since(at) < m.TTL, nil
+}
+
+// MarkPublished records the message as published, forgetting the entries older than the TTL
+func (m *MemoryPublishStore) MarkPublished(ctx context.Context, messageID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if now.Sub(m.swept) > m.TTL {
+		for id, at := range m.published {
+			if now.Sub(at) >= m.TTL {
+				delete(m.published, id)
+			}
+		}
+		m.swept = now
+	}
+	m.published[messageID] = now
+	return nil
+}
@@ -0,0 +1,172 @@
+package sqsworker_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FlakyTopic fails the first Failures publishes
+type FlakyTopic struct {
+	*workertest.Topic
+	Failures int
+}
+
+func (f *FlakyTopic) Publish(input *sns.PublishInput) (*sns.PublishOutput, error) {
+	if f.Failures > 0 {
+		f.Failures--
+		return nil, errors.New("throttled")
+	}
+	return f.Topic.Publish(input)
+}
+
+func TestPublishRetries(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:       "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor:      &HelloWorld{},
+		PublishRetries: 2,
+		PublishBackoff: time.Millisecond,
+	})
+	h.Worker.Topic = &FlakyTopic{Topic: h.Topic, Failures: 2}
+	h.Run(workertest.NewMessage("hello")).Succeeded().Deleted().Published("hello world")
+
+	// The message is kept when every retry fails
+	h.Worker.Topic = &FlakyTopic{Topic: h.Topic, Failures: 3}
+	h.Run(workertest.NewMessage("hello")).Failed().NotDeleted().NotPublished()
+
+	// Only the message whose result was published is processed
+	if stats := h.Worker.Stats(); stats.PublishErrors != 1 || stats.Processed != 1 {
+		t.Error("unexpected stats: ", stats)
+	}
+}
+
+func TestPublishRetriesWithinVisibility(t *testing.T) {
+	queue := GetMockeQueue()
+	topic := &FlakyTopic{Topic: &workertest.Topic{}, Failures: 10}
+	processed := make(chan sqsworker.Result, 1)
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:          workerQueueURL,
+		TopicArn:          workerTopicARN,
+		Workers:           1,
+		Logger:            zap.NewNop(),
+		Processor:         &HelloWorld{},
+		Callback:          func(result sqsworker.Result) { processed <- result },
+		VisibilityTimeout: 1,
+		PublishRetries:    5,
+		PublishBackoff:    2 * time.Second,
+	})
+	w.Queue = &PollQueue{queue}
+	w.Topic = topic
+	go w.Run()
+	defer w.Close()
+
+	// The message would be visible again before the first retry, so it is not retried
+	start := time.Now()
+	queue.Push("hello")
+	result := <-processed
+	if result.Err == nil || time.Since(start) > time.Second || topic.Failures != 9 {
+		t.Error("unexpected result: ", result, time.Since(start), topic.Failures)
+	}
+}
+
+func TestPublishStore(t *testing.T) {
+	var result sqsworker.Result
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:     "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor:    &HelloWorld{},
+		Callback:     func(r sqsworker.Result) { result = r },
+		PublishStore: sqsworker.NewMemoryPublishStore(time.Hour),
+	})
+	m := workertest.NewMessage("hello")
+
+	h.Queue.DeleteErr = errors.New("delete failed")
+	h.Run(m).Failed().NotDeleted().Published("hello world")
+	if !result.Partial() {
+		t.Error("Expected a partial result: ", result)
+	}
+
+	// The redelivered message is deleted without publishing it again
+	h.Queue.DeleteErr = nil
+	h.Run(m).Succeeded().Deleted().NotPublished()
+	if !result.Duplicate || !result.Published || result.Partial() {
+		t.Error("unexpected result: ", result)
+	}
+}
+
+func TestAtMostOnce(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:  "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: &HelloWorld{},
+		Delivery:  sqsworker.AtMostOnce,
+	})
+	h.Run(workertest.NewMessage("hello")).Succeeded().Deleted().Published("hello world")
+
+	h.Topic.PublishErr = errors.New("publish failed")
+	h.Run(workertest.NewMessage("hello")).Failed().Deleted().NotPublished()
+
+	h.Topic.PublishErr = nil
+	h.Queue.DeleteErr = errors.New("delete failed")
+	h.Run(workertest.NewMessage("hello")).Failed().NotDeleted().NotPublished()
+}
+
+func TestProcessorOutput(t *testing.T) {
+	other := "arn:aws:sns:us-east-1:88888888888:Other"
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn: "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			switch aws.StringValue(m.Body) {
+			case "other":
+				return &sns.PublishInput{TopicArn: aws.String(other), Message: m.Body, Subject: aws.String("redirected")}, nil
+			case "none":
+				return nil, nil
+			}
+			return &sns.PublishInput{Message: m.Body}, nil
+		}),
+	})
+
+	result := h.Run(workertest.NewMessage("default")).Succeeded().Published("default")
+	if actual := aws.StringValue(result.Publishes[0].TopicArn); actual != "arn:aws:sns:us-east-1:88888888888:Out" {
+		t.Error("Actual: ", actual, "Expected: ", "arn:aws:sns:us-east-1:88888888888:Out")
+	}
+
+	result = h.Run(workertest.NewMessage("other")).Succeeded().Published("other")
+	if actual := aws.StringValue(result.Publishes[0].TopicArn); actual != other {
+		t.Error("Actual: ", actual, "Expected: ", other)
+	}
+	if actual := aws.StringValue(result.Publishes[0].Subject); actual != "redirected" {
+		t.Error("Actual: ", actual, "Expected: ", "redirected")
+	}
+
+	h.Run(workertest.NewMessage("none")).Succeeded().Deleted().NotPublished()
+}
+
+func TestSharedOutput(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:  "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: &StaticWorker{Output: &sns.PublishInput{Message: aws.String("static")}},
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := h.Worker.Handle(context.Background(), workertest.NewMessage("hello")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(h.Topic.Published) != 4 || aws.StringValue(h.Topic.Published[0].TopicArn) != "arn:aws:sns:us-east-1:88888888888:Out" {
+		t.Error("unexpected published messages: ", h.Topic.Published)
+	}
+}
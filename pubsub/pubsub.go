@@ -0,0 +1,331 @@
+// Package pubsub provides a Google Cloud Pub/Sub sqsworker.Backend, so the same Processors
+// and middleware run on subscriptions in GCP. The QueueURLs of the worker are subscriptions,
+// and its dead-letter queue and QueueSinks send to topics. Both are named by their full
+// resource names, or by their ids in the Client's Project:
+//
+//	client := pubsub.New(httpClient, "my-project")
+//	w := sqsworker.NewWorker(nil, sqsworker.WorkerConfig{
+//		Backend:   client,
+//		QueueURL:  "orders-worker",
+//		Processor: processor,
+//		Sink:      client.Topic("orders-processed"),
+//	})
+//
+// The Client speaks the Pub/Sub REST API with an *http.Client adding the credentials, such
+// as one of golang.org/x/oauth2/google, so it has no dependencies. Messages are pulled with
+// unary pull requests, the streaming pull of the client libraries is only served over gRPC.
+// The ack deadline of pulled messages is extended to the worker's visibility timeout, and a
+// message made visible again is nacked with a zero ack deadline.
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultEndpoint is the url of the Pub/Sub REST API
+const DefaultEndpoint = "https://pubsub.googleapis.com/v1/"
+
+// MaxAckDeadline is the longest ack deadline Pub/Sub accepts
+const MaxAckDeadline = 600 * time.Second
+
+// ErrDelay is returned for messages sent with a delay, Pub/Sub delivers messages immediately
+var ErrDelay = errors.New("pubsub: messages cannot be delayed")
+
+// Client is a sqsworker.Backend consuming Pub/Sub subscriptions and publishing to topics
+type Client struct {
+	// HTTP sends the requests and adds their credentials, by default http.DefaultClient, which
+	// is enough for the emulator
+	HTTP *http.Client
+	// Project of the subscriptions and topics named by their ids
+	Project string
+	// Endpoint defaults to DefaultEndpoint, e.g. http://localhost:8085/v1/ for the emulator
+	Endpoint string
+}
+
+// New creates a Client for the project
+func New(client *http.Client, project string) *Client {
+	return &Client{HTTP: client, Project: project}
+}
+
+// Error is an error returned by the Pub/Sub API
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+func (e *Error) Error() string {
+	return "pubsub: " + strconv.Itoa(e.Code) + " " + e.Status + ": " + e.Message
+}
+
+type pubsubMessage struct {
+	Data        string            `json:"data,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	MessageID   string            `json:"messageId,omitempty"`
+	PublishTime string            `json:"publishTime,omitempty"`
+	OrderingKey string            `json:"orderingKey,omitempty"`
+}
+
+type receivedMessage struct {
+	AckID           string        `json:"ackId"`
+	Message         pubsubMessage `json:"message"`
+	DeliveryAttempt int           `json:"deliveryAttempt"`
+}
+
+type pullRequest struct {
+	MaxMessages       int64 `json:"maxMessages"`
+	ReturnImmediately bool  `json:"returnImmediately,omitempty"`
+}
+
+type pullResponse struct {
+	ReceivedMessages []receivedMessage `json:"receivedMessages"`
+}
+
+type ackRequest struct {
+	AckIDs []string `json:"ackIds"`
+}
+
+type modifyAckDeadlineRequest struct {
+	AckIDs             []string `json:"ackIds"`
+	AckDeadlineSeconds int64    `json:"ackDeadlineSeconds"`
+}
+
+type publishRequest struct {
+	Messages []pubsubMessage `json:"messages"`
+}
+
+type publishResponse struct {
+	MessageIDs []string `json:"messageIds"`
+}
+
+// name returns the resource name of a subscription or topic, of the kind, named by its id
+func (c *Client) name(kind, id string) string {
+	if strings.HasPrefix(id, "projects/") {
+		return id
+	}
+	return "projects/" + c.Project + "/" + kind + "/" + id
+}
+
+// call posts the request to the method of the resource, decoding the response into reply
+func (c *Client) call(ctx context.Context, resource, method string, request, reply interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint+resource+":"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		var failure struct {
+			Error *Error `json:"error"`
+		}
+		if json.Unmarshal(data, &failure) != nil || failure.Error == nil {
+			failure.Error = &Error{Code: resp.StatusCode, Status: http.StatusText(resp.StatusCode), Message: string(data)}
+		}
+		return failure.Error
+	}
+	if reply == nil {
+		return nil
+	}
+	return json.Unmarshal(data, reply)
+}
+
+// ackDeadline converts a visibility timeout to an ack deadline in seconds, at most
+// MaxAckDeadline
+func ackDeadline(timeout time.Duration) int64 {
+	if timeout > MaxAckDeadline {
+		timeout = MaxAckDeadline
+	}
+	return int64(timeout / time.Second)
+}
+
+// Receive pulls up to max messages from the subscription, waiting up to wait for them, and
+// extends their ack deadline to the visibility timeout. Messages pulled by a request that
+// times out are redelivered once their ack deadline passes. A subscription that does not
+// exist fails with the QueueDoesNotExist error of SQS, so the worker stops.
+func (c *Client) Receive(ctx context.Context, subscription string, max int64, wait, visibility time.Duration) ([]*sqs.Message, error) {
+	name := c.name("subscriptions", subscription)
+	pull, cancel := ctx, context.CancelFunc(func() {})
+	if wait > 0 {
+		pull, cancel = context.WithTimeout(ctx, wait)
+	}
+	defer cancel()
+
+	var resp pullResponse
+	err := c.call(pull, name, "pull", pullRequest{MaxMessages: max, ReturnImmediately: wait == 0}, &resp)
+	if err != nil {
+		if ctx.Err() == nil && pull.Err() != nil {
+			return nil, nil
+		}
+		if perr, ok := err.(*Error); ok && perr.Code == http.StatusNotFound {
+			return nil, awserr.New(sqs.ErrCodeQueueDoesNotExist, perr.Message, perr)
+		}
+		return nil, err
+	}
+	if len(resp.ReceivedMessages) == 0 {
+		return nil, nil
+	}
+
+	messages := make([]*sqs.Message, len(resp.ReceivedMessages))
+	ackIDs := make([]string, len(resp.ReceivedMessages))
+	for i, received := range resp.ReceivedMessages {
+		if messages[i], err = message(received); err != nil {
+			return nil, err
+		}
+		ackIDs[i] = received.AckID
+	}
+	// the messages keep the subscription's ack deadline when it cannot be extended
+	if visibility > 0 {
+		c.call(ctx, name, "modifyAckDeadline", modifyAckDeadlineRequest{AckIDs: ackIDs, AckDeadlineSeconds: ackDeadline(visibility)}, nil)
+	}
+	return messages, nil
+}
+
+// message converts a pulled message, its ackId is the ReceiptHandle
+func message(received receivedMessage) (*sqs.Message, error) {
+	body, err := base64.StdEncoding.DecodeString(received.Message.Data)
+	if err != nil {
+		return nil, err
+	}
+	m := &sqs.Message{
+		MessageId:     aws.String(received.Message.MessageID),
+		ReceiptHandle: aws.String(received.AckID),
+		Body:          aws.String(string(body)),
+		Attributes:    make(map[string]*string),
+	}
+	if published, err := time.Parse(time.RFC3339Nano, received.Message.PublishTime); err == nil {
+		m.Attributes[sqs.MessageSystemAttributeNameSentTimestamp] = aws.String(strconv.FormatInt(published.UnixNano()/int64(time.Millisecond), 10))
+	}
+	// the delivery attempt is only counted by subscriptions with a dead letter policy
+	if received.DeliveryAttempt > 0 {
+		m.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount] = aws.String(strconv.Itoa(received.DeliveryAttempt))
+	}
+	if received.Message.OrderingKey != "" {
+		m.Attributes[sqs.MessageSystemAttributeNameMessageGroupId] = aws.String(received.Message.OrderingKey)
+	}
+	if len(received.Message.Attributes) > 0 {
+		m.MessageAttributes = make(map[string]*sqs.MessageAttributeValue, len(received.Message.Attributes))
+		for name, value := range received.Message.Attributes {
+			m.MessageAttributes[name] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+		}
+	}
+	return m, nil
+}
+
+// Delete acknowledges the message
+func (c *Client) Delete(ctx context.Context, subscription, receiptHandle string) error {
+	return c.call(ctx, c.name("subscriptions", subscription), "acknowledge", ackRequest{AckIDs: []string{receiptHandle}}, nil)
+}
+
+// ChangeVisibility sets the ack deadline of the message to the timeout, at most
+// MaxAckDeadline. A zero timeout nacks the message, which is redelivered immediately.
+func (c *Client) ChangeVisibility(ctx context.Context, subscription, receiptHandle string, timeout time.Duration) error {
+	return c.call(ctx, c.name("subscriptions", subscription), "modifyAckDeadline", modifyAckDeadlineRequest{
+		AckIDs:             []string{receiptHandle},
+		AckDeadlineSeconds: ackDeadline(timeout),
+	}, nil)
+}
+
+// Send publishes the message to the topic, with its MessageGroupId as ordering key. Message
+// attributes are sent as strings, binary values encoded in base64.
+func (c *Client) Send(ctx context.Context, topic string, input *sqs.SendMessageInput) (string, error) {
+	if aws.Int64Value(input.DelaySeconds) > 0 {
+		return "", ErrDelay
+	}
+	m := pubsubMessage{
+		Data:        base64.StdEncoding.EncodeToString([]byte(aws.StringValue(input.MessageBody))),
+		OrderingKey: aws.StringValue(input.MessageGroupId),
+	}
+	if len(input.MessageAttributes) > 0 {
+		m.Attributes = make(map[string]string, len(input.MessageAttributes))
+		for name, value := range input.MessageAttributes {
+			m.Attributes[name] = attribute(value.StringValue, value.BinaryValue)
+		}
+	}
+	return c.publish(ctx, topic, m)
+}
+
+// attribute returns the string value of a message attribute
+func attribute(value *string, binary []byte) string {
+	if value != nil {
+		return *value
+	}
+	return base64.StdEncoding.EncodeToString(binary)
+}
+
+func (c *Client) publish(ctx context.Context, topic string, m pubsubMessage) (string, error) {
+	var resp publishResponse
+	if err := c.call(ctx, c.name("topics", topic), "publish", publishRequest{Messages: []pubsubMessage{m}}, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.MessageIDs) == 0 {
+		return "", errors.New("pubsub: no message id was returned")
+	}
+	return resp.MessageIDs[0], nil
+}
+
+// Topic is a sqsworker.Sink publishing results to a Pub/Sub topic, with their message
+// attributes and MessageGroupId as ordering key. Results using features Pub/Sub does not
+// have, such as a subject, are rejected.
+type Topic struct {
+	Client *Client
+	Name   string
+}
+
+// Topic returns a Sink publishing to the topic
+func (c *Client) Topic(name string) *Topic {
+	return &Topic{Client: c, Name: name}
+}
+
+// Send publishes the result. Results that cannot be published fail with a Fatal error.
+func (t *Topic) Send(ctx context.Context, m *sqs.Message, output *sns.PublishInput) error {
+	if output.Subject != nil || output.MessageStructure != nil {
+		return sqsworker.Fatal(errors.New("pubsub: results cannot have a Subject or MessageStructure"))
+	}
+	msg := pubsubMessage{
+		Data:        base64.StdEncoding.EncodeToString([]byte(aws.StringValue(output.Message))),
+		OrderingKey: aws.StringValue(output.MessageGroupId),
+	}
+	if len(output.MessageAttributes) > 0 {
+		msg.Attributes = make(map[string]string, len(output.MessageAttributes))
+		for name, value := range output.MessageAttributes {
+			msg.Attributes[name] = attribute(value.StringValue, value.BinaryValue)
+		}
+	}
+	_, err := t.Client.publish(ctx, t.Name, msg)
+	return err
+}
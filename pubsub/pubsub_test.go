@@ -0,0 +1,162 @@
+package pubsub_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/pubsub"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Server is a Pub/Sub API serving the subscription "orders" of the project "p" once, and
+// recording the requests made to its messages
+type Server struct {
+	mu        sync.Mutex
+	pending   []map[string]interface{}
+	Acked     chan string
+	Deadlines map[string]float64
+	Published []map[string]interface{}
+}
+
+func NewServer(bodies ...string) *Server {
+	s := &Server{Acked: make(chan string, 10), Deadlines: make(map[string]float64)}
+	for i, body := range bodies {
+		s.pending = append(s.pending, map[string]interface{}{
+			"ackId":           "ack-" + body,
+			"deliveryAttempt": i + 1,
+			"message": map[string]interface{}{
+				"data":        base64.StdEncoding.EncodeToString([]byte(body)),
+				"messageId":   "id-" + body,
+				"publishTime": "2021-03-04T05:06:07.5Z",
+				"attributes":  map[string]string{"tenant": "acme"},
+				"orderingKey": "customer-1",
+			},
+		})
+	}
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&request)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/v1/projects/p/subscriptions/orders:pull":
+		messages := s.pending
+		s.pending = nil
+		json.NewEncoder(w).Encode(map[string]interface{}{"receivedMessages": messages})
+	case "/v1/projects/p/subscriptions/orders:acknowledge":
+		for _, id := range request["ackIds"].([]interface{}) {
+			s.Acked <- id.(string)
+		}
+		w.Write([]byte("{}"))
+	case "/v1/projects/p/subscriptions/orders:modifyAckDeadline":
+		for _, id := range request["ackIds"].([]interface{}) {
+			s.Deadlines[id.(string)] = request["ackDeadlineSeconds"].(float64)
+		}
+		w.Write([]byte("{}"))
+	case "/v1/projects/p/topics/processed:publish":
+		s.Published = append(s.Published, request["messages"].([]interface{})[0].(map[string]interface{}))
+		w.Write([]byte(`{"messageIds": ["1"]}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": {"code": 404, "message": "Resource not found", "status": "NOT_FOUND"}}`))
+	}
+}
+
+func newClient(s *Server) (*pubsub.Client, func()) {
+	server := httptest.NewServer(s)
+	client := pubsub.New(server.Client(), "p")
+	client.Endpoint = server.URL + "/v1/"
+	return client, server.Close
+}
+
+func TestReceive(t *testing.T) {
+	s := NewServer("Hello")
+	client, stop := newClient(s)
+	defer stop()
+
+	messages, err := client.Receive(context.Background(), "orders", 10, time.Second, 90*time.Second)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("unexpected receive %v %v", messages, err)
+	}
+	m := messages[0]
+	if aws.StringValue(m.Body) != "Hello" || aws.StringValue(m.ReceiptHandle) != "ack-Hello" || aws.StringValue(m.MessageId) != "id-Hello" {
+		t.Errorf("unexpected message %v", m)
+	}
+	if aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameSentTimestamp]) != "1614834367500" ||
+		aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]) != "1" ||
+		aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]) != "customer-1" ||
+		aws.StringValue(m.MessageAttributes["tenant"].StringValue) != "acme" {
+		t.Errorf("unexpected attributes %v %v", m.Attributes, m.MessageAttributes)
+	}
+	if s.Deadlines["ack-Hello"] != 90 {
+		t.Errorf("expected the ack deadline to be extended, got %v", s.Deadlines)
+	}
+
+	// a zero visibility nacks the message
+	if err := client.ChangeVisibility(context.Background(), "orders", "ack-Hello", 0); err != nil || s.Deadlines["ack-Hello"] != 0 {
+		t.Errorf("expected the message to be nacked %v %v", s.Deadlines, err)
+	}
+
+	_, err = client.Receive(context.Background(), "deleted", 10, time.Second, 0)
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != sqs.ErrCodeQueueDoesNotExist {
+		t.Errorf("expected a missing subscription to fail, got %v", err)
+	}
+}
+
+func TestWorker(t *testing.T) {
+	s := NewServer("Hello", "World")
+	client, stop := newClient(s)
+	defer stop()
+
+	w := sqsworker.NewWorker(nil, sqsworker.WorkerConfig{
+		Backend:  client,
+		QueueURL: "orders",
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			return &sns.PublishInput{Message: aws.String(strings.ToLower(*m.Body)), MessageGroupId: aws.String("lower")}, nil
+		}),
+		Sink:   client.Topic("processed"),
+		Logger: zap.NewNop(),
+	})
+	go w.Run()
+	defer w.Close()
+
+	acked := map[string]bool{}
+	for len(acked) < 2 {
+		select {
+		case id := <-s.Acked:
+			acked[id] = true
+		case <-time.After(time.Second):
+			t.Fatal("messages were not acknowledged")
+		}
+	}
+	if !acked["ack-Hello"] || !acked["ack-World"] {
+		t.Errorf("unexpected acks %v", acked)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.Published) != 2 || s.Published[0]["orderingKey"] != "lower" {
+		t.Fatalf("unexpected publishes %v", s.Published)
+	}
+	for _, published := range s.Published {
+		data, _ := base64.StdEncoding.DecodeString(published["data"].(string))
+		if string(data) != "hello" && string(data) != "world" {
+			t.Errorf("unexpected result %s", data)
+		}
+	}
+}
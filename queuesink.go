@@ -0,0 +1,141 @@
+package sqsworker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MaxQueueBatchSize is the most messages SendMessageBatch accepts at once
+const MaxQueueBatchSize = 10
+
+// QueueConfig settings for a QueueSink
+type QueueConfig struct {
+	QueueURL string
+	// DelaySeconds delays the delivery of every result, unless Delay is set to choose the delay
+	// of each result. FIFO queues only have a queue-wide delay, so results sent to them cannot
+	// be delayed.
+	DelaySeconds int64
+	Delay        func(*sqs.Message, *sns.PublishInput) int64
+	// BatchSize and BatchWindow batch messages as described on Sink. BatchSize is at most
+	// MaxQueueBatchSize.
+	BatchSize   int
+	BatchWindow time.Duration
+	// VerifyMD5 checks the checksums SQS returns for the messages sent, for a Client that does
+	// not check them, like that of a worker with VerifyMD5. Mismatches are counted in
+	// ChecksumMismatches rather than failing the results, since the messages were stored.
+	VerifyMD5 bool
+}
+
+// QueueSink is a Sink sending results to an SQS queue, with their message attributes and FIFO
+// fields. Results using features SQS does not have, such as a subject, are rejected.
+type QueueSink struct {
+	Client     sqsiface.SQSAPI
+	config     QueueConfig
+	batch      *batcher
+	mismatches int64
+}
+
+// NewQueueSink creates a QueueSink
+func NewQueueSink(client sqsiface.SQSAPI, config QueueConfig) *QueueSink {
+	if config.BatchSize > MaxQueueBatchSize {
+		config.BatchSize = MaxQueueBatchSize
+	}
+	s := &QueueSink{Client: client, config: config}
+	s.batch = newBatcher(config.BatchSize, config.BatchWindow, s.send)
+	return s
+}
+
+// Send sends the result to the queue. Results that cannot be sent to a queue fail with a Fatal
+// error.
+func (s *QueueSink) Send(ctx context.Context, m *sqs.Message, output *sns.PublishInput) error {
+	input, err := sendMessageInput(s.config.QueueURL, output)
+	if err != nil {
+		return Fatal(err)
+	}
+
+	delay := s.config.DelaySeconds
+	if s.config.Delay != nil {
+		delay = s.config.Delay(m, output)
+	}
+	if delay > 0 {
+		if strings.HasSuffix(s.config.QueueURL, ".fifo") {
+			return Fatal(errors.New("sqsworker: results sent to FIFO queue " + s.config.QueueURL + " cannot be delayed"))
+		}
+		input.DelaySeconds = aws.Int64(delay)
+	}
+	return s.batch.send(ctx, input)
+}
+
+// ChecksumMismatches returns the number of messages sent whose checksums did not match, with
+// VerifyMD5
+func (s *QueueSink) ChecksumMismatches() int64 {
+	return atomic.LoadInt64(&s.mismatches)
+}
+
+// mismatch counts a message whose checksums did not match
+func (s *QueueSink) mismatch(error) {
+	atomic.AddInt64(&s.mismatches, 1)
+}
+
+// send sends a batch of messages, returning the error of each
+func (s *QueueSink) send(ctx context.Context, values []interface{}) []error {
+	errs := make([]error, len(values))
+	if len(values) == 1 {
+		var mismatch func(error)
+		if s.config.VerifyMD5 {
+			mismatch = s.mismatch
+		}
+		_, errs[0] = sendChecked(s.Client, values[0].(*sqs.SendMessageInput), mismatch)
+		return errs
+	}
+
+	input := &sqs.SendMessageBatchInput{
+		QueueUrl: aws.String(s.config.QueueURL),
+		Entries:  make([]*sqs.SendMessageBatchRequestEntry, len(values)),
+	}
+	for i, value := range values {
+		send := value.(*sqs.SendMessageInput)
+		input.Entries[i] = &sqs.SendMessageBatchRequestEntry{
+			Id:                     aws.String(strconv.Itoa(i)),
+			MessageBody:            send.MessageBody,
+			MessageAttributes:      send.MessageAttributes,
+			MessageGroupId:         send.MessageGroupId,
+			MessageDeduplicationId: send.MessageDeduplicationId,
+			DelaySeconds:           send.DelaySeconds,
+		}
+	}
+
+	out, err := s.Client.SendMessageBatch(input)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	for _, sent := range out.Successful {
+		i, err := strconv.Atoi(aws.StringValue(sent.Id))
+		if err != nil || i < 0 || i >= len(errs) || !s.config.VerifyMD5 {
+			continue
+		}
+		if err := verifyChecksums(input.Entries[i].MessageBody, input.Entries[i].MessageAttributes, sent.MD5OfMessageBody, sent.MD5OfMessageAttributes); err != nil {
+			s.mismatch(err)
+		}
+	}
+	for _, failed := range out.Failed {
+		i, err := strconv.Atoi(aws.StringValue(failed.Id))
+		if err != nil || i < 0 || i >= len(errs) {
+			continue
+		}
+		errs[i] = fmt.Errorf("sqsworker: send message failed: %s: %s", aws.StringValue(failed.Code), aws.StringValue(failed.Message))
+	}
+	return errs
+}
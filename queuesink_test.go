@@ -0,0 +1,101 @@
+package sqsworker_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"sync"
+	"testing"
+	"time"
+)
+
+// BatchQueue records the message batches sent to it, failing the messages with the body "fail"
+type BatchQueue struct {
+	workertest.Queue
+	mu      sync.Mutex
+	Batches []*sqs.SendMessageBatchInput
+}
+
+func (b *BatchQueue) SendMessageBatch(input *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.Batches = append(b.Batches, input)
+
+	out := &sqs.SendMessageBatchOutput{}
+	for _, entry := range input.Entries {
+		if aws.StringValue(entry.MessageBody) == "fail" {
+			out.Failed = append(out.Failed, &sqs.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("InternalError"), Message: aws.String("failed")})
+			continue
+		}
+		out.Successful = append(out.Successful, &sqs.SendMessageBatchResultEntry{Id: entry.Id, MessageId: aws.String("sent")})
+	}
+	return out, nil
+}
+
+func TestQueueSink(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: &EventWorker{Output: func(m *sqs.Message) *sns.PublishInput {
+			output := eventOutput(m)
+			output.MessageGroupId = aws.String("group")
+			return output
+		}},
+	})
+	h.Worker.Sink = sqsworker.NewQueueSink(h.Queue, sqsworker.QueueConfig{QueueURL: auditQueueURL, DelaySeconds: 30})
+
+	result := h.Run(workertest.NewMessage("viewed", workertest.Attribute("event", "audit"))).Succeeded().Deleted().NotPublished()
+	if len(result.Sent) != 1 {
+		t.Fatal("unexpected sent messages: ", result.Sent)
+	}
+	sent := result.Sent[0]
+	if aws.StringValue(sent.QueueUrl) != auditQueueURL || aws.StringValue(sent.MessageBody) != "viewed" ||
+		aws.Int64Value(sent.DelaySeconds) != 30 || aws.StringValue(sent.MessageGroupId) != "group" ||
+		aws.StringValue(sent.MessageAttributes["event"].StringValue) != "audit" {
+		t.Error("unexpected sent message: ", sent)
+	}
+
+	// FIFO queues cannot delay single messages
+	h.Worker.Sink = sqsworker.NewQueueSink(h.Queue, sqsworker.QueueConfig{QueueURL: auditQueueURL + ".fifo", DelaySeconds: 30})
+	result = h.Run(workertest.NewMessage("viewed", workertest.Attribute("event", "audit"))).Failed().NotDeleted()
+	if !sqsworker.IsFatal(result.Err) {
+		t.Error("Expected a fatal error, got: ", result.Err)
+	}
+}
+
+func TestQueueSinkBatches(t *testing.T) {
+	queue := &BatchQueue{}
+	sink := sqsworker.NewQueueSink(queue, sqsworker.QueueConfig{
+		QueueURL:    auditQueueURL,
+		Delay:       func(m *sqs.Message, output *sns.PublishInput) int64 { return int64(len(*output.Message)) },
+		BatchSize:   3,
+		BatchWindow: time.Second,
+	})
+
+	bodies := []string{"a", "fail", "abc"}
+	errs := make([]error, len(bodies))
+	var wg sync.WaitGroup
+	for i, body := range bodies {
+		wg.Add(1)
+		go func(i int, body string) {
+			defer wg.Done()
+			errs[i] = sink.Send(context.Background(), workertest.NewMessage(body), &sns.PublishInput{Message: aws.String(body)})
+		}(i, body)
+	}
+	wg.Wait()
+
+	if len(queue.Batches) != 1 || len(queue.Batches[0].Entries) != 3 {
+		t.Fatal("unexpected batches: ", queue.Batches)
+	}
+	for _, entry := range queue.Batches[0].Entries {
+		if delay := aws.Int64Value(entry.DelaySeconds); delay != int64(len(*entry.MessageBody)) {
+			t.Error("unexpected delay: ", entry)
+		}
+	}
+	for i, err := range errs {
+		if (err != nil) != (bodies[i] == "fail") {
+			t.Error("unexpected error for ", bodies[i], ": ", err)
+		}
+	}
+}
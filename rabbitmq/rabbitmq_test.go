@@ -0,0 +1,165 @@
+package rabbitmq_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/rabbitmq"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Channel delivers the messages pushed to the queue "orders", recording their acks and the
+// messages published
+type Channel struct {
+	mu         sync.Mutex
+	deliveries chan amqp.Delivery
+	tag        uint64
+	Prefetch   int
+	Acks       chan uint64
+	Nacks      chan uint64
+	Published  []amqp.Publishing
+}
+
+func NewChannel() *Channel {
+	return &Channel{deliveries: make(chan amqp.Delivery, 10), Acks: make(chan uint64, 10), Nacks: make(chan uint64, 10)}
+}
+
+func (c *Channel) Push(body string, headers amqp.Table) {
+	c.mu.Lock()
+	c.tag++
+	c.deliveries <- amqp.Delivery{Acknowledger: c, DeliveryTag: c.tag, Body: []byte(body), Headers: headers}
+	c.mu.Unlock()
+}
+
+func (c *Channel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	c.Prefetch = prefetchCount
+	return nil
+}
+
+func (c *Channel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	if queue != "orders" || autoAck {
+		return nil, &amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND - no queue '" + queue + "'"}
+	}
+	return c.deliveries, nil
+}
+
+func (c *Channel) PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (*amqp.DeferredConfirmation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msg.Type = exchange + "/" + key
+	c.Published = append(c.Published, msg)
+	return nil, nil
+}
+
+func (c *Channel) Ack(tag uint64, multiple bool) error {
+	c.Acks <- tag
+	return nil
+}
+
+func (c *Channel) Nack(tag uint64, multiple bool, requeue bool) error {
+	if requeue {
+		c.Nacks <- tag
+	}
+	return nil
+}
+
+func (c *Channel) Reject(tag uint64, requeue bool) error {
+	return c.Nack(tag, false, requeue)
+}
+
+func TestWorker(t *testing.T) {
+	ch := NewChannel()
+	ch.Push("Hello", nil)
+	ch.Push("World", nil)
+
+	w := sqsworker.NewWorker(nil, sqsworker.WorkerConfig{
+		Backend:  rabbitmq.New(ch, 2),
+		QueueURL: "orders",
+		Workers:  2,
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			return &sns.PublishInput{Message: aws.String(strings.ToLower(*m.Body))}, nil
+		}),
+		Sink:   rabbitmq.NewExchange(ch, "processed", "orders"),
+		Logger: zap.NewNop(),
+	})
+	go w.Run()
+	defer w.Close()
+
+	acked := map[uint64]bool{}
+	for len(acked) < 2 {
+		select {
+		case tag := <-ch.Acks:
+			acked[tag] = true
+		case <-time.After(time.Second):
+			t.Fatal("messages were not acknowledged")
+		}
+	}
+	if ch.Prefetch != 2 {
+		t.Errorf("expected a prefetch of 2, got %d", ch.Prefetch)
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if len(ch.Published) != 2 {
+		t.Fatalf("unexpected publishes %v", ch.Published)
+	}
+	for _, p := range ch.Published {
+		if p.Type != "processed/orders" || p.MessageId == "" || p.DeliveryMode != amqp.Persistent || string(p.Body) != "hello" && string(p.Body) != "world" {
+			t.Errorf("unexpected publish %+v", p)
+		}
+	}
+}
+
+func TestVisibility(t *testing.T) {
+	ch := NewChannel()
+	backend := rabbitmq.New(ch, 0)
+	ctx := context.Background()
+
+	ch.Push("Hello", amqp.Table{rabbitmq.DeliveryCountHeader: int64(2), "tenant": "acme"})
+	messages, err := backend.Receive(ctx, "orders", 10, time.Second, 20*time.Millisecond)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("unexpected receive %v %v", messages, err)
+	}
+	m := messages[0]
+	if aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]) != "3" || aws.StringValue(m.MessageAttributes["tenant"].StringValue) != "acme" {
+		t.Errorf("unexpected attributes %v %v", m.Attributes, m.MessageAttributes)
+	}
+
+	// the message is requeued once its visibility timeout passes
+	select {
+	case tag := <-ch.Nacks:
+		if tag != 1 {
+			t.Errorf("unexpected nack %d", tag)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the message was not requeued")
+	}
+	if err := backend.Delete(ctx, "orders", *m.ReceiptHandle); err != rabbitmq.ErrExpired {
+		t.Errorf("expected the lease to be expired, got %v", err)
+	}
+
+	// a zero visibility requeues the message immediately
+	ch.Push("World", nil)
+	messages, _ = backend.Receive(ctx, "orders", 10, time.Second, time.Minute)
+	if err := backend.ChangeVisibility(ctx, "orders", *messages[0].ReceiptHandle, 0); err != nil || <-ch.Nacks != 2 {
+		t.Errorf("expected the message to be requeued %v", err)
+	}
+
+	// nothing is received within the wait
+	if messages, err := backend.Receive(ctx, "orders", 10, 10*time.Millisecond, time.Minute); err != nil || len(messages) != 0 {
+		t.Errorf("unexpected receive %v %v", messages, err)
+	}
+
+	_, err = backend.Receive(ctx, "deleted", 10, 0, 0)
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != sqs.ErrCodeQueueDoesNotExist {
+		t.Errorf("expected a missing queue to fail, got %v", err)
+	}
+}
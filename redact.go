@@ -0,0 +1,81 @@
+package sqsworker
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultReplacement replaces the values a Redactor removes
+const DefaultReplacement = "[REDACTED]"
+
+// Redactor removes sensitive data from message bodies, results and errors before they are logged
+type Redactor struct {
+	// Fields are the paths of the JSON fields whose values are replaced, separated by dots, with
+	// * matching any field or array element, e.g. "customer.email" or "cards.*.number"
+	Fields []string
+	// Patterns replace the text they match, also in bodies that are not JSON and in errors
+	Patterns []*regexp.Regexp
+	// Replacement defaults to DefaultReplacement
+	Replacement string
+}
+
+// Redact returns the text with the sensitive data replaced. JSON objects and arrays have the
+// values of the Fields replaced, and are re-encoded with their keys sorted.
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	replacement := r.Replacement
+	if replacement == "" {
+		replacement = DefaultReplacement
+	}
+
+	if len(r.Fields) > 0 {
+		if trimmed := strings.TrimSpace(s); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			var value interface{}
+			d := json.NewDecoder(strings.NewReader(s))
+			d.UseNumber()
+			if err := d.Decode(&value); err == nil {
+				for _, field := range r.Fields {
+					value = redactPath(value, strings.Split(field, "."), replacement)
+				}
+				var buf bytes.Buffer
+				e := json.NewEncoder(&buf)
+				e.SetEscapeHTML(false)
+				if err := e.Encode(value); err == nil {
+					s = strings.TrimSuffix(buf.String(), "\n")
+				}
+			}
+		}
+	}
+	for _, pattern := range r.Patterns {
+		s = pattern.ReplaceAllLiteralString(s, replacement)
+	}
+	return s
+}
+
+// redactPath replaces the values at the path within a decoded JSON value
+func redactPath(value interface{}, path []string, replacement string) interface{} {
+	if len(path) == 0 {
+		return replacement
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if path[0] == "*" || path[0] == key {
+				v[key] = redactPath(child, path[1:], replacement)
+			}
+		}
+	case []interface{}:
+		// array elements are matched by * or their index
+		for i, child := range v {
+			if path[0] == "*" || path[0] == strconv.Itoa(i) {
+				v[i] = redactPath(child, path[1:], replacement)
+			}
+		}
+	}
+	return value
+}
@@ -0,0 +1,69 @@
+package sqsworker_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	r := &sqsworker.Redactor{
+		Fields:   []string{"customer.email", "cards.*.number"},
+		Patterns: []*regexp.Regexp{regexp.MustCompile(`\d{3}-\d{2}-\d{4}`)},
+	}
+	cases := []struct{ body, expected string }{
+		{
+			`{"id":1,"customer":{"email":"a@example.com","name":"A"},"cards":[{"number":"4111"},{"number":"5500"}]}`,
+			`{"cards":[{"number":"[REDACTED]"},{"number":"[REDACTED]"}],"customer":{"email":"[REDACTED]","name":"A"},"id":1}`,
+		},
+		{`{"note":"ssn 123-45-6789"}`, `{"note":"ssn [REDACTED]"}`},
+		{`ssn 123-45-6789 for a@example.com`, `ssn [REDACTED] for a@example.com`},
+		{`{"customer": invalid`, `{"customer": invalid`},
+	}
+	for _, c := range cases {
+		if actual := r.Redact(c.body); actual != c.expected {
+			t.Error("Actual: ", actual, "Expected: ", c.expected)
+		}
+	}
+
+	var none *sqsworker.Redactor
+	if actual := none.Redact("a@example.com"); actual != "a@example.com" {
+		t.Error("Actual: ", actual, "Expected: ", "a@example.com")
+	}
+}
+
+func TestRedactLogs(t *testing.T) {
+	core, logs := observer.New(zap.ErrorLevel)
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			return nil, fmt.Errorf("invalid order %s", *m.Body)
+		}),
+		Logger:    zap.New(core),
+		LogBodies: true,
+		Redactor: &sqsworker.Redactor{
+			Fields:   []string{"email"},
+			Patterns: []*regexp.Regexp{regexp.MustCompile(`[^"\s]+@[^"\s]+`)},
+		},
+	})
+
+	h.Run(workertest.NewMessage(`{"email":"a@example.com"}`)).Failed()
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatal("unexpected log entries: ", entries)
+	}
+	fields := entries[0].ContextMap()
+	if body := fields["body"]; body != `{"email":"[REDACTED]"}` {
+		t.Error("Actual: ", body, "Expected: ", `{"email":"[REDACTED]"}`)
+	}
+	if strings.Contains(entries[0].Message, "a@example.com") || strings.Contains(fmt.Sprint(fields["error"]), "a@example.com") {
+		t.Error("Expected the error to be redacted, got: ", entries[0].Message, fields["error"])
+	}
+}
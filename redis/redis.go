@@ -0,0 +1,527 @@
+// Package redis provides a Redis Streams sqsworker.Backend, a lightweight broker for
+// development and small deployments with no AWS in the loop. The QueueURLs of the worker are
+// stream keys, consumed by the Backend's consumer group:
+//
+//	backend := redis.New("localhost:6379", "orders-worker")
+//	defer backend.Close()
+//	w := sqsworker.NewWorker(nil, sqsworker.WorkerConfig{
+//		Backend:   backend,
+//		QueueURL:  "orders",
+//		Processor: processor,
+//		Sink:      backend.Stream("orders:processed"),
+//	})
+//
+// New messages are read with XREADGROUP, and deleting a message acknowledges it with XACK.
+// Messages pending in the group for longer than the visibility timeout, because their
+// processing failed or their consumer went away, are claimed again with XAUTOCLAIM, so
+// Redis 6.2 or later is needed. The group is created at the start of the stream when it does
+// not exist. The Backend speaks the Redis protocol itself, so it has no dependencies.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BodyField is the field of stream entries holding the message body, their other fields are
+// its message attributes
+const BodyField = "body"
+
+// DefaultIdle is the number of idle connections a Backend keeps open
+const DefaultIdle = 4
+
+// DefaultVisibility is the visibility timeout of messages received with none
+const DefaultVisibility = sqsworker.DefaultVisibilityTimeout * time.Second
+
+// ErrDelay is returned for messages sent with a delay, stream entries are read immediately
+var ErrDelay = errors.New("redis: messages cannot be delayed")
+
+// ErrNotReceived is returned for a receipt handle of a message that is not pending in the
+// group, because it was acknowledged
+var ErrNotReceived = errors.New("redis: the message is not received")
+
+// Backend is a sqsworker.Backend reading streams as the Consumer of the Group
+type Backend struct {
+	// Addr is the host:port of the Redis server
+	Addr string
+	// Password and DB are sent when a connection is opened, when they are set
+	Password string
+	DB       int
+	// Group is the consumer group reading the streams
+	Group string
+	// Consumer names this Backend in the group, by default the host name and process id
+	Consumer string
+	// MaxLen caps the length of the streams messages are sent to, trimming the oldest entries
+	// approximately, when it is set
+	MaxLen int64
+	// Idle is the number of idle connections kept open, by default DefaultIdle
+	Idle int
+	mu   sync.Mutex
+	idle []*conn
+	// visibility of the last receive of each stream, which ChangeVisibility counts from
+	visibility map[string]time.Duration
+}
+
+// New creates a Backend for the server at addr, reading streams as the group
+func New(addr, group string) *Backend {
+	host, _ := os.Hostname()
+	return &Backend{Addr: addr, Group: group, Consumer: host + "-" + strconv.Itoa(os.Getpid())}
+}
+
+// Receive claims up to max messages pending for longer than the visibility timeout, or reads
+// up to max new messages, blocking up to wait for them
+func (b *Backend) Receive(ctx context.Context, stream string, max int64, wait, visibility time.Duration) ([]*sqs.Message, error) {
+	if visibility == 0 {
+		visibility = DefaultVisibility
+	}
+	b.mu.Lock()
+	if b.visibility == nil {
+		b.visibility = make(map[string]time.Duration)
+	}
+	b.visibility[stream] = visibility
+	b.mu.Unlock()
+
+	count := strconv.FormatInt(max, 10)
+	reply, err := b.group(ctx, stream, "XAUTOCLAIM", stream, b.Group, b.Consumer, milliseconds(visibility), "0-0", "COUNT", count)
+	if err != nil {
+		return nil, err
+	}
+	if claimed, ok := reply.([]interface{}); ok && len(claimed) > 1 {
+		entries, _ := claimed[1].([]interface{})
+		messages, err := b.messages(ctx, stream, entries, true)
+		if err != nil || len(messages) > 0 {
+			return messages, err
+		}
+	}
+
+	args := []string{"XREADGROUP", "GROUP", b.Group, b.Consumer, "COUNT", count}
+	if wait > 0 {
+		args = append(args, "BLOCK", milliseconds(wait))
+	}
+	reply, err = b.group(ctx, stream, append(args, "STREAMS", stream, ">")...)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	streams, ok := reply.([]interface{})
+	if !ok || len(streams) == 0 {
+		return nil, fmt.Errorf("redis: unexpected reply to XREADGROUP: %v", reply)
+	}
+	read, ok := streams[0].([]interface{})
+	if !ok || len(read) != 2 {
+		return nil, fmt.Errorf("redis: unexpected reply to XREADGROUP: %v", reply)
+	}
+	entries, _ := read[1].([]interface{})
+	return b.messages(ctx, stream, entries, false)
+}
+
+// group runs a command reading the stream as the group, creating the group when it does not
+// exist
+func (b *Backend) group(ctx context.Context, stream string, args ...string) (interface{}, error) {
+	reply, err := b.do(ctx, args...)
+	if e, ok := err.(redisError); !ok || !strings.HasPrefix(string(e), "NOGROUP") {
+		return reply, err
+	}
+	_, err = b.do(ctx, "XGROUP", "CREATE", stream, b.Group, "0", "MKSTREAM")
+	if e, ok := err.(redisError); err != nil && (!ok || !strings.HasPrefix(string(e), "BUSYGROUP")) {
+		return nil, err
+	}
+	return b.do(ctx, args...)
+}
+
+// messages converts stream entries, looking up the receive counts of claimed entries.
+// Claimed entries that were deleted from the stream are acknowledged.
+func (b *Backend) messages(ctx context.Context, stream string, entries []interface{}, claimed bool) ([]*sqs.Message, error) {
+	messages := make([]*sqs.Message, 0, len(entries))
+	for _, e := range entries {
+		entry, ok := e.([]interface{})
+		if !ok || len(entry) != 2 {
+			return messages, fmt.Errorf("redis: unexpected stream entry %v", e)
+		}
+		id, _ := entry[0].([]byte)
+		fields, ok := entry[1].([]interface{})
+		if !ok {
+			if _, err := b.do(ctx, "XACK", stream, b.Group, string(id)); err != nil {
+				return messages, err
+			}
+			continue
+		}
+		count := "1"
+		if claimed {
+			var err error
+			if count, err = b.deliveries(ctx, stream, string(id)); err != nil {
+				return messages, err
+			}
+		}
+		messages = append(messages, message(string(id), fields, count))
+	}
+	return messages, nil
+}
+
+// deliveries returns the number of times a pending entry was delivered
+func (b *Backend) deliveries(ctx context.Context, stream, id string) (string, error) {
+	reply, err := b.do(ctx, "XPENDING", stream, b.Group, id, id, "1")
+	if err != nil {
+		return "", err
+	}
+	if pending, ok := reply.([]interface{}); ok && len(pending) == 1 {
+		if entry, ok := pending[0].([]interface{}); ok && len(entry) == 4 {
+			if n, ok := entry[3].(int64); ok {
+				return strconv.FormatInt(n, 10), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("redis: unexpected reply to XPENDING: %v", reply)
+}
+
+// message converts a stream entry, its id is its MessageId and ReceiptHandle and holds the
+// time it was added in milliseconds
+func message(id string, fields []interface{}, count string) *sqs.Message {
+	m := &sqs.Message{
+		MessageId:     aws.String(id),
+		ReceiptHandle: aws.String(id),
+		Attributes: map[string]*string{
+			sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String(count),
+		},
+	}
+	if i := strings.IndexByte(id, '-'); i > 0 {
+		m.Attributes[sqs.MessageSystemAttributeNameSentTimestamp] = aws.String(id[:i])
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		name, _ := fields[i].([]byte)
+		value, _ := fields[i+1].([]byte)
+		if string(name) == BodyField {
+			m.Body = aws.String(string(value))
+			continue
+		}
+		if m.MessageAttributes == nil {
+			m.MessageAttributes = make(map[string]*sqs.MessageAttributeValue)
+		}
+		m.MessageAttributes[string(name)] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(string(value))}
+	}
+	return m
+}
+
+// Delete acknowledges the message in the group, the entry stays in the stream until it is
+// trimmed
+func (b *Backend) Delete(ctx context.Context, stream, receiptHandle string) error {
+	reply, err := b.do(ctx, "XACK", stream, b.Group, receiptHandle)
+	if err != nil {
+		return err
+	}
+	if n, _ := reply.(int64); n == 0 {
+		return ErrNotReceived
+	}
+	return nil
+}
+
+// ChangeVisibility sets the idle time of the pending message, so it is claimed again once the
+// timeout has passed. A message cannot be hidden for longer than the visibility timeout of
+// the receives of its stream.
+func (b *Backend) ChangeVisibility(ctx context.Context, stream, receiptHandle string, timeout time.Duration) error {
+	b.mu.Lock()
+	visibility, ok := b.visibility[stream]
+	b.mu.Unlock()
+	if !ok {
+		visibility = DefaultVisibility
+	}
+	idle := visibility - timeout
+	if idle < 0 {
+		idle = 0
+	}
+	reply, err := b.do(ctx, "XCLAIM", stream, b.Group, b.Consumer, "0", receiptHandle, "IDLE", milliseconds(idle), "JUSTID")
+	if err != nil {
+		return err
+	}
+	if ids, _ := reply.([]interface{}); len(ids) == 0 {
+		return ErrNotReceived
+	}
+	return nil
+}
+
+// Send adds the message to the stream, with its message attributes as fields, returning the
+// id of the entry
+func (b *Backend) Send(ctx context.Context, stream string, input *sqs.SendMessageInput) (string, error) {
+	if aws.Int64Value(input.DelaySeconds) > 0 {
+		return "", ErrDelay
+	}
+	return b.add(ctx, stream, aws.StringValue(input.MessageBody), input.MessageAttributes)
+}
+
+func (b *Backend) add(ctx context.Context, stream, body string, attributes map[string]*sqs.MessageAttributeValue) (string, error) {
+	args := []string{"XADD", stream}
+	if b.MaxLen > 0 {
+		args = append(args, "MAXLEN", "~", strconv.FormatInt(b.MaxLen, 10))
+	}
+	args = append(args, "*", BodyField, body)
+	for name, value := range attributes {
+		args = append(args, name, attribute(value.StringValue, value.BinaryValue))
+	}
+	reply, err := b.do(ctx, args...)
+	if err != nil {
+		return "", err
+	}
+	id, ok := reply.([]byte)
+	if !ok {
+		return "", fmt.Errorf("redis: unexpected reply to XADD: %v", reply)
+	}
+	return string(id), nil
+}
+
+// attribute returns the string value of a message attribute
+func attribute(value *string, binary []byte) string {
+	if value != nil {
+		return *value
+	}
+	return string(binary)
+}
+
+// Depth returns the entries the group has not read yet as visible, and those pending as not
+// visible. The entries not read are only known to Redis 7 and later.
+func (b *Backend) Depth(ctx context.Context, stream string) (sqsworker.QueueDepth, error) {
+	reply, err := b.do(ctx, "XINFO", "GROUPS", stream)
+	if err != nil {
+		return sqsworker.QueueDepth{}, err
+	}
+	groups, _ := reply.([]interface{})
+	for _, g := range groups {
+		info, _ := g.([]interface{})
+		var name string
+		var depth sqsworker.QueueDepth
+		for i := 0; i+1 < len(info); i += 2 {
+			field, _ := info[i].([]byte)
+			switch string(field) {
+			case "name":
+				value, _ := info[i+1].([]byte)
+				name = string(value)
+			case "pending":
+				depth.NotVisible, _ = info[i+1].(int64)
+			case "lag":
+				depth.Visible, _ = info[i+1].(int64)
+			}
+		}
+		if name == b.Group {
+			depth.Updated = time.Now()
+			return depth, nil
+		}
+	}
+	return sqsworker.QueueDepth{}, fmt.Errorf("redis: no group %s on stream %s", b.Group, stream)
+}
+
+// Stream is a sqsworker.Sink adding results to a stream, with their message attributes as
+// fields
+type Stream struct {
+	Backend *Backend
+	Name    string
+}
+
+// Stream creates a Sink adding results to the stream
+func (b *Backend) Stream(name string) *Stream {
+	return &Stream{Backend: b, Name: name}
+}
+
+// Send adds the result to the stream
+func (s *Stream) Send(ctx context.Context, m *sqs.Message, output *sns.PublishInput) error {
+	attributes := make(map[string]*sqs.MessageAttributeValue, len(output.MessageAttributes))
+	for name, value := range output.MessageAttributes {
+		attributes[name] = &sqs.MessageAttributeValue{StringValue: value.StringValue, BinaryValue: value.BinaryValue}
+	}
+	_, err := s.Backend.add(ctx, s.Name, aws.StringValue(output.Message), attributes)
+	return err
+}
+
+// milliseconds formats a duration as the milliseconds of a Redis command
+func milliseconds(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Millisecond), 10)
+}
+
+// redisError is an error reply of the server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// conn is a connection to the server
+type conn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// do sends a command and returns its reply: nil, a string, an int64, []byte or
+// []interface{}. Connections are reused unless the command fails with anything but an error
+// reply.
+func (b *Backend) do(ctx context.Context, args ...string) (interface{}, error) {
+	c, err := b.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.do(ctx, args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		c.conn.Close()
+		return nil, err
+	}
+	b.put(c)
+	return reply, err
+}
+
+// get returns an idle connection, or opens one
+func (b *Backend) get(ctx context.Context) (*conn, error) {
+	b.mu.Lock()
+	if n := len(b.idle); n > 0 {
+		c := b.idle[n-1]
+		b.idle = b.idle[:n-1]
+		b.mu.Unlock()
+		return c, nil
+	}
+	b.mu.Unlock()
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", b.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{conn: nc, r: bufio.NewReader(nc)}
+	if b.Password != "" {
+		if _, err := c.do(ctx, "AUTH", b.Password); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	if b.DB != 0 {
+		if _, err := c.do(ctx, "SELECT", strconv.Itoa(b.DB)); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// put keeps a connection for reuse, closing it when enough connections are idle
+func (b *Backend) put(c *conn) {
+	idle := b.Idle
+	if idle == 0 {
+		idle = DefaultIdle
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.idle) >= idle {
+		c.conn.Close()
+		return
+	}
+	b.idle = append(b.idle, c)
+}
+
+// Close closes the idle connections
+func (b *Backend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range b.idle {
+		c.conn.Close()
+	}
+	b.idle = nil
+	return nil
+}
+
+// do writes a command as an array of bulk strings and reads its reply, within the deadline of
+// the context. Cancelling the context interrupts blocking commands.
+func (c *conn) do(ctx context.Context, args ...string) (interface{}, error) {
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if done := ctx.Done(); done != nil {
+		stop := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			select {
+			case <-done:
+				c.conn.SetDeadline(time.Unix(1, 0))
+			case <-stop:
+			}
+		}()
+		defer func() {
+			close(stop)
+			<-stopped
+		}()
+	}
+
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	reply, err := c.reply()
+	return reply, contextError(ctx, err)
+}
+
+// contextError returns the error of a cancelled context instead of the error it caused
+func contextError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// reply reads a reply, arrays are read recursively
+func (c *conn) reply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: invalid reply")
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		value := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, value); err != nil {
+			return nil, err
+		}
+		return value[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			value, err := c.reply()
+			// error replies within arrays are values, the array is read to the end
+			if _, ok := err.(redisError); err != nil && !ok {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
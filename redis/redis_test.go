@@ -0,0 +1,358 @@
+package redis_test
+
+import (
+	"bufio"
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/redis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// entry is a stream entry
+type entry struct {
+	id     string
+	fields []interface{}
+}
+
+// pending is an entry delivered to a consumer of a group and not acknowledged
+type pending struct {
+	consumer  string
+	delivered time.Time
+	count     int64
+}
+
+// group is a consumer group, having read the entries of its stream up to next
+type group struct {
+	next    int
+	pending map[string]*pending
+}
+
+type stream struct {
+	entries []entry
+	groups  map[string]*group
+}
+
+// StreamServer is an in-memory Redis server answering the stream commands of the Backend
+type StreamServer struct {
+	net.Listener
+	Addr    string
+	mu      sync.Mutex
+	seq     int
+	Streams map[string]*stream
+}
+
+func newStreamServer(t *testing.T) *StreamServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &StreamServer{Listener: l, Addr: l.Addr().String(), Streams: make(map[string]*stream)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// errorReply is written as an error reply
+type errorReply string
+
+func (s *StreamServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		reply := s.command(args)
+		for reply == nil && args[0] == "XREADGROUP" && args[6] == "BLOCK" {
+			// poll for new entries until the block times out
+			ms, _ := strconv.Atoi(args[7])
+			time.Sleep(5 * time.Millisecond)
+			if args[7] = strconv.Itoa(ms - 5); ms <= 5 {
+				break
+			}
+			reply = s.command(args)
+		}
+		if _, err := conn.Write(encode(nil, reply)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *StreamServer) command(args []string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := args[1]
+	if args[0] == "XGROUP" || args[0] == "XINFO" {
+		key = args[2]
+	}
+	st := s.Streams[key]
+	switch args[0] {
+	case "XGROUP":
+		if st == nil {
+			st = &stream{groups: make(map[string]*group)}
+			s.Streams[key] = st
+		}
+		if st.groups[args[3]] != nil {
+			return errorReply("BUSYGROUP Consumer Group name already exists")
+		}
+		st.groups[args[3]] = &group{pending: make(map[string]*pending)}
+		return "OK"
+	case "XADD":
+		if st == nil {
+			st = &stream{groups: make(map[string]*group)}
+			s.Streams[key] = st
+		}
+		fields := args[3:]
+		if args[2] == "MAXLEN" {
+			fields = args[6:]
+		}
+		s.seq++
+		e := entry{id: "1700000000000-" + strconv.Itoa(s.seq)}
+		for _, f := range fields {
+			e.fields = append(e.fields, f)
+		}
+		st.entries = append(st.entries, e)
+		return e.id
+	case "XINFO":
+		if st == nil {
+			return errorReply("ERR no such key")
+		}
+		var groups []interface{}
+		for name, g := range st.groups {
+			groups = append(groups, []interface{}{"name", name, "pending", int64(len(g.pending)), "lag", int64(len(st.entries) - g.next)})
+		}
+		return groups
+	}
+
+	if args[0] == "XREADGROUP" {
+		st = s.Streams[args[len(args)-2]]
+	}
+	var g *group
+	if st != nil {
+		g = st.groups[args[2]]
+	}
+	if g == nil {
+		return errorReply("NOGROUP No such key or consumer group")
+	}
+	switch args[0] {
+	case "XREADGROUP":
+		count, _ := strconv.Atoi(args[5])
+		var entries []interface{}
+		for ; g.next < len(st.entries) && len(entries) < count; g.next++ {
+			e := st.entries[g.next]
+			g.pending[e.id] = &pending{consumer: args[3], delivered: time.Now(), count: 1}
+			entries = append(entries, []interface{}{e.id, e.fields})
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		return []interface{}{[]interface{}{args[len(args)-2], entries}}
+	case "XAUTOCLAIM":
+		idle, _ := strconv.Atoi(args[4])
+		count, _ := strconv.Atoi(args[7])
+		entries := []interface{}{}
+		for _, e := range st.entries {
+			p := g.pending[e.id]
+			if p != nil && len(entries) < count && time.Since(p.delivered) >= time.Duration(idle)*time.Millisecond {
+				p.consumer, p.delivered, p.count = args[3], time.Now(), p.count+1
+				entries = append(entries, []interface{}{e.id, e.fields})
+			}
+		}
+		return []interface{}{"0-0", entries, []interface{}{}}
+	case "XPENDING":
+		p := g.pending[args[3]]
+		if p == nil {
+			return []interface{}{}
+		}
+		return []interface{}{[]interface{}{args[3], p.consumer, int64(time.Since(p.delivered) / time.Millisecond), p.count}}
+	case "XCLAIM":
+		p := g.pending[args[5]]
+		if p == nil {
+			return []interface{}{}
+		}
+		idle, _ := strconv.Atoi(args[7])
+		p.consumer, p.delivered = args[3], time.Now().Add(-time.Duration(idle)*time.Millisecond)
+		return []interface{}{args[5]}
+	case "XACK":
+		if g.pending[args[3]] == nil {
+			return int64(0)
+		}
+		delete(g.pending, args[3])
+		return int64(1)
+	}
+	return errorReply("ERR unknown command")
+}
+
+// encode appends a reply: nil is a nil array
+func encode(buf []byte, reply interface{}) []byte {
+	switch r := reply.(type) {
+	case nil:
+		return append(buf, "*-1\r\n"...)
+	case errorReply:
+		return append(buf, "-"+string(r)+"\r\n"...)
+	case int64:
+		return append(buf, ":"+strconv.FormatInt(r, 10)+"\r\n"...)
+	case string:
+		return append(buf, "$"+strconv.Itoa(len(r))+"\r\n"+r+"\r\n"...)
+	case []interface{}:
+		buf = append(buf, "*"+strconv.Itoa(len(r))+"\r\n"...)
+		for _, value := range r {
+			buf = encode(buf, value)
+		}
+	}
+	return buf
+}
+
+// readCommand reads an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		args[i] = string(arg[:size])
+	}
+	return args, nil
+}
+
+func TestVisibility(t *testing.T) {
+	server := newStreamServer(t)
+	defer server.Close()
+	backend := redis.New(server.Addr, "workers")
+	backend.MaxLen = 1000
+	defer backend.Close()
+	ctx := context.Background()
+
+	// the group is created on the first receive
+	if messages, err := backend.Receive(ctx, "orders", 10, 10*time.Millisecond, 50*time.Millisecond); err != nil || len(messages) != 0 {
+		t.Fatalf("unexpected receive %v %v", messages, err)
+	}
+	id, err := backend.Send(ctx, "orders", &sqs.SendMessageInput{
+		MessageBody:       aws.String("Hello"),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{"tenant": {DataType: aws.String("String"), StringValue: aws.String("acme")}},
+	})
+	if err != nil || id != "1700000000000-1" {
+		t.Fatalf("unexpected send %s %v", id, err)
+	}
+	if _, err := backend.Send(ctx, "orders", &sqs.SendMessageInput{MessageBody: aws.String("Later"), DelaySeconds: aws.Int64(1)}); err != redis.ErrDelay {
+		t.Errorf("expected a delay to fail, got %v", err)
+	}
+
+	messages, err := backend.Receive(ctx, "orders", 10, time.Second, 50*time.Millisecond)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("unexpected receive %v %v", messages, err)
+	}
+	m := messages[0]
+	if aws.StringValue(m.Body) != "Hello" || aws.StringValue(m.MessageAttributes["tenant"].StringValue) != "acme" ||
+		aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameSentTimestamp]) != "1700000000000" ||
+		aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]) != "1" {
+		t.Errorf("unexpected message %v", m)
+	}
+
+	// the message is hidden for the visibility timeout, then claimed again
+	if messages, _ := backend.Receive(ctx, "orders", 10, 0, 50*time.Millisecond); len(messages) != 0 {
+		t.Errorf("expected the message to be hidden, got %v", messages)
+	}
+	time.Sleep(60 * time.Millisecond)
+	messages, err = backend.Receive(ctx, "orders", 10, 0, 50*time.Millisecond)
+	if err != nil || len(messages) != 1 || aws.StringValue(messages[0].Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]) != "2" {
+		t.Fatalf("expected the message to be claimed again, got %v %v", messages, err)
+	}
+
+	// a zero visibility makes the message visible immediately
+	if err := backend.ChangeVisibility(ctx, "orders", *m.ReceiptHandle, 0); err != nil {
+		t.Fatal(err)
+	}
+	if messages, _ := backend.Receive(ctx, "orders", 10, 0, 50*time.Millisecond); len(messages) != 1 {
+		t.Errorf("expected the message to be visible, got %v", messages)
+	}
+
+	if depth, err := backend.Depth(ctx, "orders"); err != nil || depth.Visible != 0 || depth.NotVisible != 1 {
+		t.Errorf("unexpected depth %v %v", depth, err)
+	}
+	if err := backend.Delete(ctx, "orders", *m.ReceiptHandle); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Delete(ctx, "orders", *m.ReceiptHandle); err != redis.ErrNotReceived {
+		t.Errorf("expected the message to be acknowledged, got %v", err)
+	}
+	if err := backend.ChangeVisibility(ctx, "orders", *m.ReceiptHandle, 0); err != redis.ErrNotReceived {
+		t.Errorf("expected the message to be acknowledged, got %v", err)
+	}
+
+	// cancelling the context interrupts a blocking read
+	cancelled, cancel := context.WithCancel(ctx)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := backend.Receive(cancelled, "orders", 10, 10*time.Second, time.Minute); err != context.Canceled {
+		t.Errorf("expected the receive to be cancelled, got %v", err)
+	}
+}
+
+func TestWorker(t *testing.T) {
+	server := newStreamServer(t)
+	defer server.Close()
+	backend := redis.New(server.Addr, "workers")
+	defer backend.Close()
+	ctx := context.Background()
+	for _, body := range []string{"Hello", "World"} {
+		if _, err := backend.Send(ctx, "orders", &sqs.SendMessageInput{MessageBody: aws.String(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := sqsworker.NewWorker(nil, sqsworker.WorkerConfig{
+		Backend:  backend,
+		QueueURL: "orders",
+		Workers:  2,
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			return &sns.PublishInput{Message: aws.String(strings.ToLower(*m.Body))}, nil
+		}),
+		Sink:   backend.Stream("processed"),
+		Logger: zap.NewNop(),
+	})
+	go w.Run()
+	defer w.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		server.mu.Lock()
+		processed := server.Streams["processed"]
+		done := processed != nil && len(processed.entries) == 2 && len(server.Streams["orders"].groups["workers"].pending) == 0
+		server.mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("messages were not processed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
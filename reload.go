@@ -0,0 +1,196 @@
+package sqsworker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Settings are the tunables that can be changed while a worker is running, without
+// restarting its polling loop. Zero values leave the current value unchanged, so a nil
+// Paused neither pauses nor resumes polling.
+type Settings struct {
+	// Consumers is the number of consumer goroutines
+	Consumers int `json:"consumers"`
+	// VisibilityTimeout in seconds requested for received messages
+	VisibilityTimeout int64 `json:"visibility_timeout"`
+	// Paused stops polling, like Drain without waiting for in-flight messages, or resumes it
+	Paused *bool `json:"paused,omitempty"`
+	// DebugSample is the fraction of messages whose lifecycle is logged, 0 disables tracing
+	DebugSample *float64 `json:"debug_sample,omitempty"`
+	// Rate is the steady rate of the worker's Throttle in messages per second, and is ignored
+	// by workers without a Throttle
+	Rate float64 `json:"rate,omitempty"`
+}
+
+// LoadSettings loads the current Settings from a config source
+type LoadSettings func() (Settings, error)
+
+// settings holds the live values of the tunables
+type settings struct {
+	consumers         int64
+	visibilityTimeout int64
+	// debugSample holds the bits of the fraction of messages traced
+	debugSample uint64
+	// resize signals Run that the number of consumers changed
+	resize chan struct{}
+}
+
+// Settings returns the current tunables. The Consumers and VisibilityTimeout fields of the
+// Worker hold the values it was created with.
+func (w *Worker) Settings() Settings {
+	paused := w.Paused()
+	sample := w.debugSample()
+	s := Settings{
+		Consumers:         int(atomic.LoadInt64(&w.settings.consumers)),
+		VisibilityTimeout: atomic.LoadInt64(&w.settings.visibilityTimeout),
+		Paused:            &paused,
+		DebugSample:       &sample,
+	}
+	if w.throttle != nil {
+		s.Rate = w.throttle.currentRate()
+	}
+	return s
+}
+
+// Apply changes the tunables of a running worker. The visibility timeout applies from the next
+// receive, consumers are started or stopped after they finish their current message, pausing
+// stops polling while the messages already received are processed, and the debug sample and
+// the Throttle's rate apply from the next message.
+func (w *Worker) Apply(s Settings) {
+	if s.Consumers > 0 && int64(s.Consumers) != atomic.SwapInt64(&w.settings.consumers, int64(s.Consumers)) {
+		select {
+		case w.settings.resize <- struct{}{}:
+		default:
+		}
+	}
+	if s.VisibilityTimeout > 0 {
+		atomic.StoreInt64(&w.settings.visibilityTimeout, s.VisibilityTimeout)
+	}
+	if s.Paused != nil {
+		if *s.Paused {
+			w.pause()
+		} else {
+			w.Resume()
+		}
+	}
+	if s.DebugSample != nil {
+		w.setDebugSample(*s.DebugSample)
+	}
+	if s.Rate > 0 && w.throttle != nil {
+		w.throttle.setRate(s.Rate)
+	}
+	s = w.Settings()
+	w.logInfo(fmt.Sprintf("Applied settings consumers=%d visibility_timeout=%d paused=%t debug_sample=%g rate=%g", s.Consumers, s.VisibilityTimeout, *s.Paused, *s.DebugSample, s.Rate))
+}
+
+// consumers runs the consumer goroutines, starting and stopping them as the number of consumers
+// changes, until every consumer has exited after the context is done.
+func (w *Worker) consumers(ctx context.Context, in chan message) {
+	var quits []chan struct{}
+	exited := make(chan struct{})
+	running, next := 0, 0
+
+	resize := func() {
+		n := int(atomic.LoadInt64(&w.settings.consumers))
+		for len(quits) < n {
+			id, quit := next, make(chan struct{})
+			quits = append(quits, quit)
+			running++
+			next++
+			go func() {
+				w.consumer(ctx, id, in, quit)
+				exited <- struct{}{}
+			}()
+		}
+		for len(quits) > n {
+			close(quits[len(quits)-1])
+			quits = quits[:len(quits)-1]
+		}
+		w.logInfo(fmt.Sprint("Staring consumer with ", n, " consumers"))
+	}
+
+	resize()
+	for running > 0 {
+		select {
+		case <-w.settings.resize:
+			if ctx.Err() == nil {
+				resize()
+			}
+		case <-exited:
+			running--
+		}
+	}
+}
+
+// LoadSettingsFile returns a LoadSettings that reads Settings from a JSON file
+func LoadSettingsFile(path string) LoadSettings {
+	return func() (Settings, error) {
+		var s Settings
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return s, err
+		}
+		err = json.Unmarshal(data, &s)
+		return s, err
+	}
+}
+
+// ReloadOnSignal loads and applies the settings every time one of the signals is received,
+// SIGHUP by default, until the context is done.
+func ReloadOnSignal(ctx context.Context, w *Worker, load LoadSettings, signals ...os.Signal) {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGHUP}
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+	defer signal.Stop(c)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c:
+			w.reload(load)
+		}
+	}
+}
+
+// WatchSettingsFile applies the settings in a JSON file whenever its modification time
+// changes, checking every interval until the context is done.
+func WatchSettingsFile(ctx context.Context, w *Worker, path string, interval time.Duration) {
+	load := LoadSettingsFile(path)
+	var modified time.Time
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if info, err := os.Stat(path); err != nil {
+			w.logError("watch settings failed!", err)
+		} else if !info.ModTime().Equal(modified) {
+			modified = info.ModTime()
+			w.reload(load)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Worker) reload(load LoadSettings) {
+	s, err := load()
+	if err != nil {
+		w.logError("reload settings failed!", err)
+		return
+	}
+	w.Apply(s)
+}
@@ -0,0 +1,107 @@
+package sqsworker_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplySettings(t *testing.T) {
+	queue := GetMockeQueue()
+	handler := &StuckWorker{Started: make(chan bool)}
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: handler,
+		Name:      "TestApp",
+	})
+	w.Queue = &PollQueue{queue}
+	go w.Run()
+	defer w.Close()
+
+	queue.Push("first")
+	<-handler.Started
+
+	// The only consumer is busy until another is added
+	queue.Push("second")
+	select {
+	case <-handler.Started:
+		t.Fatal("Expected second message to wait for a consumer")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	w.Apply(sqsworker.Settings{Consumers: 2, VisibilityTimeout: 120})
+	select {
+	case <-handler.Started:
+	case <-time.After(time.Second):
+		t.Fatal("Expected second message to be processed by a new consumer")
+	}
+
+	expected := sqsworker.Settings{Consumers: 2, VisibilityTimeout: 120, Paused: aws.Bool(false), DebugSample: aws.Float64(0)}
+	if actual := w.Settings(); !reflect.DeepEqual(actual, expected) {
+		t.Error("Actual: ", actual, "Expected: ", expected)
+	}
+
+	w.Apply(sqsworker.Settings{Paused: aws.Bool(true)})
+	if !w.Paused() {
+		t.Error("Expected worker to be paused")
+	}
+	if actual := w.Settings().Consumers; actual != 2 {
+		t.Error("Actual: ", actual, "Expected: ", 2)
+	}
+
+	// settings without Paused leave a paused worker paused
+	w.Apply(sqsworker.Settings{Consumers: 1})
+	if !w.Paused() {
+		t.Error("Expected worker to stay paused")
+	}
+	w.Apply(sqsworker.Settings{Paused: aws.Bool(false)})
+	if w.Paused() {
+		t.Error("Expected worker to be resumed")
+	}
+}
+
+func TestWatchSettingsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqsworker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "settings.json")
+	data := []byte(`{"consumers": 3, "visibility_timeout": 60, "paused": true}`)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+		Name:      "TestApp",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sqsworker.WatchSettingsFile(ctx, w, path, time.Millisecond)
+
+	expected := sqsworker.Settings{Consumers: 3, VisibilityTimeout: 60, Paused: aws.Bool(true), DebugSample: aws.Float64(0)}
+	deadline := time.After(time.Second)
+	for !reflect.DeepEqual(w.Settings(), expected) {
+		select {
+		case <-deadline:
+			t.Fatal("Actual: ", w.Settings(), "Expected: ", expected)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Package results provides DynamoDB and Redis implementations of sqsworker.ResultStore.
+//
+// A service that sends a message with a job ID attribute polls for its result with Get:
+//
+//	result, done, err := store.Get(ctx, jobID)
+//	if err == nil && done {
+//		log.Printf("job %s completed at %s: %s", jobID, result.Completed, result.Message)
+//	}
+package results
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"strconv"
+	"time"
+)
+
+// DynamoDB is a sqsworker.ResultStore storing results in a DynamoDB table with a string
+// partition key named key. With a TTL, each item has an expires attribute holding the Unix time
+// it expires at, for the table's time to live setting to delete it.
+type DynamoDB struct {
+	Client dynamodbiface.DynamoDBAPI
+	Table  string
+	TTL    time.Duration
+}
+
+// NewDynamoDB creates a DynamoDB result store for the table
+func NewDynamoDB(client dynamodbiface.DynamoDBAPI, table string, ttl time.Duration) *DynamoDB {
+	return &DynamoDB{Client: client, Table: table, TTL: ttl}
+}
+
+// Save writes the result, replacing any result stored under its key
+func (d *DynamoDB) Save(ctx context.Context, result sqsworker.StoredResult) error {
+	item := map[string]*dynamodb.AttributeValue{
+		"key":        {S: aws.String(result.Key)},
+		"message_id": {S: aws.String(result.MessageID)},
+		"completed":  {N: aws.String(strconv.FormatInt(result.Completed.UnixNano(), 10))},
+	}
+	// DynamoDB does not store empty strings in older tables, so an empty message is left out
+	if result.Message != "" {
+		item["message"] = &dynamodb.AttributeValue{S: aws.String(result.Message)}
+	}
+	if d.TTL > 0 {
+		expires := result.Completed.Add(d.TTL).Unix()
+		item["expires"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(expires, 10))}
+	}
+	_, err := d.Client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(d.Table),
+		Item:      item,
+	})
+	return err
+}
+
+// Get reads the result stored under a key with a consistent read. Results that expired but
+// were not deleted by DynamoDB yet are not returned.
+func (d *DynamoDB) Get(ctx context.Context, key string) (sqsworker.StoredResult, bool, error) {
+	out, err := d.Client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.Table),
+		Key:            map[string]*dynamodb.AttributeValue{"key": {S: aws.String(key)}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || len(out.Item) == 0 {
+		return sqsworker.StoredResult{}, false, err
+	}
+
+	item := out.Item
+	if expires, ok := item["expires"]; ok {
+		unix, err := strconv.ParseInt(aws.StringValue(expires.N), 10, 64)
+		if err != nil {
+			return sqsworker.StoredResult{}, false, err
+		}
+		if time.Now().Unix() >= unix {
+			return sqsworker.StoredResult{}, false, nil
+		}
+	}
+	result := sqsworker.StoredResult{Key: key}
+	if messageID, ok := item["message_id"]; ok {
+		result.MessageID = aws.StringValue(messageID.S)
+	}
+	if message, ok := item["message"]; ok {
+		result.Message = aws.StringValue(message.S)
+	}
+	if completed, ok := item["completed"]; ok {
+		nanos, err := strconv.ParseInt(aws.StringValue(completed.N), 10, 64)
+		if err != nil {
+			return result, false, err
+		}
+		result.Completed = time.Unix(0, nanos)
+	}
+	return result, true, nil
+}
@@ -0,0 +1,94 @@
+package results_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/results"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Table is an in-memory DynamoDB table keyed by key, failing every put when Err is set
+type Table struct {
+	dynamodbiface.DynamoDBAPI
+	Err   error
+	mu    sync.Mutex
+	Items map[string]map[string]*dynamodb.AttributeValue
+}
+
+func (t *Table) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	if t.Err != nil {
+		return nil, t.Err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Items[*input.Item["key"].S] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (t *Table) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &dynamodb.GetItemOutput{Item: t.Items[*input.Key["key"].S]}, nil
+}
+
+// jobWorker returns the job's body upper cased, failing jobs named fail
+func jobWorker(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	if *m.Body == "fail" {
+		return nil, errors.New("job failed")
+	}
+	return &sns.PublishInput{Message: aws.String("done " + *m.Body)}, nil
+}
+
+func TestDynamoDB(t *testing.T) {
+	table := &Table{Items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	store := results.NewDynamoDB(table, "results", time.Hour)
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:    "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor:   sqsworker.ProcessorFunc(jobWorker),
+		ResultStore: store,
+		JobIDAttr:   "job_id",
+	})
+	ctx := context.Background()
+
+	h.Run(workertest.NewMessage("report", workertest.Attribute("job_id", "job-1"))).Succeeded().Deleted().Published("done report")
+	result, done, err := store.Get(ctx, "job-1")
+	if err != nil || !done || result.Message != "done report" || result.MessageID == "" || result.Completed.IsZero() {
+		t.Error("unexpected result: ", result, done, err)
+	}
+	if expires, _ := strconv.ParseInt(*table.Items["job-1"]["expires"].N, 10, 64); expires < time.Now().Add(59*time.Minute).Unix() {
+		t.Error("unexpected expiry: ", expires)
+	}
+
+	// Without a job ID the result is stored under the message id
+	m := workertest.NewMessage("export")
+	h.Run(m).Succeeded()
+	if _, done, _ := store.Get(ctx, *m.MessageId); !done {
+		t.Error("Expected the result under the message id")
+	}
+
+	h.Run(workertest.NewMessage("fail", workertest.Attribute("job_id", "job-2"))).Failed()
+	if _, done, _ := store.Get(ctx, "job-2"); done {
+		t.Error("Expected no result for a failed job")
+	}
+
+	// Expired results are not returned
+	table.Items["job-1"]["expires"].N = aws.String(strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10))
+	if _, done, _ := store.Get(ctx, "job-1"); done {
+		t.Error("Expected an expired result not to be returned")
+	}
+
+	// A result that fails to save is retried, and not published
+	table.Err = errors.New("throttled")
+	h.Run(workertest.NewMessage("report", workertest.Attribute("job_id", "job-3"))).Failed().NotDeleted().NotPublished()
+}
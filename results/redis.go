@@ -0,0 +1,230 @@
+package results
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultRedisPrefix is prepended to the keys of the results stored in Redis
+const DefaultRedisPrefix = "sqsworker:result:"
+
+// DefaultRedisIdle is the number of idle connections a Redis store keeps open
+const DefaultRedisIdle = 4
+
+// Redis is a sqsworker.ResultStore storing results as JSON strings in Redis, expiring them
+// after TTL when it is set. It speaks the Redis protocol itself, so it has no dependencies.
+type Redis struct {
+	// Addr is the host:port of the Redis server
+	Addr string
+	// Password and DB are sent when a connection is opened, when they are set
+	Password string
+	DB       int
+	// Prefix defaults to DefaultRedisPrefix
+	Prefix string
+	TTL    time.Duration
+	// Idle is the number of idle connections kept open, by default DefaultRedisIdle
+	Idle int
+	mu   sync.Mutex
+	idle []*redisConn
+}
+
+// NewRedis creates a Redis result store for the server at addr
+func NewRedis(addr string, ttl time.Duration) *Redis {
+	return &Redis{Addr: addr, TTL: ttl}
+}
+
+// storedResult is the JSON encoding of a result in Redis
+type storedResult struct {
+	MessageID string    `json:"message_id"`
+	Message   string    `json:"message"`
+	Completed time.Time `json:"completed"`
+}
+
+func (r *Redis) key(key string) string {
+	if r.Prefix == "" {
+		return DefaultRedisPrefix + key
+	}
+	return r.Prefix + key
+}
+
+// Save writes the result, replacing any result stored under its key
+func (r *Redis) Save(ctx context.Context, result sqsworker.StoredResult) error {
+	value, err := json.Marshal(storedResult{MessageID: result.MessageID, Message: result.Message, Completed: result.Completed})
+	if err != nil {
+		return err
+	}
+	args := []string{"SET", r.key(result.Key), string(value)}
+	if r.TTL > 0 {
+		args = append(args, "PX", strconv.FormatInt(int64(r.TTL/time.Millisecond), 10))
+	}
+	_, err = r.do(ctx, args...)
+	return err
+}
+
+// Get reads the result stored under a key
+func (r *Redis) Get(ctx context.Context, key string) (sqsworker.StoredResult, bool, error) {
+	reply, err := r.do(ctx, "GET", r.key(key))
+	if err != nil || reply == nil {
+		return sqsworker.StoredResult{}, false, err
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return sqsworker.StoredResult{}, false, fmt.Errorf("results: unexpected reply to GET: %v", reply)
+	}
+	var stored storedResult
+	if err := json.Unmarshal(value, &stored); err != nil {
+		return sqsworker.StoredResult{}, false, err
+	}
+	return sqsworker.StoredResult{
+		Key:       key,
+		MessageID: stored.MessageID,
+		Message:   stored.Message,
+		Completed: stored.Completed,
+	}, true, nil
+}
+
+// redisError is an error reply of the server
+type redisError string
+
+func (e redisError) Error() string {
+	return "results: redis: " + string(e)
+}
+
+// redisConn is a connection to the server
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// do sends a command and returns its reply: nil, a string, an int64 or []byte. Connections are
+// reused unless the command fails with anything but an error reply.
+func (r *Redis) do(ctx context.Context, args ...string) (interface{}, error) {
+	c, err := r.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.do(ctx, args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		c.conn.Close()
+		return nil, err
+	}
+	r.put(c)
+	return reply, err
+}
+
+// get returns an idle connection, or opens one
+func (r *Redis) get(ctx context.Context) (*redisConn, error) {
+	r.mu.Lock()
+	if n := len(r.idle); n > 0 {
+		c := r.idle[n-1]
+		r.idle = r.idle[:n-1]
+		r.mu.Unlock()
+		return c, nil
+	}
+	r.mu.Unlock()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", r.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	if r.Password != "" {
+		if _, err := c.do(ctx, "AUTH", r.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if r.DB != 0 {
+		if _, err := c.do(ctx, "SELECT", strconv.Itoa(r.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// put keeps a connection for reuse, closing it when enough connections are idle
+func (r *Redis) put(c *redisConn) {
+	idle := r.Idle
+	if idle == 0 {
+		idle = DefaultRedisIdle
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.idle) >= idle {
+		c.conn.Close()
+		return
+	}
+	r.idle = append(r.idle, c)
+}
+
+// Close closes the idle connections
+func (r *Redis) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.idle {
+		c.conn.Close()
+	}
+	r.idle = nil
+	return nil
+}
+
+// do writes a command as an array of bulk strings and reads its reply, within the deadline of
+// the context
+func (c *redisConn) do(ctx context.Context, args ...string) (interface{}, error) {
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return c.reply()
+}
+
+// reply reads a reply that is not an array
+func (c *redisConn) reply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("results: invalid redis reply")
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		value := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, value); err != nil {
+			return nil, err
+		}
+		return value[:n], nil
+	}
+	return nil, fmt.Errorf("results: unexpected redis reply %q", line)
+}
@@ -0,0 +1,150 @@
+package results_test
+
+import (
+	"bufio"
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/results"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// RedisServer is an in-memory Redis server answering AUTH, SELECT, SET and GET
+type RedisServer struct {
+	net.Listener
+	Addr     string
+	Password string
+	mu       sync.Mutex
+	Values   map[string]string
+	TTLs     map[string]string
+	Conns    int
+}
+
+func newRedisServer(t *testing.T, password string) *RedisServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &RedisServer{Listener: l, Addr: l.Addr().String(), Password: password, Values: make(map[string]string), TTLs: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.Conns++
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *RedisServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := s.Password == ""
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		var reply string
+		switch {
+		case args[0] == "AUTH":
+			authed = args[1] == s.Password
+			reply = "+OK\r\n"
+			if !authed {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case args[0] == "SELECT":
+			reply = "+OK\r\n"
+		case args[0] == "SET":
+			s.Values[args[1]] = args[2]
+			if len(args) == 5 {
+				s.TTLs[args[1]] = args[3] + " " + args[4]
+			}
+			reply = "+OK\r\n"
+		case args[0] == "GET":
+			value, ok := s.Values[args[1]]
+			reply = "$-1\r\n"
+			if ok {
+				reply = "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+			}
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		s.mu.Unlock()
+		io.WriteString(conn, reply)
+	}
+}
+
+// readCommand reads an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		args[i] = string(arg[:size])
+	}
+	return args, nil
+}
+
+func TestRedis(t *testing.T) {
+	server := newRedisServer(t, "secret")
+	defer server.Close()
+	store := results.NewRedis(server.Addr, time.Minute)
+	store.Password = "secret"
+	store.DB = 2
+	defer store.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	completed := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	for _, message := range []string{"done\r\nreport", ""} {
+		if err := store.Save(ctx, sqsworker.StoredResult{Key: "job-1", MessageID: "message-1", Message: message, Completed: completed}); err != nil {
+			t.Fatal(err)
+		}
+		result, done, err := store.Get(ctx, "job-1")
+		if err != nil || !done || result.Key != "job-1" || result.MessageID != "message-1" || result.Message != message || !result.Completed.Equal(completed) {
+			t.Error("unexpected result: ", result, done, err)
+		}
+	}
+	if _, done, err := store.Get(ctx, "job-2"); done || err != nil {
+		t.Error("Expected no result, got: ", done, err)
+	}
+	server.mu.Lock()
+	if ttl := server.TTLs[results.DefaultRedisPrefix+"job-1"]; ttl != "PX 60000" {
+		t.Error("unexpected TTL: ", ttl)
+	}
+	if server.Conns != 1 {
+		t.Error("Expected the connection to be reused, opened: ", server.Conns)
+	}
+	server.mu.Unlock()
+
+	store = results.NewRedis(server.Addr, 0)
+	defer store.Close()
+	if err := store.Save(ctx, sqsworker.StoredResult{Key: "job-3"}); err == nil || !strings.Contains(err.Error(), "NOAUTH") {
+		t.Error("Expected an error reply, got: ", err)
+	}
+}
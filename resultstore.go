@@ -0,0 +1,70 @@
+package sqsworker
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"sync"
+	"time"
+)
+
+// StoredResult is the result of a processed message, kept for the services polling for it
+type StoredResult struct {
+	// Key is the job ID of the message, or its MessageId when the worker has no JobIDAttr
+	Key       string
+	MessageID string
+	// Message is the message of the Processor's output, empty when it returned none
+	Message   string
+	Completed time.Time
+}
+
+// ResultStore persists the results of processed messages by key, so a service that sent a
+// message can poll whether its job is done. The worker saves each result after the Processor
+// succeeds, before the result is published. A message whose result fails to save is retried.
+type ResultStore interface {
+	Save(ctx context.Context, result StoredResult) error
+	// Get returns the result stored under a key, false when there is none
+	Get(ctx context.Context, key string) (StoredResult, bool, error)
+}
+
+// saveResult saves the result of a processed message to the ResultStore
+func (w *Worker) saveResult(ctx context.Context, msg message, output *sns.PublishInput) error {
+	result := StoredResult{
+		Key:       aws.StringValue(msg.MessageId),
+		MessageID: aws.StringValue(msg.MessageId),
+		Completed: time.Now(),
+	}
+	if attr, ok := msg.MessageAttributes[w.JobIDAttr]; ok && w.JobIDAttr != "" && aws.StringValue(attr.StringValue) != "" {
+		result.Key = *attr.StringValue
+	}
+	if output != nil {
+		result.Message = aws.StringValue(output.Message)
+	}
+	return w.ResultStore.Save(ctx, result)
+}
+
+// MemoryResultStore is an in-process ResultStore for development and tests. Its results are
+// lost when the process exits, and are only visible to the process that stored them.
+type MemoryResultStore struct {
+	mu      sync.Mutex
+	results map[string]StoredResult
+}
+
+// Save stores the result
+func (m *MemoryResultStore) Save(ctx context.Context, result StoredResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.results == nil {
+		m.results = make(map[string]StoredResult)
+	}
+	m.results[result.Key] = result
+	return nil
+}
+
+// Get returns the result stored under a key
+func (m *MemoryResultStore) Get(ctx context.Context, key string) (StoredResult, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result, ok := m.results[key]
+	return result, ok, nil
+}
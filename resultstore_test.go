@@ -0,0 +1,36 @@
+package sqsworker_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"testing"
+)
+
+func TestResultStore(t *testing.T) {
+	store := &sqsworker.MemoryResultStore{}
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:    "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor:   &LowerCaseWorker{},
+		ResultStore: store,
+		JobIDAttr:   "job_id",
+	})
+	ctx := context.Background()
+
+	m := workertest.NewMessage("REPORT", workertest.Attribute("job_id", "job-1"))
+	h.Run(m).Succeeded().Deleted().Published("report")
+	result, done, err := store.Get(ctx, "job-1")
+	if err != nil || !done || result.Message != "report" || result.MessageID != *m.MessageId {
+		t.Error("unexpected result: ", result, done, err)
+	}
+
+	// Messages without a job ID are stored by message id
+	m = workertest.NewMessage("EXPORT")
+	h.Run(m).Succeeded()
+	if result, done, _ := store.Get(ctx, *m.MessageId); !done || result.Key != *m.MessageId {
+		t.Error("unexpected result: ", result, done)
+	}
+	if _, done, _ := store.Get(ctx, "job-2"); done {
+		t.Error("Expected no result for an unknown job")
+	}
+}
@@ -0,0 +1,144 @@
+package sqsworker
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"strings"
+)
+
+// Destination is a topic, queue or Sink results can be sent to. Exactly one of TopicArn,
+// QueueURL and Sink must be set.
+type Destination struct {
+	TopicArn string
+	QueueURL string
+	Sink     Sink
+}
+
+// valid reports whether exactly one destination is set
+func (d Destination) valid() bool {
+	n := 0
+	for _, set := range []bool{d.TopicArn != "", d.QueueURL != "", d.Sink != nil} {
+		if set {
+			n++
+		}
+	}
+	return n == 1
+}
+
+// Router chooses the destination of a result by its name in the worker's Destinations, e.g. by
+// event type or tenant. An empty name sends the result to the TopicArn set by the Processor, or
+// the worker's TopicArn.
+type Router func(*sqs.Message, *sns.PublishInput) string
+
+// route returns the destination chosen by the Router, the zero Destination when the result is
+// published to its own TopicArn. Results for destinations that are not allowed fail with a Fatal
+// error, since they would fail again on every receive.
+func (w *Worker) route(msg message, output *sns.PublishInput) (Destination, error) {
+	if output == nil {
+		return Destination{}, nil
+	}
+
+	if w.Router != nil {
+		if name := w.Router(msg.Message, output); name != "" {
+			return w.destination(name, output)
+		}
+	}
+
+	// With an allow-list, the Processor may only choose one of the allowed topics
+	if output.TopicArn != nil && len(w.Destinations) > 0 && *output.TopicArn != w.TopicArn {
+		for _, d := range w.Destinations {
+			if d.TopicArn == *output.TopicArn {
+				return Destination{}, nil
+			}
+		}
+		return Destination{}, Fatal(fmt.Errorf("sqsworker: topic %s is not an allowed destination", *output.TopicArn))
+	}
+	return Destination{}, nil
+}
+
+// destination returns the destination named name for a result
+func (w *Worker) destination(name string, output *sns.PublishInput) (Destination, error) {
+	d, ok := w.Destinations[name]
+	if !ok {
+		return d, Fatal(fmt.Errorf("sqsworker: unknown destination %s", name))
+	}
+	if !d.valid() {
+		return d, Fatal(fmt.Errorf("sqsworker: destination %s must set one of TopicArn, QueueURL and Sink", name))
+	}
+	if d.QueueURL != "" {
+		if _, err := sendMessageInput(d.QueueURL, output); err != nil {
+			return d, Fatal(err)
+		}
+	}
+	return d, nil
+}
+
+// deliver publishes a result to its TopicArn, or sends it to queueURL when it is set
+func deliver(topic snsiface.SNSAPI, queue sqsiface.SQSAPI, queueURL string, input *sns.PublishInput, mismatch func(error)) (*sns.PublishOutput, error) {
+	if queueURL == "" {
+		return topic.Publish(input)
+	}
+
+	send, err := sendMessageInput(queueURL, input)
+	if err != nil {
+		return nil, err
+	}
+	out, err := sendChecked(queue, send, mismatch)
+	if err != nil {
+		return nil, err
+	}
+	return &sns.PublishOutput{MessageId: out.MessageId, SequenceNumber: out.SequenceNumber}, nil
+}
+
+// sendMessageInput converts a result to be sent to a queue. Results using features SQS does not
+// have, such as a subject or per-protocol messages, are rejected rather than sent without them.
+func sendMessageInput(queueURL string, input *sns.PublishInput) (*sqs.SendMessageInput, error) {
+	switch {
+	case input.Subject != nil:
+		return nil, fmt.Errorf("sqsworker: results sent to queue %s cannot have a Subject", queueURL)
+	case input.MessageStructure != nil:
+		return nil, fmt.Errorf("sqsworker: results sent to queue %s cannot have a MessageStructure", queueURL)
+	case input.TargetArn != nil || input.PhoneNumber != nil:
+		return nil, fmt.Errorf("sqsworker: results sent to queue %s cannot have a TargetArn or PhoneNumber", queueURL)
+	}
+
+	send := &sqs.SendMessageInput{
+		QueueUrl:               aws.String(queueURL),
+		MessageBody:            input.Message,
+		MessageGroupId:         input.MessageGroupId,
+		MessageDeduplicationId: input.MessageDeduplicationId,
+	}
+	if len(input.MessageAttributes) > 0 {
+		send.MessageAttributes = make(map[string]*sqs.MessageAttributeValue, len(input.MessageAttributes))
+		for name, value := range input.MessageAttributes {
+			dataType := aws.StringValue(value.DataType)
+			if !queueDataType(dataType) {
+				return nil, fmt.Errorf("sqsworker: attribute %s of type %s cannot be sent to queue %s", name, dataType, queueURL)
+			}
+			send.MessageAttributes[name] = &sqs.MessageAttributeValue{
+				DataType:    value.DataType,
+				StringValue: value.StringValue,
+				BinaryValue: value.BinaryValue,
+			}
+		}
+	}
+	return send, nil
+}
+
+// queueDataType reports whether SQS supports a message attribute data type. SQS has the String,
+// Number and Binary types with custom labels, but not the String.Array type of SNS.
+func queueDataType(dataType string) bool {
+	if dataType == "String.Array" {
+		return false
+	}
+	for _, t := range []string{"String", "Number", "Binary"} {
+		if dataType == t || strings.HasPrefix(dataType, t+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package sqsworker_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"testing"
+	"time"
+)
+
+const (
+	ordersTopicArn = "arn:aws:sns:us-east-1:88888888888:Orders"
+	auditQueueURL  = "https://sqs.us-east-1.amazonaws.com/88888888888/Audit"
+)
+
+// EventWorker returns its input as the output, with the event type as an attribute
+type EventWorker struct {
+	Output func(m *sqs.Message) *sns.PublishInput
+}
+
+func (e *EventWorker) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	return e.Output(m), nil
+}
+
+func eventOutput(m *sqs.Message) *sns.PublishInput {
+	return &sns.PublishInput{
+		Message: m.Body,
+		MessageAttributes: map[string]*sns.MessageAttributeValue{
+			"event": {DataType: aws.String("String"), StringValue: m.MessageAttributes["event"].StringValue},
+		},
+	}
+}
+
+// routeByEvent routes results by their event attribute
+func routeByEvent(m *sqs.Message, output *sns.PublishInput) string {
+	return aws.StringValue(output.MessageAttributes["event"].StringValue)
+}
+
+var destinations = map[string]sqsworker.Destination{
+	"order": {TopicArn: ordersTopicArn},
+	"audit": {QueueURL: auditQueueURL},
+	"bad":   {TopicArn: ordersTopicArn, QueueURL: auditQueueURL},
+}
+
+func TestRouter(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:           "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor:          &EventWorker{Output: eventOutput},
+		Destinations:       destinations,
+		Router:             routeByEvent,
+		DeadLetterQueueURL: workertest.QueueURL + "-dlq",
+	})
+
+	result := h.Run(workertest.NewMessage("placed", workertest.Attribute("event", "order")))
+	result.Succeeded().Deleted().Published("placed")
+	if actual := aws.StringValue(result.Publishes[0].TopicArn); actual != ordersTopicArn {
+		t.Error("Actual: ", actual, "Expected: ", ordersTopicArn)
+	}
+
+	result = h.Run(workertest.NewMessage("viewed", workertest.Attribute("event", "audit"))).Succeeded().Deleted().NotPublished()
+	if len(result.Sent) != 1 || aws.StringValue(result.Sent[0].QueueUrl) != auditQueueURL {
+		t.Fatal("unexpected sent messages: ", result.Sent)
+	}
+	if sent := result.Sent[0]; aws.StringValue(sent.MessageBody) != "viewed" || aws.StringValue(sent.MessageAttributes["event"].StringValue) != "audit" {
+		t.Error("unexpected sent message: ", sent)
+	}
+
+	// No destination name publishes to the worker's TopicArn
+	h.Run(workertest.NewMessage("other", workertest.Attribute("event", ""))).Succeeded().Deleted().Published("other")
+
+	h.Run(workertest.NewMessage("refunded", workertest.Attribute("event", "refund"))).Failed().DeadLettered()
+	h.Run(workertest.NewMessage("broken", workertest.Attribute("event", "bad"))).Failed().DeadLettered()
+}
+
+func TestAllowedTopics(t *testing.T) {
+	topicArn := "arn:aws:sns:us-east-1:88888888888:Other"
+	output := func(m *sqs.Message) *sns.PublishInput {
+		return &sns.PublishInput{TopicArn: aws.String(*m.Body), Message: aws.String("result")}
+	}
+
+	// Without Destinations the Processor may publish to any topic
+	h := workertest.New(t, sqsworker.WorkerConfig{Processor: &EventWorker{Output: output}})
+	h.Run(workertest.NewMessage(topicArn)).Succeeded().Published("result")
+
+	h = workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:     "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor:    &EventWorker{Output: output},
+		Destinations: destinations,
+	})
+	h.Run(workertest.NewMessage(ordersTopicArn)).Succeeded().Published("result")
+	h.Run(workertest.NewMessage("arn:aws:sns:us-east-1:88888888888:Out")).Succeeded().Published("result")
+	result := h.Run(workertest.NewMessage(topicArn)).Failed().NotDeleted().NotPublished()
+	if !sqsworker.IsFatal(result.Err) {
+		t.Error("Expected a fatal error, got: ", result.Err)
+	}
+}
+
+func TestQueueDestinationRejects(t *testing.T) {
+	outputs := []*sns.PublishInput{
+		{Message: aws.String("subject"), Subject: aws.String("hello")},
+		{Message: aws.String("structure"), MessageStructure: aws.String("json")},
+		{Message: aws.String("array"), MessageAttributes: map[string]*sns.MessageAttributeValue{
+			"tags": {DataType: aws.String("String.Array"), StringValue: aws.String(`["a"]`)},
+		}},
+	}
+	for _, output := range outputs {
+		output := output
+		h := workertest.New(t, sqsworker.WorkerConfig{
+			Processor:    &EventWorker{Output: func(*sqs.Message) *sns.PublishInput { return output }},
+			Destinations: destinations,
+			Router:       func(*sqs.Message, *sns.PublishInput) string { return "audit" },
+		})
+		result := h.Run(workertest.NewMessage("hello")).Failed().NotDeleted()
+		if !sqsworker.IsFatal(result.Err) || len(result.Sent) != 0 {
+			t.Error("Expected ", *output.Message, " to be rejected, got: ", result.Err, result.Sent)
+		}
+	}
+}
+
+func TestRelayQueueEntries(t *testing.T) {
+	outbox := &sqsworker.MemoryOutbox{}
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor:    &EventWorker{Output: eventOutput},
+		Destinations: destinations,
+		Router:       routeByEvent,
+		Outbox:       outbox,
+	})
+	h.Run(workertest.NewMessage("viewed", workertest.Attribute("event", "audit"))).Succeeded().Deleted().NotPublished()
+
+	entries, _ := outbox.Pending(context.Background(), 10)
+	if len(entries) != 1 || entries[0].QueueURL != auditQueueURL {
+		t.Fatal("unexpected outbox entries: ", entries)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	relay := &sqsworker.Relay{Outbox: outbox, Topic: h.Topic, Queue: h.Queue, Interval: time.Millisecond}
+	go relay.Run(ctx)
+
+	deadline := time.After(time.Second)
+	for {
+		if entries, _ := outbox.Pending(context.Background(), 10); len(entries) == 0 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("Expected the relay to empty the outbox")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	cancel()
+
+	if len(h.Queue.Sent) != 1 || aws.StringValue(h.Queue.Sent[0].QueueUrl) != auditQueueURL {
+		t.Error("unexpected sent messages: ", h.Queue.Sent)
+	}
+}
@@ -0,0 +1,247 @@
+package sqsworker
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"sync"
+)
+
+// DefaultSagaIDAttr is the message attribute holding the ID of a saga, the MessageId of the
+// message that started it
+const DefaultSagaIDAttr = "saga_id"
+
+// SagaActionAttr is the message attribute telling a step of a Saga to compensate a message,
+// rather than execute it
+const SagaActionAttr = "saga_action"
+
+// Values of the SagaActionAttr attribute. Messages without the attribute are executed.
+const (
+	SagaExecute     = "execute"
+	SagaCompensate  = "compensate"
+	SagaCompensated = "compensated"
+)
+
+// SagaStep is a step of a Saga: the queue it reads, how it executes a message, and how it undoes
+// what it executed when a later step fails
+type SagaStep struct {
+	// Queue is the name of the queue the step reads, created when it does not exist
+	Queue   string
+	Execute Processor
+	// Compensate undoes the step. It receives the message the failed step received, and its
+	// output, when it has one, is passed on to the compensation of the previous step instead.
+	// Steps without a Compensate have nothing to undo.
+	Compensate Processor
+	// MaxAttempts is the number of receives after which a failing step compensates the saga. By
+	// default only Fatal and Invalid errors do, other errors are retried.
+	MaxAttempts int
+	// Config is the config of the step's worker, see PipelineStage
+	Config WorkerConfig
+}
+
+// Saga runs a multi-step workflow across services. Each step is a stage of a Pipeline, passing
+// its result to the next step. When a step fails for good, a compensation message is sent back
+// through the previous steps, in reverse order, for each to undo what it did:
+//
+//	s := sqsworker.NewSaga(sess).
+//		Step("orders-reserve", reserve, release).
+//		Step("orders-charge", charge, refund).
+//		Step("orders-ship", ship, nil).
+//		Publish("orders-completed").
+//		PublishCompensated("orders-canceled")
+//	if err := s.Run(); err != nil {
+//		log.Fatal(err)
+//	}
+//
+// Messages of a saga are correlated by its ID in the IDAttr attribute.
+type Saga struct {
+	Pipeline *Pipeline
+	Steps    []SagaStep
+	// IDAttr defaults to DefaultSagaIDAttr
+	IDAttr string
+	// CompensatedTopicName is the name of the topic the first step publishes a compensated saga
+	// to, created when it does not exist. Compensated sagas are not published without it.
+	CompensatedTopicName string
+	mu                   sync.Mutex
+}
+
+// NewSaga creates a Saga without steps
+func NewSaga(sess *session.Session) *Saga {
+	return &Saga{Pipeline: NewPipeline(sess)}
+}
+
+// Step adds a step executing messages with execute and undoing them with compensate
+func (s *Saga) Step(queue string, execute, compensate Processor) *Saga {
+	return s.StepConfig(SagaStep{Queue: queue, Execute: execute, Compensate: compensate})
+}
+
+// StepConfig adds a step
+func (s *Saga) StepConfig(step SagaStep) *Saga {
+	s.Steps = append(s.Steps, step)
+	return s
+}
+
+// Publish sets the topic the last step publishes the result of a completed saga to
+func (s *Saga) Publish(topic string) *Saga {
+	s.Pipeline.Publish(topic)
+	return s
+}
+
+// PublishCompensated sets the topic compensated sagas are published to
+func (s *Saga) PublishCompensated(topic string) *Saga {
+	s.CompensatedTopicName = topic
+	return s
+}
+
+// Build creates the queues and topics of the saga, and the workers of its steps. It is called by
+// Run, and does nothing once the saga is built.
+func (s *Saga) Build() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.Pipeline
+	if p.workers() != nil {
+		return nil
+	}
+	if len(s.Steps) == 0 {
+		return ErrNoStages
+	}
+
+	idAttr := s.IDAttr
+	if idAttr == "" {
+		idAttr = DefaultSagaIDAttr
+	}
+	p.Stages = make([]PipelineStage, len(s.Steps))
+	for i, step := range s.Steps {
+		wc := step.Config
+		wc.Processor = &sagaStep{step: step, first: i == 0, publish: s.CompensatedTopicName != ""}
+		wc.CorrelationAttr = idAttr
+		p.Stages[i] = PipelineStage{Queue: step.Queue, Config: wc}
+	}
+	if err := p.Build(); err != nil {
+		return err
+	}
+	compensatedArn, err := GetOrCreateTopic(s.CompensatedTopicName, p.Topic)
+	if err != nil {
+		return err
+	}
+
+	// Results are sent to the next step as destinations rather than through the pipeline's
+	// QueueSink, so they carry the saga ID
+	workers := p.workers()
+	for i, w := range workers {
+		w.Destinations = make(map[string]Destination)
+		if i > 0 {
+			w.Destinations[SagaCompensate] = Destination{QueueURL: p.QueueURLs[i-1]}
+		} else if compensatedArn != "" {
+			w.Destinations[SagaCompensated] = Destination{TopicArn: compensatedArn}
+		}
+		if i < len(workers)-1 {
+			w.Destinations[SagaExecute] = Destination{QueueURL: p.QueueURLs[i+1]}
+			w.Sink = nil
+		}
+		w.Router = sagaRouter(w.Destinations)
+	}
+	return nil
+}
+
+// Run builds the saga and runs the workers of every step, see Pipeline.Run
+func (s *Saga) Run() error {
+	if err := s.Build(); err != nil {
+		return err
+	}
+	return s.Pipeline.Run()
+}
+
+// Stop stops the steps in order, see Pipeline.Stop
+func (s *Saga) Stop(ctx context.Context) error {
+	return s.Pipeline.Stop(ctx)
+}
+
+// Close closes the workers of every step
+func (s *Saga) Close() {
+	s.Pipeline.Close()
+}
+
+// sagaRouter sends results to the next step, compensation messages to the previous step, and
+// compensated sagas to the compensated topic, when the step has such a destination
+func sagaRouter(destinations map[string]Destination) Router {
+	return func(m *sqs.Message, output *sns.PublishInput) string {
+		action := SagaExecute
+		if attr, ok := output.MessageAttributes[SagaActionAttr]; ok && attr != nil {
+			action = aws.StringValue(attr.StringValue)
+		}
+		if _, ok := destinations[action]; ok {
+			return action
+		}
+		return ""
+	}
+}
+
+// sagaStep is the Processor of the worker of a step
+type sagaStep struct {
+	step  SagaStep
+	first bool
+	// publish is set on the first step when compensated sagas are published
+	publish bool
+}
+
+func (s *sagaStep) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	if attr, ok := m.MessageAttributes[SagaActionAttr]; ok && aws.StringValue(attr.StringValue) == SagaCompensate {
+		return s.compensate(ctx, m)
+	}
+
+	output, err := s.step.Execute.Process(ctx, m)
+	if err == nil || s.first || !s.failed(m, err) {
+		return output, err
+	}
+	// The message is deleted once the compensation of the previous step is sent
+	return sagaAction(&sns.PublishInput{Message: m.Body}, SagaCompensate), nil
+}
+
+// compensate undoes the step, and passes the compensation on to the previous step. Compensations
+// that fail are retried until they succeed.
+func (s *sagaStep) compensate(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	output := &sns.PublishInput{Message: m.Body}
+	if s.step.Compensate != nil {
+		out, err := s.step.Compensate.Process(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		if out != nil {
+			output = out
+		}
+	}
+	if !s.first {
+		return sagaAction(output, SagaCompensate), nil
+	}
+	if !s.publish {
+		return nil, nil
+	}
+	return sagaAction(output, SagaCompensated), nil
+}
+
+// failed reports whether a step failed for good, and compensates the saga
+func (s *sagaStep) failed(m *sqs.Message, err error) bool {
+	if IsFatal(err) || IsInvalid(err) {
+		return true
+	}
+	receives := attributeInt(m.Attributes, sqs.MessageSystemAttributeNameApproximateReceiveCount)
+	return s.step.MaxAttempts > 0 && receives >= int64(s.step.MaxAttempts)
+}
+
+// sagaAction returns a copy of the output with the action as its SagaActionAttr attribute
+func sagaAction(output *sns.PublishInput, action string) *sns.PublishInput {
+	attributes := make(map[string]*sns.MessageAttributeValue, len(output.MessageAttributes)+1)
+	for name, value := range output.MessageAttributes {
+		attributes[name] = value
+	}
+	attributes[SagaActionAttr] = &sns.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(action),
+	}
+	copied := *output
+	copied.MessageAttributes = attributes
+	return &copied
+}
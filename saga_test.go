@@ -0,0 +1,133 @@
+package sqsworker_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+	"testing"
+)
+
+// CompensatingWorker records the bodies of the messages it compensates
+type CompensatingWorker struct {
+	Bodies []string
+}
+
+func (c *CompensatingWorker) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	c.Bodies = append(c.Bodies, *m.Body)
+	return nil, nil
+}
+
+func newSaga(queue *PipelineQueue, topic *PipelineTopic, last sqsworker.Processor) (*sqsworker.Saga, *CompensatingWorker, *CompensatingWorker) {
+	reserve, charge := &CompensatingWorker{}, &CompensatingWorker{}
+	s := sqsworker.NewSaga(session.Must(session.NewSession(&aws.Config{Region: aws.String("us-east-1")})))
+	s.Pipeline.Queue, s.Pipeline.Topic = queue, topic
+	s.Step("Reserve", &LowerCaseWorker{}, reserve).
+		Step("Charge", &LowerCaseWorker{}, charge).
+		StepConfig(sqsworker.SagaStep{Queue: "Ship", Execute: last, MaxAttempts: 3}).
+		Publish("Completed").
+		PublishCompensated("Canceled")
+	for i := range s.Steps {
+		s.Steps[i].Config.Logger = zap.NewNop()
+	}
+	return s, reserve, charge
+}
+
+func TestSaga(t *testing.T) {
+	queue, topic := &PipelineQueue{}, &PipelineTopic{}
+	s, _, _ := newSaga(queue, topic, &LowerCaseWorker{})
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	workers, queueURLs := s.Pipeline.Workers, s.Pipeline.QueueURLs
+
+	ctx := context.Background()
+	first := workertest.NewMessage("ORDER")
+	m := first
+	for i, w := range workers[:2] {
+		if err := w.Handle(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+		sent := queue.Sent[len(queue.Sent)-1]
+		if *sent.QueueUrl != queueURLs[i+1] {
+			t.Fatal("Actual: ", *sent.QueueUrl, "Expected: ", queueURLs[i+1])
+		}
+		m = received(sent)
+	}
+	if sagaID := aws.StringValue(m.MessageAttributes[sqsworker.DefaultSagaIDAttr].StringValue); sagaID != *first.MessageId {
+		t.Error("Actual: ", sagaID, "Expected: ", *first.MessageId)
+	}
+
+	if err := workers[2].Handle(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	if len(topic.Published) != 1 || *topic.Published[0].TopicArn != topicBase+"Completed" || *topic.Published[0].Message != "order" {
+		t.Error("Expected the result of the saga to be published, got ", topic.Published)
+	}
+}
+
+func TestSagaCompensate(t *testing.T) {
+	queue, topic := &PipelineQueue{}, &PipelineTopic{}
+	s, reserve, charge := newSaga(queue, topic, &FailingWorker{Err: sqsworker.Fatal(errors.New("out of stock"))})
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+	workers, queueURLs := s.Pipeline.Workers, s.Pipeline.QueueURLs
+
+	ctx := context.Background()
+	first := workertest.NewMessage("ORDER")
+	if err := workers[0].Handle(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := workers[1].Handle(ctx, received(queue.Sent[0])); err != nil {
+		t.Fatal(err)
+	}
+
+	// The failed step sends the compensation back through the previous steps
+	if err := workers[2].Handle(ctx, received(queue.Sent[1])); err != nil {
+		t.Fatal(err)
+	}
+	compensation := queue.Sent[2]
+	if *compensation.QueueUrl != queueURLs[1] || *compensation.MessageAttributes[sqsworker.SagaActionAttr].StringValue != sqsworker.SagaCompensate {
+		t.Fatal("Expected a compensation for the previous step, got ", compensation)
+	}
+	if sagaID := *compensation.MessageAttributes[sqsworker.DefaultSagaIDAttr].StringValue; sagaID != *first.MessageId {
+		t.Error("Actual: ", sagaID, "Expected: ", *first.MessageId)
+	}
+	if err := workers[1].Handle(ctx, received(compensation)); err != nil {
+		t.Fatal(err)
+	}
+	if err := workers[0].Handle(ctx, received(queue.Sent[3])); err != nil {
+		t.Fatal(err)
+	}
+	if len(charge.Bodies) != 1 || len(reserve.Bodies) != 1 || reserve.Bodies[0] != "order" {
+		t.Error("Expected every previous step to be compensated: ", charge.Bodies, reserve.Bodies)
+	}
+	if len(topic.Published) != 1 || *topic.Published[0].TopicArn != topicBase+"Canceled" {
+		t.Error("Expected the compensated saga to be published, got ", topic.Published)
+	}
+}
+
+func TestSagaRetry(t *testing.T) {
+	queue, topic := &PipelineQueue{}, &PipelineTopic{}
+	s, _, _ := newSaga(queue, topic, &FailingWorker{Err: errors.New("carrier unavailable")})
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Errors are retried until the step's MaxAttempts
+	ctx := context.Background()
+	m := workertest.NewMessage("order", workertest.SystemAttribute(sqs.MessageSystemAttributeNameApproximateReceiveCount, "2"))
+	if err := s.Pipeline.Workers[2].Handle(ctx, m); err == nil || len(queue.Sent) != 0 {
+		t.Error("Expected the step to be retried")
+	}
+	m.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount] = aws.String("3")
+	if err := s.Pipeline.Workers[2].Handle(ctx, m); err != nil || len(queue.Sent) != 1 {
+		t.Error("Expected the saga to be compensated: ", err)
+	}
+}
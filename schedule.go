@@ -0,0 +1,108 @@
+package sqsworker
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultScheduleInterval is how often a worker checks whether its Schedule is open
+const DefaultScheduleInterval = 30 * time.Second
+
+// DefaultShouldPollPause is how long a worker waits before calling ShouldPoll again after it
+// returned false
+const DefaultShouldPollPause = time.Second
+
+// Window is a daily period during which a worker polls, from Start to End after midnight. A
+// window ending before it starts spans midnight, e.g. from 22h to 6h.
+type Window struct {
+	Start time.Duration
+	End   time.Duration
+	// Days the window starts on, every day when empty
+	Days []time.Weekday
+}
+
+// Schedule restricts polling to its windows, e.g. for batch queues only consumed overnight.
+// Outside of every window polling is paused while the consumers finish the messages already
+// received, and it resumes when the next window opens.
+type Schedule struct {
+	Windows []Window
+	// Location of the windows' times, by default time.Local
+	Location *time.Location
+	// Drain keeps polling after a window closes until the queues are empty, for at most Drain
+	Drain time.Duration
+	// Interval defaults to DefaultScheduleInterval
+	Interval time.Duration
+}
+
+// starts reports whether the window starts on the day
+func (w Window) starts(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether the window is open at the time
+func (w Window) contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End && w.starts(t.Weekday())
+	}
+	// windows spanning midnight opened today, or the day before
+	return offset >= w.Start && w.starts(t.Weekday()) || offset < w.End && w.starts(midnight.AddDate(0, 0, -1).Weekday())
+}
+
+// Open reports whether any window is open at the time
+func (s *Schedule) Open(t time.Time) bool {
+	if s.Location != nil {
+		t = t.In(s.Location)
+	}
+	for _, w := range s.Windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// schedule pauses and resumes polling as the Schedule's windows close and open, until the
+// context is done. Polling paused by Drain or Apply is only resumed by the schedule when the
+// next window opens.
+func (w *Worker) schedule(ctx context.Context) {
+	interval := w.Schedule.Interval
+	if interval == 0 {
+		interval = DefaultScheduleInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	open := w.Schedule.Open(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		opened := w.Schedule.Open(time.Now())
+		switch {
+		case opened && !open:
+			w.logInfo("Schedule window opened")
+			w.Resume()
+		case !opened && open:
+			w.logInfo("Schedule window closed")
+			if w.Schedule.Drain > 0 {
+				drain, cancel := context.WithTimeout(ctx, w.Schedule.Drain)
+				w.WaitForIdle(drain)
+				cancel()
+			}
+			w.pause()
+		}
+		open = opened
+	}
+}
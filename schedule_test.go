@@ -0,0 +1,133 @@
+package sqsworker_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"go.uber.org/zap"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScheduleOpen(t *testing.T) {
+	schedule := &sqsworker.Schedule{
+		Windows: []sqsworker.Window{
+			// weekday nights, from 22h to 6h
+			{Start: 22 * time.Hour, End: 6 * time.Hour, Days: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}},
+			{Start: 12 * time.Hour, End: 13 * time.Hour, Days: []time.Weekday{time.Sunday}},
+		},
+		Location: time.UTC,
+	}
+	// 2024-01-01 is a Monday
+	tests := []struct {
+		time string
+		open bool
+	}{
+		{"2024-01-01T21:59:00Z", false},
+		{"2024-01-01T22:00:00Z", true},
+		{"2024-01-02T05:59:00Z", true},
+		{"2024-01-02T06:00:00Z", false},
+		{"2024-01-01T03:00:00Z", false}, // Sunday night
+		{"2024-01-06T03:00:00Z", true},  // Friday night
+		{"2024-01-06T22:00:00Z", false}, // Saturday
+		{"2024-01-07T12:30:00Z", true},
+		{"2024-01-07T13:00:00Z", false},
+		{"2024-01-07T23:00:00+02:00", false},
+	}
+	for _, test := range tests {
+		at, _ := time.Parse(time.RFC3339, test.time)
+		if open := schedule.Open(at); open != test.open {
+			t.Error(test.time, " Actual: ", open, "Expected: ", test.open)
+		}
+	}
+}
+
+// noon returns a location where it is currently about noon, so windows around the current
+// time do not span midnight, and the time since midnight there
+func noon() (*time.Location, time.Duration) {
+	now := time.Now().UTC()
+	elapsed := now.Sub(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC))
+	location := time.FixedZone("noon", int((12*time.Hour-elapsed)/time.Second))
+	now = now.In(location)
+	return location, now.Sub(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location))
+}
+
+func TestSchedule(t *testing.T) {
+	queue := &CountingQueue{}
+	location, now := noon()
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+		Schedule: &sqsworker.Schedule{
+			// the window closes in 50ms
+			Windows:  []sqsworker.Window{{Start: now - time.Hour, End: now + 50*time.Millisecond}},
+			Location: location,
+			Interval: 5 * time.Millisecond,
+		},
+	})
+	w.Queue = queue
+	go w.Run()
+	defer w.Close()
+
+	time.Sleep(20 * time.Millisecond)
+	if w.Paused() || atomic.LoadInt32(&queue.Receives) == 0 {
+		t.Fatal("Expected the worker to poll during the window")
+	}
+	time.Sleep(100 * time.Millisecond)
+	receives := atomic.LoadInt32(&queue.Receives)
+	time.Sleep(20 * time.Millisecond)
+	if !w.Paused() || atomic.LoadInt32(&queue.Receives) != receives {
+		t.Error("Expected the worker to pause after the window")
+	}
+}
+
+func TestScheduleClosed(t *testing.T) {
+	queue := &CountingQueue{}
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+		Schedule:  &sqsworker.Schedule{},
+	})
+	w.Queue = queue
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		w.Close()
+	}()
+	w.Run()
+	if receives := atomic.LoadInt32(&queue.Receives); receives != 0 {
+		t.Error("Expected a worker started outside of its schedule not to poll, got ", receives)
+	}
+}
+
+func TestShouldPoll(t *testing.T) {
+	queue := &CountingQueue{}
+	var healthy int32
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+		ShouldPoll: func(ctx context.Context) bool {
+			return atomic.LoadInt32(&healthy) == 1
+		},
+		ShouldPollPause: time.Millisecond,
+	})
+	w.Queue = queue
+	go w.Run()
+	defer w.Close()
+
+	time.Sleep(20 * time.Millisecond)
+	if receives := atomic.LoadInt32(&queue.Receives); receives != 0 {
+		t.Fatal("Expected no receives while ShouldPoll returns false, got ", receives)
+	}
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(20 * time.Millisecond)
+	if receives := atomic.LoadInt32(&queue.Receives); receives == 0 {
+		t.Error("Expected the worker to poll once ShouldPoll returns true")
+	}
+}
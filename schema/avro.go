@@ -0,0 +1,102 @@
+package schema
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/linkedin/goavro/v2"
+)
+
+// AvroContentType is the content type of Avro encoded message bodies
+const AvroContentType = "application/avro"
+
+// AvroCodec encodes message bodies with an Avro schema. Message bodies are text, so the Avro
+// binary encoding of a value is base64 encoded. Values are converted to and from Avro through
+// their JSON encoding, with unions written as plain JSON values, so structs with json tags
+// matching the schema's fields are encoded without any Avro specific code.
+type AvroCodec struct {
+	codec *goavro.Codec
+}
+
+// NewAvroCodec creates an AvroCodec from an embedded schema
+func NewAvroCodec(schema string) (*AvroCodec, error) {
+	codec, err := goavro.NewCodecForStandardJSONFull(schema)
+	if err != nil {
+		return nil, fmt.Errorf("schema: invalid Avro schema: %v", err)
+	}
+	return &AvroCodec{codec: codec}, nil
+}
+
+// Schema returns the canonical form of the codec's schema
+func (c *AvroCodec) Schema() string {
+	return c.codec.CanonicalSchema()
+}
+
+// ContentType returns AvroContentType
+func (c *AvroCodec) ContentType() string {
+	return AvroContentType
+}
+
+// Marshal encodes v as the base64 encoded Avro binary of the codec's schema
+func (c *AvroCodec) Marshal(v interface{}) ([]byte, error) {
+	textual, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	native, _, err := c.codec.NativeFromTextual(textual)
+	if err != nil {
+		return nil, fmt.Errorf("schema: value does not match the Avro schema: %v", err)
+	}
+	binary, err := c.codec.BinaryFromNative(nil, native)
+	if err != nil {
+		return nil, fmt.Errorf("schema: value does not match the Avro schema: %v", err)
+	}
+	body := make([]byte, base64.StdEncoding.EncodedLen(len(binary)))
+	base64.StdEncoding.Encode(body, binary)
+	return body, nil
+}
+
+// Unmarshal decodes a body encoded by Marshal into v
+func (c *AvroCodec) Unmarshal(body []byte, v interface{}) error {
+	native, err := c.decode(body)
+	if err != nil {
+		return err
+	}
+	textual, err := c.codec.TextualFromNative(nil, native)
+	if err != nil {
+		return fmt.Errorf("schema: invalid Avro body: %v", err)
+	}
+	return json.Unmarshal(textual, v)
+}
+
+// UnmarshalMessage decodes a message body into v. Bodies that cannot be decoded are
+// sqsworker.Invalid, and quarantined.
+func (c *AvroCodec) UnmarshalMessage(m *sqs.Message, v interface{}) error {
+	return sqsworker.Invalid(c.Unmarshal([]byte(aws.StringValue(m.Body)), v))
+}
+
+// Validate checks that a body is the Avro binary of a value of the codec's schema
+func (c *AvroCodec) Validate(body []byte) error {
+	_, err := c.decode(body)
+	return err
+}
+
+// decode returns the native Avro value of a body, which must hold exactly one value
+func (c *AvroCodec) decode(body []byte) (interface{}, error) {
+	binary := make([]byte, base64.StdEncoding.DecodedLen(len(body)))
+	n, err := base64.StdEncoding.Decode(binary, body)
+	if err != nil {
+		return nil, fmt.Errorf("schema: Avro body is not base64 encoded: %v", err)
+	}
+	native, rest, err := c.codec.NativeFromBinary(binary[:n])
+	if err != nil {
+		return nil, fmt.Errorf("schema: invalid Avro body: %v", err)
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("schema: invalid Avro body: %d trailing bytes", len(rest))
+	}
+	return native, nil
+}
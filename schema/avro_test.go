@@ -0,0 +1,128 @@
+package schema_test
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/schema"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"testing"
+)
+
+const avroOrderSchema = `{
+	"type": "record",
+	"name": "Order",
+	"fields": [
+		{"name": "id", "type": "string"},
+		{"name": "total", "type": "double"},
+		{"name": "coupon", "type": ["null", "string"], "default": null}
+	]
+}`
+
+type AvroOrder struct {
+	ID     string  `json:"id"`
+	Total  float64 `json:"total"`
+	Coupon *string `json:"coupon"`
+}
+
+func TestAvroCodec(t *testing.T) {
+	codec, err := schema.NewAvroCodec(avroOrderSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, order := range []AvroOrder{{ID: "o-1", Total: 2.5}, {ID: "o-2", Coupon: aws.String("SAVE")}} {
+		body, err := codec.Marshal(order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded AvroOrder
+		if err := codec.Unmarshal(body, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.ID != order.ID || decoded.Total != order.Total || aws.StringValue(decoded.Coupon) != aws.StringValue(order.Coupon) {
+			t.Error("Actual: ", decoded, "Expected: ", order)
+		}
+	}
+
+	body, _ := codec.Marshal(AvroOrder{ID: "o-1"})
+	binary, _ := base64.StdEncoding.DecodeString(string(body))
+	trailing := base64.StdEncoding.EncodeToString(append(binary, 0))
+	for _, body := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte{0x07}), trailing} {
+		if err := codec.Validate([]byte(body)); err == nil {
+			t.Error("Expected ", body, " to be invalid")
+		}
+	}
+	if _, err := codec.Marshal(map[string]interface{}{"id": 1}); err == nil {
+		t.Error("Expected a value not matching the schema to fail")
+	}
+	if _, err := schema.NewAvroCodec(`{"type": "record"}`); err == nil {
+		t.Error("Expected an invalid schema to fail")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	codec, _ := schema.NewAvroCodec(avroOrderSchema)
+	var orders []AvroOrder
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn: "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: schema.Handler(codec.UnmarshalMessage, func(ctx context.Context, order AvroOrder) (*sns.PublishInput, error) {
+			orders = append(orders, order)
+			return &sns.PublishInput{Message: aws.String(order.ID)}, nil
+		}),
+		QuarantineQueueURL: workertest.QueueURL + "-quarantine",
+	})
+
+	body, _ := codec.Marshal(AvroOrder{ID: "o-1", Total: 3})
+	h.Run(workertest.NewMessage(string(body))).Succeeded().Deleted().Published("o-1")
+	if len(orders) != 1 || orders[0].Total != 3 {
+		t.Error("unexpected orders: ", orders)
+	}
+	h.Run(workertest.NewMessage(`{"id":"o-2"}`)).Failed().Quarantined()
+
+	// Pointers are allocated, and results are optional
+	var handled *AvroOrder
+	h = workertest.New(t, sqsworker.WorkerConfig{
+		Processor: schema.Handler(codec.UnmarshalMessage, func(ctx context.Context, order *AvroOrder) error {
+			handled = order
+			return nil
+		}),
+	})
+	h.Run(workertest.NewMessage(string(body))).Succeeded().Deleted().NotPublished()
+	if handled == nil || handled.ID != "o-1" {
+		t.Error("unexpected order: ", handled)
+	}
+
+	for _, handler := range []interface{}{"handler", func(AvroOrder) error { return nil }, func(context.Context, AvroOrder) string { return "" }} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected %T to panic", handler)
+				}
+			}()
+			schema.Handler(codec.UnmarshalMessage, handler)
+		}()
+	}
+}
+
+func TestRegistryAvro(t *testing.T) {
+	codec, _ := schema.NewAvroCodec(avroOrderSchema)
+	registry := schema.NewRegistry(&Glue{}, "events")
+	var orders []AvroOrder
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: schema.Handler(registry.Unmarshal, func(ctx context.Context, order AvroOrder) error {
+			orders = append(orders, order)
+			return nil
+		}),
+		Validator:          registry.Validator,
+		QuarantineQueueURL: workertest.QueueURL + "-quarantine",
+	})
+
+	body, _ := codec.Marshal(AvroOrder{ID: "o-1", Total: 3})
+	h.Run(workertest.NewMessage(string(body), workertest.Attribute(schema.VersionAttribute, avroV1))).Succeeded().Deleted()
+	if len(orders) != 1 || orders[0].ID != "o-1" {
+		t.Error("unexpected orders: ", orders)
+	}
+	h.Run(workertest.NewMessage(`{"id":"o-2"}`, workertest.Attribute(schema.VersionAttribute, avroV1))).Failed().Quarantined()
+}
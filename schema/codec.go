@@ -0,0 +1,172 @@
+package schema
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"google.golang.org/protobuf/proto"
+	"strings"
+)
+
+// Content types of the codecs in this package
+const (
+	GzipJSONContentType = "application/json+gzip"
+	ProtobufContentType = "application/x-protobuf"
+)
+
+var (
+	// GzipJSON encodes values as gzipped JSON, base64 encoded since message bodies are text
+	GzipJSON sqsworker.Codec = gzipJSONCodec{}
+	// Protobuf encodes protocol buffer messages in their base64 encoded binary format. Values
+	// must implement proto.Message.
+	Protobuf sqsworker.Codec = protobufCodec{}
+)
+
+type gzipJSONCodec struct{}
+
+func (gzipJSONCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := base64.NewEncoder(base64.StdEncoding, &buf)
+	w := gzip.NewWriter(encoder)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipJSONCodec) Unmarshal(body []byte, v interface{}) error {
+	body, err := sqsworker.Base64(body)
+	if err != nil {
+		return err
+	}
+	if body, err = sqsworker.Gzip(body); err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+func (gzipJSONCodec) ContentType() string {
+	return GzipJSONContentType
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("schema: %T is not a protocol buffer message", v)
+	}
+	binary, err := proto.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	body := make([]byte, base64.StdEncoding.EncodedLen(len(binary)))
+	base64.StdEncoding.Encode(body, binary)
+	return body, nil
+}
+
+func (protobufCodec) Unmarshal(body []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("schema: %T is not a protocol buffer message", v)
+	}
+	binary, err := sqsworker.Base64(body)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(binary, m)
+}
+
+func (protobufCodec) ContentType() string {
+	return ProtobufContentType
+}
+
+// Negotiator chooses the codec of each message by its sqsworker.ContentTypeAttribute, so producers of a
+// queue can move to another format without all its consumers changing at once. Results are
+// encoded with the Default codec.
+type Negotiator struct {
+	// Codecs decode the messages with their content type
+	Codecs []sqsworker.Codec
+	// Default encodes results, and decodes messages without a content type. It does not have
+	// to be in Codecs.
+	Default sqsworker.Codec
+}
+
+// NewNegotiator creates a Negotiator decoding JSON, gzipped JSON and protocol buffers, and the
+// content types of any other codecs, e.g. an AvroCodec. Results are encoded with the default.
+func NewNegotiator(def sqsworker.Codec, codecs ...sqsworker.Codec) *Negotiator {
+	return &Negotiator{Codecs: append([]sqsworker.Codec{sqsworker.JSON, GzipJSON, Protobuf}, codecs...), Default: def}
+}
+
+// codec returns the codec of a message's content type. Parameters of the content type, such as
+// a charset, are ignored.
+func (n *Negotiator) codec(m *sqs.Message) (sqsworker.Codec, error) {
+	attr, ok := m.MessageAttributes[sqsworker.ContentTypeAttribute]
+	if !ok || aws.StringValue(attr.StringValue) == "" {
+		if n.Default == nil {
+			return nil, errors.New("schema: message has no content type")
+		}
+		return n.Default, nil
+	}
+	contentType := strings.TrimSpace(strings.SplitN(*attr.StringValue, ";", 2)[0])
+	if n.Default != nil && strings.EqualFold(n.Default.ContentType(), contentType) {
+		return n.Default, nil
+	}
+	for _, codec := range n.Codecs {
+		if strings.EqualFold(codec.ContentType(), contentType) {
+			return codec, nil
+		}
+	}
+	return nil, fmt.Errorf("schema: unsupported content type %s", contentType)
+}
+
+// Validator is a sqsworker.Validator quarantining messages with a content type that has no codec
+func (n *Negotiator) Validator(m *sqs.Message) error {
+	_, err := n.codec(m)
+	return err
+}
+
+// UnmarshalMessage decodes a message body into v with the codec of its content type. Bodies that
+// cannot be decoded are sqsworker.Invalid, and quarantined.
+func (n *Negotiator) UnmarshalMessage(m *sqs.Message, v interface{}) error {
+	codec, err := n.codec(m)
+	if err != nil {
+		return sqsworker.Invalid(err)
+	}
+	return sqsworker.Invalid(codec.Unmarshal([]byte(aws.StringValue(m.Body)), v))
+}
+
+// Marshal encodes v into a result with the Default codec, setting its content type in the
+// sqsworker.ContentTypeAttribute. Values that cannot be encoded fail with a Fatal error.
+func (n *Negotiator) Marshal(v interface{}) (*sns.PublishInput, error) {
+	if n.Default == nil {
+		return nil, sqsworker.Fatal(errors.New("schema: Negotiator has no Default codec"))
+	}
+	body, err := n.Default.Marshal(v)
+	if err != nil {
+		return nil, sqsworker.Fatal(err)
+	}
+	return &sns.PublishInput{
+		Message: aws.String(string(body)),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{
+			sqsworker.ContentTypeAttribute: contentTypeValue(n.Default.ContentType()),
+		},
+	}, nil
+}
+
+func contentTypeValue(contentType string) *sns.MessageAttributeValue {
+	return &sns.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(contentType)}
+}
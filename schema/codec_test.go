@@ -0,0 +1,98 @@
+package schema_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/schema"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"google.golang.org/protobuf/types/known/structpb"
+	"testing"
+)
+
+func TestCodecs(t *testing.T) {
+	for _, codec := range []sqsworker.Codec{sqsworker.JSON, schema.GzipJSON} {
+		body, err := codec.Marshal(Order{ID: "o-1", Total: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var order Order
+		if err := codec.Unmarshal(body, &order); err != nil || order.ID != "o-1" || order.Total != 2 {
+			t.Error(codec.ContentType(), " unexpected order: ", order, err)
+		}
+	}
+
+	body, err := schema.Protobuf.Marshal(structpb.NewStringValue("o-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value structpb.Value
+	if err := schema.Protobuf.Unmarshal(body, &value); err != nil || value.GetStringValue() != "o-1" {
+		t.Error("unexpected value: ", value.GetStringValue(), err)
+	}
+	if _, err := schema.Protobuf.Marshal(Order{}); err == nil {
+		t.Error("Expected a value that is not a protocol buffer message to fail")
+	}
+}
+
+func TestNegotiator(t *testing.T) {
+	avro, _ := schema.NewAvroCodec(avroOrderSchema)
+	negotiator := schema.NewNegotiator(avro)
+	var orders []string
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn: "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: schema.Handler(negotiator.UnmarshalMessage, func(ctx context.Context, order AvroOrder) (*sns.PublishInput, error) {
+			orders = append(orders, order.ID)
+			return negotiator.Marshal(order)
+		}),
+		Validator:          negotiator.Validator,
+		QuarantineQueueURL: workertest.QueueURL + "-quarantine",
+	})
+
+	avroBody, _ := avro.Marshal(AvroOrder{ID: "o-1"})
+	gzipBody, _ := schema.GzipJSON.Marshal(AvroOrder{ID: "o-3"})
+	messages := []struct{ body, contentType string }{
+		{string(avroBody), ""},
+		{`{"id":"o-2","total":1}`, "application/json; charset=utf-8"},
+		{string(gzipBody), schema.GzipJSONContentType},
+		{string(avroBody), schema.AvroContentType},
+	}
+	for _, m := range messages {
+		var options []workertest.MessageOption
+		if m.contentType != "" {
+			options = append(options, workertest.Attribute(sqsworker.ContentTypeAttribute, m.contentType))
+		}
+		result := h.Run(workertest.NewMessage(m.body, options...)).Succeeded().Deleted()
+		// results are encoded with the default codec
+		output := result.Publishes[0]
+		if actual := aws.StringValue(output.MessageAttributes[sqsworker.ContentTypeAttribute].StringValue); actual != schema.AvroContentType {
+			t.Error("Actual: ", actual, "Expected: ", schema.AvroContentType)
+		}
+		if err := avro.Validate([]byte(aws.StringValue(output.Message))); err != nil {
+			t.Error(err)
+		}
+	}
+	if len(orders) != 4 || orders[1] != "o-2" || orders[2] != "o-3" {
+		t.Error("unexpected orders: ", orders)
+	}
+
+	h.Run(workertest.NewMessage("<order/>", workertest.Attribute(sqsworker.ContentTypeAttribute, "application/xml"))).Failed().Quarantined()
+	h.Run(workertest.NewMessage("not json", workertest.Attribute(sqsworker.ContentTypeAttribute, sqsworker.JSONContentType))).Failed().Quarantined()
+}
+
+func TestHandlerWorkerCodec(t *testing.T) {
+	var orders []Order
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: schema.Handler(nil, func(ctx context.Context, order Order) error {
+			orders = append(orders, order)
+			return nil
+		}),
+		Codec: schema.GzipJSON,
+	})
+	body, _ := schema.GzipJSON.Marshal(Order{ID: "o-1"})
+	h.Run(workertest.NewMessage(string(body))).Succeeded().Deleted()
+	if len(orders) != 1 || orders[0].ID != "o-1" {
+		t.Error("unexpected orders: ", orders)
+	}
+}
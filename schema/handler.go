@@ -0,0 +1,68 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"reflect"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	outputType  = reflect.TypeOf((*sns.PublishInput)(nil))
+)
+
+// Unmarshaler unmarshals a message body into a value, like the UnmarshalMessage method of an
+// AvroCodec or the Unmarshal method of a Registry
+type Unmarshaler func(m *sqs.Message, v interface{}) error
+
+// Handler adapts a typed function to a sqsworker.Processor. The handler is a function of one of
+// the forms
+//
+//	func(ctx context.Context, v T) error
+//	func(ctx context.Context, v T) (*sns.PublishInput, error)
+//
+// and each message body is unmarshaled into a new T before it is called, so handlers contain no
+// decoding code. A nil unmarshal decodes bodies with the worker's Codec. T may be a pointer.
+// Handler panics when the handler is not of either form.
+func Handler(unmarshal Unmarshaler, handler interface{}) sqsworker.Processor {
+	fn := reflect.ValueOf(handler)
+	t := fn.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 2 || t.In(0) != contextType ||
+		!(t.NumOut() == 1 && t.Out(0) == errorType || t.NumOut() == 2 && t.Out(0) == outputType && t.Out(1) == errorType) {
+		panic(fmt.Sprintf("schema: handler must be a func(context.Context, T) error or func(context.Context, T) (*sns.PublishInput, error), not %s", t))
+	}
+
+	in := t.In(1)
+	return sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+		var v reflect.Value
+		if in.Kind() == reflect.Ptr {
+			v = reflect.New(in.Elem())
+		} else {
+			v = reflect.New(in)
+		}
+		var err error
+		if unmarshal == nil {
+			err = sqsworker.Unmarshal(ctx, m, v.Interface())
+		} else {
+			err = unmarshal(m, v.Interface())
+		}
+		if err != nil {
+			return nil, err
+		}
+		if in.Kind() != reflect.Ptr {
+			v = v.Elem()
+		}
+
+		out := fn.Call([]reflect.Value{reflect.ValueOf(ctx), v})
+		err, _ = out[len(out)-1].Interface().(error)
+		if len(out) == 1 {
+			return nil, err
+		}
+		output, _ := out[0].Interface().(*sns.PublishInput)
+		return output, err
+	})
+}
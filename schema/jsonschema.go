@@ -0,0 +1,344 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonSchema validates JSON documents against a JSON Schema. It checks the keywords contracts
+// are written with: $ref to local definitions, type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, minLength, maxLength, pattern, minimum,
+// maximum, exclusiveMinimum, exclusiveMaximum, allOf, anyOf, oneOf and not. Other keywords,
+// such as format, are ignored.
+type jsonSchema struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// parseJSONSchema parses a JSON Schema definition, compiling its patterns
+func parseJSONSchema(definition string) (*jsonSchema, error) {
+	s := &jsonSchema{patterns: make(map[string]*regexp.Regexp)}
+	if err := json.Unmarshal([]byte(definition), &s.root); err != nil {
+		return nil, fmt.Errorf("schema: invalid JSON Schema: %v", err)
+	}
+	if err := s.compile(s.root); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// compile compiles the patterns of a schema and the schemas it contains
+func (s *jsonSchema) compile(schema interface{}) error {
+	switch schema := schema.(type) {
+	case map[string]interface{}:
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("schema: invalid pattern %q: %v", pattern, err)
+			}
+			s.patterns[pattern] = re
+		}
+		for _, value := range schema {
+			if err := s.compile(value); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, value := range schema {
+			if err := s.compile(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks a JSON document against the schema
+func (s *jsonSchema) validate(data []byte) error {
+	var value interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&value); err != nil {
+		return fmt.Errorf("schema: invalid JSON: %v", err)
+	}
+	return s.check(s.root, value, "$", 0)
+}
+
+// maxRefDepth bounds the $ref chains followed, so recursive definitions cannot loop forever
+const maxRefDepth = 64
+
+// check validates a value at path against a schema
+func (s *jsonSchema) check(schema interface{}, value interface{}, path string, depth int) error {
+	switch schema := schema.(type) {
+	case bool:
+		if !schema {
+			return fmt.Errorf("schema: %s is not allowed", path)
+		}
+		return nil
+	case map[string]interface{}:
+		if ref, ok := schema["$ref"].(string); ok {
+			if depth >= maxRefDepth {
+				return fmt.Errorf("schema: %s: too many nested $refs", path)
+			}
+			target, err := s.resolve(ref)
+			if err != nil {
+				return err
+			}
+			return s.check(target, value, path, depth+1)
+		}
+		for _, check := range []func(map[string]interface{}, interface{}, string, int) error{
+			s.checkType, s.checkEnum, s.checkObject, s.checkArray, s.checkString, s.checkNumber, s.checkCombined,
+		} {
+			if err := check(schema, value, path, depth); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("schema: invalid schema at %s", path)
+	}
+}
+
+// resolve returns the schema a local reference points to, e.g. #/definitions/address
+func (s *jsonSchema) resolve(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("schema: only local references are supported, not %s", ref)
+	}
+	target := s.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		object, ok := target.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("schema: unresolved reference %s", ref)
+		}
+		if target, ok = object[token]; !ok {
+			return nil, fmt.Errorf("schema: unresolved reference %s", ref)
+		}
+	}
+	return target, nil
+}
+
+// typeOf returns the JSON Schema type of a decoded value
+func typeOf(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := value.Int64(); err == nil {
+			return "integer"
+		}
+		if f, err := value.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func (s *jsonSchema) checkType(schema map[string]interface{}, value interface{}, path string, depth int) error {
+	var types []interface{}
+	switch t := schema["type"].(type) {
+	case nil:
+		return nil
+	case string:
+		types = []interface{}{t}
+	case []interface{}:
+		types = t
+	}
+	actual := typeOf(value)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return nil
+		}
+	}
+	return fmt.Errorf("schema: %s is %s, not %v", path, actual, schema["type"])
+}
+
+func (s *jsonSchema) checkEnum(schema map[string]interface{}, value interface{}, path string, depth int) error {
+	if c, ok := schema["const"]; ok && !equal(c, value) {
+		return fmt.Errorf("schema: %s must be %v", path, c)
+	}
+	enum, ok := schema["enum"].([]interface{})
+	if !ok {
+		return nil
+	}
+	for _, e := range enum {
+		if equal(e, value) {
+			return nil
+		}
+	}
+	return fmt.Errorf("schema: %s must be one of %v", path, enum)
+}
+
+// equal compares decoded JSON values, numbers by their value
+func equal(schema, value interface{}) bool {
+	if n, ok := value.(json.Number); ok {
+		f, ok := schema.(float64)
+		actual, err := n.Float64()
+		return ok && err == nil && f == actual
+	}
+	return reflect.DeepEqual(schema, value)
+}
+
+func (s *jsonSchema) checkObject(schema map[string]interface{}, value interface{}, path string, depth int) error {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, ok := object[name]; !ok {
+					return fmt.Errorf("schema: %s is missing required property %s", path, name)
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	additional, hasAdditional := schema["additionalProperties"]
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if property, ok := properties[name]; ok {
+			if err := s.check(property, object[name], path+"."+name, depth); err != nil {
+				return err
+			}
+		} else if hasAdditional {
+			if err := s.check(additional, object[name], path+"."+name, depth); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) checkArray(schema map[string]interface{}, value interface{}, path string, depth int) error {
+	array, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	if min, ok := schema["minItems"].(float64); ok && float64(len(array)) < min {
+		return fmt.Errorf("schema: %s must have at least %v items", path, min)
+	}
+	if max, ok := schema["maxItems"].(float64); ok && float64(len(array)) > max {
+		return fmt.Errorf("schema: %s must have at most %v items", path, max)
+	}
+	switch items := schema["items"].(type) {
+	case nil:
+	case []interface{}:
+		// a tuple, each item has its own schema
+		for i, item := range array {
+			if i >= len(items) {
+				break
+			}
+			if err := s.check(items[i], item, path+"["+strconv.Itoa(i)+"]", depth); err != nil {
+				return err
+			}
+		}
+	default:
+		for i, item := range array {
+			if err := s.check(items, item, path+"["+strconv.Itoa(i)+"]", depth); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) checkString(schema map[string]interface{}, value interface{}, path string, depth int) error {
+	str, ok := value.(string)
+	if !ok {
+		return nil
+	}
+	n := float64(utf8.RuneCountInString(str))
+	if min, ok := schema["minLength"].(float64); ok && n < min {
+		return fmt.Errorf("schema: %s must be at least %v characters", path, min)
+	}
+	if max, ok := schema["maxLength"].(float64); ok && n > max {
+		return fmt.Errorf("schema: %s must be at most %v characters", path, max)
+	}
+	if pattern, ok := schema["pattern"].(string); ok && !s.patterns[pattern].MatchString(str) {
+		return fmt.Errorf("schema: %s does not match %s", path, pattern)
+	}
+	return nil
+}
+
+func (s *jsonSchema) checkNumber(schema map[string]interface{}, value interface{}, path string, depth int) error {
+	n, ok := value.(json.Number)
+	if !ok {
+		return nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return fmt.Errorf("schema: %s: %v", path, err)
+	}
+	if min, ok := schema["minimum"].(float64); ok && f < min {
+		return fmt.Errorf("schema: %s must be at least %v", path, min)
+	}
+	if max, ok := schema["maximum"].(float64); ok && f > max {
+		return fmt.Errorf("schema: %s must be at most %v", path, max)
+	}
+	if min, ok := schema["exclusiveMinimum"].(float64); ok && f <= min {
+		return fmt.Errorf("schema: %s must be greater than %v", path, min)
+	}
+	if max, ok := schema["exclusiveMaximum"].(float64); ok && f >= max {
+		return fmt.Errorf("schema: %s must be less than %v", path, max)
+	}
+	return nil
+}
+
+func (s *jsonSchema) checkCombined(schema map[string]interface{}, value interface{}, path string, depth int) error {
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			if err := s.check(sub, value, path, depth); err != nil {
+				return err
+			}
+		}
+	}
+	if any, ok := schema["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, sub := range any {
+			if s.check(sub, value, path, depth) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("schema: %s does not match any of the anyOf schemas", path)
+		}
+	}
+	if one, ok := schema["oneOf"].([]interface{}); ok {
+		matched := 0
+		for _, sub := range one {
+			if s.check(sub, value, path, depth) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fmt.Errorf("schema: %s matches %d of the oneOf schemas, not exactly one", path, matched)
+		}
+	}
+	if not, ok := schema["not"]; ok && s.check(not, value, path, depth) == nil {
+		return fmt.Errorf("schema: %s must not match the not schema", path)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package schema
+
+import (
+	"testing"
+)
+
+func TestJSONSchema(t *testing.T) {
+	s, err := parseJSONSchema(`{
+		"definitions": {
+			"item": {
+				"type": "object",
+				"properties": {
+					"sku": {"type": "string", "minLength": 1, "maxLength": 8},
+					"quantity": {"type": "integer", "exclusiveMinimum": 0}
+				},
+				"required": ["sku"],
+				"additionalProperties": false
+			}
+		},
+		"type": "object",
+		"properties": {
+			"status": {"enum": ["placed", "shipped"]},
+			"items": {"type": "array", "items": {"$ref": "#/definitions/item"}, "minItems": 1, "maxItems": 2},
+			"note": {"type": ["string", "null"]},
+			"contact": {"oneOf": [
+				{"type": "object", "required": ["email"]},
+				{"type": "object", "required": ["phone"]}
+			]},
+			"version": {"const": 2},
+			"total": {"anyOf": [{"type": "integer"}, {"type": "string", "pattern": "^[0-9]+\\.[0-9]{2}$"}]},
+			"tag": {"allOf": [{"type": "string"}, {"not": {"const": "test"}}]}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := []string{
+		`{}`,
+		`{"status":"placed","items":[{"sku":"a","quantity":2}],"note":null,"version":2.0}`,
+		`{"items":[{"sku":"a"},{"sku":"b","quantity":1}],"note":"hi","contact":{"email":"a@b.c"}}`,
+		`{"total":12,"tag":"live"}`,
+		`{"total":"12.50"}`,
+	}
+	for _, body := range valid {
+		if err := s.validate([]byte(body)); err != nil {
+			t.Error("Expected ", body, " to be valid, got: ", err)
+		}
+	}
+
+	invalid := []string{
+		`[]`,
+		`not json`,
+		`{"status":"lost"}`,
+		`{"items":[]}`,
+		`{"items":[{"sku":"a"},{"sku":"b"},{"sku":"c"}]}`,
+		`{"items":[{"quantity":1}]}`,
+		`{"items":[{"sku":""}]}`,
+		`{"items":[{"sku":"123456789"}]}`,
+		`{"items":[{"sku":"a","quantity":0}]}`,
+		`{"items":[{"sku":"a","quantity":1.5}]}`,
+		`{"items":[{"sku":"a","colour":"red"}]}`,
+		`{"note":3}`,
+		`{"contact":{"email":"a@b.c","phone":"1"}}`,
+		`{"contact":{}}`,
+		`{"version":3}`,
+		`{"total":"12.5"}`,
+		`{"tag":"test"}`,
+	}
+	for _, body := range invalid {
+		if err := s.validate([]byte(body)); err == nil {
+			t.Error("Expected ", body, " to be invalid")
+		}
+	}
+
+	for _, definition := range []string{`{"pattern": "("}`, `not json`} {
+		if _, err := parseJSONSchema(definition); err == nil {
+			t.Error("Expected ", definition, " to be rejected")
+		}
+	}
+	looping, _ := parseJSONSchema(`{"definitions": {"a": {"$ref": "#/definitions/a"}}, "$ref": "#/definitions/a"}`)
+	if err := looping.validate([]byte(`{}`)); err == nil {
+		t.Error("Expected a looping reference to fail")
+	}
+}
@@ -0,0 +1,315 @@
+// Package schema checks message bodies against the schemas of an AWS Glue Schema Registry, so
+// producers and consumers share enforced contracts. Results are serialized and validated with
+// the latest version of a schema, and carry the id of that version in the VersionAttribute.
+// Received messages are validated and deserialized with the version they reference.
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/glue"
+	"github.com/aws/aws-sdk-go/service/glue/glueiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"sync"
+	"time"
+)
+
+// VersionAttribute is the message attribute holding the id of the schema version a body was
+// serialized with
+const VersionAttribute = "schema_version_id"
+
+// DefaultRegistryName is the registry Glue creates for every account
+const DefaultRegistryName = "default-registry"
+
+// DefaultRefresh is how long the latest version of a schema is cached before it is looked up
+// again. Versions looked up by id never change, and are cached for as long as the Registry.
+const DefaultRefresh = 5 * time.Minute
+
+// Data formats of schema versions
+const (
+	FormatJSON     = "JSON"
+	FormatAvro     = "AVRO"
+	FormatProtobuf = "PROTOBUF"
+)
+
+// Version is a version of a registered schema
+type Version struct {
+	ID         string
+	Number     int64
+	DataFormat string
+	Definition string
+	json       *jsonSchema
+	avro       *AvroCodec
+}
+
+// newVersion parses the definition of a schema version
+func newVersion(out *glue.GetSchemaVersionOutput) (*Version, error) {
+	v := &Version{
+		ID:         aws.StringValue(out.SchemaVersionId),
+		Number:     aws.Int64Value(out.VersionNumber),
+		DataFormat: aws.StringValue(out.DataFormat),
+		Definition: aws.StringValue(out.SchemaDefinition),
+	}
+	if status := aws.StringValue(out.Status); status != glue.SchemaVersionStatusAvailable {
+		return nil, fmt.Errorf("schema: version %s is %s", v.ID, status)
+	}
+	var err error
+	switch v.DataFormat {
+	case FormatJSON:
+		v.json, err = parseJSONSchema(v.Definition)
+	case FormatAvro:
+		v.avro, err = NewAvroCodec(v.Definition)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Validate checks that a body is valid for the schema version. Bodies of the JSON data format
+// are JSON documents, those of the Avro data format are encoded like an AvroCodec does.
+// Protobuf schemas are not supported.
+func (v *Version) Validate(body []byte) error {
+	switch {
+	case v.json != nil:
+		return v.json.validate(body)
+	case v.avro != nil:
+		return v.avro.Validate(body)
+	}
+	return fmt.Errorf("schema: %s schemas are not supported", v.DataFormat)
+}
+
+// ContentType returns the content type of the bodies of the schema version
+func (v *Version) ContentType() string {
+	switch v.DataFormat {
+	case FormatAvro:
+		return AvroContentType
+	case FormatProtobuf:
+		return ProtobufContentType
+	}
+	return sqsworker.JSONContentType
+}
+
+// Marshal serializes a value that is valid for the schema version
+func (v *Version) Marshal(value interface{}) ([]byte, error) {
+	if v.avro != nil {
+		return v.avro.Marshal(value)
+	}
+	body, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.Validate(body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// Unmarshal deserializes a body that is valid for the schema version into value
+func (v *Version) Unmarshal(body []byte, value interface{}) error {
+	if v.avro != nil {
+		return v.avro.Unmarshal(body, value)
+	}
+	if err := v.Validate(body); err != nil {
+		return err
+	}
+	return json.Unmarshal(body, value)
+}
+
+// latestVersion is the cached latest version of a schema
+type latestVersion struct {
+	version *Version
+	expires time.Time
+}
+
+// Registry looks up and caches the schema versions of a Glue Schema Registry
+type Registry struct {
+	Client glueiface.GlueAPI
+	// Name of the registry, by default DefaultRegistryName
+	Name string
+	// Refresh is how long the latest version of a schema is cached, by default DefaultRefresh
+	Refresh  time.Duration
+	mu       sync.Mutex
+	versions map[string]*Version
+	latest   map[string]latestVersion
+}
+
+// NewRegistry creates a Registry for the named registry
+func NewRegistry(client glueiface.GlueAPI, name string) *Registry {
+	return &Registry{Client: client, Name: name}
+}
+
+// registryError is the registry failing to return a schema version, which may succeed on
+// another attempt
+type registryError struct {
+	err error
+}
+
+func (r *registryError) Error() string {
+	return "schema: looking up schema version: " + r.err.Error()
+}
+
+func (r *registryError) Unwrap() error {
+	return r.err
+}
+
+// Temporary reports that the lookup may succeed on another attempt
+func (r *registryError) Temporary() bool {
+	return true
+}
+
+// getVersion looks up a schema version. A version that does not exist is not a registryError,
+// since looking it up again will not find it.
+func (r *Registry) getVersion(input *glue.GetSchemaVersionInput) (*Version, error) {
+	out, err := r.Client.GetSchemaVersion(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == glue.ErrCodeEntityNotFoundException {
+			return nil, fmt.Errorf("schema: version not found: %v", err)
+		}
+		return nil, &registryError{err}
+	}
+	return newVersion(out)
+}
+
+// Version returns the schema version with an id
+func (r *Registry) Version(id string) (*Version, error) {
+	r.mu.Lock()
+	v, ok := r.versions[id]
+	r.mu.Unlock()
+	if ok {
+		return v, nil
+	}
+
+	v, err := r.getVersion(&glue.GetSchemaVersionInput{SchemaVersionId: aws.String(id)})
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.versions == nil {
+		r.versions = make(map[string]*Version)
+	}
+	r.versions[id] = v
+	return v, nil
+}
+
+// Latest returns the latest version of a schema in the registry
+func (r *Registry) Latest(schemaName string) (*Version, error) {
+	r.mu.Lock()
+	latest, ok := r.latest[schemaName]
+	r.mu.Unlock()
+	if ok && time.Now().Before(latest.expires) {
+		return latest.version, nil
+	}
+
+	name := r.Name
+	if name == "" {
+		name = DefaultRegistryName
+	}
+	v, err := r.getVersion(&glue.GetSchemaVersionInput{
+		SchemaId:            &glue.SchemaId{RegistryName: aws.String(name), SchemaName: aws.String(schemaName)},
+		SchemaVersionNumber: &glue.SchemaVersionNumber{LatestVersion: aws.Bool(true)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refresh := r.Refresh
+	if refresh == 0 {
+		refresh = DefaultRefresh
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.latest == nil {
+		r.latest = make(map[string]latestVersion)
+	}
+	r.latest[schemaName] = latestVersion{version: v, expires: time.Now().Add(refresh)}
+	if r.versions == nil {
+		r.versions = make(map[string]*Version)
+	}
+	r.versions[v.ID] = v
+	return v, nil
+}
+
+// referenced returns the schema version a message references in its VersionAttribute
+func (r *Registry) referenced(m *sqs.Message) (*Version, error) {
+	attr, ok := m.MessageAttributes[VersionAttribute]
+	if !ok || aws.StringValue(attr.StringValue) == "" {
+		return nil, errors.New("schema: message does not reference a schema version")
+	}
+	return r.Version(*attr.StringValue)
+}
+
+// Validator is a sqsworker.Validator checking message bodies against the schema version they
+// reference. Messages without a version, or that are not valid for it, are quarantined. The
+// registry failing to return the version is temporary, and the message is retried.
+func (r *Registry) Validator(m *sqs.Message) error {
+	v, err := r.referenced(m)
+	if err != nil {
+		return err
+	}
+	return v.Validate([]byte(aws.StringValue(m.Body)))
+}
+
+// Unmarshal validates a message body with the schema version it references, and unmarshals it
+// into v. Invalid bodies are sqsworker.Invalid, and quarantined, while the registry failing to
+// return the version is retried.
+func (r *Registry) Unmarshal(m *sqs.Message, v interface{}) error {
+	version, err := r.referenced(m)
+	if _, ok := err.(*registryError); ok {
+		return err
+	} else if err != nil {
+		return sqsworker.Invalid(err)
+	}
+	return sqsworker.Invalid(version.Unmarshal([]byte(aws.StringValue(m.Body)), v))
+}
+
+// Encode validates the message of a result with the latest version of a schema, and sets the
+// id of the version in its VersionAttribute and its content type in the
+// sqsworker.ContentTypeAttribute. Invalid messages fail with a Fatal error, since they would
+// fail again on every receive.
+func (r *Registry) Encode(schemaName string, output *sns.PublishInput) error {
+	v, err := r.Latest(schemaName)
+	if err != nil {
+		return err
+	}
+	if err := v.Validate([]byte(aws.StringValue(output.Message))); err != nil {
+		return sqsworker.Fatal(err)
+	}
+	if output.MessageAttributes == nil {
+		output.MessageAttributes = make(map[string]*sns.MessageAttributeValue)
+	}
+	output.MessageAttributes[VersionAttribute] = &sns.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(v.ID),
+	}
+	output.MessageAttributes[sqsworker.ContentTypeAttribute] = contentTypeValue(v.ContentType())
+	return nil
+}
+
+// Marshal serializes v into a result with the latest version of a schema, and sets the id of
+// the version in its VersionAttribute and its content type in the
+// sqsworker.ContentTypeAttribute. Values that are not valid for the version fail with a Fatal
+// error.
+func (r *Registry) Marshal(schemaName string, v interface{}) (*sns.PublishInput, error) {
+	version, err := r.Latest(schemaName)
+	if err != nil {
+		return nil, err
+	}
+	body, err := version.Marshal(v)
+	if err != nil {
+		return nil, sqsworker.Fatal(err)
+	}
+	return &sns.PublishInput{
+		Message: aws.String(string(body)),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{
+			VersionAttribute:               {DataType: aws.String("String"), StringValue: aws.String(version.ID)},
+			sqsworker.ContentTypeAttribute: contentTypeValue(version.ContentType()),
+		},
+	}, nil
+}
@@ -0,0 +1,160 @@
+package schema_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/schema"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/glue"
+	"github.com/aws/aws-sdk-go/service/glue/glueiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"sync"
+	"testing"
+)
+
+const (
+	orderV1 = "b7b4a7f0-0000-4000-8000-000000000001"
+	orderV2 = "b7b4a7f0-0000-4000-8000-000000000002"
+	avroV1  = "b7b4a7f0-0000-4000-8000-000000000003"
+)
+
+const orderSchema = `{
+	"type": "object",
+	"required": ["id", "total"],
+	"properties": {
+		"id": {"type": "string", "pattern": "^o-[0-9]+$"},
+		"total": {"type": "number", "minimum": 0}
+	}
+}`
+
+// Glue is a schema registry holding versions of the Order schema, failing every lookup when
+// Err is set
+type Glue struct {
+	glueiface.GlueAPI
+	Err     error
+	mu      sync.Mutex
+	Lookups int
+}
+
+func (g *Glue) GetSchemaVersion(input *glue.GetSchemaVersionInput) (*glue.GetSchemaVersionOutput, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.Lookups++
+	if g.Err != nil {
+		return nil, g.Err
+	}
+	id := aws.StringValue(input.SchemaVersionId)
+	if input.SchemaId != nil {
+		if aws.StringValue(input.SchemaId.SchemaName) != "Order" || aws.StringValue(input.SchemaId.RegistryName) != "events" {
+			return nil, awserr.New(glue.ErrCodeEntityNotFoundException, "schema not found", nil)
+		}
+		id = orderV2
+	}
+	switch id {
+	case orderV1:
+		return version(id, 1, `{"type": "object", "required": ["id"]}`), nil
+	case orderV2:
+		return version(id, 2, orderSchema), nil
+	case avroV1:
+		v := version(id, 1, avroOrderSchema)
+		v.DataFormat = aws.String(schema.FormatAvro)
+		return v, nil
+	}
+	return nil, awserr.New(glue.ErrCodeEntityNotFoundException, "version not found", nil)
+}
+
+func version(id string, number int64, definition string) *glue.GetSchemaVersionOutput {
+	return &glue.GetSchemaVersionOutput{
+		SchemaVersionId:  aws.String(id),
+		VersionNumber:    aws.Int64(number),
+		DataFormat:       aws.String(schema.FormatJSON),
+		SchemaDefinition: aws.String(definition),
+		Status:           aws.String(glue.SchemaVersionStatusAvailable),
+	}
+}
+
+type Order struct {
+	ID    string  `json:"id"`
+	Total float64 `json:"total"`
+}
+
+func TestRegistryMarshal(t *testing.T) {
+	client := &Glue{}
+	registry := schema.NewRegistry(client, "events")
+
+	output, err := registry.Marshal("Order", Order{ID: "o-1", Total: 12.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := aws.StringValue(output.MessageAttributes[schema.VersionAttribute].StringValue); actual != orderV2 {
+		t.Error("Actual: ", actual, "Expected: ", orderV2)
+	}
+	if actual := aws.StringValue(output.MessageAttributes[sqsworker.ContentTypeAttribute].StringValue); actual != sqsworker.JSONContentType {
+		t.Error("Actual: ", actual, "Expected: ", sqsworker.JSONContentType)
+	}
+	if actual := aws.StringValue(output.Message); actual != `{"id":"o-1","total":12.5}` {
+		t.Error("unexpected message: ", actual)
+	}
+
+	// The latest version is cached
+	if _, err := registry.Marshal("Order", Order{ID: "o-2"}); err != nil || client.Lookups != 1 {
+		t.Error("Expected the latest version to be cached, got: ", err, client.Lookups)
+	}
+
+	if _, err := registry.Marshal("Order", Order{ID: "order-3"}); !sqsworker.IsFatal(err) {
+		t.Error("Expected a fatal error, got: ", err)
+	}
+	if _, err := registry.Marshal("Refund", Order{ID: "o-4"}); err == nil || sqsworker.IsFatal(err) {
+		t.Error("Expected an unknown schema to fail, got: ", err)
+	}
+}
+
+func TestRegistryValidator(t *testing.T) {
+	client := &Glue{}
+	registry := schema.NewRegistry(client, "events")
+	var orders []Order
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			var order Order
+			if err := registry.Unmarshal(m, &order); err != nil {
+				return nil, err
+			}
+			orders = append(orders, order)
+			return nil, nil
+		}),
+		Validator:          registry.Validator,
+		QuarantineQueueURL: workertest.QueueURL + "-quarantine",
+	})
+
+	// Each message is validated with the version it references
+	h.Run(workertest.NewMessage(`{"id":"o-1","total":3}`, workertest.Attribute(schema.VersionAttribute, orderV2))).Succeeded().Deleted()
+	h.Run(workertest.NewMessage(`{"id":"order-2"}`, workertest.Attribute(schema.VersionAttribute, orderV1))).Succeeded().Deleted()
+	if len(orders) != 2 || orders[0].ID != "o-1" || orders[1].ID != "order-2" {
+		t.Error("unexpected orders: ", orders)
+	}
+
+	bodies := []*sqs.Message{
+		workertest.NewMessage(`{"id":"order-3","total":3}`, workertest.Attribute(schema.VersionAttribute, orderV2)),
+		workertest.NewMessage(`{"id":"o-4","total":-1}`, workertest.Attribute(schema.VersionAttribute, orderV2)),
+		workertest.NewMessage(`{"total":3}`, workertest.Attribute(schema.VersionAttribute, orderV1)),
+		workertest.NewMessage(`{"id":"o-5"}`, workertest.Attribute(schema.VersionAttribute, "unknown")),
+		workertest.NewMessage(`{"id":"o-6"}`),
+	}
+	for _, m := range bodies {
+		if result := h.Run(m).Failed().Quarantined(); !sqsworker.IsInvalid(result.Err) {
+			t.Error("Expected ", *m.Body, " to be invalid, got: ", result.Err)
+		}
+	}
+
+	// The registry failing is retried
+	registry = schema.NewRegistry(&Glue{Err: errors.New("throttled")}, "events")
+	h.Worker.Validator = registry.Validator
+	result := h.Run(workertest.NewMessage(`{"id":"o-7"}`, workertest.Attribute(schema.VersionAttribute, orderV2))).Failed().NotDeleted()
+	if sqsworker.IsInvalid(result.Err) || len(result.Sent) != 0 {
+		t.Error("Expected the message to be retried, got: ", result.Err, result.Sent)
+	}
+}
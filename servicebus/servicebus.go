@@ -0,0 +1,393 @@
+// Package servicebus provides an Azure Service Bus sqsworker.Backend, so the same Processors
+// and middleware run on queues and topic subscriptions in Azure. The QueueURLs of the worker
+// are entity paths: a queue name, or a subscription as topic/subscriptions/name. Messages are
+// sent to queues and topics:
+//
+//	client := servicebus.New("my-namespace", "RootManageSharedAccessKey", key)
+//	w := sqsworker.NewWorker(nil, sqsworker.WorkerConfig{
+//		Backend:            client,
+//		QueueURL:           "orders/subscriptions/worker",
+//		DeadLetterQueueURL: "orders-failed",
+//		Processor:          processor,
+//		Sink:               client.Topic("orders-processed"),
+//	})
+//
+// The Client speaks the Service Bus REST API with a Shared Access Signature, so it has no
+// dependencies. Messages are received with peek-lock: deleting a message completes it, and
+// making it visible again abandons it by releasing its lock. A message that is not deleted
+// within the worker's visibility timeout is abandoned, and the entity moves it to its
+// dead-letter subqueue, entity/$DeadLetterQueue, once it was delivered MaxDeliveryCount
+// times. The REST API cannot dead-letter a message explicitly, so the messages the worker
+// dead-letters are sent to its DeadLetterQueueURL.
+package servicebus
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BrokerPropertiesHeader is the header holding the system properties of a message as JSON,
+// its custom properties are the other headers with JSON string values
+const BrokerPropertiesHeader = "BrokerProperties"
+
+// TokenTTL is how long the Shared Access Signatures of requests are valid
+const TokenTTL = time.Hour
+
+// ErrNotReceived is returned for a receipt handle of a message that is no longer locked,
+// because it was completed, abandoned, or its lock expired
+var ErrNotReceived = errors.New("servicebus: the message is not locked")
+
+// Client is a sqsworker.Backend receiving from Service Bus queues and subscriptions, and
+// sending to queues and topics
+type Client struct {
+	// HTTP sends the requests, by default http.DefaultClient
+	HTTP *http.Client
+	// Namespace is the Service Bus namespace, the entities are served by its endpoint
+	Namespace string
+	// Endpoint defaults to https://Namespace.servicebus.windows.net/
+	Endpoint string
+	// KeyName and Key sign the requests with a Shared Access Signature
+	KeyName string
+	Key     string
+	mu      sync.Mutex
+	leases  map[string]*time.Timer
+}
+
+// New creates a Client for the namespace, signing requests with the shared access key
+func New(namespace, keyName, key string) *Client {
+	return &Client{Namespace: namespace, KeyName: keyName, Key: key}
+}
+
+// Error is an error returned by the Service Bus API
+type Error struct {
+	Code   int    `xml:"Code"`
+	Detail string `xml:"Detail"`
+}
+
+func (e *Error) Error() string {
+	return "servicebus: " + strconv.Itoa(e.Code) + " " + http.StatusText(e.Code) + ": " + e.Detail
+}
+
+// brokerProperties are the system properties of a message
+type brokerProperties struct {
+	MessageID               string `json:"MessageId,omitempty"`
+	SessionID               string `json:"SessionId,omitempty"`
+	DeliveryCount           int    `json:"DeliveryCount,omitempty"`
+	EnqueuedTimeUtc         string `json:"EnqueuedTimeUtc,omitempty"`
+	ScheduledEnqueueTimeUtc string `json:"ScheduledEnqueueTimeUtc,omitempty"`
+}
+
+func (c *Client) endpoint() string {
+	if c.Endpoint != "" {
+		return c.Endpoint
+	}
+	return "https://" + c.Namespace + ".servicebus.windows.net/"
+}
+
+// token returns a Shared Access Signature for the namespace, valid for TokenTTL
+func (c *Client) token() string {
+	resource := url.QueryEscape(strings.ToLower(c.endpoint()))
+	expiry := strconv.FormatInt(time.Now().Add(TokenTTL).Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(c.Key))
+	io.WriteString(mac, resource+"\n"+expiry)
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return "SharedAccessSignature sr=" + resource + "&sig=" + url.QueryEscape(signature) + "&se=" + expiry + "&skn=" + url.QueryEscape(c.KeyName)
+}
+
+// do sends a signed request to the url, or to the path of the endpoint, returning the response
+// with its body read. Responses that are not successful are returned as an *Error.
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body []byte) (*http.Response, []byte, error) {
+	u := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		u = c.endpoint() + path
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Authorization", c.token())
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		failure := &Error{}
+		if xml.Unmarshal(data, failure) != nil || failure.Code == 0 {
+			failure = &Error{Code: resp.StatusCode, Detail: string(data)}
+		}
+		failure.Code = resp.StatusCode
+		return resp, data, failure
+	}
+	return resp, data, nil
+}
+
+// Receive locks up to max messages one at a time, waiting up to wait for the first one. The
+// lock of each message is released once the visibility timeout passes, so it is redelivered,
+// and expires after the LockDuration of the entity when that is shorter. An entity that does
+// not exist fails with the QueueDoesNotExist error of SQS, so the worker stops.
+func (c *Client) Receive(ctx context.Context, entity string, max int64, wait, visibility time.Duration) ([]*sqs.Message, error) {
+	var messages []*sqs.Message
+	for int64(len(messages)) < max {
+		timeout := int64(0)
+		if len(messages) == 0 {
+			timeout = int64(wait / time.Second)
+		}
+		resp, data, err := c.do(ctx, http.MethodPost, entity+"/messages/head?timeout="+strconv.FormatInt(timeout, 10), nil, nil)
+		if err != nil {
+			if serr, ok := err.(*Error); ok && serr.Code == http.StatusNotFound {
+				return messages, awserr.New(sqs.ErrCodeQueueDoesNotExist, serr.Detail, serr)
+			}
+			return messages, err
+		}
+		if resp.StatusCode == http.StatusNoContent {
+			break
+		}
+		m, err := message(resp, data)
+		if err != nil {
+			return messages, err
+		}
+		c.hold(*m.ReceiptHandle, visibility)
+		messages = append(messages, m)
+	}
+	return messages, nil
+}
+
+// message converts a locked message, the url of its lock is its ReceiptHandle
+func message(resp *http.Response, data []byte) (*sqs.Message, error) {
+	var props brokerProperties
+	if err := json.Unmarshal([]byte(resp.Header.Get(BrokerPropertiesHeader)), &props); err != nil {
+		return nil, err
+	}
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return nil, errors.New("servicebus: no lock location was returned")
+	}
+	m := &sqs.Message{
+		MessageId:     aws.String(props.MessageID),
+		ReceiptHandle: aws.String(location),
+		Body:          aws.String(string(data)),
+		Attributes: map[string]*string{
+			sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String(strconv.Itoa(props.DeliveryCount)),
+		},
+	}
+	if enqueued, err := time.Parse(http.TimeFormat, props.EnqueuedTimeUtc); err == nil {
+		m.Attributes[sqs.MessageSystemAttributeNameSentTimestamp] = aws.String(strconv.FormatInt(enqueued.UnixNano()/int64(time.Millisecond), 10))
+	}
+	if props.SessionID != "" {
+		m.Attributes[sqs.MessageSystemAttributeNameMessageGroupId] = aws.String(props.SessionID)
+	}
+	for name, values := range resp.Header {
+		var value string
+		if name == "Etag" || len(values) == 0 || json.Unmarshal([]byte(values[0]), &value) != nil {
+			continue
+		}
+		if m.MessageAttributes == nil {
+			m.MessageAttributes = make(map[string]*sqs.MessageAttributeValue)
+		}
+		m.MessageAttributes[name] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+	}
+	return m, nil
+}
+
+// hold abandons the message locked at the receipt handle once the visibility timeout passes
+func (c *Client) hold(receiptHandle string, visibility time.Duration) {
+	if visibility <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.leases == nil {
+		c.leases = make(map[string]*time.Timer)
+	}
+	c.leases[receiptHandle] = time.AfterFunc(visibility, func() {
+		if c.release(receiptHandle) {
+			c.do(context.Background(), http.MethodPut, receiptHandle, nil, nil)
+		}
+	})
+}
+
+// release stops the timer of a message, reporting whether it was held
+func (c *Client) release(receiptHandle string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer, ok := c.leases[receiptHandle]
+	if ok {
+		timer.Stop()
+		delete(c.leases, receiptHandle)
+	}
+	return ok
+}
+
+// lockError converts the errors of locks that were lost to ErrNotReceived
+func lockError(err error) error {
+	if serr, ok := err.(*Error); ok && (serr.Code == http.StatusNotFound || serr.Code == http.StatusGone) {
+		return ErrNotReceived
+	}
+	return err
+}
+
+// Delete completes the message
+func (c *Client) Delete(ctx context.Context, entity, receiptHandle string) error {
+	c.release(receiptHandle)
+	_, _, err := c.do(ctx, http.MethodDelete, receiptHandle, nil, nil)
+	return lockError(err)
+}
+
+// ChangeVisibility abandons the message when the timeout is zero. Otherwise its lock is
+// renewed and it is abandoned once the timeout has passed, so a message cannot be hidden for
+// longer than the LockDuration of its entity.
+func (c *Client) ChangeVisibility(ctx context.Context, entity, receiptHandle string, timeout time.Duration) error {
+	c.release(receiptHandle)
+	if timeout == 0 {
+		_, _, err := c.do(ctx, http.MethodPut, receiptHandle, nil, nil)
+		return lockError(err)
+	}
+	if _, _, err := c.do(ctx, http.MethodPost, receiptHandle, nil, nil); err != nil {
+		return lockError(err)
+	}
+	c.hold(receiptHandle, timeout)
+	return nil
+}
+
+// Send sends the message to a queue or topic, with its message attributes as custom
+// properties. The MessageDeduplicationId is its MessageId, which entities with duplicate
+// detection deduplicate, the MessageGroupId its SessionId, and a delay schedules it.
+func (c *Client) Send(ctx context.Context, entity string, input *sqs.SendMessageInput) (string, error) {
+	props := brokerProperties{
+		MessageID: aws.StringValue(input.MessageDeduplicationId),
+		SessionID: aws.StringValue(input.MessageGroupId),
+	}
+	if delay := aws.Int64Value(input.DelaySeconds); delay > 0 {
+		props.ScheduledEnqueueTimeUtc = time.Now().Add(time.Duration(delay) * time.Second).UTC().Format(http.TimeFormat)
+	}
+	return c.send(ctx, entity, aws.StringValue(input.MessageBody), props, input.MessageAttributes)
+}
+
+func (c *Client) send(ctx context.Context, entity, body string, props brokerProperties, attributes map[string]*sqs.MessageAttributeValue) (string, error) {
+	if props.MessageID == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return "", err
+		}
+		props.MessageID = hex.EncodeToString(id)
+	}
+	brokerProps, err := json.Marshal(props)
+	if err != nil {
+		return "", err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	header.Set(BrokerPropertiesHeader, string(brokerProps))
+	for name, value := range attributes {
+		v, err := json.Marshal(attribute(value.StringValue, value.BinaryValue))
+		if err != nil {
+			return "", err
+		}
+		header.Set(name, string(v))
+	}
+	if _, _, err := c.do(ctx, http.MethodPost, entity+"/messages", header, []byte(body)); err != nil {
+		return "", err
+	}
+	return props.MessageID, nil
+}
+
+// attribute returns the string value of a message attribute
+func attribute(value *string, binary []byte) string {
+	if value != nil {
+		return *value
+	}
+	return base64.StdEncoding.EncodeToString(binary)
+}
+
+// entityDescription holds the message counts of a queue or subscription
+type entityDescription struct {
+	Counts struct {
+		Active    int64 `xml:"ActiveMessageCount"`
+		Scheduled int64 `xml:"ScheduledMessageCount"`
+	} `xml:"content>QueueDescription>CountDetails"`
+	SubscriptionCounts struct {
+		Active    int64 `xml:"ActiveMessageCount"`
+		Scheduled int64 `xml:"ScheduledMessageCount"`
+	} `xml:"content>SubscriptionDescription>CountDetails"`
+}
+
+// Depth returns the active messages of the entity as visible and its scheduled messages as
+// delayed. Service Bus does not count the locked messages apart from the active ones.
+func (c *Client) Depth(ctx context.Context, entity string) (sqsworker.QueueDepth, error) {
+	_, data, err := c.do(ctx, http.MethodGet, entity+"?api-version=2017-04", nil, nil)
+	if err != nil {
+		return sqsworker.QueueDepth{}, err
+	}
+	var description entityDescription
+	if err := xml.Unmarshal(data, &description); err != nil {
+		return sqsworker.QueueDepth{}, err
+	}
+	return sqsworker.QueueDepth{
+		Visible: description.Counts.Active + description.SubscriptionCounts.Active,
+		Delayed: description.Counts.Scheduled + description.SubscriptionCounts.Scheduled,
+		Updated: time.Now(),
+	}, nil
+}
+
+// Topic is a sqsworker.Sink sending results to a Service Bus topic or queue, with their
+// message attributes as custom properties
+type Topic struct {
+	Client *Client
+	Name   string
+}
+
+// Topic returns a Sink sending to the topic or queue
+func (c *Client) Topic(name string) *Topic {
+	return &Topic{Client: c, Name: name}
+}
+
+// Send sends the result, its MessageDeduplicationId is its MessageId and its MessageGroupId
+// its SessionId. Results with a Subject or MessageStructure fail with a Fatal error.
+func (t *Topic) Send(ctx context.Context, m *sqs.Message, output *sns.PublishInput) error {
+	if output.Subject != nil || output.MessageStructure != nil {
+		return sqsworker.Fatal(errors.New("servicebus: results cannot have a Subject or MessageStructure"))
+	}
+	attributes := make(map[string]*sqs.MessageAttributeValue, len(output.MessageAttributes))
+	for name, value := range output.MessageAttributes {
+		attributes[name] = &sqs.MessageAttributeValue{StringValue: value.StringValue, BinaryValue: value.BinaryValue}
+	}
+	props := brokerProperties{
+		MessageID: aws.StringValue(output.MessageDeduplicationId),
+		SessionID: aws.StringValue(output.MessageGroupId),
+	}
+	_, err := t.Client.send(ctx, t.Name, aws.StringValue(output.Message), props, attributes)
+	return err
+}
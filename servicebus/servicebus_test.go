@@ -0,0 +1,235 @@
+package servicebus_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/servicebus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sent is a message sent to an entity
+type sent struct {
+	Body       string
+	Properties map[string]interface{}
+	Header     http.Header
+	locked     bool
+	deliveries int
+}
+
+// Server is a Service Bus namespace with the queue "orders", locking its messages on
+// receive and recording what happens to them
+type Server struct {
+	URL      string
+	mu       sync.Mutex
+	Entities map[string][]*sent
+	Events   chan string
+}
+
+func NewServer() *Server {
+	return &Server{Entities: map[string][]*sent{"orders": nil, "processed": nil}, Events: make(chan string, 10)}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "SharedAccessSignature sr=") || !strings.Contains(r.Header.Get("Authorization"), "&skn=sender") {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	messages, ok := s.Entities[parts[0]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("<Error><Code>404</Code><Detail>The messaging entity could not be found.</Detail></Error>"))
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		w.Write([]byte(`<entry><content type="application/xml"><QueueDescription><CountDetails>` +
+			`<d2p1:ActiveMessageCount>` + strconv.Itoa(len(messages)) + `</d2p1:ActiveMessageCount>` +
+			`<d2p1:ScheduledMessageCount>0</d2p1:ScheduledMessageCount></CountDetails></QueueDescription></content></entry>`))
+	case len(parts) == 2 && r.Method == http.MethodPost:
+		m := &sent{Body: string(body), Header: r.Header}
+		json.Unmarshal([]byte(r.Header.Get(servicebus.BrokerPropertiesHeader)), &m.Properties)
+		s.Entities[parts[0]] = append(messages, m)
+		w.WriteHeader(http.StatusCreated)
+	case len(parts) == 3 && r.Method == http.MethodPost:
+		for i, m := range messages {
+			if m.locked {
+				continue
+			}
+			m.locked = true
+			m.deliveries++
+			properties, _ := json.Marshal(map[string]interface{}{
+				"MessageId":       m.Properties["MessageId"],
+				"DeliveryCount":   m.deliveries,
+				"EnqueuedTimeUtc": "Thu, 04 Mar 2021 05:06:07 GMT",
+			})
+			w.Header().Set(servicebus.BrokerPropertiesHeader, string(properties))
+			w.Header().Set("Location", s.URL+"/"+parts[0]+"/messages/"+strconv.Itoa(i)+"/lock")
+			w.Header().Set("Tenant", m.Header.Get("Tenant"))
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(m.Body))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 4:
+		i, _ := strconv.Atoi(parts[2])
+		if i >= len(messages) || messages[i] == nil || !messages[i].locked {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		switch r.Method {
+		case http.MethodDelete:
+			messages[i] = &sent{locked: true}
+			s.Events <- "complete " + parts[2]
+		case http.MethodPut:
+			messages[i].locked = false
+			s.Events <- "abandon " + parts[2]
+		case http.MethodPost:
+			s.Events <- "renew " + parts[2]
+		}
+	}
+}
+
+func newClient(s *Server) (*servicebus.Client, func()) {
+	server := httptest.NewServer(s)
+	s.URL = server.URL
+	client := servicebus.New("test", "sender", "secret")
+	client.Endpoint = server.URL + "/"
+	return client, server.Close
+}
+
+func TestWorker(t *testing.T) {
+	s := NewServer()
+	client, stop := newClient(s)
+	defer stop()
+	ctx := context.Background()
+	for _, body := range []string{"Hello", "World"} {
+		if _, err := client.Send(ctx, "orders", &sqs.SendMessageInput{MessageBody: aws.String(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := sqsworker.NewWorker(nil, sqsworker.WorkerConfig{
+		Backend:  client,
+		QueueURL: "orders",
+		Workers:  2,
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			return &sns.PublishInput{Message: aws.String(strings.ToLower(*m.Body)), MessageDeduplicationId: m.MessageId}, nil
+		}),
+		Sink:   client.Topic("processed"),
+		Logger: zap.NewNop(),
+	})
+	go w.Run()
+	defer w.Close()
+
+	completed := map[string]bool{}
+	for len(completed) < 2 {
+		select {
+		case event := <-s.Events:
+			completed[event] = true
+		case <-time.After(time.Second):
+			t.Fatal("messages were not completed")
+		}
+	}
+	if !completed["complete 0"] || !completed["complete 1"] {
+		t.Errorf("unexpected events %v", completed)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	processed := s.Entities["processed"]
+	if len(processed) != 2 {
+		t.Fatalf("unexpected results %v", processed)
+	}
+	for _, p := range processed {
+		if p.Body != "hello" && p.Body != "world" || p.Properties["MessageId"] == "" {
+			t.Errorf("unexpected result %+v", p)
+		}
+	}
+}
+
+func TestVisibility(t *testing.T) {
+	s := NewServer()
+	client, stop := newClient(s)
+	defer stop()
+	ctx := context.Background()
+
+	id, err := client.Send(ctx, "orders", &sqs.SendMessageInput{
+		MessageBody:            aws.String("Hello"),
+		MessageDeduplicationId: aws.String("order-1"),
+		MessageAttributes:      map[string]*sqs.MessageAttributeValue{"Tenant": {DataType: aws.String("String"), StringValue: aws.String("acme")}},
+	})
+	if err != nil || id != "order-1" {
+		t.Fatalf("unexpected send %s %v", id, err)
+	}
+	messages, err := client.Receive(ctx, "orders", 10, time.Second, 20*time.Millisecond)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("unexpected receive %v %v", messages, err)
+	}
+	m := messages[0]
+	if aws.StringValue(m.MessageId) != "order-1" || aws.StringValue(m.Body) != "Hello" ||
+		aws.StringValue(m.MessageAttributes["Tenant"].StringValue) != "acme" ||
+		aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameSentTimestamp]) != "1614834367000" ||
+		aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]) != "1" {
+		t.Errorf("unexpected message %v", m)
+	}
+
+	// the message is abandoned once its visibility timeout passes
+	select {
+	case event := <-s.Events:
+		if event != "abandon 0" {
+			t.Errorf("unexpected event %s", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the message was not abandoned")
+	}
+	if err := client.Delete(ctx, "orders", *m.ReceiptHandle); err != servicebus.ErrNotReceived {
+		t.Errorf("expected the lock to be released, got %v", err)
+	}
+
+	// a visibility timeout renews the lock, and abandons the message once it passes
+	messages, _ = client.Receive(ctx, "orders", 10, 0, time.Minute)
+	if len(messages) != 1 || aws.StringValue(messages[0].Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]) != "2" {
+		t.Fatalf("unexpected receive %v", messages)
+	}
+	if err := client.ChangeVisibility(ctx, "orders", *messages[0].ReceiptHandle, 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if event := <-s.Events; event != "renew 0" {
+		t.Errorf("unexpected event %s", event)
+	}
+	if event := <-s.Events; event != "abandon 0" {
+		t.Errorf("unexpected event %s", event)
+	}
+
+	if depth, err := client.Depth(ctx, "orders"); err != nil || depth.Visible != 1 {
+		t.Errorf("unexpected depth %v %v", depth, err)
+	}
+
+	_, err = client.Receive(ctx, "deleted", 10, 0, 0)
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != sqs.ErrCodeQueueDoesNotExist {
+		t.Errorf("expected a missing queue to fail, got %v", err)
+	}
+	client.KeyName = "reader"
+	if _, err := client.Receive(ctx, "orders", 10, 0, 0); err == nil || err.(*servicebus.Error).Code != http.StatusUnauthorized {
+		t.Errorf("expected an unauthorized receive to fail, got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package sqsworker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// DefaultGracePeriod time given to in-flight messages to finish after a shutdown signal
+const DefaultGracePeriod = 30 * time.Second
+
+// ErrGracePeriodExceeded is returned when in-flight messages did not finish within the grace period
+var ErrGracePeriodExceeded = errors.New("sqsworker: in-flight messages did not finish within the grace period")
+
+// RunUntilSignal runs the worker until SIGINT or SIGTERM is received. On a signal polling
+// stops and the messages already received are processed. If they do not finish within the
+// grace period the worker is closed, canceling the context passed to the Processor, and
+// ErrGracePeriodExceeded is returned. A grace period of zero uses DefaultGracePeriod.
+//
+// If the worker stops on its own, the error that stopped it is returned (see Worker.Err).
+// The returned error is nil after a clean shutdown, so a service's main function can exit
+// with a non-zero status whenever it is not:
+//
+//	if err := sqsworker.RunUntilSignal(w, 0); err != nil {
+//		log.Fatal(err)
+//	}
+func RunUntilSignal(w *Worker, grace time.Duration) error {
+	if grace == 0 {
+		grace = DefaultGracePeriod
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	stopped := make(chan struct{})
+	go func() {
+		w.Run()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		if err := w.Err(); err != ErrClosed {
+			return err
+		}
+		return nil
+	case sig := <-signals:
+		w.logInfo(fmt.Sprint("Received ", sig, ", draining in-flight messages"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := w.Stop(ctx); err != nil {
+		return ErrGracePeriodExceeded
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package sqsworker_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// PollQueue returns an empty receive after a millisecond without messages, like a short poll
+type PollQueue struct {
+	*MockQueue
+}
+
+func (p *PollQueue) ReceiveMessageRequest(input *sqs.ReceiveMessageInput) (*request.Request, *sqs.ReceiveMessageOutput) {
+	select {
+	case body := <-p.In:
+		return newRequest(), &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{{Body: aws.String(body)}}}
+	case <-time.After(time.Millisecond):
+		return newRequest(), &sqs.ReceiveMessageOutput{}
+	}
+}
+
+// StuckWorker blocks until its context is canceled
+type StuckWorker struct {
+	Started chan bool
+}
+
+func (s *StuckWorker) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	s.Started <- true
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func signal(t *testing.T, sig os.Signal) {
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(sig); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunUntilSignal(t *testing.T) {
+	queue := GetMockeQueue()
+	handler := &BlockingWorker{Started: make(chan bool), Release: make(chan bool)}
+	processed := make(chan error, 1)
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: handler,
+		Callback:  func(result sqsworker.Result) { processed <- result.Err },
+		Name:      "TestApp",
+	})
+	w.Queue = &PollQueue{queue}
+
+	go func() {
+		queue.Push("hello")
+		<-handler.Started
+		signal(t, syscall.SIGTERM)
+		time.Sleep(10 * time.Millisecond)
+		handler.Release <- true
+	}()
+
+	if err := sqsworker.RunUntilSignal(w, time.Second); err != nil {
+		t.Error(err)
+	}
+	if err := <-processed; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRunUntilSignalGracePeriod(t *testing.T) {
+	queue := GetMockeQueue()
+	handler := &StuckWorker{Started: make(chan bool)}
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: handler,
+		Name:      "TestApp",
+	})
+	w.Queue = &PollQueue{queue}
+
+	go func() {
+		queue.Push("hello")
+		<-handler.Started
+		signal(t, os.Interrupt)
+	}()
+
+	if err := sqsworker.RunUntilSignal(w, 10*time.Millisecond); err != sqsworker.ErrGracePeriodExceeded {
+		t.Error("Actual: ", err, "Expected: ", sqsworker.ErrGracePeriodExceeded)
+	}
+}
+
+func TestStop(t *testing.T) {
+	queue := GetMockeQueue()
+	handler := &BlockingWorker{Started: make(chan bool), Release: make(chan bool)}
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: handler,
+		Name:      "TestApp",
+	})
+	w.Queue = &PollQueue{queue}
+	go w.Run()
+
+	queue.Push("hello")
+	<-handler.Started
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		handler.Release <- true
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := w.Stop(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStopDeadline(t *testing.T) {
+	queue := GetMockeQueue()
+	handler := &StuckWorker{Started: make(chan bool)}
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: handler,
+		Name:      "TestApp",
+	})
+	w.Queue = &PollQueue{queue}
+	go w.Run()
+
+	queue.Push("hello")
+	<-handler.Started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := w.Stop(ctx); err != context.DeadlineExceeded {
+		t.Error("Actual: ", err, "Expected: ", context.DeadlineExceeded)
+	}
+}
+
+func TestRunUntilSignalError(t *testing.T) {
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+		Name:      "TestApp",
+	})
+	w.QueueURL = ""
+
+	if err := sqsworker.RunUntilSignal(w, time.Second); err != sqsworker.ErrNoQueue {
+		t.Error("Actual: ", err, "Expected: ", sqsworker.ErrNoQueue)
+	}
+}
@@ -0,0 +1,150 @@
+package sqsworker
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"sync"
+	"time"
+)
+
+// DefaultBatchWindow is how long a batching Sink waits for a batch to fill before sending it
+const DefaultBatchWindow = 10 * time.Millisecond
+
+// Sink sends results to a service other than SNS. Send is called concurrently by the
+// consumers, and returns once the result is stored by the service, so the message it was
+// produced from can be deleted.
+//
+// The QueueSink, KinesisSink, FirehoseSink and EventBridgeSink send results one at a time by
+// default, or BatchSize of them together, at most what the service accepts in a request. A
+// batch is sent once it is full, or BatchWindow after its first result, which defaults to
+// DefaultBatchWindow. The KinesisSink and FirehoseSink send the records that failed in a
+// batch, e.g. when a shard is throttled, again up to Retries times, defaulting to
+// DefaultKinesisRetries, with exponential backoff starting at Backoff, defaulting to
+// DefaultPublishBackoff. Negative Retries disables retrying.
+type Sink interface {
+	Send(ctx context.Context, m *sqs.Message, output *sns.PublishInput) error
+}
+
+// batchEntry is a value waiting in a batcher, and the channel its sender waits on
+type batchEntry struct {
+	value interface{}
+	done  chan error
+}
+
+// batcher collects the values sent by concurrent consumers and flushes them together, once
+// size values are waiting or window has passed since the first one. Each sender waits for
+// the error of its own value.
+type batcher struct {
+	size    int
+	window  time.Duration
+	flush   func(context.Context, []interface{}) []error
+	mu      sync.Mutex
+	pending []*batchEntry
+	timer   *time.Timer
+}
+
+func newBatcher(size int, window time.Duration, flush func(context.Context, []interface{}) []error) *batcher {
+	if size < 1 {
+		size = 1
+	}
+	if window == 0 {
+		window = DefaultBatchWindow
+	}
+	return &batcher{size: size, window: window, flush: flush}
+}
+
+// send adds a value to the current batch and waits until the batch is flushed or the context is done
+func (b *batcher) send(ctx context.Context, value interface{}) error {
+	if b.size == 1 {
+		return b.flush(ctx, []interface{}{value})[0]
+	}
+
+	entry := &batchEntry{value: value, done: make(chan error, 1)}
+	b.mu.Lock()
+	b.pending = append(b.pending, entry)
+	if len(b.pending) >= b.size {
+		batch := b.take()
+		b.mu.Unlock()
+		b.run(batch)
+	} else {
+		if len(b.pending) == 1 {
+			b.timer = time.AfterFunc(b.window, b.expire)
+		}
+		b.mu.Unlock()
+	}
+
+	select {
+	case err := <-entry.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// take removes the pending batch, the caller holds the lock
+func (b *batcher) take() []*batchEntry {
+	batch := b.pending
+	b.pending = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	return batch
+}
+
+// expire flushes a batch that did not fill within the window
+func (b *batcher) expire() {
+	b.mu.Lock()
+	batch := b.take()
+	b.mu.Unlock()
+	if len(batch) > 0 {
+		b.run(batch)
+	}
+}
+
+// run flushes a batch. Other senders may still wait for it when one gives up, so its retries
+// are not cut short by the context of any of them.
+func (b *batcher) run(batch []*batchEntry) {
+	values := make([]interface{}, len(batch))
+	for i, entry := range batch {
+		values[i] = entry.value
+	}
+	errs := b.flush(context.Background(), values)
+	for i, entry := range batch {
+		entry.done <- errs[i]
+	}
+}
+
+// retryFailed calls put with the indexes of every value, then with the indexes of the values that
+// failed, until all succeed or the retries run out, waiting with exponential backoff between
+// attempts, or until the context is done. put returns the error of each index it is given. The
+// last error of each value is returned.
+func retryFailed(ctx context.Context, n, retries int, backoff time.Duration, put func(indexes []int) []error) []error {
+	errs := make([]error, n)
+	pending := make([]int, n)
+	for i := range pending {
+		pending[i] = i
+	}
+	for attempt := 0; ; attempt++ {
+		var failed []int
+		for i, err := range put(pending) {
+			errs[pending[i]] = err
+			if err != nil {
+				failed = append(failed, pending[i])
+			}
+		}
+		if len(failed) == 0 || attempt >= retries {
+			return errs
+		}
+		pending = failed
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errs
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,129 @@
+package sqsworker
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"strconv"
+	"sync/atomic"
+)
+
+// MaxPublishBatchSize is the most messages PublishBatch accepts at once
+const MaxPublishBatchSize = 10
+
+// MaxPublishBatchBytes is the largest total size of the messages PublishBatch accepts at once
+const MaxPublishBatchBytes = 256 * 1024
+
+// Split adapts a function expanding a message into many results, such as the items of a
+// manifest, to a Processor. Each result is published as its own message, to its TopicArn or
+// the worker's, and the message is deleted once every result was published. Split is a FanOut
+// without destinations, see FanOut for how failures are handled.
+type Split func(ctx context.Context, m *sqs.Message) ([]*sns.PublishInput, error)
+
+// Process calls f and hands its results to the worker handling the message
+func (f Split) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	return FanOut(func(ctx context.Context, m *sqs.Message) ([]Publish, error) {
+		outputs, err := f(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		publishes := make([]Publish, len(outputs))
+		for i, output := range outputs {
+			publishes[i].Output = output
+		}
+		return publishes, nil
+	}).Process(ctx, m)
+}
+
+// publishBatch is a batch of results for the same topic, waiting to be published together
+type publishBatch struct {
+	topicArn string
+	bytes    int
+	entries  []*sns.PublishBatchRequestEntry
+	// indexes are the indexes of the entries in the results of the FanOut, keys their keys in
+	// the PublishStore
+	indexes []int
+	keys    []string
+}
+
+// batchBytes is the size of a result counted towards MaxPublishBatchBytes
+func batchBytes(output *sns.PublishInput) int {
+	n := len(aws.StringValue(output.Message))
+	for name, value := range output.MessageAttributes {
+		n += len(name) + len(aws.StringValue(value.DataType)) + len(aws.StringValue(value.StringValue)) + len(value.BinaryValue)
+	}
+	return n
+}
+
+// fits reports whether a result for the topic can be added to the batch
+func (b *publishBatch) fits(topicArn string, output *sns.PublishInput) bool {
+	return len(b.entries) == 0 || b.topicArn == topicArn && len(b.entries) < MaxPublishBatchSize &&
+		b.bytes+batchBytes(output) <= MaxPublishBatchBytes
+}
+
+// add adds a result to the batch, copying the fields it is published with
+func (b *publishBatch) add(index int, key string, output *sns.PublishInput) {
+	b.topicArn = aws.StringValue(output.TopicArn)
+	b.bytes += batchBytes(output)
+	b.entries = append(b.entries, &sns.PublishBatchRequestEntry{
+		Id:                     aws.String(strconv.Itoa(len(b.entries))),
+		Message:                output.Message,
+		MessageAttributes:      output.MessageAttributes,
+		MessageGroupId:         output.MessageGroupId,
+		MessageDeduplicationId: output.MessageDeduplicationId,
+		MessageStructure:       output.MessageStructure,
+		Subject:                output.Subject,
+	})
+	b.indexes = append(b.indexes, index)
+	b.keys = append(b.keys, key)
+}
+
+// publishBatch publishes a batch with PublishBatch, publishing the entries that failed again up to
+// PublishRetries times, and records the error of each entry in errs by its index
+func (w *Worker) publishBatch(ctx context.Context, state *consumerState, b *publishBatch, errs []error, result *Result) {
+	if len(b.entries) == 0 {
+		return
+	}
+	batchErrs := retryFailed(ctx, len(b.entries), w.PublishRetries, w.PublishBackoff, func(indexes []int) []error {
+		input := &sns.PublishBatchInput{
+			TopicArn:                   aws.String(b.topicArn),
+			PublishBatchRequestEntries: make([]*sns.PublishBatchRequestEntry, len(indexes)),
+		}
+		positions := make(map[string]int, len(indexes))
+		for j, i := range indexes {
+			input.PublishBatchRequestEntries[j] = b.entries[i]
+			positions[aws.StringValue(b.entries[i].Id)] = j
+		}
+
+		putErrs := make([]error, len(indexes))
+		atomic.AddInt64(&w.stats.api.publishBatches, 1)
+		atomic.AddInt64(&w.stats.api.publishBatchEntries, int64(len(indexes)))
+		out, err := w.Topic.PublishBatch(input)
+		if err != nil {
+			for j := range putErrs {
+				putErrs[j] = err
+			}
+			return putErrs
+		}
+		for _, failed := range out.Failed {
+			if j, ok := positions[aws.StringValue(failed.Id)]; ok {
+				putErrs[j] = fmt.Errorf("sqsworker: publish failed: %s: %s", aws.StringValue(failed.Code), aws.StringValue(failed.Message))
+			}
+		}
+		return putErrs
+	})
+
+	for j, err := range batchErrs {
+		if err != nil {
+			atomic.AddInt64(&w.stats.publishErrors, 1)
+			w.logBodyError(state, "send message failed!", b.entries[j].Message, err)
+			errs[b.indexes[j]] = err
+			continue
+		}
+		result.Published = true
+		w.markPublished(ctx, state, b.keys[j])
+	}
+	*b = publishBatch{}
+}
@@ -0,0 +1,92 @@
+package sqsworker_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// splitManifest expands a manifest of n items into a message per item
+func splitManifest(ctx context.Context, m *sqs.Message) ([]*sns.PublishInput, error) {
+	n, err := strconv.Atoi(*m.Body)
+	if err != nil {
+		return nil, sqsworker.Invalid(err)
+	}
+	items := make([]*sns.PublishInput, n)
+	for i := range items {
+		items[i] = &sns.PublishInput{Message: aws.String(fmt.Sprint("item-", i))}
+	}
+	return items, nil
+}
+
+// PartialTopic fails the entries of batches whose message is in Fail
+type PartialTopic struct {
+	workertest.Topic
+	Fail map[string]bool
+}
+
+func (p *PartialTopic) PublishBatch(input *sns.PublishBatchInput) (*sns.PublishBatchOutput, error) {
+	var entries []*sns.PublishBatchRequestEntry
+	out := &sns.PublishBatchOutput{}
+	for _, entry := range input.PublishBatchRequestEntries {
+		if p.Fail[*entry.Message] {
+			out.Failed = append(out.Failed, &sns.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("Throttled"), Message: aws.String("slow down")})
+		} else {
+			entries = append(entries, entry)
+		}
+	}
+	published, _ := p.Topic.PublishBatch(&sns.PublishBatchInput{TopicArn: input.TopicArn, PublishBatchRequestEntries: entries})
+	out.Successful = published.Successful
+	return out, nil
+}
+
+func TestSplit(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:  "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: sqsworker.Split(splitManifest),
+	})
+
+	result := h.Run(workertest.NewMessage("25")).Succeeded().Deleted()
+	if len(result.Publishes) != 25 || *result.Publishes[24].Message != "item-24" {
+		t.Fatal("Expected every item to be published, got ", len(result.Publishes))
+	}
+	if len(h.Topic.Batches) != 3 || len(h.Topic.Batches[0].PublishBatchRequestEntries) != sqsworker.MaxPublishBatchSize {
+		t.Error("Expected the items to be published in batches, got ", len(h.Topic.Batches))
+	}
+
+	h.Run(workertest.NewMessage("0")).Succeeded().Deleted().NotPublished()
+	h.Run(workertest.NewMessage("manifest")).Failed().NotDeleted().NotPublished()
+}
+
+func TestSplitPartialFailure(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:     "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor:    sqsworker.Split(splitManifest),
+		PublishStore: sqsworker.NewMemoryPublishStore(time.Minute),
+	})
+	topic := &PartialTopic{Fail: map[string]bool{"item-3": true}}
+	h.Worker.Topic = topic
+
+	m := workertest.NewMessage("12")
+	result := h.Run(m).Failed().NotDeleted()
+	if fanOutErr, ok := result.Err.(*sqsworker.FanOutError); !ok || fanOutErr.Errs[3] == nil || fanOutErr.Errs[2] != nil {
+		t.Fatal("Expected item-3 to fail, got ", result.Err)
+	}
+	if len(topic.Published) != 11 {
+		t.Error("Expected the other items to be published, got ", len(topic.Published))
+	}
+
+	// Only the failed item is published again
+	topic.Fail = nil
+	h.Run(m).Succeeded().Deleted()
+	if len(topic.Published) != 12 || *topic.Published[11].Message != "item-3" {
+		t.Error("Expected item-3 to be published again, got ", len(topic.Published))
+	}
+}
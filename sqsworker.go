@@ -2,6 +2,7 @@ package sqsworker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -14,6 +15,8 @@ import (
 	"os"
 	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // DefaultWorkers Number of worker goroutines to spawn, each runs the handler function
@@ -28,47 +31,418 @@ const DefaultVisibilityTimeout = 60
 // DefaultWaitTimeSeconds Long-polling interval for SQS
 const DefaultWaitTimeSeconds = 20
 
-// Handler interface for SQS consumers
+// Processor handles each message, returning the result to publish. A nil result publishes
+// nothing, and a result without a TopicArn is published to the worker's TopicArn.
 type Processor interface {
-	Process(context.Context, *sqs.Message, *sns.PublishInput) error
+	Process(context.Context, *sqs.Message) (*sns.PublishInput, error)
 }
 
-// Callback which is passed result from handler on success
-type Callback func(*string, error)
+// ProcessorFunc adapts a function to a Processor
+type ProcessorFunc func(context.Context, *sqs.Message) (*sns.PublishInput, error)
+
+// Process calls f
+func (f ProcessorFunc) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	return f(ctx, m)
+}
+
+// Result of handling a message, passed to the Callback
+type Result struct {
+	// Message received from the queue
+	Message *sqs.Message
+	// Output is the result returned by the Processor
+	Output *sns.PublishInput
+	// Err is the error of the Validator, Processor, publish or delete, in that order
+	Err error
+	// Publish is the output of the SNS publish, nil when nothing was published
+	Publish *sns.PublishOutput
+	// Published reports whether the result was published or written to the Outbox, now or
+	// when the message was previously received according to the PublishStore
+	Published bool
+	// Duplicate reports whether the publish was skipped because the PublishStore recorded it
+	Duplicate bool
+	// Deleted reports whether the message was deleted from the queue
+	Deleted bool
+	// Filtered reports whether the message did not match the Filter and was not processed
+	Filtered bool
+	// Expired reports whether the message was older than the MessageTTL and was not processed
+	Expired bool
+	// Deduplicated reports whether a message with the same DedupKey was processed within the
+	// DedupWindow, and the message was deleted without being processed
+	Deduplicated bool
+	// Duration of the Processor, zero when it was not called
+	Duration time.Duration
+	// Handler is the name given to the message by the worker's HandlerName
+	Handler string
+	// Skipped reports whether the Processor returned ErrSkip
+	Skipped bool
+	// FanOut holds the results of a FanOut Processor, which has no Output
+	FanOut []Publish
+}
+
+// Partial reports whether the result was published but the message was not deleted, so it
+// will be received again
+func (r Result) Partial() bool {
+	return r.Published && !r.Deleted
+}
+
+// Callback which is passed the result of every message handled
+type Callback func(Result)
+
+// message is a received SQS message along with the queue it was received from
+type message struct {
+	*sqs.Message
+	queueURL string
+	// visibleAt is when the message becomes visible again in Unix nanoseconds, unless its
+	// visibility is changed. It is zero for messages handled without being received.
+	visibleAt int64
+}
 
 // Worker encapsulates the SQS consumer
 type Worker struct {
-	QueueURL  string
-	TopicArn  string
-	Queue     sqsiface.SQSAPI
-	Topic     snsiface.SNSAPI
-	Session   *session.Session
-	Consumers int
-	Logger    *zap.Logger
-	Processor Processor
-	Callback  Callback
-	Name      string
-	done      chan error
+	QueueURL string
+	// QueueName is resolved to the QueueURL when the worker runs
+	QueueName string
+	QueueArn  string
+	// QueueURLs lists the input queues in priority order, or interleaved by their QueueWeights,
+	// QueueURL is the first entry
+	QueueURLs       []string
+	StarvationLimit int
+	QueueWeights    []int
+	Discovery       *Discovery
+	QueueHandlers   []QueueHandler
+	Provision       *Provision
+	TopicArn        string
+	Queue           sqsiface.SQSAPI
+	Topic           snsiface.SNSAPI
+	Session         *session.Session
+	Consumers       int
+	Logger          *zap.Logger
+	Processor       Processor
+	Callback        Callback
+	Name            string
+	KeyFunc         KeyFunc
+	MaxPerKey       int
+	// KeyDeferral is the visibility timeout in seconds of the messages over MaxPerKey
+	KeyDeferral int64
+	// VisibilityTimeout in seconds requested for received messages
+	VisibilityTimeout int64
+	// VisibilityJitter is the most seconds randomly added to the VisibilityTimeout of each receive
+	VisibilityJitter int64
+	// Schedule restricts polling to its windows
+	Schedule *Schedule
+	// RateLimiter is waited on before each message is processed
+	RateLimiter RateLimiter
+	// ShouldPoll gates each cycle of receives, checked again every ShouldPollPause
+	ShouldPoll      func(context.Context) bool
+	ShouldPollPause time.Duration
+	// QueueDepthInterval is how often the queue depth is fetched for Stats, zero disables it
+	QueueDepthInterval time.Duration
+	MaxMessageAge      time.Duration
+	AgeAlert           AlertFunc
+	MessageTTL         time.Duration
+	ExpiredQueueURL    string
+	DedupKey           KeyFunc
+	DedupStore         DedupStore
+	ErrorClassifier    ErrorClassifier
+	DeadLetterQueueURL string
+	Validator          Validator
+	QuarantineQueueURL string
+	PublishRetries     int
+	PublishBackoff     time.Duration
+	Delivery           Delivery
+	PublishStore       PublishStore
+	Outbox             Outbox
+	ResultStore        ResultStore
+	JobIDAttr          string
+	JobStore           JobStore
+	DeleteRetries      int
+	DeleteBackoff      time.Duration
+	OnDeleteFailure    DeleteFailureFunc
+	Destinations       map[string]Destination
+	Router             Router
+	Sink               Sink
+	Mirror             Sink
+	FIFO               FIFOConfig
+	CorrelationAttr    string
+	Filter             *Filter
+	Decoders           []Decoder
+	Codec              Codec
+	Redactor           *Redactor
+	LogBodies          bool
+	DebugSample        float64
+	LogSampling        LogSampling
+	HandlerName        func(*sqs.Message) string
+	Audit              AuditSink
+	AuditBodyHash      bool
+	VerifyMD5          bool
+	logs               *logLimiter
+	subscribers        subscribers
+	done               chan error
+	keys               *keyLimiter
+	pressure           *backpressure
+	deliveries         *MemoryDedupStore
+	discovered         discovered
+	tuner              *visibilityTuner
+	governor           *governor
+	throttle           *tokenBucket
+	stats              *stats
+	alerter            *ageAlerter
+	stopped            chan struct{}
+	polling            polling
+	mu                 sync.Mutex
+	state              state
+	closeOnce          sync.Once
+	err                error
+	settings           settings
 }
 
 // WorkerConfig settings for Worker to be passed in NewWorker Contstuctor
 type WorkerConfig struct {
 	QueueURL string
-	TopicArn string
+	// QueueName of the input queue, resolved to its url when the worker runs, instead of the
+	// QueueURL. When the queue is deleted it is resolved again, and polling continues if it was
+	// recreated.
+	QueueName string
+	// QueueArn of the input queue instead of the QueueURL, its url is derived from the arn and
+	// the queue is polled by a client in its region. The results are published by a client in
+	// the region of the TopicArn.
+	QueueArn string
+	// QueueURLs lists multiple input queues in strict priority order. Lower priority queues are
+	// only polled when every queue ahead of them returned no messages.
+	QueueURLs []string
+	// StarvationLimit is the number of consecutive receives from the highest priority queue
+	// after which the lower priority queues are polled once. Zero disables starvation protection.
+	StarvationLimit int
+	TopicArn        string
+	// QueueWeights polls the QueueURLs in proportion to their weights rather than in priority
+	// order, e.g. with weights 3 and 1 the first queue is received from three times for each
+	// receive from the second while both have messages. A queue without messages gives its turn
+	// to the others, and the queues without a positive weight have a weight of one.
+	QueueWeights []int
+	// Discovery polls the queues it lists instead of the QueueURLs, starting and stopping their
+	// pollers as queues are created and deleted
+	Discovery *Discovery
+	// QueueHandlers create the Processors of the discovered queues by name, the queues matching
+	// none of them are processed by the Processor, or not polled without one
+	QueueHandlers []QueueHandler
+	// Provision resolves or creates the input queue and output topic by name when the worker runs
+	Provision *Provision
+	// Backend is a broker consumed instead of SQS, the QueueURLs name its queues. The Session
+	// may be nil with a Backend, the results are then sent to Sinks rather than published.
+	Backend Backend
 	// If the number of workers is 0, the number of workers defaults to runtime.NumCPU()
 	Workers   int
 	Processor Processor
 	Callback  Callback
 	Name      string
 	Logger    *zap.Logger
+	// KeyFunc derives a concurrency key from each message, e.g. a customer ID.
+	KeyFunc KeyFunc
+	// MaxPerKey caps the number of in-flight messages sharing a key. Messages over
+	// the cap have their visibility reset so they are redelivered later. Zero means no cap.
+	MaxPerKey int
+	// KeyDeferral is how many seconds the messages over MaxPerKey stay invisible before they are
+	// redelivered, DefaultKeyDeferral when it is not positive
+	KeyDeferral int64
+	// Backpressure pauses polling while handlers are slow or memory usage is high
+	Backpressure *Backpressure
+	// VisibilityTimeout in seconds requested for received messages, defaults to DefaultVisibilityTimeout
+	VisibilityTimeout int64
+	// VisibilityJitter adds up to this many seconds, chosen at random for each receive, to the
+	// VisibilityTimeout, so the messages of different receives that fail together are not all
+	// redelivered at the same time. Zero disables it.
+	VisibilityJitter int64
+	// VisibilityTuning sets the VisibilityTimeout from the observed handler durations
+	VisibilityTuning *VisibilityTuning
+	// PollingGovernor adjusts the number of pollers and their long polls to how busy the
+	// queues are, reported in Stats.Polling
+	PollingGovernor *PollingGovernor
+	// Schedule restricts polling to daily windows
+	Schedule *Schedule
+	// Throttle limits the rate at which messages are passed to the Processor. Its Rate can be
+	// changed while the worker runs with Apply.
+	Throttle *Throttle
+	// RateLimiter limits the rate at which messages are passed to the Processor instead of the
+	// Throttle, e.g. across every instance of the worker
+	RateLimiter RateLimiter
+	// ShouldPoll is called before each cycle of receives, e.g. to gate consumption on a
+	// feature flag, a maintenance calendar or the health of a downstream service. While it
+	// returns false the worker does not receive, and calls it again after ShouldPollPause,
+	// by default DefaultShouldPollPause. It must be safe to call concurrently.
+	ShouldPoll      func(context.Context) bool
+	ShouldPollPause time.Duration
+	// QueueDepthInterval is how often the approximate number of messages in the input queues
+	// is fetched and reported in Stats. Zero disables it.
+	QueueDepthInterval time.Duration
+	// MaxMessageAge is the age, measured from SentTimestamp when a message is received, above
+	// which AgeAlert is called. Alerts are sent at most once every AlertInterval. SQS queue
+	// attributes do not include the age of the oldest message, so a queue that is not being
+	// received from at all is not detected; PutAlarms alarms on that CloudWatch metric instead.
+	MaxMessageAge time.Duration
+	AgeAlert      AlertFunc
+	// MessageTTL is the age, measured from SentTimestamp, above which messages are not
+	// processed. Expired messages are deleted, after being sent to the ExpiredQueueURL when it
+	// is set. Zero disables it.
+	MessageTTL      time.Duration
+	ExpiredQueueURL string
+	// DedupWindow drops the messages whose DedupKey was processed within the window, even on
+	// standard queues. DedupKey defaults to the DefaultDedupAttr attribute, messages with an
+	// empty key are always processed. Processed keys are remembered in the DedupStore, by
+	// default a MemoryDedupStore. Duplicates received while the first message is still being
+	// processed are not detected.
+	DedupWindow time.Duration
+	DedupKey    KeyFunc
+	DedupStore  DedupStore
+	// DuplicateWindow counts the messages whose MessageId was already received within the
+	// window in Stats.RepeatedIDs, remembering the ids of every message received in the window.
+	// Zero disables it. Redeliveries are counted in Stats.Redelivered regardless.
+	DuplicateWindow time.Duration
+	// AlertInterval defaults to DefaultAlertInterval
+	AlertInterval time.Duration
+	// ErrorClassifier decides whether a message whose handler failed is retried, dropped or
+	// sent to the dead-letter queue. Messages are retried when it is nil.
+	ErrorClassifier ErrorClassifier
+	// DeadLetterQueueURL receives the messages classified as DLQ
+	DeadLetterQueueURL string
+	// Validator checks each message before it is processed. Invalid messages are forwarded
+	// to the QuarantineQueueURL instead of being redelivered, unless the error is temporary.
+	Validator          Validator
+	QuarantineQueueURL string
+	// PublishRetries is the number of times a failed publish is retried before the message is
+	// left on the queue to be processed again. PublishBackoff defaults to DefaultPublishBackoff.
+	PublishRetries int
+	PublishBackoff time.Duration
+	// Delivery orders the publish and delete of processed messages, AtLeastOnce by default
+	Delivery Delivery
+	// PublishStore records published results, so a message redelivered after its delete
+	// failed is not published twice
+	PublishStore PublishStore
+	// Outbox stores results instead of publishing them, a Relay run by the worker publishes them
+	Outbox Outbox
+	// ResultStore saves the result of each processed message, under the value of its JobIDAttr
+	// message attribute or its MessageId, for the services polling for it
+	ResultStore ResultStore
+	JobIDAttr   string
+	// JobStore records the status of the jobs sent by Jobs.Enqueue as the worker processes them.
+	// JobIDAttr defaults to DefaultJobIDAttr with a JobStore.
+	JobStore JobStore
+	// DeleteRetries is the number of times a failed delete is retried, with exponential backoff
+	// starting at DeleteBackoff, which defaults to DefaultDeleteBackoff. OnDeleteFailure is
+	// called when every retry failed, a DeleteLog records the failures to delete them later.
+	DeleteRetries   int
+	DeleteBackoff   time.Duration
+	OnDeleteFailure DeleteFailureFunc
+	// Destinations is the allow-list of topics and queues results can be sent to besides the
+	// TopicArn. The Router chooses a destination by name for each result, and a Processor that
+	// sets the TopicArn of its output may only choose one of these topics.
+	Destinations map[string]Destination
+	Router       Router
+	// Sink receives the results published to the TopicArn otherwise, unless the Processor set
+	// the TopicArn of its output. Results for a Sink are sent directly, not through the Outbox.
+	Sink Sink
+	// Mirror receives a copy of every result once its message completed, independent of where the
+	// result was sent, e.g. a FirehoseSink archiving results. Results that cannot be mirrored are
+	// logged and counted in MirrorErrors, without failing their message.
+	Mirror Sink
+	// FIFO sets the IDs of results sent to a FIFO topic or queue when the Processor did not
+	FIFO FIFOConfig
+	// CorrelationAttr is the message attribute holding the correlation ID of each message. When
+	// it is set, the ID is available from the Processor's context with CorrelationID, added to
+	// the worker's log entries, and set on the results published. Messages without the
+	// attribute are given their MessageId.
+	CorrelationAttr string
+	// Filter selects the messages passed to the Processor by their attributes
+	Filter *Filter
+	// Decoders decode message bodies before they are validated and processed. Messages whose
+	// body cannot be decoded are sent to the QuarantineQueueURL.
+	Decoders []Decoder
+	// Codec decodes messages and encodes results for typed handlers and Marshal, by default
+	// JSON. It is available to Processors from ContextCodec.
+	Codec Codec
+	// Redactor removes sensitive data from the errors, message bodies and results logged
+	Redactor *Redactor
+	// LogBodies adds the bodies of failed messages, and of the results that could not be sent,
+	// to their log entries, after the Redactor removed sensitive data from them
+	LogBodies bool
+	// DebugSample is the fraction of messages, between 0 and 1, whose lifecycle is logged at the
+	// info level: their attributes and body when received, the duration of the Processor, and
+	// the outcome of the publish and delete. Bodies and results are redacted by the Redactor.
+	// The fraction can be changed while the worker runs with Apply.
+	DebugSample float64
+	// LogSampling limits how often the same error is logged, summarizing the errors suppressed
+	LogSampling LogSampling
+	// HandlerName names the handler of each message, e.g. by the event type attribute a Router
+	// routes by, breaking the counters and latencies down by handler in Stats.Handlers
+	HandlerName func(*sqs.Message) string
+	// Audit records every message handled, with its outcome and durations
+	Audit AuditSink
+	// AuditBodyHash adds the SHA-256 of each message body to its AuditRecord
+	AuditBodyHash bool
+	// VerifyMD5 checks the MD5 checksums of the body and attributes of every message received.
+	// Messages that do not match are quarantined, or dropped without a QuarantineQueueURL. The
+	// checksums of the messages the worker sends are checked as well, and mismatches counted in
+	// Stats.SendChecksumMismatches.
+	VerifyMD5 bool
 }
 
 func (w *Worker) logError(msg string, err error) {
 	if w.Logger != nil {
-		w.Logger.Error(err.Error(),
+		text, field := w.errorField(err)
+		if w.logs != nil && !w.logs.allow(msg, text) {
+			return
+		}
+		w.Logger.Error(text,
 			zap.String("app", w.Name),
 			zap.String("msg", msg),
-			zap.Error(err),
+			field,
+		)
+	}
+}
+
+// errorField returns the text of an error and its log field, with sensitive data removed by
+// the Redactor, since errors often quote the body they failed on
+func (w *Worker) errorField(err error) (string, zap.Field) {
+	if w.Redactor == nil {
+		return err.Error(), zap.Error(err)
+	}
+	text := w.Redactor.Redact(err.Error())
+	return text, zap.String("error", text)
+}
+
+// logConsumerError logs an error handling a message, along with the consumer that handled it
+// and the correlation ID of the message
+func (w *Worker) logConsumerError(state *consumerState, msg string, err error) {
+	w.logBodyError(state, msg, nil, err)
+}
+
+// logBodyError logs an error like logConsumerError, adding the body of the message or result
+// that failed when LogBodies is set
+func (w *Worker) logBodyError(state *consumerState, msg string, body *string, err error) {
+	if w.Logger == nil {
+		return
+	}
+	text, field := w.errorField(err)
+	if w.logs != nil && !w.logs.allow(msg, text) {
+		return
+	}
+	fields := []zap.Field{zap.String("app", w.Name), zap.String("msg", msg)}
+	if state.stats != nil {
+		fields = append(fields, zap.Int("consumer", state.id))
+	}
+	if state.correlationID != "" {
+		fields = append(fields, zap.String("correlation_id", state.correlationID))
+	}
+	if w.LogBodies && body != nil {
+		fields = append(fields, zap.String("body", w.Redactor.Redact(*body)))
+	}
+	w.Logger.Error(text, append(fields, field)...)
+}
+
+func (w *Worker) logConsumer(id int, msg string) {
+	if w.Logger != nil {
+		w.Logger.Info(msg,
+			zap.String("app", w.Name),
+			zap.Int("consumer", id),
 		)
 	}
 }
@@ -82,6 +456,7 @@ func (w *Worker) logInfo(msg string) {
 }
 
 func (w *Worker) deleteMessage(m *sqs.DeleteMessageInput) error {
+	atomic.AddInt64(&w.stats.api.deletes, 1)
 	_, err := w.Queue.DeleteMessage(m)
 	if err != nil {
 		return err
@@ -89,115 +464,560 @@ func (w *Worker) deleteMessage(m *sqs.DeleteMessageInput) error {
 	return nil
 }
 
-func (w *Worker) sendMessage(msg *sns.PublishInput) error {
-	if w.TopicArn == "" {
-		return nil
+// sendMessage sends the result to the destination's Sink or queue, or publishes it to its TopicArn
+func (w *Worker) sendMessage(ctx context.Context, msg message, output *sns.PublishInput, dest Destination) (*sns.PublishOutput, error) {
+	if dest.Sink != nil {
+		return nil, dest.Sink.Send(ctx, msg.Message, output)
 	}
-
-	if msg.Message == nil {
-		return nil
+	if dest.QueueURL == "" {
+		atomic.AddInt64(&w.stats.api.publishes, 1)
+	} else {
+		atomic.AddInt64(&w.stats.api.sends, 1)
 	}
+	return deliver(w.Topic, w.Queue, dest.QueueURL, output, w.sendCheck())
+}
 
-	_, err := w.Topic.Publish(msg)
+// changeVisibility makes a message visible again after the timeout in seconds
+func (w *Worker) changeVisibility(msg message, timeout int64) error {
+	atomic.AddInt64(&w.stats.api.visibilityChanges, 1)
+	_, err := w.Queue.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          &msg.queueURL,
+		ReceiptHandle:     msg.ReceiptHandle,
+		VisibilityTimeout: aws.Int64(timeout),
+	})
 	return err
 }
 
-func (w *Worker) consumer(ctx context.Context, in chan *sqs.Message) {
-	var msgString string
-	deleteInput := &sqs.DeleteMessageInput{QueueUrl: &w.QueueURL}
-	var sendInput *sns.PublishInput
+// consumerState holds the per consumer values that are reused across messages
+type consumerState struct {
+	// id of the consumer, stats is nil for messages passed to Handle
+	id          int
+	stats       *consumerStats
+	queueURL    string
+	deleteInput sqs.DeleteMessageInput
+	// output is the copy of a result published to the worker's TopicArn
+	output sns.PublishInput
+	// correlationID of the message being handled, for its log entries
+	correlationID string
+	// debug reports whether the lifecycle of the message being handled is traced
+	debug bool
+	// fanOut holds the destinations of the results of a FanOut
+	fanOut []Destination
+}
+
+// Handle runs a single message received from QueueURL through the worker's pipeline: the
+// message is processed, the result is published and the message is deleted. The returned
+// error is the same error passed to the Callback.
+func (w *Worker) Handle(ctx context.Context, m *sqs.Message) error {
+	return w.handle(ctx, &consumerState{}, message{Message: m, queueURL: w.QueueURL})
+}
+
+func (w *Worker) handle(ctx context.Context, state *consumerState, msg message) error {
+	var start time.Time
+	if w.Audit != nil {
+		start = time.Now()
+	}
+	state.correlationID = w.correlationID(msg)
+	ctx = withMessage(ctx, msg, state.correlationID, w.Codec)
+	if state.debug = w.sampled(); state.debug {
+		w.traceReceived(state, msg)
+	}
+	w.emit(EventReceived, msg, nil)
+	w.observeDelivery(msg.Message)
+	var output *sns.PublishInput
+	var dest Destination
 	var err error
+
+	var dedupKey string
+	if w.DedupStore != nil {
+		dedupKey = w.dedupKey(msg.Message)
+	}
+	// messages that are not processed are deleted or returned by one of these
+	var drop func(context.Context, *consumerState, message, *Result) error
+	switch {
+	case w.Filter != nil && !w.Filter.Matches(msg.Message):
+		drop = w.filter
+	case w.expired(msg.Message):
+		drop = w.expire
+	case w.duplicate(ctx, state, dedupKey):
+		drop = w.deduplicate
+	}
+	if drop != nil {
+		result := Result{Message: msg.Message}
+		err = drop(ctx, state, msg, &result)
+		result.Err = err
+		if state.debug {
+			w.traceResult(state, msg, result)
+		}
+		if w.Audit != nil {
+			w.audit(ctx, state, msg, result, start)
+		}
+		w.emitResult(msg, result)
+		if w.Callback != nil {
+			w.Callback(result)
+		}
+		return err
+	}
+
+	var key string
+	if w.keys != nil {
+		key = w.KeyFunc(msg.Message)
+		if !w.keys.acquire(key) {
+			return w.deferKey(state, msg)
+		}
+		defer w.keys.release(key)
+	}
+
+	// the Processor receives the decoded message, failed messages are forwarded as received
+	input := msg
+	if w.VerifyMD5 {
+		err = verifyReceived(msg.Message)
+	}
+	if err == nil && len(w.Decoders) > 0 && msg.Body != nil {
+		input, err = w.decode(msg)
+	}
+	if err == nil && w.Validator != nil {
+		if err = w.Validator(input.Message); err != nil && !temporary(err) {
+			err = &invalidError{err}
+		}
+	}
+	var duration time.Duration
+	if err == nil && w.RateLimiter != nil {
+		err = w.RateLimiter.Wait(ctx, 1)
+	}
+	if err == nil && w.JobStore != nil {
+		w.recordJob(ctx, state, msg, JobRunning, nil, nil)
+	}
+	if err == nil {
+		start := time.Now()
+		output, err = w.process(ctx, input)
+		duration = time.Since(start)
+		if w.tuner != nil {
+			w.tuner.durations.observe(duration)
+		}
+	}
+	publishes := fannedOut(ctx)
+	if err == nil {
+		dest, err = w.route(msg, output)
+	}
+	if err == nil && len(publishes) > 0 {
+		err = w.routeFanOut(state, msg, publishes)
+	}
+	if err == nil && w.ResultStore != nil {
+		err = w.saveResult(ctx, msg, output)
+	}
+	result := Result{Message: msg.Message, Duration: duration, FanOut: publishes}
+	if errors.Is(err, ErrSkip) {
+		atomic.AddInt64(&w.stats.skipped, 1)
+		result.Skipped = true
+		err = w.delete(ctx, state, msg)
+		if err != nil {
+			w.logConsumerError(state, "delete message failed!", err)
+		}
+		result.Deleted = err == nil
+	} else if err == nil {
+		// a message is processed once its result is published and it is deleted
+		if err = w.complete(ctx, state, msg, output, dest, &result); err == nil {
+			atomic.AddInt64(&w.stats.processed, 1)
+		}
+	} else {
+		atomic.AddInt64(&w.stats.failed, 1)
+		w.stats.countFailure(err)
+		if IsInvalid(err) {
+			w.logBodyError(state, "validation failed!", input.Body, err)
+		} else {
+			w.logBodyError(state, "handler failed!", input.Body, err)
+		}
+		result.Deleted = w.settle(ctx, state, msg, err)
+	}
+
+	if err == nil && dedupKey != "" {
+		w.markSeen(ctx, state, dedupKey)
+	}
+
+	if w.JobStore != nil {
+		switch {
+		case err == nil:
+			w.recordJob(ctx, state, msg, JobSucceeded, output, nil)
+		case result.Deleted:
+			// the failed message was dropped, dead-lettered or quarantined
+			w.recordJob(ctx, state, msg, JobFailed, nil, err)
+		default:
+			w.recordJob(ctx, state, msg, JobQueued, nil, err)
+		}
+	}
+
+	w.observeEndToEnd(msg.Message)
+	if w.Discovery != nil {
+		w.observeQueue(msg, err, duration)
+	}
+	if w.HandlerName != nil {
+		result.Handler = w.HandlerName(msg.Message)
+		w.observeHandler(result.Handler, msg.Message, err, duration)
+	}
+
+	result.Output = output
+	result.Err = err
+	if state.debug {
+		w.traceResult(state, msg, result)
+	}
+	if w.Audit != nil {
+		w.audit(ctx, state, msg, result, start)
+	}
+	w.emitResult(msg, result)
+	if w.Callback != nil {
+		w.Callback(result)
+	}
+	return err
+}
+
+// process runs the Processor, timing it when backpressure is enabled. A Processor that panics
+// fails the message with a *PanicError.
+func (w *Worker) process(ctx context.Context, msg message) (output *sns.PublishInput, err error) {
+	defer recoverPanic(&err)
+	processor := w.Processor
+	if w.QueueHandlers != nil {
+		if processor = w.queueProcessor(msg.queueURL); processor == nil {
+			return nil, ErrNoProcessor
+		}
+	}
+	if w.pressure == nil {
+		return processor.Process(ctx, msg.Message)
+	}
+	start := w.pressure.begin()
+	defer w.pressure.end(start)
+	return processor.Process(ctx, msg.Message)
+}
+
+// consume handles a message received by the producer
+func (w *Worker) consume(ctx context.Context, state *consumerState, msg message) {
+	w.handle(ctx, state, msg)
+	atomic.AddInt64(&w.stats.inFlight, -1)
+	atomic.AddInt64(&state.stats.handled, 1)
+	atomic.StoreInt64(&state.stats.lastActive, time.Now().UnixNano())
+}
+
+func (w *Worker) consumer(ctx context.Context, id int, in chan message, quit chan struct{}) {
+	state := consumerState{id: id, stats: w.stats.addConsumer(id)}
+	defer w.stats.removeConsumer(id)
+	w.logConsumer(id, "Starting consumer")
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case msg := <-in:
-			if w.Callback != nil || w.TopicArn != "" {
-				sendInput = &sns.PublishInput{TopicArn: &w.TopicArn, Message: &msgString}
-			}
-			err = w.Processor.Process(ctx, msg, sendInput)
-			if err == nil {
-				err = w.sendMessage(sendInput)
-				if err != nil {
-					w.logError("send message failed!", err)
-				}
-				deleteInput.ReceiptHandle = msg.ReceiptHandle
-				err = w.deleteMessage(deleteInput)
-				if err != nil {
-					w.logError("delete message failed!", err)
-				}
-			} else {
-				w.logError("handler failed!", err)
-			}
-
-			if w.Callback != nil {
-				w.Callback(sendInput.Message, err)
+		case <-quit:
+			return
+		case msg, ok := <-in:
+			if !ok {
+				return
 			}
+			w.consume(ctx, &state, msg)
 		}
 	}
 }
 
-func (w *Worker) producer(ctx context.Context, out chan *sqs.Message) {
+func (w *Worker) receiveParams(queueURL string, waitTimeSeconds int64) *sqs.ReceiveMessageInput {
 	params := &sqs.ReceiveMessageInput{
-		QueueUrl:            aws.String(w.QueueURL),
+		QueueUrl:            aws.String(queueURL),
 		MaxNumberOfMessages: aws.Int64(DefaultMaxNumberOfMessages),
-		VisibilityTimeout:   aws.Int64(DefaultVisibilityTimeout),
-		WaitTimeSeconds:     aws.Int64(DefaultWaitTimeSeconds),
+		VisibilityTimeout:   aws.Int64(atomic.LoadInt64(&w.settings.visibilityTimeout)),
+		WaitTimeSeconds:     aws.Int64(waitTimeSeconds),
+		// the message group of FIFO messages is kept when they are sent to a dead-letter queue
+		// and keys their results' partitions and groups, the rest are the metadata available
+		// from the Processor's context
+		AttributeNames: aws.StringSlice([]string{
+			sqs.MessageSystemAttributeNameSentTimestamp,
+			sqs.MessageSystemAttributeNameMessageGroupId,
+			sqs.MessageSystemAttributeNameApproximateReceiveCount,
+			sqs.MessageSystemAttributeNameAwstraceHeader,
+		}),
+		// every message attribute is received, for KeyFuncs, Processors and the attributes
+		// forwarded with failed messages
+		MessageAttributeNames: aws.StringSlice([]string{"All"}),
+	}
+	return params
+}
+
+func (w *Worker) producer(ctx context.Context, out chan message) {
+	var pollers sync.WaitGroup
+	if w.Discovery != nil {
+		w.discover(ctx, out, &pollers)
+	} else {
+		w.pollQueues(ctx, out, w.QueueURLs, 0, &pollers)
+	}
+	pollers.Wait()
+}
+
+// pollQueues receives from the queues until the context is done. The pollers started by the
+// governor, with an id above zero, also return once it no longer needs them.
+func (w *Worker) pollQueues(ctx context.Context, out chan message, queueURLs []string, id int, pollers *sync.WaitGroup) (left bool) {
+	// Only the lowest priority queue, or the last queue of a weighted cycle, is long-polled,
+	// the others are polled without waiting so that an empty queue does not block the rest.
+	last := len(queueURLs) - 1
+	params := make([]*sqs.ReceiveMessageInput, len(queueURLs))
+	for i, queueURL := range queueURLs {
+		var wait int64
+		if i == last {
+			wait = DefaultWaitTimeSeconds
+		}
+		params[i] = w.receiveParams(queueURL, wait)
+	}
+
+	var fair *weightedRoundRobin
+	if len(w.QueueWeights) > 0 && last > 0 {
+		fair = newWeightedRoundRobin(w.QueueWeights, len(queueURLs))
 	}
+	order := make([]int, 0, len(queueURLs))
 
+	var consecutive int
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
-			req, resp := w.Queue.ReceiveMessageRequest(params)
-			err := req.Send()
-			if err != nil {
-				w.logError("receive messages failed!", err)
+			if id > 0 && w.governor.leave(id) {
+				return true
+			}
+
+			if w.pressure != nil {
+				if reason := w.pressure.overloaded(); reason != "" {
+					w.logInfo(fmt.Sprint("Pausing producer due to ", reason))
+					select {
+					case <-ctx.Done():
+					case <-time.After(w.pressure.config.Pause):
+					}
+					continue
+				}
+			}
+
+			if w.ShouldPoll != nil && !w.ShouldPoll(ctx) {
+				sleep(ctx, w.ShouldPollPause)
+				continue
+			}
+
+			order = order[:0]
+			starving := false
+			if fair != nil {
+				order = fair.order(order)
 			} else {
-				messages := resp.Messages
-				if len(messages) > 0 {
-					for _, message := range messages {
-						out <- message
+				start := 0
+				if w.StarvationLimit > 0 && consecutive >= w.StarvationLimit && last > 0 {
+					start = 1
+					consecutive = 0
+					starving = true
+				}
+				for i := start; i <= last; i++ {
+					order = append(order, i)
+				}
+			}
+
+			// The queues are idle when a full cycle, starting from the highest priority
+			// queue or polling every weighted queue, returned no messages without errors.
+			idle := order[0] == 0 || fair != nil
+			for p, i := range order {
+				*params[i].VisibilityTimeout = w.receiveVisibility()
+				if fair != nil {
+					*params[i].WaitTimeSeconds = 0
+					if p == last {
+						*params[i].WaitTimeSeconds = DefaultWaitTimeSeconds
+					}
+				} else if i == last {
+					*params[i].WaitTimeSeconds = DefaultWaitTimeSeconds
+				}
+				if p == len(order)-1 && w.governor != nil {
+					*params[i].WaitTimeSeconds = w.governor.wait()
+				}
+				// the lower priority queues are only polled once to keep them from starving,
+				// without waiting, so the busy highest priority queue is polled again right away
+				if starving {
+					*params[i].WaitTimeSeconds = 0
+				}
+				n, err := w.receive(ctx, params[i], queueURLs[i], out)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					idle = false
+					continue
+				}
+				if w.governor != nil {
+					if started := w.governor.observe(n); started > 0 {
+						w.startPoller(ctx, out, queueURLs, started, pollers)
 					}
 				}
+				if n == 0 {
+					continue
+				}
+
+				idle = false
+				if i == 0 {
+					consecutive++
+				} else {
+					consecutive = 0
+				}
+				break
+			}
+			if idle {
+				atomic.StoreInt32(&w.stats.idle, 1)
+				if w.governor != nil {
+					sleep(ctx, w.governor.idle())
+				}
 			}
 		}
 	}
 }
 
-// Close function will send a signal to all workers to exit
-func (w *Worker) Close() {
-	close(w.done)
+// startPoller starts a poller for the governor
+func (w *Worker) startPoller(ctx context.Context, out chan message, queueURLs []string, id int, pollers *sync.WaitGroup) {
+	pollers.Add(1)
+	go func() {
+		defer pollers.Done()
+		if !w.pollQueues(ctx, out, queueURLs, id, pollers) {
+			w.governor.stopped()
+		}
+	}()
 }
 
-// Run does the main consumer/producer loop
+// receive sends a single receive request and dispatches the messages, returning how many were received
+func (w *Worker) receive(ctx context.Context, params *sqs.ReceiveMessageInput, queueURL string, out chan message) (int, error) {
+	req, resp := w.Queue.ReceiveMessageRequest(params)
+	if req.HTTPRequest != nil {
+		req.SetContext(ctx)
+	}
+	err := req.Send()
+	atomic.AddInt64(&w.stats.api.receives, 1)
+	if err == nil && len(resp.Messages) == 0 {
+		atomic.AddInt64(&w.stats.api.emptyReceives, 1)
+	}
+	if ctx.Err() != nil {
+		w.returnMessages(queueURL, resp.Messages)
+		return 0, ctx.Err()
+	}
+	if err != nil {
+		atomic.AddInt64(&w.stats.receiveErrors, 1)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == sqs.ErrCodeQueueDoesNotExist {
+			// a discovered queue was deleted since it was listed
+			if w.Discovery != nil {
+				w.logError("receive messages failed!", err)
+				w.discovered.stop(queueURL)
+				return 0, err
+			}
+			if w.recreated(queueURL) {
+				w.logError("receive messages failed!", err)
+				return 0, err
+			}
+			w.fail(err)
+			return 0, err
+		}
+		w.logError("receive messages failed!", err)
+		w.emit(EventPollerError, message{queueURL: queueURL}, err)
+		return 0, err
+	}
+
+	messages := resp.Messages
+	if len(messages) == 0 {
+		return 0, nil
+	}
+	atomic.StoreInt32(&w.stats.idle, 0)
+	atomic.AddInt64(&w.stats.received, int64(len(messages)))
+	visibleAt := time.Now().Add(time.Duration(aws.Int64Value(params.VisibilityTimeout)) * time.Second).UnixNano()
+	for j, m := range messages {
+		if w.AgeAlert != nil {
+			w.checkAge(queueURL, m)
+		}
+		atomic.AddInt64(&w.stats.inFlight, 1)
+		select {
+		case out <- message{m, queueURL, visibleAt}:
+		case <-ctx.Done():
+			atomic.AddInt64(&w.stats.inFlight, -int64(len(messages)-j))
+			w.returnMessages(queueURL, messages[j:])
+			return j, ctx.Err()
+		}
+	}
+	return len(messages), nil
+}
+
+// returnMessages makes messages that were received but will not be processed visible again
+func (w *Worker) returnMessages(queueURL string, messages []*sqs.Message) {
+	if len(messages) == 0 {
+		return
+	}
+	batches := (len(messages) + DefaultMaxNumberOfMessages - 1) / DefaultMaxNumberOfMessages
+	atomic.AddInt64(&w.stats.api.visibilityChanges, int64(batches))
+	if err := returnVisibility(queueURL, messages, w.Queue); err != nil {
+		w.logError("return messages failed!", err)
+	}
+}
+
+// Run does the main consumer/producer loop. It returns once the worker is closed, and
+// immediately if the worker was already run or closed.
 func (w *Worker) Run() {
+	if w.Provision != nil {
+		if err := w.provision(); err != nil {
+			w.fail(err)
+			return
+		}
+	}
+	if w.QueueName != "" && w.QueueURL == "" {
+		if err := w.resolveQueue(); err != nil {
+			w.fail(err)
+			return
+		}
+	}
+	if err := w.validate(); err != nil {
+		w.fail(err)
+		return
+	}
+	if !w.transition(stateNew, stateRunning) {
+		return
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
-	messages := make(chan *sqs.Message, w.Consumers)
+	defer cancel()
+	messages := make(chan message, atomic.LoadInt64(&w.settings.consumers))
+	polled := make(chan struct{})
+
+	// a worker started outside of its schedule starts paused
+	if w.Schedule != nil {
+		if !w.Schedule.Open(time.Now()) {
+			w.pause()
+		}
+		go w.schedule(ctx)
+	}
 
 	w.logInfo(fmt.Sprint("Staring producer"))
 	go func() {
-		w.producer(ctx, messages)
+		w.poll(ctx, messages)
 		close(messages)
+		close(polled)
 	}()
 
 	go func() {
-		<-w.done
-		cancel()
+		select {
+		case <-w.done:
+			cancel()
+		case <-ctx.Done():
+		}
 	}()
 
-	w.logInfo(fmt.Sprint("Staring consumer with ", w.Consumers, " consumers"))
-	// Consume messages
-	var wg sync.WaitGroup
-	for x := 0; x < w.Consumers; x++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			w.consumer(ctx, messages)
-		}()
+	if w.QueueDepthInterval > 0 {
+		go w.queueDepth(ctx)
 	}
-	wg.Wait()
+	if w.tuner != nil {
+		go w.tuneVisibility(ctx)
+	}
+	if w.Outbox != nil {
+		relay := &Relay{Outbox: w.Outbox, Topic: w.Topic, Queue: w.Queue, Logger: w.Logger}
+		go relay.Run(ctx)
+	}
+
+	w.consumers(ctx, messages)
+
+	go func() {
+		<-polled
+		w.closeEvents()
+		close(w.stopped)
+	}()
 }
 
 // CreateQueue Create queue by name.
@@ -214,18 +1034,7 @@ func CreateQueue(name string, sqsc sqsiface.SQSAPI) (string, error) {
 
 // GetOrCreateQueue an SQS Queue by name.
 func GetOrCreateQueue(name string, sqsc sqsiface.SQSAPI) (string, error) {
-	queueOut, err := sqsc.GetQueueUrl(&sqs.GetQueueUrlInput{
-		QueueName: aws.String(name),
-	})
-
-	if aerr, ok := err.(awserr.Error); ok {
-		switch aerr.Code() {
-		case sqs.ErrCodeQueueDoesNotExist:
-			return CreateQueue(name, sqsc)
-		}
-	}
-
-	return *queueOut.QueueUrl, err
+	return GetOrCreateQueueWithAttributes(name, nil, sqsc)
 }
 
 // GetOrCreateTopic Create SNS topic by name.
@@ -234,48 +1043,224 @@ func GetOrCreateTopic(name string, snsc snsiface.SNSAPI) (string, error) {
 		return "", nil
 	}
 
-	snsOut, err := snsc.CreateTopic(&sns.CreateTopicInput{
-		Name: aws.String(name),
-	})
-
-	return *snsOut.TopicArn, err
+	return createTopic(name, nil, snsc)
 }
 
 // NewWorker constructor for SQS Worker
 func NewWorker(sess *session.Session, wc WorkerConfig) *Worker {
 	var logger *zap.Logger
+	var keys *keyLimiter
+	var visibilityTimeout int64 = DefaultVisibilityTimeout
+	var pressure *backpressure
+	var tuner *visibilityTuner
+	var governed *governor
+	var throttle *tokenBucket
+	var limiter = wc.RateLimiter
+	var alertInterval = DefaultAlertInterval
+	var publishBackoff = DefaultPublishBackoff
+	var deleteBackoff = DefaultDeleteBackoff
+	var shouldPollPause = DefaultShouldPollPause
+	var keyDeferral int64 = DefaultKeyDeferral
 	workers := runtime.NumCPU()
 	var queueURL, topicARN = wc.QueueURL, wc.TopicArn
+	var queueURLs = wc.QueueURLs
+	var queueConfigs []*aws.Config
+	var jobIDAttr = wc.JobIDAttr
+	var dedupStore = wc.DedupStore
+	var deliveries *MemoryDedupStore
 
 	if wc.Workers != 0 {
 		workers = wc.Workers
 	}
 
+	if wc.PublishBackoff != 0 {
+		publishBackoff = wc.PublishBackoff
+	}
+
+	if wc.DeleteBackoff != 0 {
+		deleteBackoff = wc.DeleteBackoff
+	}
+
+	if wc.ShouldPollPause != 0 {
+		shouldPollPause = wc.ShouldPollPause
+	}
+
+	if wc.VisibilityTimeout != 0 {
+		visibilityTimeout = wc.VisibilityTimeout
+	}
+
+	if wc.KeyDeferral > 0 {
+		keyDeferral = wc.KeyDeferral
+	}
+
+	if wc.AlertInterval != 0 {
+		alertInterval = wc.AlertInterval
+	}
+
+	// The SDK fails a whole receive when the checksum of one message does not match, the
+	// worker checks them per message instead
+	if wc.VerifyMD5 {
+		queueConfigs = append(queueConfigs, aws.NewConfig().WithDisableComputeChecksums(true))
+	}
+
+	if wc.QueueArn != "" && queueURL == "" {
+		queueURL, _ = QueueURLFromARN(wc.QueueArn)
+		if config := regionConfig(sess, wc.QueueArn, sqs.EndpointsID); config != nil {
+			queueConfigs = append(queueConfigs, config)
+		}
+	}
+
+	if jobIDAttr == "" && wc.JobStore != nil {
+		jobIDAttr = DefaultJobIDAttr
+	}
+
+	if dedupStore == nil && wc.DedupWindow > 0 {
+		dedupStore = NewMemoryDedupStore(wc.DedupWindow)
+	}
+
+	if wc.DuplicateWindow > 0 {
+		deliveries = NewMemoryDedupStore(wc.DuplicateWindow)
+	}
+
 	if wc.Logger == nil {
 		logger, _ = zap.NewProduction()
 	} else {
 		logger = wc.Logger
 	}
 
-	if queueURL == "" {
+	if wc.KeyFunc != nil && wc.MaxPerKey > 0 {
+		keys = newKeyLimiter(wc.MaxPerKey)
+	}
+
+	if wc.Backpressure != nil {
+		pressure = newBackpressure(*wc.Backpressure)
+	}
+
+	if wc.VisibilityTuning != nil {
+		tuner = newVisibilityTuner(*wc.VisibilityTuning)
+	}
+
+	if wc.PollingGovernor != nil {
+		governed = newGovernor(*wc.PollingGovernor)
+	}
+
+	if limiter == nil && wc.Throttle != nil && wc.Throttle.Rate > 0 {
+		throttle = newTokenBucket(wc.Throttle.Rate, wc.Throttle.Burst)
+		limiter = throttle
+	}
+
+	if queueURL == "" && len(queueURLs) > 0 {
+		queueURL = queueURLs[0]
+	}
+
+	if queueURL == "" && wc.QueueName == "" && wc.QueueArn == "" {
 		queueURL = os.Getenv("QUEUE_URL")
 	}
 
+	if len(queueURLs) == 0 && (queueURL != "" || wc.Discovery == nil) {
+		queueURLs = []string{queueURL}
+	}
+
 	if topicARN == "" {
 		topicARN = os.Getenv("TOPIC_ARN")
 	}
 
-	return &Worker{
-		queueURL,
-		topicARN,
-		sqs.New(sess),
-		sns.New(sess),
-		sess,
-		workers,
-		logger,
-		wc.Processor,
-		wc.Callback,
-		wc.Name,
-		make(chan error),
+	var queue sqsiface.SQSAPI
+	var topic snsiface.SNSAPI
+	if wc.Backend != nil {
+		queue = NewBackendQueue(wc.Backend)
+	} else {
+		queue = sqs.New(sess, queueConfigs...)
+	}
+	if sess != nil || wc.Backend == nil {
+		topic = sns.New(sess, topicConfigs(sess, topicARN)...)
+	}
+
+	w := &Worker{
+		QueueURL:           queueURL,
+		QueueName:          wc.QueueName,
+		QueueArn:           wc.QueueArn,
+		QueueURLs:          queueURLs,
+		StarvationLimit:    wc.StarvationLimit,
+		QueueWeights:       wc.QueueWeights,
+		Discovery:          wc.Discovery,
+		QueueHandlers:      wc.QueueHandlers,
+		Provision:          wc.Provision,
+		TopicArn:           topicARN,
+		Queue:              queue,
+		Topic:              topic,
+		Session:            sess,
+		Consumers:          workers,
+		Logger:             logger,
+		Processor:          wc.Processor,
+		Callback:           wc.Callback,
+		Name:               wc.Name,
+		KeyFunc:            wc.KeyFunc,
+		MaxPerKey:          wc.MaxPerKey,
+		KeyDeferral:        keyDeferral,
+		VisibilityTimeout:  visibilityTimeout,
+		VisibilityJitter:   wc.VisibilityJitter,
+		Schedule:           wc.Schedule,
+		RateLimiter:        limiter,
+		ShouldPoll:         wc.ShouldPoll,
+		ShouldPollPause:    shouldPollPause,
+		QueueDepthInterval: wc.QueueDepthInterval,
+		done:               make(chan error),
+		keys:               keys,
+		pressure:           pressure,
+		deliveries:         deliveries,
+		tuner:              tuner,
+		governor:           governed,
+		throttle:           throttle,
+		MaxMessageAge:      wc.MaxMessageAge,
+		AgeAlert:           wc.AgeAlert,
+		MessageTTL:         wc.MessageTTL,
+		ExpiredQueueURL:    wc.ExpiredQueueURL,
+		DedupKey:           wc.DedupKey,
+		DedupStore:         dedupStore,
+		ErrorClassifier:    wc.ErrorClassifier,
+		DeadLetterQueueURL: wc.DeadLetterQueueURL,
+		Validator:          wc.Validator,
+		QuarantineQueueURL: wc.QuarantineQueueURL,
+		PublishRetries:     wc.PublishRetries,
+		PublishBackoff:     publishBackoff,
+		Delivery:           wc.Delivery,
+		PublishStore:       wc.PublishStore,
+		Outbox:             wc.Outbox,
+		ResultStore:        wc.ResultStore,
+		JobIDAttr:          jobIDAttr,
+		JobStore:           wc.JobStore,
+		DeleteRetries:      wc.DeleteRetries,
+		DeleteBackoff:      deleteBackoff,
+		OnDeleteFailure:    wc.OnDeleteFailure,
+		Destinations:       wc.Destinations,
+		Router:             wc.Router,
+		Sink:               wc.Sink,
+		Mirror:             wc.Mirror,
+		FIFO:               wc.FIFO,
+		CorrelationAttr:    wc.CorrelationAttr,
+		Filter:             wc.Filter,
+		Decoders:           wc.Decoders,
+		Codec:              wc.Codec,
+		Redactor:           wc.Redactor,
+		LogBodies:          wc.LogBodies,
+		DebugSample:        wc.DebugSample,
+		LogSampling:        wc.LogSampling,
+		HandlerName:        wc.HandlerName,
+		Audit:              wc.Audit,
+		AuditBodyHash:      wc.AuditBodyHash,
+		VerifyMD5:          wc.VerifyMD5,
+		stats:              newStats(),
+		alerter:            &ageAlerter{interval: alertInterval},
+		stopped:            make(chan struct{}),
+		polling:            polling{resume: make(chan struct{}, 1)},
+		settings: settings{
+			consumers:         int64(workers),
+			visibilityTimeout: visibilityTimeout,
+			debugSample:       sampleBits(wc.DebugSample),
+			resize:            make(chan struct{}, 1),
+		},
 	}
+	w.logs = newLogLimiter(wc.LogSampling, w.logSuppressed)
+	return w
 }
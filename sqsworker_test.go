@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
@@ -14,9 +15,20 @@ import (
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+// newRequest returns a request that sends nothing, for mocked receives
+func newRequest() *request.Request {
+	return &request.Request{HTTPRequest: &http.Request{URL: &url.URL{}, Header: http.Header{}}}
+}
+
 var sess *session.Session
 
 const queueBase = "https://sqs.us-east-1.amazonaws.com/88888888888/"
@@ -36,6 +48,7 @@ type MockQueue struct {
 	receive *sqs.ReceiveMessageOutput
 	Msg     string
 	Error   awserr.Error
+	Visible chan *sqs.ChangeMessageVisibilityInput
 }
 
 type HelloWorld struct {
@@ -47,17 +60,36 @@ type NoOP struct {
 type ErrorWorker struct {
 }
 
-func (e *ErrorWorker) Process(ctx context.Context, m *sqs.Message, w *sns.PublishInput) error {
-	return errors.New("test error")
+func (e *ErrorWorker) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	return nil, errors.New("test error")
+}
+
+func (n *NoOP) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	return nil, nil
+}
+
+// StaticWorker returns the same output for every message
+type StaticWorker struct {
+	Output *sns.PublishInput
+}
+
+func (s *StaticWorker) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	return s.Output, nil
+}
+
+type BlockingWorker struct {
+	Started chan bool
+	Release chan bool
 }
 
-func (n *NoOP) Process(ctx context.Context, m *sqs.Message, w *sns.PublishInput) error {
-	return nil
+func (b *BlockingWorker) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	b.Started <- true
+	<-b.Release
+	return nil, nil
 }
 
-func (h *HelloWorld) Process(ctx context.Context, m *sqs.Message, w *sns.PublishInput) error {
-	*w.Message = fmt.Sprint(*m.Body, " ", "world")
-	return nil
+func (h *HelloWorld) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	return &sns.PublishInput{Message: aws.String(fmt.Sprint(*m.Body, " ", "world"))}, nil
 }
 
 func (m *MockQueue) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
@@ -72,6 +104,15 @@ func (m *MockQueue) ReceiveMessageRequest(input *sqs.ReceiveMessageInput) (*requ
 	return m.req, m.receive
 }
 
+func (m *MockQueue) ChangeMessageVisibility(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+	m.Visible <- input
+	return nil, nil
+}
+
+func (m *MockQueue) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+}
+
 func (m *MockQueue) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
 	m.Out <- *input.MessageBody
 	return nil, nil
@@ -106,11 +147,12 @@ func GetMockeQueue() *MockQueue {
 	return &MockQueue{
 		In:  make(chan string),
 		Out: make(chan string),
-		req: &request.Request{},
+		req: newRequest(),
 		receive: &sqs.ReceiveMessageOutput{
 			Messages: []*sqs.Message{{Body: nil}},
 		},
-		Msg: "",
+		Msg:     "",
+		Visible: make(chan *sqs.ChangeMessageVisibilityInput, 10),
 	}
 }
 
@@ -152,7 +194,8 @@ func BenchmarkWorkerSQSSNS(b *testing.B) {
 	queue := GetMockeQueue()
 	topic := GetMockTopic()
 
-	handler := &NoOP{}
+	// The output is reused so the benchmark measures the worker's allocations
+	handler := &StaticWorker{Output: &sns.PublishInput{Message: aws.String("")}}
 
 	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
 		QueueURL:  workerQueueURL,
@@ -206,8 +249,8 @@ func TestError(t *testing.T) {
 
 	handler := &ErrorWorker{}
 
-	var callback = func(result *string, err error) {
-		if err == nil {
+	var callback = func(result sqsworker.Result) {
+		if result.Err == nil {
 			t.Error("Expected error")
 		}
 		done <- true
@@ -241,9 +284,9 @@ func TestProcessMessage(t *testing.T) {
 
 	handler := &HelloWorld{}
 
-	var callback = func(result *string, err error) {
-		if *result != "hello world" {
-			t.Error("Expected: ", "hello world", "Actual: ", result)
+	var callback = func(result sqsworker.Result) {
+		if *result.Output.Message != "hello world" {
+			t.Error("Expected: ", "hello world", "Actual: ", *result.Output.Message)
 		}
 		close(done)
 	}
@@ -314,3 +357,332 @@ func TestGetOrCreateTopic(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestMaxPerKey(t *testing.T) {
+	queue := GetMockeQueue()
+	done := make(chan bool)
+
+	handler := &BlockingWorker{Started: make(chan bool), Release: make(chan bool)}
+
+	var callback = func(result sqsworker.Result) {
+		if result.Err != nil {
+			t.Error(result.Err)
+		}
+		close(done)
+	}
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   2,
+		Logger:    zap.NewNop(),
+		Processor: handler,
+		Callback:  callback,
+		Name:      "TestApp",
+		KeyFunc:   sqsworker.AttributeKey("customer"),
+		MaxPerKey: 1,
+	})
+	w.Queue = queue
+
+	go func() {
+		queue.Push("first")
+		<-handler.Started
+		queue.Push("second")
+		input := <-queue.Visible
+		if *input.VisibilityTimeout != sqsworker.DefaultKeyDeferral {
+			t.Error("Actual: ", *input.VisibilityTimeout, "Expected: ", sqsworker.DefaultKeyDeferral)
+		}
+		handler.Release <- true
+		<-done
+		w.Close()
+	}()
+
+	w.Run()
+	queue.Close()
+}
+
+func TestKeyDeferral(t *testing.T) {
+	testKeyDeferral(t, 30, 30)
+	// messages over the cap are never redelivered at once
+	testKeyDeferral(t, 0, sqsworker.DefaultKeyDeferral)
+}
+
+func testKeyDeferral(t *testing.T, deferral, expected int64) {
+	queue := GetMockeQueue()
+	done := make(chan bool)
+
+	handler := &BlockingWorker{Started: make(chan bool), Release: make(chan bool)}
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:    workerQueueURL,
+		Workers:     2,
+		Logger:      zap.NewNop(),
+		Processor:   handler,
+		Callback:    func(result sqsworker.Result) { close(done) },
+		Name:        "TestApp",
+		KeyFunc:     sqsworker.AttributeKey("order_id"),
+		MaxPerKey:   1,
+		KeyDeferral: deferral,
+	})
+	w.Queue = queue
+
+	go func() {
+		queue.Push("first")
+		<-handler.Started
+		queue.Push("second")
+		input := <-queue.Visible
+		if *input.VisibilityTimeout != expected {
+			t.Error("Actual: ", *input.VisibilityTimeout, "Expected: ", expected)
+		}
+		handler.Release <- true
+		<-done
+		w.Close()
+	}()
+
+	w.Run()
+	queue.Close()
+	if deferred := w.Stats().Deferred; deferred != 1 {
+		t.Error("Actual: ", deferred, "Expected: ", 1)
+	}
+}
+
+type PriorityQueue struct {
+	sqsiface.SQSAPI
+	mu       sync.Mutex
+	Messages map[string][]string
+	// Waits are the WaitTimeSeconds of the receives that returned a message
+	Waits map[string][]int64
+}
+
+func (p *PriorityQueue) ReceiveMessageRequest(input *sqs.ReceiveMessageInput) (*request.Request, *sqs.ReceiveMessageOutput) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := &sqs.ReceiveMessageOutput{}
+	bodies := p.Messages[*input.QueueUrl]
+	if len(bodies) == 0 {
+		time.Sleep(time.Millisecond)
+		return newRequest(), out
+	}
+	p.Messages[*input.QueueUrl] = bodies[1:]
+	if p.Waits == nil {
+		p.Waits = map[string][]int64{}
+	}
+	p.Waits[*input.QueueUrl] = append(p.Waits[*input.QueueUrl], *input.WaitTimeSeconds)
+	out.Messages = []*sqs.Message{{Body: aws.String(bodies[0])}}
+	return newRequest(), out
+}
+
+func (p *PriorityQueue) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	return nil, nil
+}
+
+func (p *PriorityQueue) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+}
+
+type RecordingWorker struct {
+	Bodies chan string
+}
+
+func (r *RecordingWorker) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	r.Bodies <- *m.Body
+	return nil, nil
+}
+
+func testPriority(t *testing.T, starvationLimit int, expected []string) *PriorityQueue {
+	queue := &PriorityQueue{Messages: map[string][]string{
+		queueBase + "High": {"h1", "h2", "h3"},
+		queueBase + "Low":  {"l1"},
+	}}
+	handler := &RecordingWorker{Bodies: make(chan string, len(expected))}
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURLs:       []string{queueBase + "High", queueBase + "Low"},
+		StarvationLimit: starvationLimit,
+		Workers:         1,
+		Logger:          zap.NewNop(),
+		Processor:       handler,
+		Name:            "TestApp",
+	})
+	w.Queue = queue
+
+	go func() {
+		for i, body := range expected {
+			actual := <-handler.Bodies
+			if actual != body {
+				t.Error("Message ", i, "Actual: ", actual, "Expected: ", body)
+			}
+		}
+		w.Close()
+	}()
+
+	w.Run()
+	return queue
+}
+
+func TestPriorityQueues(t *testing.T) {
+	queue := testPriority(t, 0, []string{"h1", "h2", "h3", "l1"})
+	if actual := queue.Waits[queueBase+"Low"]; len(actual) != 1 || actual[0] != sqsworker.DefaultWaitTimeSeconds {
+		t.Error("Actual: ", actual, "Expected: ", []int64{sqsworker.DefaultWaitTimeSeconds})
+	}
+}
+
+func TestPriorityStarvation(t *testing.T) {
+	// The starved queue is polled without waiting
+	queue := testPriority(t, 2, []string{"h1", "h2", "l1", "h3"})
+	if actual := queue.Waits[queueBase+"Low"]; len(actual) != 1 || actual[0] != 0 {
+		t.Error("Actual: ", actual, "Expected: ", []int64{0})
+	}
+}
+
+type CountingQueue struct {
+	sqsiface.SQSAPI
+	Receives int32
+	mu       sync.Mutex
+	Input    *sqs.ReceiveMessageInput
+}
+
+func (c *CountingQueue) ReceiveMessageRequest(input *sqs.ReceiveMessageInput) (*request.Request, *sqs.ReceiveMessageOutput) {
+	atomic.AddInt32(&c.Receives, 1)
+	c.mu.Lock()
+	c.Input = input
+	c.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	return newRequest(), &sqs.ReceiveMessageOutput{}
+}
+
+func TestReceiveMessageAttributes(t *testing.T) {
+	queue := &CountingQueue{}
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   1,
+		Logger:    zap.NewNop(),
+		Processor: &NoOP{},
+		Name:      "TestApp",
+	})
+	w.Queue = queue
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		w.Close()
+	}()
+
+	w.Run()
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	if actual := aws.StringValueSlice(queue.Input.MessageAttributeNames); len(actual) != 1 || actual[0] != "All" {
+		t.Error("Actual: ", actual, "Expected: ", []string{"All"})
+	}
+	expected := []string{"SentTimestamp", "MessageGroupId", "ApproximateReceiveCount", "AWSTraceHeader"}
+	if actual := aws.StringValueSlice(queue.Input.AttributeNames); !reflect.DeepEqual(actual, expected) {
+		t.Error("Actual: ", actual, "Expected: ", expected)
+	}
+}
+
+func TestBackpressureMemory(t *testing.T) {
+	queue := &CountingQueue{}
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:     workerQueueURL,
+		Workers:      1,
+		Logger:       zap.NewNop(),
+		Processor:    &NoOP{},
+		Name:         "TestApp",
+		Backpressure: &sqsworker.Backpressure{MaxMemory: 1, Pause: time.Millisecond},
+	})
+	w.Queue = queue
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		w.Close()
+	}()
+
+	w.Run()
+	if receives := atomic.LoadInt32(&queue.Receives); receives != 0 {
+		t.Error("Actual: ", receives, "Expected: ", 0)
+	}
+}
+
+// SlowQueue returns a single message, then counts the empty receives
+type SlowQueue struct {
+	CountingQueue
+	sent int32
+}
+
+func (s *SlowQueue) ReceiveMessageRequest(input *sqs.ReceiveMessageInput) (*request.Request, *sqs.ReceiveMessageOutput) {
+	if atomic.CompareAndSwapInt32(&s.sent, 0, 1) {
+		return newRequest(), &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{{Body: aws.String("slow")}}}
+	}
+	return s.CountingQueue.ReceiveMessageRequest(input)
+}
+
+func (s *SlowQueue) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func TestBackpressureLatency(t *testing.T) {
+	queue := &SlowQueue{}
+	started, finished := make(chan struct{}), make(chan struct{})
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL: workerQueueURL,
+		Workers:  1,
+		Logger:   zap.NewNop(),
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+			close(finished)
+			return nil, nil
+		}),
+		Name:         "TestApp",
+		Backpressure: &sqsworker.Backpressure{MaxLatency: 10 * time.Millisecond, Pause: time.Millisecond},
+	})
+	w.Queue = queue
+
+	go func() {
+		defer w.Close()
+		<-started
+		time.Sleep(20 * time.Millisecond)
+		paused := atomic.LoadInt32(&queue.Receives)
+		time.Sleep(20 * time.Millisecond)
+		if receives := atomic.LoadInt32(&queue.Receives); receives != paused {
+			t.Error("polled while the handler was slow: ", receives-paused, " receives")
+		}
+
+		<-finished
+		resumed := atomic.LoadInt32(&queue.Receives)
+		time.Sleep(20 * time.Millisecond)
+		if receives := atomic.LoadInt32(&queue.Receives); receives == resumed {
+			t.Error("polling did not resume after the slow handler finished")
+		}
+	}()
+
+	w.Run()
+}
+
+func TestCallbackResult(t *testing.T) {
+	var result sqsworker.Result
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:  "arn:aws:sns:us-east-1:88888888888:Out",
+		Processor: &HelloWorld{},
+		Callback:  func(r sqsworker.Result) { result = r },
+	})
+	m := workertest.NewMessage("hello")
+	h.Run(m).Succeeded().Deleted()
+
+	if result.Message != m || !result.Deleted || result.Err != nil {
+		t.Error("unexpected result: ", result)
+	}
+	if actual := aws.StringValue(result.Output.Message); actual != "hello world" {
+		t.Error("Actual: ", actual, "Expected: ", "hello world")
+	}
+	if result.Publish == nil || aws.StringValue(result.Publish.MessageId) != "published-1" {
+		t.Error("unexpected publish output: ", result.Publish)
+	}
+
+	h.Worker.Processor = &ErrorWorker{}
+	h.Run(workertest.NewMessage("hello")).Failed().Retried()
+	if result.Deleted || result.Publish != nil || result.Err == nil {
+		t.Error("unexpected result: ", result)
+	}
+}
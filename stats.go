@@ -0,0 +1,341 @@
+package sqsworker
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// QueueDepth approximate number of messages in a queue, from GetQueueAttributes
+type QueueDepth struct {
+	Visible    int64
+	NotVisible int64
+	Delayed    int64
+	Updated    time.Time
+}
+
+// Stats snapshot of a Worker's counters and gauges
+type Stats struct {
+	Received  int64
+	Processed int64
+	Failed    int64
+	// Failures are the Failed messages by the class of their error
+	Failures FailureCounts
+	// Skipped counts the messages acknowledged with ErrSkip
+	Skipped int64
+	// Filtered counts the messages that did not match the Filter
+	Filtered int64
+	// Expired counts the messages older than the MessageTTL
+	Expired int64
+	// Deduplicated counts the duplicates dropped within the DedupWindow
+	Deduplicated int64
+	// Deferred counts the messages returned to the queue because their key was over MaxPerKey
+	Deferred int64
+	// Redelivered counts the messages received with an ApproximateReceiveCount above one, and
+	// RepeatedIDs those whose MessageId was already received within the DuplicateWindow
+	Redelivered   int64
+	RepeatedIDs   int64
+	ReceiveErrors int64
+	// PublishErrors counts the results that could not be published after every retry
+	PublishErrors int64
+	// DeleteErrors counts the messages that could not be deleted after every retry
+	DeleteErrors int64
+	// MirrorErrors counts the results that could not be sent to the Mirror
+	MirrorErrors int64
+	// SendChecksumMismatches counts the messages sent to a queue whose checksums SQS returned
+	// did not match, with VerifyMD5. They were stored, and are not sent again.
+	SendChecksumMismatches int64
+	// DroppedEvents counts the events not sent to a subscriber of Events whose buffer was full
+	DroppedEvents int64
+	// Dropped, DeadLettered and Quarantined count the failed messages deleted or sent to the
+	// dead-letter or quarantine queue
+	Dropped      int64
+	DeadLettered int64
+	Quarantined  int64
+	// InFlight number of messages received and not yet processed
+	InFlight int64
+	// EndToEndLatency from when a message was sent, using its SentTimestamp, until processing completed
+	EndToEndLatency Histogram
+	// QueueDepth by queue url, only set when the worker is configured with a QueueDepthInterval
+	QueueDepth map[string]QueueDepth
+	// API counts the requests made to SQS and SNS
+	API APIUsage
+	// Polling of the worker, only set when it is configured with a PollingGovernor
+	Polling *PollingStats
+	// Handlers by name, only set when the worker is configured with a HandlerName
+	Handlers map[string]HandlerStats
+	// Queues by url, only set for the queues found by a Discovery
+	Queues map[string]QueueStats
+	// Consumers running, ordered by ID
+	Consumers []ConsumerStats
+}
+
+// ConsumerStats counters of a single consumer goroutine
+type ConsumerStats struct {
+	// ID is stable for the life of the consumer, and included in its log entries
+	ID      int
+	Handled int64
+	// LastActive is when the consumer last finished a message, zero if it has not
+	LastActive time.Time
+}
+
+// HandlerStats counters and latencies of the messages given the same name by the HandlerName
+type HandlerStats struct {
+	Processed int64
+	Failed    int64
+	// Latency of the Processor
+	Latency         Histogram
+	EndToEndLatency Histogram
+}
+
+// QueueStats counters and latencies of the messages of a discovered queue
+type QueueStats struct {
+	HandlerStats
+	// Paused by PauseQueue
+	Paused bool
+}
+
+// handlerStats holds the live counters of a handler
+type handlerStats struct {
+	processed int64
+	failed    int64
+	latency   *histogram
+	endToEnd  *histogram
+}
+
+// consumerStats holds the live counters of a consumer
+type consumerStats struct {
+	handled    int64
+	lastActive int64
+}
+
+// stats holds the live counters of a Worker
+type stats struct {
+	received      int64
+	processed     int64
+	failed        int64
+	failures      [PanicFailure + 1]int64
+	skipped       int64
+	filtered      int64
+	expired       int64
+	deduplicated  int64
+	deferred      int64
+	redelivered   int64
+	repeatedIDs   int64
+	receiveErrors int64
+	publishErrors int64
+	deleteErrors  int64
+	mirrorErrors  int64
+	// sendMismatches counts the sent messages whose checksums did not match
+	sendMismatches int64
+	droppedEvents  int64
+	dropped        int64
+	deadLettered   int64
+	quarantined    int64
+	inFlight       int64
+	idle           int32
+	endToEnd       *histogram
+	api            apiUsage
+	mu             sync.Mutex
+	depth          map[string]QueueDepth
+	consumers      map[int]*consumerStats
+	handlers       map[string]*handlerStats
+}
+
+// Stats returns a snapshot of the worker's counters and gauges
+func (w *Worker) Stats() Stats {
+	s := Stats{
+		Received:               atomic.LoadInt64(&w.stats.received),
+		Processed:              atomic.LoadInt64(&w.stats.processed),
+		Failed:                 atomic.LoadInt64(&w.stats.failed),
+		Failures:               w.stats.failureCounts(),
+		Skipped:                atomic.LoadInt64(&w.stats.skipped),
+		Filtered:               atomic.LoadInt64(&w.stats.filtered),
+		Expired:                atomic.LoadInt64(&w.stats.expired),
+		Deduplicated:           atomic.LoadInt64(&w.stats.deduplicated),
+		Deferred:               atomic.LoadInt64(&w.stats.deferred),
+		Redelivered:            atomic.LoadInt64(&w.stats.redelivered),
+		RepeatedIDs:            atomic.LoadInt64(&w.stats.repeatedIDs),
+		ReceiveErrors:          atomic.LoadInt64(&w.stats.receiveErrors),
+		PublishErrors:          atomic.LoadInt64(&w.stats.publishErrors),
+		DeleteErrors:           atomic.LoadInt64(&w.stats.deleteErrors),
+		MirrorErrors:           atomic.LoadInt64(&w.stats.mirrorErrors),
+		SendChecksumMismatches: atomic.LoadInt64(&w.stats.sendMismatches),
+		DroppedEvents:          atomic.LoadInt64(&w.stats.droppedEvents),
+		Dropped:                atomic.LoadInt64(&w.stats.dropped),
+		DeadLettered:           atomic.LoadInt64(&w.stats.deadLettered),
+		Quarantined:            atomic.LoadInt64(&w.stats.quarantined),
+		InFlight:               atomic.LoadInt64(&w.stats.inFlight),
+		EndToEndLatency:        w.stats.endToEnd.snapshot(),
+		API:                    w.stats.api.snapshot(),
+	}
+	if w.governor != nil {
+		polling := w.governor.stats()
+		s.Polling = &polling
+	}
+	if w.Discovery != nil {
+		s.Queues = w.queueStats()
+	}
+
+	w.stats.mu.Lock()
+	defer w.stats.mu.Unlock()
+	if w.stats.depth != nil {
+		s.QueueDepth = make(map[string]QueueDepth, len(w.stats.depth))
+		for queueURL, depth := range w.stats.depth {
+			s.QueueDepth[queueURL] = depth
+		}
+	}
+	if w.stats.handlers != nil {
+		s.Handlers = make(map[string]HandlerStats, len(w.stats.handlers))
+		for name, h := range w.stats.handlers {
+			s.Handlers[name] = h.snapshot()
+		}
+	}
+	for id, c := range w.stats.consumers {
+		consumer := ConsumerStats{ID: id, Handled: atomic.LoadInt64(&c.handled)}
+		if lastActive := atomic.LoadInt64(&c.lastActive); lastActive != 0 {
+			consumer.LastActive = time.Unix(0, lastActive)
+		}
+		s.Consumers = append(s.Consumers, consumer)
+	}
+	sort.Slice(s.Consumers, func(i, j int) bool { return s.Consumers[i].ID < s.Consumers[j].ID })
+	return s
+}
+
+func (s *stats) addConsumer(id int) *consumerStats {
+	c := &consumerStats{}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.consumers == nil {
+		s.consumers = make(map[int]*consumerStats)
+	}
+	s.consumers[id] = c
+	return c
+}
+
+func (s *stats) removeConsumer(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.consumers, id)
+}
+
+func newStats() *stats {
+	return &stats{endToEnd: newHistogram(LatencyBuckets)}
+}
+
+// handler returns the live counters of the named handler, adding them on its first message
+func (s *stats) handler(name string) *handlerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handlers == nil {
+		s.handlers = make(map[string]*handlerStats)
+	}
+	h, ok := s.handlers[name]
+	if !ok {
+		h = newHandlerStats()
+		s.handlers[name] = h
+	}
+	return h
+}
+
+func newHandlerStats() *handlerStats {
+	return &handlerStats{latency: newHistogram(LatencyBuckets), endToEnd: newHistogram(LatencyBuckets)}
+}
+
+func (h *handlerStats) snapshot() HandlerStats {
+	return HandlerStats{
+		Processed:       atomic.LoadInt64(&h.processed),
+		Failed:          atomic.LoadInt64(&h.failed),
+		Latency:         h.latency.snapshot(),
+		EndToEndLatency: h.endToEnd.snapshot(),
+	}
+}
+
+// observeHandler counts a message processed by the named handler and records its latencies
+func (w *Worker) observeHandler(name string, m *sqs.Message, err error, duration time.Duration) {
+	w.stats.handler(name).observe(m, err, duration)
+}
+
+// observe counts a processed message and records its latencies
+func (h *handlerStats) observe(m *sqs.Message, err error, duration time.Duration) {
+	if err != nil {
+		atomic.AddInt64(&h.failed, 1)
+	} else {
+		atomic.AddInt64(&h.processed, 1)
+	}
+	if duration > 0 {
+		h.latency.observe(duration)
+	}
+	if sent := attributeInt(m.Attributes, sqs.MessageSystemAttributeNameSentTimestamp); sent != 0 {
+		h.endToEnd.observe(time.Since(time.Unix(0, sent*int64(time.Millisecond))))
+	}
+}
+
+// observeEndToEnd records the time since the message was sent
+func (w *Worker) observeEndToEnd(m *sqs.Message) {
+	sent := attributeInt(m.Attributes, sqs.MessageSystemAttributeNameSentTimestamp)
+	if sent == 0 {
+		return
+	}
+	w.stats.endToEnd.observe(time.Since(time.Unix(0, sent*int64(time.Millisecond))))
+}
+
+func attributeInt(attributes map[string]*string, name string) int64 {
+	value, ok := attributes[name]
+	if !ok || value == nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(*value, 10, 64)
+	return n
+}
+
+// updateQueueDepth fetches the approximate message counts of every input queue
+func (w *Worker) updateQueueDepth() {
+	for _, queueURL := range w.Queues() {
+		atomic.AddInt64(&w.stats.api.queueAttributes, 1)
+		out, err := w.Queue.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+			QueueUrl: aws.String(queueURL),
+			AttributeNames: aws.StringSlice([]string{
+				sqs.QueueAttributeNameApproximateNumberOfMessages,
+				sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+				sqs.QueueAttributeNameApproximateNumberOfMessagesDelayed,
+			}),
+		})
+		if err != nil {
+			w.logError("get queue attributes failed!", err)
+			continue
+		}
+
+		depth := QueueDepth{
+			Visible:    attributeInt(out.Attributes, sqs.QueueAttributeNameApproximateNumberOfMessages),
+			NotVisible: attributeInt(out.Attributes, sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible),
+			Delayed:    attributeInt(out.Attributes, sqs.QueueAttributeNameApproximateNumberOfMessagesDelayed),
+			Updated:    time.Now(),
+		}
+		w.stats.mu.Lock()
+		if w.stats.depth == nil {
+			w.stats.depth = make(map[string]QueueDepth)
+		}
+		w.stats.depth[queueURL] = depth
+		w.stats.mu.Unlock()
+	}
+}
+
+// queueDepth polls the queue depth every QueueDepthInterval until the context is done
+func (w *Worker) queueDepth(ctx context.Context) {
+	ticker := time.NewTicker(w.QueueDepthInterval)
+	defer ticker.Stop()
+	for {
+		w.updateQueueDepth()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
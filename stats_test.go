@@ -0,0 +1,182 @@
+package sqsworker_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+type DepthQueue struct {
+	*MockQueue
+}
+
+func (d *DepthQueue) GetQueueAttributes(input *sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error) {
+	return &sqs.GetQueueAttributesOutput{Attributes: map[string]*string{
+		sqs.QueueAttributeNameApproximateNumberOfMessages:           aws.String("12"),
+		sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible: aws.String("3"),
+		sqs.QueueAttributeNameApproximateNumberOfMessagesDelayed:    aws.String("1"),
+	}}, nil
+}
+
+func TestStats(t *testing.T) {
+	queue := GetMockeQueue()
+	done := make(chan bool)
+
+	var callback = func(result sqsworker.Result) {
+		done <- true
+	}
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:           workerQueueURL,
+		Workers:            1,
+		Logger:             zap.NewNop(),
+		Processor:          &ErrorWorker{},
+		Callback:           callback,
+		Name:               "TestApp",
+		QueueDepthInterval: time.Millisecond,
+	})
+	w.Queue = &DepthQueue{queue}
+
+	go func() {
+		queue.Push("hello")
+		<-done
+		for len(w.Stats().QueueDepth) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		w.Close()
+	}()
+
+	w.Run()
+	queue.Close()
+
+	stats := w.Stats()
+	if stats.Received != 1 || stats.Failed != 1 || stats.Processed != 0 {
+		t.Error("unexpected stats: ", stats)
+	}
+	depth := stats.QueueDepth[workerQueueURL]
+	if depth.Visible != 12 || depth.NotVisible != 3 || depth.Delayed != 1 {
+		t.Error("unexpected queue depth: ", depth)
+	}
+}
+
+func TestEndToEndLatency(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{Processor: &NoOP{}})
+	sent := time.Now().Add(-2*time.Second).UnixNano() / int64(time.Millisecond)
+	h.Run(workertest.NewMessage("hello", workertest.SystemAttribute(sqs.MessageSystemAttributeNameSentTimestamp, fmt.Sprint(sent))))
+	h.Run(workertest.NewMessage("no timestamp"))
+
+	latency := h.Worker.Stats().EndToEndLatency
+	if latency.Count != 1 {
+		t.Fatal("Actual: ", latency.Count, "Expected: ", 1)
+	}
+	if q := latency.Quantile(0.99); q != 2500*time.Millisecond {
+		t.Error("Actual: ", q, "Expected: ", 2500*time.Millisecond)
+	}
+	if mean := latency.Mean(); mean < 2*time.Second {
+		t.Error("Actual: ", mean, "Expected at least: ", 2*time.Second)
+	}
+}
+
+func TestHandlerStats(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			if *m.Body == "fail" {
+				return nil, errors.New("failed")
+			}
+			return nil, nil
+		}),
+		HandlerName: func(m *sqs.Message) string {
+			return aws.StringValue(m.MessageAttributes["event"].StringValue)
+		},
+	})
+	sent := time.Now().Add(-2*time.Second).UnixNano() / int64(time.Millisecond)
+	h.Run(workertest.NewMessage("hello", workertest.Attribute("event", "order"), workertest.SystemAttribute(sqs.MessageSystemAttributeNameSentTimestamp, fmt.Sprint(sent))))
+	h.Run(workertest.NewMessage("fail", workertest.Attribute("event", "order")))
+	h.Run(workertest.NewMessage("hello", workertest.Attribute("event", "refund")))
+
+	handlers := h.Worker.Stats().Handlers
+	order, refund := handlers["order"], handlers["refund"]
+	if len(handlers) != 2 || order.Processed != 1 || order.Failed != 1 || refund.Processed != 1 || refund.Failed != 0 {
+		t.Fatal("unexpected handler stats: ", handlers)
+	}
+	if order.Latency.Count != 2 || order.EndToEndLatency.Count != 1 || refund.EndToEndLatency.Count != 0 {
+		t.Error("unexpected handler latencies: ", handlers)
+	}
+
+	// Without a HandlerName the stats are not broken down
+	h = workertest.New(t, sqsworker.WorkerConfig{Processor: &NoOP{}})
+	h.Run(workertest.NewMessage("hello"))
+	if handlers := h.Worker.Stats().Handlers; handlers != nil {
+		t.Error("Expected no handler stats, got: ", handlers)
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	h := sqsworker.Histogram{
+		Bounds: []time.Duration{time.Millisecond, time.Second},
+		Counts: []int64{90, 9, 1},
+		Count:  100,
+	}
+	if q := h.Quantile(0.5); q != time.Millisecond {
+		t.Error("Actual: ", q, "Expected: ", time.Millisecond)
+	}
+	if q := h.Quantile(0.95); q != time.Second {
+		t.Error("Actual: ", q, "Expected: ", time.Second)
+	}
+	if q := h.Quantile(0.999); q != time.Second {
+		t.Error("Actual: ", q, "Expected: ", time.Second)
+	}
+}
+
+func TestConsumerStats(t *testing.T) {
+	queue := GetMockeQueue()
+	handler := &RecordingWorker{Bodies: make(chan string, 10)}
+
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:  workerQueueURL,
+		Workers:   2,
+		Logger:    zap.NewNop(),
+		Processor: handler,
+		Name:      "TestApp",
+	})
+	w.Queue = &PollQueue{queue}
+	go w.Run()
+	defer w.Close()
+
+	queue.Push("hello")
+	<-handler.Bodies
+
+	deadline := time.After(time.Second)
+	for {
+		stats := w.Stats()
+		if len(stats.Consumers) == 2 {
+			var handled int64
+			for i, c := range stats.Consumers {
+				if c.ID != i {
+					t.Error("Actual: ", c.ID, "Expected: ", i)
+				}
+				if c.Handled > 0 && c.LastActive.IsZero() {
+					t.Error("Expected last activity to be set")
+				}
+				handled += c.Handled
+			}
+			if handled == 1 {
+				return
+			}
+		}
+
+		select {
+		case <-deadline:
+			t.Fatal("unexpected consumer stats: ", stats.Consumers)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
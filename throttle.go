@@ -0,0 +1,113 @@
+package sqsworker
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the rate at which messages are passed to the Processor. Implementations
+// backed by Redis or DynamoDB coordinate the rate across every instance of a worker, rather
+// than per process like a Throttle.
+type RateLimiter interface {
+	// Wait blocks until n messages may proceed, or returns an error, such as the context's
+	// error when it is done first, failing the messages
+	Wait(ctx context.Context, n int) error
+}
+
+// RateLimiterFunc adapts a function to a RateLimiter
+type RateLimiterFunc func(ctx context.Context, n int) error
+
+// Wait calls f
+func (f RateLimiterFunc) Wait(ctx context.Context, n int) error {
+	return f(ctx, n)
+}
+
+// Throttle limits the rate at which messages are passed to the Processor across every consumer
+// of a worker, e.g. to stay under the contractual rate of a partner API whatever the number of
+// consumers. Messages wait for their turn holding their consumer, so the VisibilityTimeout must
+// cover the wait as well as the handler.
+type Throttle struct {
+	// Rate is the steady number of messages per second, a Throttle without a Rate is ignored
+	Rate float64
+	// Burst is the most messages passed at once after an idle period, by default one
+	Burst int
+}
+
+// tokenBucket is a token bucket refilled at rate tokens per second up to burst tokens. Waiters
+// reserve their tokens in order, so the bucket goes negative while they wait.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// refill adds the tokens accrued since the last call, the caller holds the lock
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// reserve takes n tokens, returning how long to wait for them
+func (b *tokenBucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	if b.rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel returns n reserved tokens
+func (b *tokenBucket) cancel(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += float64(n)
+}
+
+// Wait blocks until n tokens are available, or the context is done
+func (b *tokenBucket) Wait(ctx context.Context, n int) error {
+	delay := b.reserve(n)
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.cancel(n)
+		return ctx.Err()
+	}
+}
+
+// setRate changes the steady rate, keeping the tokens accrued at the previous rate
+func (b *tokenBucket) setRate(rate float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.rate = rate
+}
+
+// currentRate returns the steady rate
+func (b *tokenBucket) currentRate() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rate
+}
@@ -0,0 +1,71 @@
+package sqsworker_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: &NoOP{},
+		Throttle:  &sqsworker.Throttle{Rate: 50, Burst: 5},
+	})
+
+	messages := make([]*sqs.Message, 15)
+	for i := range messages {
+		messages[i] = workertest.NewMessage("throttled")
+	}
+	start := time.Now()
+	for _, err := range handleAll(h.Worker, 0, messages...) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the burst passes at once, then the other 10 at 50 per second
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond || elapsed > time.Second {
+		t.Error("Expected the messages to be throttled to 50 per second, took ", elapsed)
+	}
+
+	h.Worker.Apply(sqsworker.Settings{Rate: 1000})
+	if rate := h.Worker.Settings().Rate; rate != 1000 {
+		t.Error("Actual: ", rate, "Expected: ", 1000)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	var waits int32
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		Processor: &NoOP{},
+		Throttle:  &sqsworker.Throttle{Rate: 1},
+		RateLimiter: sqsworker.RateLimiterFunc(func(ctx context.Context, n int) error {
+			if atomic.AddInt32(&waits, int32(n)) > 2 {
+				return errors.New("over the limit")
+			}
+			return nil
+		}),
+	})
+
+	errs := handleAll(h.Worker, 0, workertest.NewMessage("a"), workertest.NewMessage("b"), workertest.NewMessage("c"))
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Error("Actual: ", failed, "Expected: ", 1)
+	}
+	if waits != 3 {
+		t.Error("Actual: ", waits, "Expected: ", 3)
+	}
+	// the Throttle is replaced, so its rate can't be reloaded
+	if rate := h.Worker.Settings().Rate; rate != 0 {
+		t.Error("Actual: ", rate, "Expected: ", 0)
+	}
+}
@@ -0,0 +1,119 @@
+package sqsworker
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// MaxVisibilityTimeout is the longest visibility timeout in seconds SQS accepts
+const MaxVisibilityTimeout = 43200
+
+// Defaults of VisibilityTuning
+const (
+	DefaultVisibilityFactor   = 3
+	DefaultMinVisibility      = 10
+	DefaultVisibilityInterval = time.Minute
+	DefaultVisibilitySamples  = 100
+)
+
+// VisibilityTuning sets the VisibilityTimeout of receives to the 99th percentile of the handler
+// durations observed over each Interval, multiplied by Factor and bounded by Min and Max,
+// rather than a static value. Intervals with fewer than Samples handled messages leave the
+// timeout as it is. Tuning replaces the VisibilityTimeout set by Apply.
+type VisibilityTuning struct {
+	// Factor defaults to DefaultVisibilityFactor
+	Factor float64
+	// Min and Max in seconds default to DefaultMinVisibility and MaxVisibilityTimeout. Min
+	// should leave room for the time received messages wait for a free consumer.
+	Min int64
+	Max int64
+	// Interval defaults to DefaultVisibilityInterval, and Samples to DefaultVisibilitySamples
+	Interval time.Duration
+	Samples  int64
+}
+
+// visibilityTuner records the handler durations used to tune the visibility timeout
+type visibilityTuner struct {
+	config    VisibilityTuning
+	durations *histogram
+	// last snapshot of the durations, the next tuning only uses those observed since
+	last Histogram
+}
+
+func newVisibilityTuner(config VisibilityTuning) *visibilityTuner {
+	if config.Factor <= 0 {
+		config.Factor = DefaultVisibilityFactor
+	}
+	if config.Min <= 0 {
+		config.Min = DefaultMinVisibility
+	}
+	if config.Max <= 0 || config.Max > MaxVisibilityTimeout {
+		config.Max = MaxVisibilityTimeout
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultVisibilityInterval
+	}
+	if config.Samples <= 0 {
+		config.Samples = DefaultVisibilitySamples
+	}
+	durations := newHistogram(LatencyBuckets)
+	return &visibilityTuner{config: config, durations: durations, last: durations.snapshot()}
+}
+
+// timeout returns the visibility timeout for the durations observed since the last call, and
+// whether there were enough of them
+func (t *visibilityTuner) timeout() (int64, bool) {
+	current := t.durations.snapshot()
+	interval := Histogram{Bounds: current.Bounds, Counts: make([]int64, len(current.Counts)), Count: current.Count - t.last.Count}
+	for i := range current.Counts {
+		interval.Counts[i] = current.Counts[i] - t.last.Counts[i]
+	}
+	if interval.Count < t.config.Samples {
+		return 0, false
+	}
+	t.last = current
+
+	timeout := int64(math.Ceil(interval.Quantile(0.99).Seconds() * t.config.Factor))
+	if timeout < t.config.Min {
+		timeout = t.config.Min
+	}
+	if timeout > t.config.Max {
+		timeout = t.config.Max
+	}
+	return timeout, true
+}
+
+// tuneVisibility sets the visibility timeout every tuning Interval until the context is done
+func (w *Worker) tuneVisibility(ctx context.Context) {
+	ticker := time.NewTicker(w.tuner.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		timeout, ok := w.tuner.timeout()
+		if ok && atomic.SwapInt64(&w.settings.visibilityTimeout, timeout) != timeout {
+			w.logInfo(fmt.Sprint("Tuned visibility_timeout=", timeout))
+		}
+	}
+}
+
+// receiveVisibility is the visibility timeout requested by a receive, the VisibilityTimeout
+// plus a random share of the VisibilityJitter. Spreading it keeps the messages of a batch that
+// failed together, e.g. during a downstream outage, from all becoming visible at once.
+func (w *Worker) receiveVisibility() int64 {
+	visibility := atomic.LoadInt64(&w.settings.visibilityTimeout)
+	if w.VisibilityJitter > 0 {
+		visibility += rand.Int63n(w.VisibilityJitter + 1)
+	}
+	if visibility > MaxVisibilityTimeout {
+		visibility = MaxVisibilityTimeout
+	}
+	return visibility
+}
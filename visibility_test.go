@@ -0,0 +1,117 @@
+package sqsworker_test
+
+import (
+	"context"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+	"sync"
+	"testing"
+	"time"
+)
+
+// VisibilityQueue records the visibility timeout of every receive
+type VisibilityQueue struct {
+	CountingQueue
+	mu         sync.Mutex
+	Visibility []int64
+}
+
+func (v *VisibilityQueue) ReceiveMessageRequest(input *sqs.ReceiveMessageInput) (*request.Request, *sqs.ReceiveMessageOutput) {
+	v.mu.Lock()
+	v.Visibility = append(v.Visibility, *input.VisibilityTimeout)
+	v.mu.Unlock()
+	return v.CountingQueue.ReceiveMessageRequest(input)
+}
+
+func TestVisibilityJitter(t *testing.T) {
+	queue := &VisibilityQueue{}
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL:          workerQueueURL,
+		Workers:           1,
+		Logger:            zap.NewNop(),
+		Processor:         &NoOP{},
+		VisibilityTimeout: 30,
+		VisibilityJitter:  30,
+	})
+	w.Queue = queue
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		w.Close()
+	}()
+	w.Run()
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	distinct := make(map[int64]bool)
+	for _, visibility := range queue.Visibility {
+		if visibility < 30 || visibility > 60 {
+			t.Fatal("Expected the visibility timeout to be within the jitter, got ", visibility)
+		}
+		distinct[visibility] = true
+	}
+	if len(queue.Visibility) < 10 || len(distinct) < 2 {
+		t.Error("Expected the visibility timeout to vary between receives, got ", queue.Visibility)
+	}
+}
+
+// TunedQueue returns a message on every receive, recording the visibility timeouts
+type TunedQueue struct {
+	workertest.Queue
+	mu         sync.Mutex
+	Visibility []int64
+}
+
+func (q *TunedQueue) ReceiveMessageRequest(input *sqs.ReceiveMessageInput) (*request.Request, *sqs.ReceiveMessageOutput) {
+	q.mu.Lock()
+	q.Visibility = append(q.Visibility, *input.VisibilityTimeout)
+	q.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	return newRequest(), &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{workertest.NewMessage("slow")}}
+}
+
+func (q *TunedQueue) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+}
+
+func TestVisibilityTuning(t *testing.T) {
+	queue := &TunedQueue{}
+	w := sqsworker.NewWorker(sess, sqsworker.WorkerConfig{
+		QueueURL: workerQueueURL,
+		Workers:  4,
+		Logger:   zap.NewNop(),
+		Processor: sqsworker.ProcessorFunc(func(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+			time.Sleep(15 * time.Millisecond)
+			return nil, nil
+		}),
+		VisibilityTimeout: 600,
+		VisibilityTuning: &sqsworker.VisibilityTuning{
+			Factor:   200,
+			Min:      1,
+			Interval: 50 * time.Millisecond,
+			Samples:  5,
+		},
+	})
+	w.Queue = queue
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		w.Close()
+	}()
+	w.Run()
+
+	// the 99th percentile of 15ms handlers is in the 25ms bucket, or a later one on a busy machine
+	visibility := w.Settings().VisibilityTimeout
+	if visibility < 5 || visibility > 20 {
+		t.Error("Expected the visibility timeout to be tuned to about 5 seconds, got ", visibility)
+	}
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	if queue.Visibility[0] != 600 || queue.Visibility[len(queue.Visibility)-1] != visibility {
+		t.Error("Expected the receives to use the tuned visibility timeout, got ", queue.Visibility)
+	}
+}
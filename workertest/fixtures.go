@@ -0,0 +1,138 @@
+package workertest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Update rewrites golden files during Replay instead of comparing against them, set with
+// go test -workertest.update
+var Update = flag.Bool("workertest.update", false, "update workertest golden files")
+
+// FixtureExt is the file extension of message fixtures
+const FixtureExt = ".json"
+
+// GoldenExt is the file extension of golden files
+const GoldenExt = ".golden"
+
+// recorder is a Processor that dumps every message it receives to a fixture file
+type recorder struct {
+	dir       string
+	processor sqsworker.Processor
+}
+
+// Record wraps a Processor so every received message is written to a fixture file in dir,
+// named after the message id, before being processed. Use it to capture real traffic for Replay.
+func Record(dir string, p sqsworker.Processor) sqsworker.Processor {
+	return &recorder{dir, p}
+}
+
+func (r *recorder) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	if err := WriteFixture(filepath.Join(r.dir, aws.StringValue(m.MessageId)+FixtureExt), m); err != nil {
+		return nil, err
+	}
+	return r.processor.Process(ctx, m)
+}
+
+// WriteFixture writes a message to a JSON fixture file
+func WriteFixture(path string, m *sqs.Message) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// LoadFixture reads a message from a JSON fixture file
+func LoadFixture(path string) (*sqs.Message, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &sqs.Message{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.Attributes == nil {
+		m.Attributes = map[string]*string{}
+	}
+	if m.MessageAttributes == nil {
+		m.MessageAttributes = map[string]*sqs.MessageAttributeValue{}
+	}
+	return m, nil
+}
+
+// golden is the recorded outcome of replaying a fixture
+type golden struct {
+	Error     string   `json:"error,omitempty"`
+	Deleted   bool     `json:"deleted"`
+	Published []string `json:"published"`
+}
+
+// Replay runs every fixture in dir through the Harness and compares the outcome (error,
+// deletion and published messages) to the golden file with the same name in goldenDir.
+// Golden files are written instead when the -workertest.update flag is set.
+func (h *Harness) Replay(dir, goldenDir string) {
+	h.T.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+FixtureExt))
+	if err != nil {
+		h.T.Fatal(err)
+	}
+	if len(paths) == 0 {
+		h.T.Fatalf("no fixtures found in %s", dir)
+	}
+
+	for _, path := range paths {
+		m, err := LoadFixture(path)
+		if err != nil {
+			h.T.Fatal(err)
+		}
+
+		result := h.Run(m)
+		actual := golden{Deleted: result.deleted, Published: []string{}}
+		if result.Err != nil {
+			actual.Error = result.Err.Error()
+		}
+		for _, p := range result.Publishes {
+			actual.Published = append(actual.Published, aws.StringValue(p.Message))
+		}
+		data, err := json.MarshalIndent(actual, "", "  ")
+		if err != nil {
+			h.T.Fatal(err)
+		}
+		data = append(data, '\n')
+
+		name := strings.TrimSuffix(filepath.Base(path), FixtureExt)
+		goldenPath := filepath.Join(goldenDir, name+GoldenExt)
+		if *Update {
+			if err := os.MkdirAll(goldenDir, 0755); err != nil {
+				h.T.Fatal(err)
+			}
+			if err := ioutil.WriteFile(goldenPath, data, 0644); err != nil {
+				h.T.Fatal(err)
+			}
+			continue
+		}
+
+		expected, err := ioutil.ReadFile(goldenPath)
+		if err != nil {
+			h.T.Fatal(err)
+		}
+		if !bytes.Equal(expected, data) {
+			h.T.Errorf("%s does not match %s\nexpected:\n%s\nactual:\n%s", path, goldenPath, expected, data)
+		}
+	}
+}
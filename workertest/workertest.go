@@ -0,0 +1,351 @@
+// Package workertest provides helpers for testing sqsworker Processors against the
+// worker's real pipeline without talking to SQS or SNS.
+//
+// A Harness runs messages through a Worker synchronously using in-memory fakes for the
+// queue and topic, and returns a Result with fluent assertions:
+//
+//	h := workertest.New(t, sqsworker.WorkerConfig{
+//		TopicArn:  "arn:aws:sns:us-east-1:88888888888:Out",
+//		Processor: &LowerCaseWorker{},
+//	})
+//	h.Run(workertest.NewMessage("HELLO")).Deleted().Published("hello")
+//
+package workertest
+
+import (
+	"context"
+	"fmt"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// QueueURL is the queue url used by a Harness when the config does not set one
+const QueueURL = "https://sqs.us-east-1.amazonaws.com/000000000000/workertest"
+
+// DefaultMaxReceiveCount is the redrive policy maxReceiveCount simulated by Redeliver
+const DefaultMaxReceiveCount = sqsworker.DefaultMaxReceiveCount
+
+var messageID int64
+
+var sess = session.Must(session.NewSession(&aws.Config{Region: aws.String("us-east-1")}))
+
+// MessageOption sets optional fields on a message built by NewMessage
+type MessageOption func(*sqs.Message)
+
+// Attribute sets a string message attribute
+func Attribute(name, value string) MessageOption {
+	return func(m *sqs.Message) {
+		m.MessageAttributes[name] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+}
+
+// SystemAttribute sets a system attribute such as ApproximateReceiveCount or SentTimestamp
+func SystemAttribute(name, value string) MessageOption {
+	return func(m *sqs.Message) {
+		m.Attributes[name] = aws.String(value)
+	}
+}
+
+// NewMessage builds a fake SQS message with a unique message id and receipt handle
+func NewMessage(body string, opts ...MessageOption) *sqs.Message {
+	id := atomic.AddInt64(&messageID, 1)
+	m := &sqs.Message{
+		Body:              aws.String(body),
+		MessageId:         aws.String(fmt.Sprint("message-", id)),
+		ReceiptHandle:     aws.String(fmt.Sprint("receipt-", id)),
+		Attributes:        map[string]*string{},
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Queue is an in-memory sqsiface.SQSAPI that records the calls made by a Worker
+type Queue struct {
+	sqsiface.SQSAPI
+	mu        sync.Mutex
+	Deleted   []*sqs.DeleteMessageInput
+	Visible   []*sqs.ChangeMessageVisibilityInput
+	Sent      []*sqs.SendMessageInput
+	DeleteErr error
+}
+
+// DeleteMessage records the deleted receipt handle
+func (q *Queue) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.DeleteErr != nil {
+		return nil, q.DeleteErr
+	}
+	q.Deleted = append(q.Deleted, input)
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+// ChangeMessageVisibility records the visibility change
+func (q *Queue) ChangeMessageVisibility(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.Visible = append(q.Visible, input)
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+// SendMessage records the sent message
+func (q *Queue) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.Sent = append(q.Sent, input)
+	return &sqs.SendMessageOutput{MessageId: aws.String(fmt.Sprint("sent-", len(q.Sent)))}, nil
+}
+
+// SendMessageWithContext records the sent message
+func (q *Queue) SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	return q.SendMessage(input)
+}
+
+// Topic is an in-memory snsiface.SNSAPI that records published messages
+type Topic struct {
+	snsiface.SNSAPI
+	mu         sync.Mutex
+	Published  []*sns.PublishInput
+	Batches    []*sns.PublishBatchInput
+	PublishErr error
+}
+
+// Publish records a copy of the publish input
+func (t *Topic) Publish(input *sns.PublishInput) (*sns.PublishOutput, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.PublishErr != nil {
+		return nil, t.PublishErr
+	}
+	// Keep a copy in case the Processor reuses its output between messages.
+	published := *input
+	published.Message = aws.String(aws.StringValue(input.Message))
+	t.Published = append(t.Published, &published)
+	return &sns.PublishOutput{MessageId: aws.String(fmt.Sprint("published-", len(t.Published)))}, nil
+}
+
+// PublishBatch records each entry of the batch as a published message
+func (t *Topic) PublishBatch(input *sns.PublishBatchInput) (*sns.PublishBatchOutput, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.PublishErr != nil {
+		return nil, t.PublishErr
+	}
+	t.Batches = append(t.Batches, input)
+	out := &sns.PublishBatchOutput{}
+	for _, entry := range input.PublishBatchRequestEntries {
+		t.Published = append(t.Published, &sns.PublishInput{
+			TopicArn:               input.TopicArn,
+			Message:                aws.String(aws.StringValue(entry.Message)),
+			MessageAttributes:      entry.MessageAttributes,
+			MessageGroupId:         entry.MessageGroupId,
+			MessageDeduplicationId: entry.MessageDeduplicationId,
+			MessageStructure:       entry.MessageStructure,
+			Subject:                entry.Subject,
+		})
+		out.Successful = append(out.Successful, &sns.PublishBatchResultEntry{
+			Id:        entry.Id,
+			MessageId: aws.String(fmt.Sprint("published-", len(t.Published))),
+		})
+	}
+	return out, nil
+}
+
+// Harness runs messages through a Worker backed by an in-memory Queue and Topic
+type Harness struct {
+	T      testing.TB
+	Worker *sqsworker.Worker
+	Queue  *Queue
+	Topic  *Topic
+	// MaxReceiveCount is the simulated redrive policy used by Redeliver
+	MaxReceiveCount int
+}
+
+// New creates a Harness for the given config. QueueURL and Logger are defaulted when unset.
+func New(t testing.TB, wc sqsworker.WorkerConfig) *Harness {
+	if wc.QueueURL == "" && len(wc.QueueURLs) == 0 {
+		wc.QueueURL = QueueURL
+	}
+	if wc.Logger == nil {
+		wc.Logger = zap.NewNop()
+	}
+
+	h := &Harness{
+		T:               t,
+		Worker:          sqsworker.NewWorker(sess, wc),
+		Queue:           &Queue{},
+		Topic:           &Topic{},
+		MaxReceiveCount: DefaultMaxReceiveCount,
+	}
+	h.Worker.Queue = h.Queue
+	h.Worker.Topic = h.Topic
+	return h
+}
+
+// Run processes a message once, synchronously, and returns the outcome
+func (h *Harness) Run(m *sqs.Message) *Result {
+	return h.run(m, 1)
+}
+
+// Redeliver processes a message until it is deleted or the simulated redrive policy
+// moves it to the dead-letter queue after MaxReceiveCount receives.
+func (h *Harness) Redeliver(m *sqs.Message) *Result {
+	var result *Result
+	for receives := 1; receives <= h.MaxReceiveCount; receives++ {
+		m.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount] = aws.String(fmt.Sprint(receives))
+		result = h.run(m, receives)
+		if result.deleted || result.deadLettered || result.quarantined {
+			return result
+		}
+	}
+	result.deadLettered = true
+	return result
+}
+
+func (h *Harness) run(m *sqs.Message, receives int) *Result {
+	h.Queue.mu.Lock()
+	deleted, published, visible, sent := len(h.Queue.Deleted), len(h.Topic.Published), len(h.Queue.Visible), len(h.Queue.Sent)
+	h.Queue.mu.Unlock()
+
+	result := &Result{T: h.T, Message: m, Receives: receives}
+	result.Err = h.Worker.Handle(context.Background(), m)
+
+	h.Queue.mu.Lock()
+	defer h.Queue.mu.Unlock()
+	result.deleted = len(h.Queue.Deleted) > deleted
+	result.Visibility = h.Queue.Visible[visible:]
+	result.Sent = h.Queue.Sent[sent:]
+	for _, input := range result.Sent {
+		switch queueURL := aws.StringValue(input.QueueUrl); {
+		case queueURL == "":
+		case queueURL == h.Worker.DeadLetterQueueURL:
+			result.deadLettered = true
+		case queueURL == h.Worker.QuarantineQueueURL:
+			result.quarantined = true
+		}
+	}
+	h.Topic.mu.Lock()
+	result.Publishes = h.Topic.Published[published:]
+	h.Topic.mu.Unlock()
+	return result
+}
+
+// Result is the outcome of running a message through a Harness
+type Result struct {
+	T          testing.TB
+	Message    *sqs.Message
+	Err        error
+	Receives   int
+	Publishes  []*sns.PublishInput
+	Visibility []*sqs.ChangeMessageVisibilityInput
+	// Sent messages, including those sent to the worker's DeadLetterQueueURL
+	Sent         []*sqs.SendMessageInput
+	deleted      bool
+	deadLettered bool
+	quarantined  bool
+}
+
+// Succeeded asserts that the pipeline returned no error
+func (r *Result) Succeeded() *Result {
+	r.T.Helper()
+	if r.Err != nil {
+		r.T.Errorf("expected message %s to succeed, got error: %v", aws.StringValue(r.Message.MessageId), r.Err)
+	}
+	return r
+}
+
+// Failed asserts that the pipeline returned an error
+func (r *Result) Failed() *Result {
+	r.T.Helper()
+	if r.Err == nil {
+		r.T.Errorf("expected message %s to fail", aws.StringValue(r.Message.MessageId))
+	}
+	return r
+}
+
+// Deleted asserts that the message was deleted from the queue
+func (r *Result) Deleted() *Result {
+	r.T.Helper()
+	if !r.deleted {
+		r.T.Errorf("expected message %s to be deleted", aws.StringValue(r.Message.MessageId))
+	}
+	return r
+}
+
+// NotDeleted asserts that the message was left on the queue
+func (r *Result) NotDeleted() *Result {
+	r.T.Helper()
+	if r.deleted {
+		r.T.Errorf("expected message %s not to be deleted", aws.StringValue(r.Message.MessageId))
+	}
+	return r
+}
+
+// Retried asserts that the message was left on the queue to be received again
+func (r *Result) Retried() *Result {
+	r.T.Helper()
+	if r.deleted || r.deadLettered || r.quarantined {
+		r.T.Errorf("expected message %s to be retried", aws.StringValue(r.Message.MessageId))
+	}
+	return r
+}
+
+// DeadLettered asserts that the message was sent to the worker's DeadLetterQueueURL, or exhausted
+// its receives and was moved to the dead-letter queue by the simulated redrive policy.
+func (r *Result) DeadLettered() *Result {
+	r.T.Helper()
+	if !r.deadLettered {
+		r.T.Errorf("expected message %s to be sent to the dead-letter queue", aws.StringValue(r.Message.MessageId))
+	}
+	return r
+}
+
+// Quarantined asserts that the message failed validation and was sent to the worker's
+// QuarantineQueueURL
+func (r *Result) Quarantined() *Result {
+	r.T.Helper()
+	if !r.quarantined {
+		r.T.Errorf("expected message %s to be sent to the quarantine queue", aws.StringValue(r.Message.MessageId))
+	}
+	return r
+}
+
+// Published asserts that exactly the given message bodies were published
+func (r *Result) Published(bodies ...string) *Result {
+	r.T.Helper()
+	if len(r.Publishes) != len(bodies) {
+		r.T.Errorf("expected %d published messages, got %d", len(bodies), len(r.Publishes))
+		return r
+	}
+	for i, body := range bodies {
+		if actual := aws.StringValue(r.Publishes[i].Message); actual != body {
+			r.T.Errorf("published message %d: expected %q, got %q", i, body, actual)
+		}
+	}
+	return r
+}
+
+// NotPublished asserts that nothing was published
+func (r *Result) NotPublished() *Result {
+	r.T.Helper()
+	if len(r.Publishes) != 0 {
+		r.T.Errorf("expected no published messages, got %d", len(r.Publishes))
+	}
+	return r
+}
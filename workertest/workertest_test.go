@@ -0,0 +1,79 @@
+package workertest_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ajbeach2/sqsworker"
+	"github.com/ajbeach2/sqsworker/workertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const topicArn = "arn:aws:sns:us-east-1:88888888888:Out"
+
+type UpperCase struct {
+}
+
+func (u *UpperCase) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	return &sns.PublishInput{Message: aws.String(strings.ToUpper(*m.Body) + *m.MessageAttributes["suffix"].StringValue)}, nil
+}
+
+type Failing struct {
+}
+
+func (f *Failing) Process(ctx context.Context, m *sqs.Message) (*sns.PublishInput, error) {
+	return nil, errors.New("failed")
+}
+
+func TestPublishedAndDeleted(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{TopicArn: topicArn, Processor: &UpperCase{}})
+	h.Run(workertest.NewMessage("hello", workertest.Attribute("suffix", "!"))).
+		Succeeded().
+		Deleted().
+		Published("HELLO!")
+}
+
+func TestRetriedAndDeadLettered(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{TopicArn: topicArn, Processor: &Failing{}})
+	h.Run(workertest.NewMessage("hello")).Failed().Retried().NotPublished()
+
+	h.MaxReceiveCount = 3
+	result := h.Redeliver(workertest.NewMessage("hello")).NotDeleted().DeadLettered()
+	if result.Receives != 3 {
+		t.Error("Actual: ", result.Receives, "Expected: ", 3)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workertest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := workertest.New(t, sqsworker.WorkerConfig{
+		TopicArn:  topicArn,
+		Processor: workertest.Record(dir, &UpperCase{}),
+	})
+	m := workertest.NewMessage("hello", workertest.Attribute("suffix", "?"))
+	h.Run(m).Published("HELLO?")
+
+	fixture, err := workertest.LoadFixture(filepath.Join(dir, *m.MessageId+workertest.FixtureExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *fixture.Body != "hello" {
+		t.Error("Actual: ", *fixture.Body, "Expected: ", "hello")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	h := workertest.New(t, sqsworker.WorkerConfig{TopicArn: topicArn, Processor: &UpperCase{}})
+	h.Replay("testdata/fixtures", "testdata/golden")
+}